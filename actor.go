@@ -0,0 +1,77 @@
+package audited
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+// Database session sources of the acting user, see SessionActor
+const (
+	ActorCurrentUser     = "current_user"
+	ActorApplicationName = "application_name"
+)
+
+// SessionActor makes the acting user come from the database session instead
+// of the application, for apps that authenticate each user against the
+// database. It is ActorCurrentUser, ActorApplicationName, or the name of a
+// custom setting such as "app.user" set with SET LOCAL (postgres) or a user
+// variable (mysql). Session level values are only reliable within a
+// transaction, where the audit entry is written on the same connection. It is
+// read once per statement, for all the rows the statement changes.
+var SessionActor string
+
+var settingName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// sessionActor reads the acting user from the database session, "" when it is
+// not set or SessionActor is disabled
+func sessionActor(db *gorm.DB) string {
+	if SessionActor == "" {
+		return ""
+	}
+	query, args, err := sessionActorQuery(db.Dialector.Name(), SessionActor)
+	if err != nil {
+		log.Println(fmt.Errorf("error reading actor from database session: %s", err.Error()))
+		return ""
+	}
+
+	var actor *string
+	if err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).
+		Raw(query, args...).
+		Row().
+		Scan(&actor); err != nil {
+		log.Println(fmt.Errorf("error reading actor from database session: %s", err.Error()))
+		return ""
+	}
+	if actor == nil {
+		return ""
+	}
+	return *actor
+}
+
+func sessionActorQuery(dialect, source string) (string, []interface{}, error) {
+	switch dialect {
+	case "postgres":
+		switch source {
+		case ActorCurrentUser:
+			return "SELECT current_user", nil, nil
+		case ActorApplicationName:
+			return "SELECT current_setting('application_name')", nil, nil
+		}
+		return "SELECT NULLIF(current_setting(?, true), '')", []interface{}{source}, nil
+	case "mysql":
+		switch source {
+		case ActorCurrentUser:
+			return "SELECT CURRENT_USER()", nil, nil
+		case ActorApplicationName:
+			return "", nil, fmt.Errorf("%s is not available on mysql", source)
+		}
+		if !settingName.MatchString(source) {
+			return "", nil, fmt.Errorf("invalid variable name %q", source)
+		}
+		return "SELECT @" + source, nil, nil
+	}
+	return "", nil, ErrUnsupportedDialect
+}
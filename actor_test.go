@@ -0,0 +1,36 @@
+package audited
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSessionActorQuery(t *testing.T) {
+	for _, c := range []struct {
+		dialect, source string
+		query           string
+		args            []interface{}
+	}{
+		{"postgres", ActorCurrentUser, "SELECT current_user", nil},
+		{"postgres", ActorApplicationName, "SELECT current_setting('application_name')", nil},
+		{"postgres", "app.user", "SELECT NULLIF(current_setting(?, true), '')", []interface{}{"app.user"}},
+		{"mysql", ActorCurrentUser, "SELECT CURRENT_USER()", nil},
+		{"mysql", "app.user", "SELECT @app.user", nil},
+	} {
+		query, args, err := sessionActorQuery(c.dialect, c.source)
+		if err != nil || query != c.query || !reflect.DeepEqual(args, c.args) {
+			t.Errorf("%s %s: got %q %v, %v", c.dialect, c.source, query, args, err)
+		}
+	}
+
+	// variables are spliced into the query on mysql, only names are accepted
+	for _, source := range []string{"app.user; DROP TABLE audit_logs", "1user", ActorApplicationName} {
+		if _, _, err := sessionActorQuery("mysql", source); err == nil {
+			t.Errorf("mysql %q accepted", source)
+		}
+	}
+	if _, _, err := sessionActorQuery("sqlite", ActorCurrentUser); !errors.Is(err, ErrUnsupportedDialect) {
+		t.Errorf("got %v, want ErrUnsupportedDialect", err)
+	}
+}
@@ -0,0 +1,173 @@
+package audited
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// AnchorsTable stores the anchors of the hash chain, see AnchorChain
+const AnchorsTable = "audit_anchors"
+
+// Notary attests that a digest existed at a point in time, e.g. a TSA
+type Notary interface {
+	// Name identifies the notary in stored anchors
+	Name() string
+	// Timestamp returns the proof the notary issued for digest
+	Timestamp(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// Anchor is the hash of the chain of entries up to Seq as attested by a notary
+type Anchor struct {
+	Seq       int64     `json:"seq" gorm:"primaryKey;autoIncrement:false"`
+	ChainHash []byte    `json:"chain_hash"`
+	Notary    string    `json:"notary"`
+	Proof     []byte    `json:"proof"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrChainMismatch is returned by VerifyAnchors when entries changed after
+// they were anchored
+var ErrChainMismatch = errors.New("audited: audit entries don't match their anchor")
+
+// annotationKeys are the metadata keys set on entries after they are written,
+// see RecordOutcome and WithAttempt, which the hash chain leaves out
+var annotationKeys = []string{"attempts", "final_status"}
+
+// EntryHash returns the SHA-256 hash of entry as stored, every column in the
+// canonical form of Canonicalize, except for the annotations of its metadata.
+// Payloads are hashed decrypted, so rekeying doesn't change the hash.
+func EntryHash(entry AuditLog) []byte {
+	entry.CreatedAt = entry.CreatedAt.UTC()
+	if len(entry.Metadata) > 0 {
+		metadata := datatypes.JSONMap{}
+		for key, value := range entry.Metadata {
+			metadata[key] = value
+		}
+		for _, key := range annotationKeys {
+			delete(metadata, key)
+		}
+		entry.Metadata = metadata
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		// payloads that aren't JSON are hashed as text
+		entry.Data, _ = json.Marshal(string(entry.Data))
+		entry.OldData, _ = json.Marshal(string(entry.OldData))
+		encoded, _ = json.Marshal(entry)
+	}
+	if canonical, err := Canonicalize(encoded); err == nil {
+		encoded = canonical
+	}
+	sum := sha256.Sum256(encoded)
+	return sum[:]
+}
+
+// chainHash extends the rolling hash previous with entry
+func chainHash(previous []byte, entry AuditLog) []byte {
+	h := sha256.New()
+	h.Write(previous)
+	h.Write(EntryHash(entry))
+	return h.Sum(nil)
+}
+
+// AnchorChain extends the hash chain with the entries after the latest anchor,
+// in sequence order, and stores the resulting hash with the proof of notary.
+// Entries after a gap in the sequence younger than 10 seconds are left for the
+// next run, see ConsumerOptions.GapTimeout. It returns nil when there is
+// nothing new to anchor.
+func AnchorChain(ctx context.Context, db *gorm.DB, notary Notary) (*Anchor, error) {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	var latest []Anchor
+	if err := db.Table(AnchorsTable).Order("seq DESC").Limit(1).Find(&latest).Error; err != nil {
+		return nil, err
+	}
+	var cursor int64
+	var hash []byte
+	if len(latest) > 0 {
+		cursor, hash = latest[0].Seq, latest[0].ChainHash
+	}
+
+	anchored := cursor
+	for {
+		entries, err := EntriesAfter(db, cursor, defaultSeqLimit)
+		if err != nil {
+			return nil, err
+		}
+		ready := consumable(cursor, entries, defaultConsumerGapTimeout, time.Now())
+		for _, entry := range ready {
+			hash = chainHash(hash, entry)
+			cursor = entry.Seq
+		}
+		if len(ready) < defaultSeqLimit {
+			break
+		}
+	}
+	if cursor == anchored {
+		return nil, nil
+	}
+
+	proof, err := notary.Timestamp(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	anchor := &Anchor{Seq: cursor, ChainHash: hash, Notary: notary.Name(), Proof: proof, CreatedAt: time.Now()}
+	if err := db.Table(AnchorsTable).Create(anchor).Error; err != nil {
+		return nil, err
+	}
+	return anchor, nil
+}
+
+// VerifyAnchors recomputes the hash chain and compares it with every stored
+// anchor, it returns ErrChainMismatch for the first anchor that doesn't match.
+// The proofs themselves are verified with the notary, e.g. with openssl ts
+// -verify for a TSA.
+func VerifyAnchors(ctx context.Context, db *gorm.DB) ([]Anchor, error) {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	var anchors []Anchor
+	if err := db.Table(AnchorsTable).Order("seq").Find(&anchors).Error; err != nil {
+		return nil, err
+	}
+	var cursor int64
+	var hash []byte
+	for _, anchor := range anchors {
+		var err error
+		if cursor, hash, err = extendChain(db, cursor, hash, anchor.Seq); err != nil {
+			return nil, err
+		}
+		if cursor != anchor.Seq || !bytes.Equal(hash, anchor.ChainHash) {
+			return nil, fmt.Errorf("%w: anchor at seq %d", ErrChainMismatch, anchor.Seq)
+		}
+	}
+	return anchors, nil
+}
+
+// extendChain extends hash with the entries after cursor up to seq upto
+func extendChain(db *gorm.DB, cursor int64, hash []byte, upto int64) (int64, []byte, error) {
+	for cursor < upto {
+		entries, err := EntriesAfter(db, cursor, defaultSeqLimit)
+		if err != nil || len(entries) == 0 {
+			return cursor, hash, err
+		}
+		for _, entry := range entries {
+			if entry.Seq > upto {
+				return cursor, hash, nil
+			}
+			hash = chainHash(hash, entry)
+			cursor = entry.Seq
+		}
+	}
+	return cursor, hash, nil
+}
+
+// ScheduleAnchoring runs AnchorChain every interval until ctx is done
+func ScheduleAnchoring(ctx context.Context, db *gorm.DB, notary Notary, interval time.Duration) {
+	RunJobs(ctx, db, ScheduledJob{Job: AnchorJob(notary), Interval: interval})
+}
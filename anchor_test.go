@@ -0,0 +1,157 @@
+package audited
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+func TestChainHash(t *testing.T) {
+	entries := []AuditLog{
+		{Id: "1", Seq: 1, TableName: "orders", ObjectId: "7", Data: datatypes.JSON(`{"total":10}`)},
+		{Id: "2", Seq: 2, TableName: "orders", ObjectId: "7", Data: datatypes.JSON(`{"total":12}`)},
+	}
+	var hash []byte
+	for _, entry := range entries {
+		hash = chainHash(hash, entry)
+	}
+
+	tampered := append([]AuditLog{}, entries...)
+	tampered[0].Data = datatypes.JSON(`{"total":11}`)
+	var other []byte
+	for _, entry := range tampered {
+		other = chainHash(other, entry)
+	}
+	if bytes.Equal(hash, other) {
+		t.Fatal("changing an entry doesn't change the chain hash")
+	}
+	if again := chainHash(chainHash(nil, entries[0]), entries[1]); !bytes.Equal(hash, again) {
+		t.Fatal("chain hash is not deterministic")
+	}
+}
+
+func TestEntryHashCoversEntry(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)
+	entry := AuditLog{Id: "1", Seq: 1, TableName: "orders", OperationType: OperationUpdate, ObjectId: "7",
+		Data: datatypes.JSON(`{"status":"paid","total":10}`), OldData: datatypes.JSON(`{"status":"open","total":10}`),
+		UserId: "ann", OwnerId: "bob", TenantId: "acme", Source: "ui", RequestId: "r1", ActorIP: "203.0.113.7",
+		Metadata: datatypes.JSONMap{"service": "billing"}, CreatedAt: created}
+	hash := EntryHash(entry)
+
+	for name, tamper := range map[string]func(e *AuditLog){
+		"old data":   func(e *AuditLog) { e.OldData = datatypes.JSON(`{"status":"void","total":10}`) },
+		"metadata":   func(e *AuditLog) { e.Metadata = datatypes.JSONMap{"service": "payroll"} },
+		"owner":      func(e *AuditLog) { e.OwnerId = "eve" },
+		"tenant":     func(e *AuditLog) { e.TenantId = "other" },
+		"source":     func(e *AuditLog) { e.Source = "" },
+		"request":    func(e *AuditLog) { e.RequestId = "r2" },
+		"actor ip":   func(e *AuditLog) { e.ActorIP = "198.51.100.1" },
+		"summary":    func(e *AuditLog) { e.Summary = "paid" },
+		"created at": func(e *AuditLog) { e.CreatedAt = created.Add(time.Microsecond) },
+	} {
+		tampered := entry
+		tamper(&tampered)
+		if bytes.Equal(EntryHash(tampered), hash) {
+			t.Errorf("changing the %s doesn't change the hash", name)
+		}
+	}
+
+	// the same entry read back differently hashes the same, so do annotations
+	// made after it was written
+	same := entry
+	same.Data = datatypes.JSON(`{ "total": 10.0, "status": "paid" }`)
+	same.CreatedAt = created.In(time.FixedZone("CEST", 2*60*60))
+	same.Metadata = datatypes.JSONMap{"service": "billing", "attempts": 2, "final_status": OutcomeSucceeded}
+	if !bytes.Equal(EntryHash(same), hash) {
+		t.Error("equivalent entry hashes differently")
+	}
+	if entry.Metadata["attempts"] != nil || len(same.Metadata) != 3 {
+		t.Error("hashing changed the metadata of the entry")
+	}
+	invalid := entry
+	invalid.Data = datatypes.JSON(`not json`)
+	if bytes.Equal(EntryHash(invalid), hash) || !bytes.Equal(EntryHash(invalid), EntryHash(invalid)) {
+		t.Error("entry with a payload that isn't JSON not hashed as text")
+	}
+}
+
+// tstInfoWithOptionals has the optional fields TSAs commonly add
+type tstInfoWithOptionals struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       struct{ Seconds int }
+	Nonce          *big.Int
+}
+
+func fakeTSA(t *testing.T, genTime time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/timestamp-query" {
+			t.Errorf("got content type %q", r.Header.Get("Content-Type"))
+		}
+		var body bytes.Buffer
+		body.ReadFrom(r.Body)
+		var req timeStampReq
+		if _, err := asn1.Unmarshal(body.Bytes(), &req); err != nil {
+			t.Errorf("invalid request: %v", err)
+			return
+		}
+		info, _ := asn1.Marshal(tstInfoWithOptionals{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 2, 3},
+			MessageImprint: req.MessageImprint,
+			SerialNumber:   big.NewInt(1),
+			GenTime:        genTime,
+			Accuracy:       struct{ Seconds int }{1},
+			Nonce:          req.Nonce,
+		})
+		signed, _ := asn1.Marshal(signedData{
+			Version:          3,
+			DigestAlgorithms: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+			EncapContentInfo: encapsulatedContentInfo{EContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}, EContent: info},
+			SignerInfos:      asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+		})
+		token, _ := asn1.Marshal(contentInfo{
+			ContentType: oidSignedData,
+			Content:     asn1.RawValue{FullBytes: mustExplicit(signed)},
+		})
+		resp, _ := asn1.Marshal(timeStampResp{Status: pkiStatusInfo{Status: 0}, TimeStampToken: asn1.RawValue{FullBytes: token}})
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(resp)
+	}))
+}
+
+// mustExplicit wraps der in a [0] EXPLICIT tag
+func mustExplicit(der []byte) []byte {
+	wrapped, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der})
+	return wrapped
+}
+
+func TestTSA(t *testing.T) {
+	genTime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	server := fakeTSA(t, genTime)
+	defer server.Close()
+
+	digest := sha256.Sum256([]byte("chain"))
+	token, err := NewTSA(server.URL).Timestamp(context.Background(), digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamped, at, err := ParseTimestampToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stamped, digest[:]) || !at.Equal(genTime) {
+		t.Fatalf("got digest %x at %s", stamped, at)
+	}
+}
@@ -0,0 +1,230 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Backpressure policies of the asynchronous writer, applied when its queue
+// is full, see AsyncOptions
+const (
+	// BackpressureBlock waits for room in the queue
+	BackpressureBlock = "block"
+	// BackpressureDrop drops the entries, see AsyncOptions.OnDrop
+	BackpressureDrop = "drop"
+	// BackpressureSync writes the entries in the caller, as without the
+	// asynchronous writer
+	BackpressureSync = "sync"
+)
+
+// AsyncOptions configures the asynchronous writer, see WithAsync
+type AsyncOptions struct {
+	// QueueSize is the number of writes queued, 1024 by default
+	QueueSize int
+	// Workers is the number of goroutines writing queued entries, 4 by
+	// default
+	Workers int
+	// Backpressure is applied when the queue is full, BackpressureBlock by
+	// default
+	Backpressure string
+	// BatchSize is the number of entries a worker collects from the queue
+	// into a single insert, 1 by default: every write is inserted on its own
+	BatchSize int
+	// FlushInterval is how long a worker waits for a batch to fill up before
+	// inserting it, 100ms by default
+	FlushInterval time.Duration
+	// OnDrop is called with the entries that are lost, dropped by
+	// BackpressureDrop or failing to be written, e.g. to count them in a
+	// metric
+	OnDrop func(logs []AuditLog, err error)
+}
+
+// ErrQueueFull is passed to AsyncOptions.OnDrop for entries dropped by
+// BackpressureDrop
+var ErrQueueFull = errors.New("audited: async queue full")
+
+// WithAsync writes entries in a pool of workers instead of in the statement
+// making the change, so the latency of writes isn't doubled by auditing.
+// Entries of changes made in a transaction are still written in it, so they
+// are rolled back with it. Call CloseAsync on shutdown to write the queued
+// entries.
+func WithAsync(opts AsyncOptions) Option {
+	return func(o *options) {
+		if opts.QueueSize <= 0 {
+			opts.QueueSize = 1024
+		}
+		if opts.Workers <= 0 {
+			opts.Workers = 4
+		}
+		if opts.Backpressure == "" {
+			opts.Backpressure = BackpressureBlock
+		}
+		if opts.BatchSize <= 0 {
+			opts.BatchSize = 1
+		}
+		if opts.FlushInterval <= 0 {
+			opts.FlushInterval = 100 * time.Millisecond
+		}
+		o.async = &opts
+	}
+}
+
+// asyncWrite is a write of entries queued to the asynchronous writer
+type asyncWrite struct {
+	db   *gorm.DB
+	logs []AuditLog
+	key  asyncKey
+}
+
+// asyncKey is what the writes merged into a batch share: the instance and the
+// region and tenant of their context, which decide where they are stored
+type asyncKey struct {
+	config *options
+	region string
+	tenant string
+}
+
+type asyncWriter struct {
+	opts  AsyncOptions
+	queue chan asyncWrite
+	wg    sync.WaitGroup
+	// held while sending to the queue, so it isn't closed under a send
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAsyncWriter(opts AsyncOptions) *asyncWriter {
+	return &asyncWriter{opts: opts, queue: make(chan asyncWrite, opts.QueueSize)}
+}
+
+func (w *asyncWriter) start() {
+	for i := 0; i < w.opts.Workers; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for write := range w.queue {
+				for {
+					batch, next := w.batch(write)
+					w.save(batch)
+					if next == nil {
+						break
+					}
+					write = *next
+				}
+			}
+		}()
+	}
+}
+
+// batch returns write with the entries of the writes queued within the
+// FlushInterval after it, up to the BatchSize. They are written with the
+// session of the first write, so only writes with its key are merged: the
+// first write with another key ends the batch and is returned as next.
+func (w *asyncWriter) batch(write asyncWrite) (batch asyncWrite, next *asyncWrite) {
+	if len(write.logs) >= w.opts.BatchSize {
+		return write, nil
+	}
+	timer := time.NewTimer(w.opts.FlushInterval)
+	defer timer.Stop()
+	for len(write.logs) < w.opts.BatchSize {
+		select {
+		case queued, ok := <-w.queue:
+			if !ok {
+				return write, nil
+			}
+			if queued.key != write.key {
+				return write, &queued
+			}
+			write.logs = append(write.logs, queued.logs...)
+		case <-timer.C:
+			return write, nil
+		}
+	}
+	return write, nil
+}
+
+func (w *asyncWriter) save(write asyncWrite) {
+	if err := saveAuditLogs(write.db, write.logs); err != nil {
+		log.Println(fmt.Errorf("error in async audit log creation: %s", err.Error()))
+		w.drop(write.logs, err)
+	}
+}
+
+// enqueue queues logs to be written with db, it reports false when they are
+// to be written by the caller
+func (w *asyncWriter) enqueue(db *gorm.DB, logs []AuditLog) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return false
+	}
+	// the statement context ends with the request, the write outlives it
+	ctx := db.Statement.Context
+	write := asyncWrite{
+		db:   db.Session(&gorm.Session{NewDB: true, Context: context.WithoutCancel(ctx)}),
+		logs: logs,
+		key:  asyncKey{config: configFor(db), region: residencyOf(ctx), tenant: TenantFrom(ctx)},
+	}
+	switch w.opts.Backpressure {
+	case BackpressureDrop:
+		select {
+		case w.queue <- write:
+		default:
+			w.drop(logs, ErrQueueFull)
+		}
+	case BackpressureSync:
+		select {
+		case w.queue <- write:
+		default:
+			return false
+		}
+	default:
+		w.queue <- write
+	}
+	return true
+}
+
+func (w *asyncWriter) drop(logs []AuditLog, err error) {
+	if w.opts.OnDrop != nil {
+		w.opts.OnDrop(logs, err)
+	}
+}
+
+// close stops queueing and waits for the queued entries to be written, or
+// for ctx to be done
+func (w *asyncWriter) close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CloseAsync stops the asynchronous writer of db once the queued entries are
+// written, or returns the error of ctx when it is done first. Entries of
+// later changes are written synchronously. It is a no-op without WithAsync.
+func CloseAsync(ctx context.Context, db *gorm.DB) error {
+	if w := configFor(db).asyncWriter; w != nil {
+		return w.close(ctx)
+	}
+	return nil
+}
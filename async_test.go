@@ -0,0 +1,102 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAsyncWriter(t *testing.T) {
+	store := NewMemoryStore()
+	db := statementFor(t, &Invoice{})
+	if err := RegisterCallbacks(db, WithMemoryStore(store)); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newAsyncWriter(AsyncOptions{QueueSize: 8, Workers: 2, Backpressure: BackpressureBlock})
+	w.start()
+	for i := 0; i < 20; i++ {
+		if !w.enqueue(db, []AuditLog{{Id: newID(), TableName: "invoices", OperationType: OperationCreate}}) {
+			t.Fatal("entries not queued")
+		}
+	}
+	if err := w.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(store.Entries()); n != 20 {
+		t.Fatalf("got %d entries written, want 20", n)
+	}
+	if w.enqueue(db, []AuditLog{{}}) {
+		t.Fatal("entries queued after close")
+	}
+}
+
+func TestAsyncBackpressure(t *testing.T) {
+	db := statementFor(t, &Invoice{})
+
+	var dropped []AuditLog
+	// without workers the queue fills up
+	w := newAsyncWriter(AsyncOptions{QueueSize: 1, Backpressure: BackpressureDrop, OnDrop: func(logs []AuditLog, err error) {
+		if !errors.Is(err, ErrQueueFull) {
+			t.Errorf("got %v, want ErrQueueFull", err)
+		}
+		dropped = append(dropped, logs...)
+	}})
+	w.enqueue(db, []AuditLog{{ObjectId: "1"}})
+	if !w.enqueue(db, []AuditLog{{ObjectId: "2"}}) {
+		t.Fatal("dropped entries left to the caller")
+	}
+	if len(dropped) != 1 || dropped[0].ObjectId != "2" {
+		t.Fatalf("got %v dropped, want the second entry", dropped)
+	}
+
+	w = newAsyncWriter(AsyncOptions{QueueSize: 1, Backpressure: BackpressureSync})
+	w.enqueue(db, []AuditLog{{ObjectId: "1"}})
+	if w.enqueue(db, []AuditLog{{ObjectId: "2"}}) {
+		t.Fatal("entries queued to a full queue with BackpressureSync")
+	}
+}
+
+func TestAsyncBatch(t *testing.T) {
+	db := statementFor(t, &Invoice{})
+	w := newAsyncWriter(AsyncOptions{QueueSize: 16, BatchSize: 5, FlushInterval: time.Hour})
+	for i := 0; i < 7; i++ {
+		w.enqueue(db, []AuditLog{{ObjectId: strconv.Itoa(i)}})
+	}
+
+	first := <-w.queue
+	if batch, _ := w.batch(first); len(batch.logs) != 5 || batch.logs[4].ObjectId != "4" {
+		t.Fatalf("got a batch of %v, want the first 5 entries", batch.logs)
+	}
+	// the rest of the queue is flushed when the interval elapses
+	w.opts.FlushInterval = time.Millisecond
+	first = <-w.queue
+	if batch, _ := w.batch(first); len(batch.logs) != 2 {
+		t.Fatalf("got a batch of %v, want the last 2 entries", batch.logs)
+	}
+}
+
+func TestAsyncBatchKey(t *testing.T) {
+	db := statementFor(t, &Invoice{})
+	w := newAsyncWriter(AsyncOptions{QueueSize: 16, BatchSize: 5, FlushInterval: time.Hour})
+	eu := db.WithContext(WithResidency(context.Background(), "eu"))
+	acme := db.WithContext(WithTenant(context.Background(), "acme"))
+	w.enqueue(db, []AuditLog{{ObjectId: "1"}})
+	w.enqueue(db, []AuditLog{{ObjectId: "2"}})
+	w.enqueue(eu, []AuditLog{{ObjectId: "3"}})
+	w.enqueue(acme, []AuditLog{{ObjectId: "4"}})
+
+	batch, next := w.batch(<-w.queue)
+	if len(batch.logs) != 2 || next == nil || next.logs[0].ObjectId != "3" {
+		t.Fatalf("got a batch of %v then %v, want the first 2 entries then the one of the region", batch.logs, next)
+	}
+	if residencyOf(batch.db.Statement.Context) != "" {
+		t.Fatal("got the batch written with the context of another region")
+	}
+	batch, next = w.batch(*next)
+	if len(batch.logs) != 1 || next == nil || next.logs[0].ObjectId != "4" {
+		t.Fatalf("got a batch of %v then %v, want the entry of the region then the one of the tenant", batch.logs, next)
+	}
+}
@@ -0,0 +1,125 @@
+package audited
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var contextKeyAttempt = ContextKey("audited_attempt")
+
+// Outcomes of a retried operation, see RecordOutcome
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// ErrNoIdempotencyKey is returned by RecordOutcome for contexts without an
+// idempotency key
+var ErrNoIdempotencyKey = errors.New("audited: no idempotency key")
+
+// WithAttempt returns a copy of ctx marking the execution as the attempt-th
+// of the operation of its idempotency key, e.g. the delivery count of a queue
+// message. Its entries carry the count in their "attempts" metadata, and a
+// retry replaying the entries of an earlier attempt raises their count
+// instead of writing them again, so the retries of an operation collapse into
+// one entry per change.
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, contextKeyAttempt, attempt)
+}
+
+func attemptOf(ctx context.Context) int {
+	attempt, _ := ctx.Value(contextKeyAttempt).(int)
+	return attempt
+}
+
+// RecordOutcome sets the "final_status" metadata of the entries written under
+// the idempotency key of ctx to status, e.g. OutcomeFailed when the last
+// retry of the operation failed, and their "attempts" to the attempt of ctx
+func RecordOutcome(ctx context.Context, db *gorm.DB, status string) error {
+	key := getIdempotencyKey(ctx)
+	if key == "" {
+		return ErrNoIdempotencyKey
+	}
+	attempt := attemptOf(ctx)
+	return annotateEntries(db.WithContext(ctx), AuditLog{IdempotencyKey: key}, func(metadata map[string]interface{}) bool {
+		metadata["final_status"] = status
+		raiseAttempts(metadata, attempt)
+		return true
+	})
+}
+
+// recordReplay raises the attempt count of the stored entries entry replays
+func recordReplay(db *gorm.DB, entry *AuditLog) {
+	attempt := attemptOf(db.Statement.Context)
+	if attempt == 0 {
+		return
+	}
+	if err := annotateEntries(db, *entry, func(metadata map[string]interface{}) bool {
+		return raiseAttempts(metadata, attempt)
+	}); err != nil {
+		log.Println(fmt.Errorf("error recording audit log attempt: %s", err.Error()))
+	}
+}
+
+// raiseAttempts sets the "attempts" of metadata to attempt when it is
+// higher, and reports whether it did
+func raiseAttempts(metadata map[string]interface{}, attempt int) bool {
+	if attempt == 0 || metadataInt(metadata["attempts"]) >= attempt {
+		return false
+	}
+	metadata["attempts"] = attempt
+	return true
+}
+
+func metadataInt(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case json.Number:
+		n, _ := v.Int64()
+		return int(n)
+	}
+	return 0
+}
+
+// annotateEntries updates the metadata of the stored entries of the operation
+// of match with annotate, or of all the entries of its idempotency key when
+// it has no table. Entries annotate returns false for are left as they are.
+func annotateEntries(db *gorm.DB, match AuditLog, annotate func(metadata map[string]interface{}) bool) error {
+	if store := memoryStoreOf(db); store != nil {
+		store.annotate(match, annotate)
+		return nil
+	}
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	query := QueryOperations(db).Select("id, metadata").Where("idempotency_key = ?", match.IdempotencyKey)
+	if match.TableName != "" {
+		query = query.Where("table_name = ? AND object_id = ? AND operation_type = ?",
+			match.TableName, match.ObjectId, match.OperationType)
+	}
+	var entries []AuditLog
+	if err := query.Find(&entries).Error; err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Metadata == nil {
+			entry.Metadata = datatypes.JSONMap{}
+		}
+		if !annotate(entry.Metadata) {
+			continue
+		}
+		if err := db.Table(operationsTable(db)).Where("id = ?", entry.Id).Update("metadata", entry.Metadata).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
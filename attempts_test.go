@@ -0,0 +1,61 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestAttempts(t *testing.T) {
+	store := NewMemoryStore()
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterCallbacks(db, WithMemoryStore(store)); err != nil {
+		t.Fatal(err)
+	}
+
+	// execute runs the update of one attempt under key
+	execute := func(key string, attempt int) context.Context {
+		ctx := WithAttempt(WithIdempotencyKey(context.Background(), key), attempt)
+		tx := db.WithContext(ctx)
+		entry := AuditLog{TableName: "widgets", ObjectId: "w-1", OperationType: OperationUpdate, IdempotencyKey: key}
+		entry.SetMetadata("attempts", attempt)
+		if isReplay(tx, &entry) {
+			recordReplay(tx, &entry)
+		} else {
+			store.add(entry)
+		}
+		return ctx
+	}
+	execute("k1", 1)
+	execute("k1", 2)
+	ctx := execute("k1", 3)
+	execute("k2", 1)
+
+	entries := store.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("stored %d entries, want one per key", len(entries))
+	}
+	if attempts := metadataInt(entries[0].Metadata["attempts"]); attempts != 3 {
+		t.Fatalf("got %d attempts, want 3", attempts)
+	}
+
+	if err := RecordOutcome(ctx, db, OutcomeFailed); err != nil {
+		t.Fatal(err)
+	}
+	entries = store.Entries()
+	if entries[0].Metadata["final_status"] != OutcomeFailed {
+		t.Fatalf("got metadata %v, want the final status", entries[0].Metadata)
+	}
+	if _, ok := entries[1].Metadata["final_status"]; ok {
+		t.Fatal("final status set on the entry of another key")
+	}
+	if err := RecordOutcome(context.Background(), db, OutcomeSucceeded); !errors.Is(err, ErrNoIdempotencyKey) {
+		t.Fatalf("got %v, want ErrNoIdempotencyKey", err)
+	}
+}
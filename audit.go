@@ -1,14 +1,12 @@
 package audited
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"reflect"
 	"time"
 
-	"github.com/google/uuid"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
@@ -25,146 +23,489 @@ var (
 	ContextKeyEmail = ContextKey("email")
 )
 
+// SettingUser is the gorm setting holding the acting user, for code that
+// doesn't thread a context such as CLI tools and cron jobs:
+//
+//	db.Set(audited.SettingUser, "cron@example.com").Delete(&session)
+const SettingUser = "audited:user"
+
+const settingOldData = "audited:old_data"
+
 // AuditLog represents the audit log model
 type AuditLog struct {
-	Id            uuid.UUID      `json:"id" gorm:"primaryKey"`
+	Id            ID             `json:"id" gorm:"primaryKey;default:(-)"`
 	TableName     string         `json:"table_name"`
 	OperationType string         `json:"operation_type"`
 	ObjectId      string         `json:"object_id"`
 	Data          datatypes.JSON `json:"data"`
-	UserId        string         `json:"user_id"`
-	CreatedAt     time.Time      `json:"created_at"`
+	// OldData is the data of the object before an update, read before it is
+	// applied
+	OldData datatypes.JSON `json:"old_data,omitempty"`
+	UserId  string         `json:"user_id"`
+	// OwnerId is the owner of the object, see RegisterOwnerOf
+	OwnerId string `json:"owner_id,omitempty"`
+	// RootTable and RootObjectId identify the topmost parent of the object,
+	// see RegisterParent
+	RootTable    string `json:"root_table,omitempty"`
+	RootObjectId string `json:"root_object_id,omitempty"`
+	// Domain is the business domain of the table, see WithDomain
+	Domain string `json:"domain,omitempty"`
+	// ActorIP, UserAgent, RequestId and SessionId identify the request of the
+	// change, see WithRequestInfo
+	ActorIP   string `json:"actor_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	RequestId string `json:"request_id,omitempty"`
+	SessionId string `json:"session_id,omitempty"`
+	// Source tags the changes of migrations and batch jobs, see WithSource
+	Source string `json:"source,omitempty"`
+	// TenantId is the tenant the change was made for, see WithTenant
+	TenantId string `json:"tenant_id,omitempty"`
+	Summary  string `json:"summary"`
+	// IdempotencyKey is set when the write carried one, see WithIdempotencyKey
+	IdempotencyKey string `json:"idempotency_key"`
+	// Metadata holds additional information added by enrichers, see RegisterEnricher
+	Metadata datatypes.JSONMap `json:"metadata"`
+	// Seq is the position of the entry in the global sequence of entries,
+	// assigned by the database, see EntriesAfter. Only postgres returns it
+	// from the insert, elsewhere it is set on entries read back.
+	Seq       int64     `json:"seq" gorm:"default:(-)"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// one row per changed field, for models registered with RegisterLongFormat
+	changes []FieldChange
 }
 
+// Operation types recorded in audit logs
+const (
+	OperationCreate = "CREATE"
+	OperationUpdate = "UPDATE"
+	OperationDelete = "DELETE"
+	// Deletes of models with a gorm.DeletedAt field: a soft delete sets it,
+	// an unscoped delete removes the row, an unscoped update clearing it
+	// restores the row
+	OperationSoftDelete = "SOFT_DELETE"
+	OperationHardDelete = "HARD_DELETE"
+	OperationRestore    = "RESTORE"
+)
+
 // Create method to add create audit log hook
 func Create(db *gorm.DB) {
-	if db.Statement.Schema != nil && db.Statement.Schema.Table == AuditTable || db.Error != nil {
+	audit(db, OperationCreate)
+}
+
+// Update method to add update audit log hook
+func Update(db *gorm.DB) {
+	audit(db, OperationUpdate)
+}
+
+// Delete method to add delete audit log hook
+func Delete(db *gorm.DB) {
+	audit(db, deleteOperation(db))
+}
+
+func audit(db *gorm.DB, operation string) {
+	if isAuditTable(db, db.Statement.Table) || db.Error != nil {
 		return
 	}
-
-	recordMap, err := getDataBeforeOperation(db)
-	if err != nil {
+	if !auditsStatement(db) {
 		return
 	}
-	objId := getKeyFromData("id", recordMap)
 
-	auditLog := &AuditLog{
-		TableName:     db.Statement.Schema.Table,
-		OperationType: "CREATE",
-		ObjectId:      objId,
-		Data:          prepareData(recordMap),
-		UserId:        getCurrentUser(db.Statement.Context),
+	// a slice of objects, e.g. a batch create, gets one entry per object, as
+	// do the rows a batch update or delete matched
+	rows, reload := statementRows(db), true
+	if matched, ok := db.InstanceGet(settingBatchRows); ok {
+		var err error
+		if rows, err = reloadRows(db, matched.([]reflect.Value)); err != nil {
+			log.Println(fmt.Errorf("error reading audited batch rows: %s", err.Error()))
+			return
+		}
+		reload = false
+	} else if isDelete(operation) && isBatch(db) {
+		// deletes are audited before they run, the rows are still there
+		var err error
+		if rows, err = matchingRows(db); err != nil {
+			log.Println(fmt.Errorf("error reading audited batch rows: %s", err.Error()))
+			return
+		}
+		db.InstanceSet(settingBatchRows, rows)
+		reload = false
+	}
+	if len(rows) == 0 {
+		return
+	}
+	// the user is the same for every row, the database session may take a
+	// query to read it
+	user := getCurrentUser(db)
+	var logs []AuditLog
+	for i, row := range rows {
+		op := operation
+		if operation == OperationCreate {
+			// upserts insert some objects and update the rows of others
+			var ok bool
+			if op, row, ok = upsertOperation(db, i, row); !ok {
+				continue
+			}
+		}
+		if auditLog := newAuditLog(db, op, row, reload, user); auditLog != nil {
+			logs = append(logs, *auditLog)
+		}
+	}
+	if len(logs) == 0 {
+		return
 	}
 
-	if err := db.Session(&gorm.Session{SkipHooks: true, NewDB: true}).
-		Table(AuditTable).
-		Create(auditLog).
-		Error; err != nil {
+	recorder := FromContext(db.Statement.Context)
+	// entries of a transaction are written with it so they roll back with it,
+	// held back they would outlive a rollback
+	if recorder.deferred() && !inTransaction(db) {
+		recorder.enqueue(logs...)
+		return
+	}
+	if w := configFor(db).asyncWriter; w != nil && !inTransaction(db) && w.enqueue(db, logs) {
+		recorder.record(logs...)
+		return
+	}
+	if configFor(db).auditDB == nil || !inTransaction(db) {
+		writeAuditLogs(db, recorder, logs)
+		return
+	}
+	// entries of the audit database can't join the transaction, they are
+	// written once it commits, or flagged when that can't be waited for
+	if uncommitted(db) {
+		for i := range logs {
+			logs[i].SetMetadata("uncommitted", true)
+		}
+	}
+	afterCommit(db, func() { writeAuditLogs(db, recorder, logs) })
+}
 
+func writeAuditLogs(db *gorm.DB, recorder *Recorder, logs []AuditLog) {
+	if err := saveAuditLogs(db, logs); err != nil {
 		log.Println(fmt.Errorf("error in audit log creation: %s", err.Error()))
 		return
 	}
+	// ids left to the column default are filled in by the insert
+	recorder.record(logs...)
 }
 
-// Update method to add update audit log hook
-func Update(db *gorm.DB) {
-	if db.Statement.Schema != nil && db.Statement.Schema.Table == AuditTable || db.Error != nil {
-		return
+// statementRows returns the objects of the statement of db, the elements of
+// a slice or the single object
+func statementRows(db *gorm.DB) []reflect.Value {
+	value := reflect.Indirect(db.Statement.ReflectValue)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return []reflect.Value{value}
 	}
+	rows := make([]reflect.Value, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		rows = append(rows, reflect.Indirect(value.Index(i)))
+	}
+	return rows
+}
 
-	recordMap, err := getDataBeforeOperation(db)
-	if err != nil {
-		return
+// newAuditLog returns the entry of operation by user on the object row, read
+// back from the database first when reload is set, nil when none is written
+func newAuditLog(db *gorm.DB, operation string, row reflect.Value, reload bool, user string) *AuditLog {
+	// consent decisions are those of this row, not of the one before it
+	db.InstanceSet(settingConsent, map[string]string(nil))
+	var err error
+	record := row
+	if reload {
+		if record, err = readRow(db, row); err != nil {
+			return nil
+		}
+	}
+	recordMap := map[string]interface{}{}
+	if !record.IsValid() {
+		// nothing read back in dry run sessions
+		record = row
+	} else if recordMap, err = snapshot(db, record); err != nil {
+		return nil
 	}
-	objId := getKeyFromData("id", recordMap)
+	objId := rowObjectId(db, row, recordMap)
+
 	auditLog := &AuditLog{
-		TableName:     db.Statement.Schema.Table,
-		OperationType: "UPDATE",
-		ObjectId:      objId,
-		Data:          prepareData(recordMap),
-		UserId:        getCurrentUser(db.Statement.Context),
+		Id:             newID(),
+		TableName:      db.Statement.Table,
+		OperationType:  operation,
+		ObjectId:       objId,
+		Data:           prepareData(recordMap),
+		UserId:         user,
+		IdempotencyKey: getIdempotencyKey(db.Statement.Context),
+		OwnerId:        ownerOf(record),
+		Domain:         configFor(db).domains[db.Statement.Table],
+		Source:         SourceFrom(db.Statement.Context),
+		TenantId:       TenantFrom(db.Statement.Context),
+	}
+	auditLog.RootTable, auditLog.RootObjectId = rootOf(db, record)
+	request := requestOf(db.Statement.Context, configFor(db))
+	auditLog.ActorIP, auditLog.UserAgent = request.ActorIP, request.UserAgent
+	auditLog.RequestId, auditLog.SessionId = request.RequestId, request.SessionId
+	if operation == OperationUpdate && wasSoftDeleted(db, objId) && !isSoftDeleted(db, record) {
+		auditLog.OperationType = OperationRestore
+	}
+	setWorkflowMetadata(db.Statement.Context, auditLog)
+	if attempt := attemptOf(db.Statement.Context); attempt > 0 {
+		auditLog.SetMetadata("attempts", attempt)
+	}
+	if service := configFor(db).serviceName; service != "" {
+		auditLog.SetMetadata("service", service)
+	}
+	if region := residencyOf(db.Statement.Context); region != "" {
+		auditLog.SetMetadata("residency", region)
 	}
+	if value, ok := db.InstanceGet(settingConsent); ok && len(value.(map[string]string)) > 0 {
+		auditLog.SetMetadata("consent", value)
+	}
+	if isReplay(db, auditLog) {
+		recordReplay(db, auditLog)
+		return nil
+	}
+	// updates are recorded after the fact, what they changed from is the
+	// data of the previous entry of the object
+	var previous datatypes.JSON
+	value, _ := db.InstanceGet(settingOldData)
+	oldData, _ := value.(map[string]datatypes.JSON)
+	if old, ok := oldData[objId]; ok && operation == OperationUpdate {
+		auditLog.OldData = old
+		previous = auditLog.OldData
+	} else if operation == OperationUpdate && (hasSummary(auditLog.TableName) || isLongFormat(db)) {
+		previous = previousData(db, auditLog)
+	}
+	auditLog.Summary = summarize(auditLog, previous)
+	enrich(db.Statement.Context, auditLog)
+	if isLongFormat(db) {
+		if auditLog.changes, err = fieldChanges(auditLog, previous); err != nil {
+			log.Println(fmt.Errorf("error computing audit field changes: %s", err.Error()))
+		}
+	}
+	if operation == OperationUpdate && auditLog.OldData != nil && configFor(db).jsonPatch {
+		// the data stays the object after the update for the readers of
+		// snapshots, the patch is kept next to it
+		if patch, err := patchData(auditLog.TableName, auditLog.OldData, auditLog.Data); err != nil {
+			log.Println(fmt.Errorf("error computing audit json patch: %s", err.Error()))
+		} else {
+			auditLog.SetMetadata("json_patch", string(patch))
+		}
+	} else if operation == OperationUpdate && auditLog.OldData != nil && configFor(db).changedOnly {
+		if auditLog.OldData, auditLog.Data, err = changedFields(auditLog.TableName, auditLog.OldData, auditLog.Data); err != nil {
+			log.Println(fmt.Errorf("error computing audit changed fields: %s", err.Error()))
+		} else {
+			auditLog.SetMetadata("changed_only", true)
+		}
+	}
+	return auditLog
+}
 
-	if err := db.Session(&gorm.Session{SkipHooks: true, NewDB: true}).
-		Table(AuditTable).
-		Create(auditLog).
-		Error; err != nil {
-		log.Println(fmt.Errorf("error in audit log creation: %s", err.Error()))
+// captureOldData snapshots the objects of an update before it is applied, the
+// pre-images of their entries by object id
+func captureOldData(db *gorm.DB) {
+	if isAuditTable(db, db.Statement.Table) || db.Error != nil || db.DryRun || memoryStoreOf(db) != nil {
+		return
+	}
+	if !auditsStatement(db) {
 		return
 	}
+	rows, reload := statementRows(db), true
+	if isBatch(db) {
+		// the rows the update matches, it may not match them anymore after
+		matched, err := matchingRows(db)
+		if err != nil {
+			log.Println(fmt.Errorf("error reading audited batch rows: %s", err.Error()))
+			return
+		}
+		db.InstanceSet(settingBatchRows, matched)
+		rows, reload = matched, false
+	}
+	oldData := make(map[string]datatypes.JSON, len(rows))
+	deleted := map[string]bool{}
+	for _, row := range rows {
+		record := row
+		if reload {
+			var err error
+			if record, err = readRow(db, row); err != nil || !record.IsValid() {
+				continue
+			}
+		}
+		recordMap, err := snapshot(db, record)
+		if err != nil {
+			continue
+		}
+		objId := rowObjectId(db, row, recordMap)
+		oldData[objId] = prepareData(recordMap)
+		deleted[objId] = isSoftDeleted(db, record)
+	}
+	db.InstanceSet(settingOldData, oldData)
+	db.InstanceSet(settingWasDeleted, deleted)
 }
 
-// Delete method to add delete audit log hook
-func Delete(db *gorm.DB) {
-	if db.Statement.Schema != nil && db.Statement.Schema.Table == AuditTable || db.Error != nil {
-		return
+// rowObjectId returns the object id of row, recordMap being its snapshot
+func rowObjectId(db *gorm.DB, row reflect.Value, recordMap map[string]interface{}) string {
+	if c, ok := capturerOf(row); ok {
+		if objId := c.AuditObjectId(); objId != "" {
+			return objId
+		}
+		return getKeyFromData("id", recordMap)
+	}
+	if key, ok := primaryKey(db.Statement.Context, db.Statement.Schema, row); ok {
+		return key.objectId()
 	}
+	return getKeyFromData("id", recordMap)
+}
 
-	recordMap, err := getDataBeforeOperation(db)
+// saveAuditLogs inserts logs into the audit table in a single statement, or
+// one per table in LayoutSplit
+func saveAuditLogs(db *gorm.DB, logs []AuditLog) error {
+	logs, injected := injectFaults(db, logs)
+	if store := memoryStoreOf(db); store != nil {
+		store.add(logs...)
+	} else if len(logs) > 0 {
+		if err := storeOf(db).Write(db.Statement.Context, logs); err != nil {
+			return err
+		}
+	}
+	invalidateTrails(db, logs)
+	afterCommit(db, func() { notifyWatchers(logs) })
+	publish(db.Statement.Context, logs)
+	return injected
+}
+
+// getDataBeforeOperation returns the snapshot of the object row, read back
+// from the database
+func getDataBeforeOperation(db *gorm.DB, row reflect.Value) (map[string]interface{}, error) {
+	record, err := readRow(db, row)
 	if err != nil {
-		return
+		return nil, err
 	}
-	objId := getKeyFromData("id", recordMap)
-	auditLog := &AuditLog{
-		TableName:     db.Statement.Schema.Table,
-		OperationType: "DELETE",
-		ObjectId:      objId,
-		Data:          prepareData(recordMap),
-		UserId:        getCurrentUser(db.Statement.Context),
-	}
-	if err := db.Session(&gorm.Session{SkipHooks: true, NewDB: true}).
-		Table(AuditTable).
-		Create(auditLog).
-		Error; err != nil {
-		log.Println(fmt.Errorf("error in audit log creation: %s", err.Error()))
-		return
+	if !record.IsValid() {
+		return map[string]interface{}{}, nil
 	}
+	return snapshot(db, record)
 }
 
-func getDataBeforeOperation(db *gorm.DB) (map[string]interface{}, error) {
-	objMap := map[string]interface{}{}
-	if db.Error == nil && !db.DryRun {
-		objectType := reflect.TypeOf(db.Statement.ReflectValue.Interface())
+// readRow returns the object row as it is in the database, or nothing in dry
+// run sessions and failed statements
+func readRow(db *gorm.DB, row reflect.Value) (reflect.Value, error) {
+	if db.Error != nil {
+		return reflect.Value{}, nil
+	}
+	// the in-memory store snapshots the statement's own value instead of
+	// reading the row back, so it also works in dry run sessions
+	if memoryStoreOf(db) != nil {
+		return row, nil
+	}
+	if !db.DryRun {
+		objectType := reflect.TypeOf(row.Interface())
 
 		// Create a new instance of the object type
 		targetObj := reflect.New(objectType).Interface()
 
-		primaryKeyValue := ""
-		value := db.Statement.ReflectValue
-
-		// Check if the value is a struct
-		if value.Kind() == reflect.Struct {
-			primaryKeyValue = value.FieldByName("Id").String()
+		key, ok := primaryKey(db.Statement.Context, db.Statement.Schema, row)
+		if !ok {
+			log.Println("gorm callback: error while finding target object: no primary key")
+			return reflect.Value{}, gorm.ErrPrimaryKeyRequired
 		}
 
-		// Fetch the target object separately
-		if err := db.Session(&gorm.Session{SkipHooks: true, NewDB: true}).
-			Where("id = ?", primaryKeyValue).
+		// Fetch the target object separately, soft deleted too when the
+		// statement is unscoped
+		query := db.Session(&gorm.Session{SkipHooks: true, NewDB: true}).Table(db.Statement.Table)
+		if db.Statement.Unscoped {
+			query = query.Unscoped()
+		}
+		if err := key.where(query).
 			First(&targetObj).
 			Error; err != nil {
 			log.Println(fmt.Errorf("gorm callback: error while finding target object: %s",
 				err.Error()))
-			return nil, err
+			return reflect.Value{}, err
 		}
+		return reflect.ValueOf(targetObj), nil
+	}
+	return reflect.Value{}, nil
+}
 
-		jsonBytes, err := json.Marshal(targetObj)
-		if err != nil {
-			return nil, err
+// previousData returns the data of the latest entry of the object of entry,
+// looking at entries not written yet first
+func previousData(db *gorm.DB, entry *AuditLog) datatypes.JSON {
+	pending := FromContext(db.Statement.Context).Pending()
+	for i := len(pending) - 1; i >= 0; i-- {
+		if pending[i].TableName == entry.TableName && pending[i].ObjectId == entry.ObjectId {
+			return pending[i].Data
+		}
+	}
+	if store := memoryStoreOf(db); store != nil {
+		if trail := store.TrailFor(entry.TableName, entry.ObjectId); len(trail) > 0 {
+			return trail[len(trail)-1].Data
 		}
-		if err := json.Unmarshal(jsonBytes, &objMap); err != nil {
-			return objMap, err
+		return nil
+	}
+	var previous []AuditLog
+	Query(db.Session(&gorm.Session{SkipHooks: true})).
+		Where("table_name = ? AND object_id = ?", entry.TableName, entry.ObjectId).
+		Order("created_at DESC").
+		Limit(1).
+		Find(&previous)
+	if len(previous) == 0 {
+		return nil
+	}
+	data, err := decryptPayload(db.Statement.Context, payloadAAD(previous[0].Id, "data"), previous[0].Data)
+	if err != nil {
+		log.Println(fmt.Errorf("error decrypting previous audit data: %s", err.Error()))
+		return nil
+	}
+	return data
+}
+
+// snapshot returns the audited representation of obj
+func snapshot(db *gorm.DB, obj reflect.Value) (map[string]interface{}, error) {
+	o := configFor(db)
+	if c, ok := capturerOf(obj); ok && (len(o.redactionKey) == 0 || !hashesFields(db.Statement.Schema)) {
+		objMap := c.AuditSnapshot()
+		for key, strategy := range o.redactions[db.Statement.Table] {
+			redact(o.redactionKey, strategy, key, objMap)
 		}
+		return objMap, nil
+	}
+	jsonBytes, err := json.Marshal(obj.Interface())
+	if err != nil {
+		return nil, err
+	}
+	// decode numbers as json.Number so they aren't rounded through float64
+	objMap, err := decodeSnapshot(jsonBytes)
+	if err != nil {
+		return objMap, err
+	}
+	if decisions := applyFieldOptions(db, obj, objMap); len(decisions) > 0 {
+		db.InstanceSet(settingConsent, decisions)
+	}
+	for key, strategy := range o.redactions[db.Statement.Table] {
+		redact(o.redactionKey, strategy, key, objMap)
 	}
 	return objMap, nil
 }
 
-func RegisterCallbacks(db *gorm.DB) error {
+// RegisterCallbacks audits the changes made through db, configured by opts:
+//
+//	audited.RegisterCallbacks(db, audited.WithTableName("billing_audit_logs"), audited.WithSkipTables("sessions"))
+func RegisterCallbacks(db *gorm.DB, opts ...Option) error {
+	if err := db.Callback().
+		Create().
+		Before("gorm:create").
+		Register("custom_plugin:capture_upsert", captureUpsert); err != nil {
+		return err
+	}
 	if err := db.Callback().
 		Create().
 		After("gorm:create").
 		Register("custom_plugin:create_audit_log", Create); err != nil {
 		return err
 	}
+	if err := db.Callback().
+		Update().
+		Before("gorm:update").
+		Register("custom_plugin:capture_old_data", captureOldData); err != nil {
+		return err
+	}
 	if err := db.Callback().
 		Update().
 		After("gorm:update").
@@ -178,11 +519,72 @@ func RegisterCallbacks(db *gorm.DB) error {
 		Register("custom_plugin:delete_audit_log", Delete); err != nil {
 		return err
 	}
+	if err := db.Callback().
+		Delete().
+		After("gorm:delete").
+		Register("custom_plugin:cascade_purge", cascadePurge); err != nil {
+		return err
+	}
+	if err := db.Callback().
+		Create().
+		After("gorm:create").
+		Register("custom_plugin:count_bulk", countBulk(func(*gorm.DB) string { return OperationCreate })); err != nil {
+		return err
+	}
+	if err := db.Callback().
+		Update().
+		After("gorm:update").
+		Register("custom_plugin:count_bulk", countBulk(func(*gorm.DB) string { return OperationUpdate })); err != nil {
+		return err
+	}
+	if err := db.Callback().
+		Delete().
+		After("gorm:delete").
+		Register("custom_plugin:count_bulk", countBulk(deleteOperation)); err != nil {
+		return err
+	}
+	o := newOptions(opts)
+	if o.async != nil {
+		o.asyncWriter = newAsyncWriter(*o.async)
+		o.asyncWriter.start()
+	}
+	instances.Store(db.Callback(), o)
+	if o.replica != nil {
+		// the replica reads the tables the options give
+		instances.Store(o.replica.Callback(), o)
+	}
+	if o.auditDB != nil {
+		// so does the audit database
+		instances.Store(o.auditDB.Callback(), o)
+	}
 	return nil
 }
 
-// Sample method to retrieve user currently using the system
-func getCurrentUser(ctx context.Context) string {
+// Sample method to retrieve user currently using the system. A user set on
+// the session with SettingUser takes precedence over the database session
+// (see SessionActor), which takes precedence over the UserResolver given to
+// WithUserResolver, which takes precedence over the context.
+func getCurrentUser(db *gorm.DB) string {
+	if user, ok := db.Get(SettingUser); ok {
+		if user, ok := user.(string); ok && user != "" {
+			return user
+		}
+	}
+	if user := sessionActor(db); user != "" {
+		return user
+	}
+	ctx := db.Statement.Context
+	if resolver := configFor(db).userResolver; resolver != nil {
+		user, err := resolver(ctx, db)
+		if err != nil {
+			log.Println(fmt.Errorf("error resolving audit user: %s", err.Error()))
+		} else if user != "" {
+			return user
+		}
+	}
+	if info, ok := WorkflowFrom(ctx); ok && info.UserId != "" && ctx.Value(ContextKeyEmail) == nil {
+		return info.UserId
+	}
 	if ctx.Value(ContextKeyEmail) == nil {
 		log.Println("user not specified in context, please specify user for audit purposes")
 		return "ctx-nonspecified"
@@ -195,10 +597,14 @@ func getKeyFromData(key string, data map[string]interface{}) string {
 	if !ok {
 		return ""
 	}
-	return objId.(string)
+	return formatObjectId(objId)
 }
 
+// prepareData encodes a snapshot in canonical form, see Canonicalize
 func prepareData(data map[string]interface{}) datatypes.JSON {
 	dataByte, _ := json.Marshal(&data)
+	if canonical, err := Canonicalize(dataByte); err == nil {
+		return canonical
+	}
 	return dataByte
 }
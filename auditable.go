@@ -0,0 +1,46 @@
+package audited
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// Auditable is implemented by models choosing whether their changes are
+// audited, e.g. to opt tables in one at a time, see WithOptIn. It is called
+// on the zero value of the model.
+type Auditable interface {
+	Auditable() bool
+}
+
+// WithOptIn audits only the models implementing Auditable with Auditable
+// returning true, instead of every model not opting out
+func WithOptIn() Option {
+	return func(o *options) {
+		o.optIn = true
+	}
+}
+
+// auditsStatement reports whether the changes of the statement of db are
+// audited with an entry per row: they are audited, and not in bulk mode, see
+// BeginBulk
+func auditsStatement(db *gorm.DB) bool {
+	return bulkOf(db.Statement.Context) == nil && auditsModel(db)
+}
+
+// auditsModel reports whether the changes of the statement of db are
+// audited, per the options of db and the Auditable of its model
+func auditsModel(db *gorm.DB) bool {
+	o := configFor(db)
+	if !o.audits(db.Statement.Table) {
+		return false
+	}
+	if db.Statement.Schema == nil {
+		return !o.optIn
+	}
+	auditable, ok := reflect.New(db.Statement.Schema.ModelType).Interface().(Auditable)
+	if !ok {
+		return !o.optIn
+	}
+	return auditable.Auditable()
+}
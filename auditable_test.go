@@ -0,0 +1,55 @@
+package audited
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type optedIn struct{ Id string }
+
+func (optedIn) Auditable() bool { return true }
+
+type optedOut struct{ Id string }
+
+func (*optedOut) Auditable() bool { return false }
+
+type plain struct{ Id string }
+
+func TestAuditsStatement(t *testing.T) {
+	open := func(opts ...Option) *gorm.DB {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := RegisterCallbacks(db, opts...); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	statement := func(db *gorm.DB, model interface{}) *gorm.DB {
+		tx := db.Model(model)
+		if err := tx.Statement.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	byDefault, optIn := open(), open(WithOptIn())
+	for _, tt := range []struct {
+		model              interface{}
+		byDefault, optedIn bool
+	}{
+		{&optedIn{}, true, true},
+		{&optedOut{}, false, false},
+		{&plain{}, true, false},
+	} {
+		if got := auditsStatement(statement(byDefault, tt.model)); got != tt.byDefault {
+			t.Errorf("%T: audited %v by default, want %v", tt.model, got, tt.byDefault)
+		}
+		if got := auditsStatement(statement(optIn, tt.model)); got != tt.optedIn {
+			t.Errorf("%T: audited %v with WithOptIn, want %v", tt.model, got, tt.optedIn)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package audited
+
+import (
+	"gorm.io/gorm"
+)
+
+// WithAuditDB stores the audit trail in auditDB, a connection to another
+// database, e.g. a locked-down server the application can only append to,
+// instead of the database of the changes. Entries are written to it, and
+// Query, the reports and the maintenance of the trail read and write it when
+// given the database of the changes. auditDB mustn't have audit callbacks of
+// its own.
+//
+// Entries can't be written in the transaction of their change. Those of
+// changes made in a Transaction are written once it commits, and dropped when
+// it rolls back; those of other transactions are written when the change is
+// made with the "uncommitted" metadata, as they stay if it rolls back.
+func WithAuditDB(auditDB *gorm.DB) Option {
+	return func(o *options) {
+		o.auditDB = auditDB
+	}
+}
+
+// auditConn returns the audit database of db, see WithAuditDB, with the
+// context of db, or db itself when it has none or is already a session of it
+// or of its read replica
+func auditConn(db *gorm.DB) *gorm.DB {
+	o := configFor(db)
+	if o.auditDB == nil || db.Callback() == o.auditDB.Callback() ||
+		o.replica != nil && db.Callback() == o.replica.Callback() {
+		return db
+	}
+	return o.auditDB.Session(&gorm.Session{NewDB: true, Context: db.Statement.Context})
+}
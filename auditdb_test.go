@@ -0,0 +1,47 @@
+package audited
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestAuditDB(t *testing.T) {
+	open := func() *gorm.DB {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	app, auditDB, replica := open(), open(), open()
+	if err := RegisterCallbacks(app, WithTableName("billing_audit_logs"), WithAuditDB(auditDB), WithReadReplica(replica)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), ContextKeyEmail, "ann@example.com")
+	conn := auditConn(app.WithContext(ctx))
+	if conn.Callback() != auditDB.Callback() {
+		t.Fatal("expected entries to be stored in the audit database")
+	}
+	if conn.Statement.Context != ctx {
+		t.Fatal("expected the audit database to have the context of the change")
+	}
+	if got := auditTable(conn); got != "billing_audit_logs" {
+		t.Fatalf("got table %q in the audit database", got)
+	}
+	if got := auditConn(conn); got != conn {
+		t.Fatal("expected the audit database to be its own")
+	}
+	if query := Query(app); query.Callback() != auditDB.Callback() {
+		t.Fatal("expected queries to read the audit database")
+	}
+	if reader := Reader(app); reader.Callback() != replica.Callback() {
+		t.Fatal("expected the reader to be the replica")
+	}
+	if query := Query(Reader(app)); query.Callback() != replica.Callback() {
+		t.Fatal("expected queries of the reader to read the replica")
+	}
+}
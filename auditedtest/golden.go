@@ -0,0 +1,183 @@
+// Package auditedtest provides helpers for testing code audited by the
+// audited package.
+package auditedtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mleonidas/audited"
+	"gorm.io/datatypes"
+)
+
+var update = flag.Bool("audited.update", false, "rewrite audit golden files")
+
+// NormalizedTime replaces timestamps in normalized entries
+var NormalizedTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// NormalizeMetadata is applied to a copy of the metadata of each normalized
+// entry, to replace values that change across runs. By default it replaces the
+// client IP.
+var NormalizeMetadata = func(metadata map[string]interface{}) {
+	if _, ok := metadata["client_ip"]; ok {
+		metadata["client_ip"] = "192.0.2.1"
+	}
+}
+
+// Normalize returns a copy of entries that is stable across runs: object ids,
+// including where they appear in the snapshots, are replaced by sequential
+// placeholders in order of first appearance, entries are ordered by table and
+// placeholder (keeping write order within an object), ids are replaced by
+// sequential ones, sequence numbers are cleared, timestamps, including time
+// values in the snapshots before and after the change, are replaced by
+// NormalizedTime and metadata is passed through NormalizeMetadata
+func Normalize(entries []audited.AuditLog) []audited.AuditLog {
+	normalized := make([]audited.AuditLog, len(entries))
+	copy(normalized, entries)
+
+	// number the objects of each table in order of first appearance
+	objects := map[string]string{}
+	order := map[string]int{}
+	perTable := map[string]int{}
+	for _, entry := range normalized {
+		if _, ok := objects[entry.ObjectId]; ok || entry.ObjectId == "" {
+			continue
+		}
+		perTable[entry.TableName]++
+		objects[entry.ObjectId] = fmt.Sprintf("%s-%d", entry.TableName, perTable[entry.TableName])
+		order[entry.ObjectId] = perTable[entry.TableName]
+	}
+	sort.SliceStable(normalized, func(i, j int) bool {
+		if normalized[i].TableName != normalized[j].TableName {
+			return normalized[i].TableName < normalized[j].TableName
+		}
+		return order[normalized[i].ObjectId] < order[normalized[j].ObjectId]
+	})
+
+	for i := range normalized {
+		entry := &normalized[i]
+		entry.Id = audited.ID(strconv.Itoa(i + 1))
+		if placeholder, ok := objects[entry.ObjectId]; ok {
+			entry.ObjectId = placeholder
+		}
+		if placeholder, ok := objects[entry.RootObjectId]; ok {
+			entry.RootObjectId = placeholder
+		}
+		entry.Seq = 0
+		entry.CreatedAt = NormalizedTime
+		entry.Data = normalizeData(entry.Data, objects)
+		if len(entry.OldData) > 0 {
+			entry.OldData = normalizeData(entry.OldData, objects)
+		}
+		if entry.Metadata != nil && NormalizeMetadata != nil {
+			metadata := make(map[string]interface{}, len(entry.Metadata))
+			for k, v := range entry.Metadata {
+				metadata[k] = v
+			}
+			NormalizeMetadata(metadata)
+			entry.Metadata = metadata
+		}
+	}
+	return normalized
+}
+
+func normalizeData(data datatypes.JSON, objects map[string]string) datatypes.JSON {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return data
+	}
+	normalized, err := json.Marshal(normalizeValue(v, objects))
+	if err != nil {
+		return data
+	}
+	return normalized
+}
+
+func normalizeValue(v interface{}, objects map[string]string) interface{} {
+	switch v := v.(type) {
+	case string:
+		if placeholder, ok := objects[v]; ok {
+			return placeholder
+		}
+	case map[string]interface{}:
+		if v[audited.TypeKey] == audited.TypeTime {
+			v["value"] = NormalizedTime.Format(time.RFC3339Nano)
+			return v
+		}
+		for key, value := range v {
+			v[key] = normalizeValue(value, objects)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalizeValue(value, objects)
+		}
+	}
+	return v
+}
+
+// Golden compares the normalized entries with testdata/<name>.golden, failing
+// t when they differ. Run the tests with -audited.update to rewrite the file.
+func Golden(t testing.TB, name string, entries []audited.AuditLog) {
+	t.Helper()
+
+	got, err := json.MarshalIndent(Normalize(entries), "", "  ")
+	if err != nil {
+		t.Fatalf("auditedtest: encoding entries: %s", err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("auditedtest: %s", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("auditedtest: %s", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("auditedtest: %s, run with -audited.update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("auditedtest: entries differ from %s:\n%s", path, lineDiff(string(want), string(got)))
+	}
+}
+
+// lineDiff returns the first lines where want and got differ
+func lineDiff(want, got string) string {
+	wantLines := bytes.Split([]byte(want), []byte("\n"))
+	gotLines := bytes.Split([]byte(got), []byte("\n"))
+	var b bytes.Buffer
+	shown := 0
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g []byte
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if bytes.Equal(w, g) {
+			continue
+		}
+		fmt.Fprintf(&b, "line %d:\n- %s\n+ %s\n", i+1, w, g)
+		if shown++; shown == 10 {
+			b.WriteString("...\n")
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package auditedtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mleonidas/audited"
+)
+
+// run returns the entries of one run of a workload using random ids, the
+// way models with uuid.NewString() ids produce them
+func run() []audited.AuditLog {
+	order, line := uuid.NewString(), uuid.NewString()
+	at := time.Now()
+	entry := func(table, object, operation, data string) audited.AuditLog {
+		at = at.Add(time.Millisecond)
+		return audited.AuditLog{
+			Id:            audited.ID(uuid.NewString()),
+			TableName:     table,
+			ObjectId:      object,
+			OperationType: operation,
+			UserId:        "ana@example.com",
+			Data:          []byte(data),
+			Metadata:      map[string]interface{}{"client_ip": uuid.NewString()[:8], "team": "sales"},
+			CreatedAt:     at,
+		}
+	}
+	created := fmt.Sprintf(`{"$type": "time", "value": %q, "offset": "+00:00"}`, at.Format(time.RFC3339Nano))
+	entries := []audited.AuditLog{
+		entry("orders", order, audited.OperationCreate, fmt.Sprintf(`{"id": %q, "status": "new", "created_at": %s}`, order, created)),
+		entry("order_lines", line, audited.OperationCreate, fmt.Sprintf(`{"id": %q, "order_id": %q, "quantity": 2}`, line, order)),
+		entry("orders", order, audited.OperationUpdate, fmt.Sprintf(`{"id": %q, "status": "paid", "created_at": %s}`, order, created)),
+	}
+	entries[1].RootTable, entries[1].RootObjectId = "orders", order
+	entries[2].OldData = entries[0].Data
+	return entries
+}
+
+func TestNormalizeIsStable(t *testing.T) {
+	first, err := json.Marshal(Normalize(run()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := json.Marshal(Normalize(run()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != string(second) {
+		t.Errorf("normalized runs differ:\n%s\n%s", first, second)
+	}
+}
+
+func TestNormalizeOrdersByFirstAppearance(t *testing.T) {
+	a, b := uuid.NewString(), uuid.NewString()
+	entries := Normalize([]audited.AuditLog{
+		{TableName: "orders", ObjectId: b, OperationType: audited.OperationCreate},
+		{TableName: "orders", ObjectId: a, OperationType: audited.OperationCreate},
+		{TableName: "orders", ObjectId: b, OperationType: audited.OperationDelete},
+	})
+	want := []string{"orders-1 CREATE", "orders-1 DELETE", "orders-2 CREATE"}
+	for i, entry := range entries {
+		if got := entry.ObjectId + " " + entry.OperationType; got != want[i] {
+			t.Errorf("entry %d: got %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestGolden(t *testing.T) {
+	Golden(t, "orders", run())
+}
@@ -0,0 +1,175 @@
+// Package auditlint provides an analyzer reporting the writes of a codebase
+// that bypass auditing: raw SQL writes made with Exec or Raw, writes made
+// through database/sql, and databases opened in packages that never register
+// the audit callbacks. Run it with cmd/auditlint, or as a vet tool.
+//
+// UpdateColumn, UpdateColumns and sessions skipping hooks still run the
+// callbacks, so their writes are audited and aren't reported. A write known
+// to be audited otherwise is left out with a comment on or above its line:
+//
+//	//auditlint:ignore entries written by the migration job
+package auditlint
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/types"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+const (
+	gormPath    = "gorm.io/gorm"
+	auditedPath = "github.com/mleonidas/audited"
+	ignore      = "//auditlint:ignore"
+)
+
+// Analyzer reports the writes bypassing auditing
+var Analyzer = &analysis.Analyzer{
+	Name:     "auditlint",
+	Doc:      "report gorm writes bypassing the audit callbacks",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	ignored := ignoredLines(pass)
+	report := func(node ast.Node, format string, args ...interface{}) {
+		position := pass.Fset.Position(node.Pos())
+		if ignored[position.Filename][position.Line] || ignored[position.Filename][position.Line-1] {
+			return
+		}
+		pass.Reportf(node.Pos(), format, args...)
+	}
+
+	var opens []*ast.CallExpr
+	registered := false
+	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	inspect.Preorder([]ast.Node{(*ast.CallExpr)(nil)}, func(n ast.Node) {
+		call := n.(*ast.CallExpr)
+		fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+		if !ok {
+			return
+		}
+		switch {
+		case isFunc(fn, gormPath, "Open"):
+			opens = append(opens, call)
+		case isFunc(fn, auditedPath, "RegisterCallbacks"), isFunc(fn, auditedPath, "New"):
+			registered = true
+		case isMethod(fn, gormPath, "DB", "Exec"):
+			checkSQL(pass, report, call, 0, true)
+		case isMethod(fn, gormPath, "DB", "Raw"):
+			checkSQL(pass, report, call, 0, false)
+		case isMethod(fn, gormPath, "ConnPool", "ExecContext"),
+			isMethod(fn, "database/sql", "DB", "ExecContext"),
+			isMethod(fn, "database/sql", "Tx", "ExecContext"),
+			isMethod(fn, "database/sql", "Conn", "ExecContext"):
+			checkSQL(pass, report, call, 1, true)
+		case isMethod(fn, "database/sql", "DB", "Exec"),
+			isMethod(fn, "database/sql", "Tx", "Exec"):
+			checkSQL(pass, report, call, 0, true)
+		}
+	})
+	if !registered {
+		for _, open := range opens {
+			report(open, "database opened without the audit callbacks, register them with audited.RegisterCallbacks or db.Use(audited.New())")
+		}
+	}
+	return nil, nil
+}
+
+// checkSQL reports the call when its SQL argument at index writes, or when it
+// isn't a constant and unknown is set
+func checkSQL(pass *analysis.Pass, report func(ast.Node, string, ...interface{}), call *ast.CallExpr, index int, unknown bool) {
+	if len(call.Args) <= index {
+		return
+	}
+	value := pass.TypesInfo.Types[call.Args[index]].Value
+	if value == nil || value.Kind() != constant.String {
+		if unknown {
+			report(call, "raw SQL isn't audited, writes it makes bypass the audit callbacks")
+		}
+		return
+	}
+	if statement := writeStatement(constant.StringVal(value)); statement != "" {
+		report(call, "raw %s isn't audited, make the write with gorm so the audit callbacks run", statement)
+	}
+}
+
+var (
+	sqlComments   = regexp.MustCompile(`(?s)^(\s|--[^\n]*\n|/\*.*?\*/)*`)
+	writeWords    = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|MERGE|REPLACE|UPSERT|TRUNCATE)\b`)
+	writeKeywords = map[string]bool{
+		"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "REPLACE": true, "UPSERT": true, "TRUNCATE": true,
+	}
+)
+
+// writeStatement returns the kind of statement of sql when it writes, e.g.
+// UPDATE, empty otherwise
+func writeStatement(sql string) string {
+	fields := strings.Fields(sqlComments.ReplaceAllString(sql, ""))
+	if len(fields) == 0 {
+		return ""
+	}
+	word := strings.ToUpper(fields[0])
+	if word == "WITH" {
+		// the statement of a common table expression follows it
+		if match := writeWords.FindString(sql); match != "" {
+			return strings.ToUpper(match)
+		}
+		return ""
+	}
+	if writeKeywords[word] {
+		return word
+	}
+	return ""
+}
+
+// ignoredLines returns the lines of the //auditlint:ignore comments, by file
+func ignoredLines(pass *analysis.Pass) map[string]map[int]bool {
+	lines := map[string]map[int]bool{}
+	for _, file := range pass.Files {
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				if !strings.HasPrefix(comment.Text, ignore) {
+					continue
+				}
+				position := pass.Fset.Position(comment.Pos())
+				if lines[position.Filename] == nil {
+					lines[position.Filename] = map[int]bool{}
+				}
+				lines[position.Filename][position.Line] = true
+			}
+		}
+	}
+	return lines
+}
+
+func isFunc(fn *types.Func, path, name string) bool {
+	return fn.Pkg() != nil && fn.Pkg().Path() == path && fn.Name() == name &&
+		fn.Type().(*types.Signature).Recv() == nil
+}
+
+// isMethod reports whether fn is the method name of the type path.typeName,
+// or of a pointer to it
+func isMethod(fn *types.Func, path, typeName, name string) bool {
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil || fn.Name() != name {
+		return false
+	}
+	typ := recv.Type()
+	if ptr, ok := typ.(*types.Pointer); ok {
+		typ = ptr.Elem()
+	}
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == path && obj.Name() == typeName
+}
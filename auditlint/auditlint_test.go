@@ -0,0 +1,136 @@
+package auditlint
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+// stubImporter type-checks the packages of testdata/src, such as the stub of
+// gorm, and the standard library from source
+type stubImporter struct {
+	fset *token.FileSet
+	std  types.Importer
+	pkgs map[string]*types.Package
+}
+
+func newStubImporter() *stubImporter {
+	fset := token.NewFileSet()
+	return &stubImporter{fset: fset, std: importer.ForCompiler(fset, "source", nil), pkgs: map[string]*types.Package{}}
+}
+
+func (im *stubImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := im.pkgs[path]; ok {
+		return pkg, nil
+	}
+	if _, err := os.Stat(filepath.Join("testdata", "src", filepath.FromSlash(path))); err != nil {
+		return im.std.Import(path)
+	}
+	pkg, _, _, err := im.check(path)
+	return pkg, err
+}
+
+func (im *stubImporter) check(path string) (*types.Package, []*ast.File, *types.Info, error) {
+	matches, err := filepath.Glob(filepath.Join("testdata", "src", filepath.FromSlash(path), "*.go"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var files []*ast.File
+	for _, match := range matches {
+		file, err := parser.ParseFile(im.fset, match, nil, parser.ParseComments)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, file)
+	}
+	info := &types.Info{
+		Types:      map[ast.Expr]types.TypeAndValue{},
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+	}
+	config := types.Config{Importer: im, Sizes: types.SizesFor("gc", "amd64")}
+	pkg, err := config.Check(path, im.fset, files, info)
+	im.pkgs[path] = pkg
+	return pkg, files, info, err
+}
+
+var wantComment = regexp.MustCompile("// want `([^`]+)`")
+
+// testAnalyzer runs the analyzer on the package path of testdata/src and
+// checks its diagnostics against the `// want` comments of its lines
+func testAnalyzer(t *testing.T, path string) {
+	t.Helper()
+	im := newStubImporter()
+	pkg, files, info, err := im.check(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wants := map[int]*regexp.Regexp{}
+	for _, file := range files {
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				if match := wantComment.FindStringSubmatch(comment.Text); match != nil {
+					wants[im.fset.Position(comment.Pos()).Line] = regexp.MustCompile(match[1])
+				}
+			}
+		}
+	}
+
+	pass := &analysis.Pass{
+		Analyzer:  Analyzer,
+		Fset:      im.fset,
+		Files:     files,
+		Pkg:       pkg,
+		TypesInfo: info,
+		ResultOf:  map[*analysis.Analyzer]interface{}{inspect.Analyzer: inspector.New(files)},
+	}
+	pass.Report = func(d analysis.Diagnostic) {
+		line := im.fset.Position(d.Pos).Line
+		want, ok := wants[line]
+		if !ok || !want.MatchString(d.Message) {
+			t.Errorf("%s:%d: unexpected diagnostic %q", path, line, d.Message)
+			return
+		}
+		delete(wants, line)
+	}
+	if _, err := Analyzer.Run(pass); err != nil {
+		t.Fatal(err)
+	}
+	for line, want := range wants {
+		t.Errorf("%s:%d: no diagnostic matching %q", path, line, want)
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	for _, path := range []string{"writes", "unregistered"} {
+		t.Run(path, func(t *testing.T) {
+			testAnalyzer(t, path)
+		})
+	}
+}
+
+func TestWriteStatement(t *testing.T) {
+	for sql, want := range map[string]string{
+		"update orders set status = 'paid'":            "UPDATE",
+		"/* batch */ INSERT INTO orders VALUES (1)":    "INSERT",
+		"SELECT * FROM orders WHERE status = 'update'": "",
+		"WITH x AS (SELECT 1) SELECT * FROM x":         "",
+		"":                                             "",
+	} {
+		if got := writeStatement(sql); got != want {
+			t.Errorf("writeStatement(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package audited
+
+import "gorm.io/gorm"
+
+type Plugin struct{}
+
+type Option func()
+
+func New(opts ...Option) *Plugin { return &Plugin{} }
+
+func RegisterCallbacks(db *gorm.DB, opts ...Option) error { return nil }
@@ -0,0 +1,8 @@
+package unregistered
+
+import "gorm.io/gorm"
+
+func open() *gorm.DB {
+	db, _ := gorm.Open(nil) // want `database opened without the audit callbacks`
+	return db
+}
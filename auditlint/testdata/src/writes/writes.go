@@ -0,0 +1,40 @@
+package writes
+
+import (
+	"context"
+
+	"github.com/mleonidas/audited"
+	"gorm.io/gorm"
+)
+
+type Order struct {
+	Id     string
+	Status string
+}
+
+func open() *gorm.DB {
+	db, _ := gorm.Open(nil)
+	db.Use(audited.New())
+	return db
+}
+
+func writes(ctx context.Context, db *gorm.DB, order *Order, query string) {
+	db.Create(order)
+	db.Model(order).UpdateColumn("status", "paid")
+	db.Raw("SELECT * FROM orders").Scan(order)
+
+	db.Exec("UPDATE orders SET status = ?", "paid")                                // want `raw UPDATE isn't audited`
+	db.Exec("  -- archive\n  delete from orders WHERE status = 'void'")            // want `raw DELETE isn't audited`
+	db.Raw("INSERT INTO orders (id) VALUES (?) RETURNING id", "1").Scan(order)     // want `raw INSERT isn't audited`
+	db.Exec("WITH paid AS (SELECT id FROM orders) UPDATE orders SET status = 'x'") // want `raw UPDATE isn't audited`
+	db.Exec(query)                                                                 // want `raw SQL isn't audited`
+	db.ConnPool.ExecContext(ctx, "INSERT INTO orders (id) VALUES (1)")             // want `raw INSERT isn't audited`
+
+	sqlDB, _ := db.DB()
+	sqlDB.ExecContext(ctx, "TRUNCATE orders") // want `raw TRUNCATE isn't audited`
+	sqlDB.Exec("CREATE INDEX orders_status ON orders (status)")
+
+	//auditlint:ignore audited by the archive job
+	db.Exec("DELETE FROM orders WHERE status = 'void'")
+	db.Exec(query) //auditlint:ignore migrations
+}
@@ -0,0 +1,87 @@
+// Package auditpb holds the protobuf definition of audit events, for consumers
+// in other languages, and conversions from and to audited.AuditLog
+package auditpb
+
+//go:generate protoc --go_out=.. --go_opt=paths=source_relative --proto_path=.. auditpb/audit.proto
+
+import (
+	"encoding/json"
+
+	"github.com/mleonidas/audited"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/datatypes"
+)
+
+var operationTypes = map[string]OperationType{
+	audited.OperationCreate: OperationType_OPERATION_TYPE_CREATE,
+	audited.OperationUpdate: OperationType_OPERATION_TYPE_UPDATE,
+	audited.OperationDelete: OperationType_OPERATION_TYPE_DELETE,
+	// the proto has no soft delete, restore and upsert types, they map to the
+	// closest ones
+	audited.OperationSoftDelete: OperationType_OPERATION_TYPE_DELETE,
+	audited.OperationHardDelete: OperationType_OPERATION_TYPE_DELETE,
+	audited.OperationRestore:    OperationType_OPERATION_TYPE_UPDATE,
+	audited.OperationUpsert:     OperationType_OPERATION_TYPE_UPDATE,
+}
+
+var operationNames = map[OperationType]string{
+	OperationType_OPERATION_TYPE_CREATE: audited.OperationCreate,
+	OperationType_OPERATION_TYPE_UPDATE: audited.OperationUpdate,
+	OperationType_OPERATION_TYPE_DELETE: audited.OperationDelete,
+}
+
+// FromAuditLog returns entry as an AuditEvent
+func FromAuditLog(entry audited.AuditLog) (*AuditEvent, error) {
+	event := &AuditEvent{
+		Id:             string(entry.Id),
+		TableName:      entry.TableName,
+		OperationType:  operationTypes[entry.OperationType],
+		ObjectId:       entry.ObjectId,
+		Data:           entry.Data,
+		UserId:         entry.UserId,
+		Summary:        entry.Summary,
+		IdempotencyKey: entry.IdempotencyKey,
+		Seq:            entry.Seq,
+		CreatedAt:      timestamppb.New(entry.CreatedAt),
+	}
+	if entry.Metadata != nil {
+		metadata, err := json.Marshal(entry.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		event.Metadata = metadata
+	}
+	return event, nil
+}
+
+// AuditLog returns the event as an audited.AuditLog
+func (x *AuditEvent) AuditLog() (audited.AuditLog, error) {
+	entry := audited.AuditLog{
+		Id:             audited.ID(x.GetId()),
+		TableName:      x.GetTableName(),
+		ObjectId:       x.GetObjectId(),
+		Data:           datatypes.JSON(x.GetData()),
+		UserId:         x.GetUserId(),
+		Summary:        x.GetSummary(),
+		IdempotencyKey: x.GetIdempotencyKey(),
+		Seq:            x.GetSeq(),
+		CreatedAt:      x.GetCreatedAt().AsTime(),
+	}
+	entry.OperationType = operationNames[x.GetOperationType()]
+	if len(x.GetMetadata()) > 0 {
+		if err := json.Unmarshal(x.GetMetadata(), &entry.Metadata); err != nil {
+			return entry, err
+		}
+	}
+	return entry, nil
+}
+
+// Marshal encodes entry as a serialized AuditEvent
+func Marshal(entry audited.AuditLog) ([]byte, error) {
+	event, err := FromAuditLog(entry)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(event)
+}
@@ -0,0 +1,45 @@
+package auditpb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mleonidas/audited"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/datatypes"
+)
+
+func TestRoundTrip(t *testing.T) {
+	entry := audited.AuditLog{
+		Id:             "01890a5d-ac96-774b-bcce-b302099a8057",
+		TableName:      "orders",
+		OperationType:  audited.OperationUpdate,
+		ObjectId:       "7",
+		Data:           datatypes.JSON(`{"id":"7","status":"paid"}`),
+		UserId:         "ann@example.com",
+		Summary:        "status changed",
+		IdempotencyKey: "req-1",
+		Metadata:       datatypes.JSONMap{"team": "sales"},
+		Seq:            42,
+		CreatedAt:      time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+	encoded, err := Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event AuditEvent
+	if err := proto.Unmarshal(encoded, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.GetOperationType() != OperationType_OPERATION_TYPE_UPDATE {
+		t.Fatalf("got operation %v", event.GetOperationType())
+	}
+	decoded, err := event.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, entry) {
+		t.Fatalf("got %+v, want %+v", decoded, entry)
+	}
+}
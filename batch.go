@@ -0,0 +1,74 @@
+package audited
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// settingBatchRows holds the rows matched by the Where clause of a batch
+// statement, read before it runs
+const settingBatchRows = "audited:batch_rows"
+
+// isBatch reports whether the statement of db changes the rows matching its
+// Where clause rather than the object it was given, e.g.
+//
+//	db.Model(&User{}).Where("status = ?", "x").Update("status", "y")
+func isBatch(db *gorm.DB) bool {
+	if db.Statement.Schema == nil {
+		return false
+	}
+	value := reflect.Indirect(db.Statement.ReflectValue)
+	if value.Kind() != reflect.Struct {
+		return false
+	}
+	if _, ok := primaryKey(db.Statement.Context, db.Statement.Schema, value); ok {
+		return false
+	}
+	_, hasWhere := db.Statement.Clauses["WHERE"]
+	return hasWhere || db.AllowGlobalUpdate
+}
+
+// matchingRows returns the rows the Where clause of the statement of db
+// matches
+func matchingRows(db *gorm.DB) ([]reflect.Value, error) {
+	var conds []clause.Expression
+	if where, ok := db.Statement.Clauses["WHERE"]; ok {
+		conds = append(conds, where.Expression)
+	}
+	return selectRows(db, conds...)
+}
+
+// reloadRows returns rows as they are in the database now, read in one query
+// by their primary keys
+func reloadRows(db *gorm.DB, rows []reflect.Value) ([]reflect.Value, error) {
+	keys := make([]clause.Expression, 0, len(rows))
+	for _, row := range rows {
+		if key, ok := primaryKey(db.Statement.Context, db.Statement.Schema, row); ok {
+			keys = append(keys, key.condition())
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return selectRows(db, clause.Where{Exprs: []clause.Expression{clause.Or(keys...)}})
+}
+
+// selectRows returns the rows of the model of the statement of db matching
+// conds, soft deleted ones only when the statement is unscoped
+func selectRows(db *gorm.DB, conds ...clause.Expression) ([]reflect.Value, error) {
+	found := reflect.New(reflect.SliceOf(db.Statement.Schema.ModelType))
+	query := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Table(db.Statement.Table)
+	if db.Statement.Unscoped {
+		query = query.Unscoped()
+	}
+	if err := query.Clauses(conds...).Find(found.Interface()).Error; err != nil {
+		return nil, err
+	}
+	rows := make([]reflect.Value, found.Elem().Len())
+	for i := range rows {
+		rows[i] = found.Elem().Index(i)
+	}
+	return rows, nil
+}
@@ -0,0 +1,34 @@
+package audited
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestIsBatch(t *testing.T) {
+	statement := func(model interface{}) *gorm.DB {
+		db := statementFor(t, model)
+		db.Statement.ReflectValue = reflect.ValueOf(model)
+		return db
+	}
+	global := statement(&Counter{})
+	global.AllowGlobalUpdate = true
+	cases := []struct {
+		name  string
+		db    *gorm.DB
+		batch bool
+	}{
+		{"object", statement(&Counter{ID: 1}), false},
+		{"where", statement(&Counter{}).Where("count > ?", 1), true},
+		{"object and where", statement(&Counter{ID: 1}).Where("count > ?", 1), false},
+		{"no where", statement(&Counter{}), false},
+		{"global update", global, true},
+	}
+	for _, c := range cases {
+		if got := isBatch(c.db); got != c.batch {
+			t.Errorf("%s: got %v", c.name, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var contextKeyBulk = ContextKey("audited_bulk")
+
+// bulk counts the rows changed in bulk mode, by table and operation
+type bulk struct {
+	id     ID
+	mu     sync.Mutex
+	start  time.Time
+	counts map[[2]string]int64
+	// the table and operation pairs in the order they were first changed
+	order [][2]string
+}
+
+// BeginBulk returns a copy of ctx in bulk mode, for large backfills: the
+// changes made with it write no entry per row, FinishBulk writes a summary
+// entry per table and operation instead, with the number of rows changed and
+// the duration of the backfill in its metadata. The beginning of bulk mode is
+// recorded in the meta-audit trail as MetaBulk, with the "bulk_id" the
+// summary entries have in their metadata, so a backfill that never finishes
+// still shows. Tag the backfill with WithSource so its entries tell where
+// they come from.
+func BeginBulk(ctx context.Context, db *gorm.DB) (context.Context, error) {
+	b := &bulk{id: newID(), start: time.Now(), counts: map[[2]string]int64{}}
+	if err := RecordAdminAction(ctx, db, MetaBulk, map[string]interface{}{
+		"bulk_id": b.id, "source": SourceFrom(ctx), "tenant": TenantFrom(ctx),
+	}); err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, contextKeyBulk, b), nil
+}
+
+func bulkOf(ctx context.Context) *bulk {
+	if ctx == nil {
+		return nil
+	}
+	b, _ := ctx.Value(contextKeyBulk).(*bulk)
+	return b
+}
+
+func (b *bulk) add(table, operation string, rows int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := [2]string{table, operation}
+	if _, ok := b.counts[key]; !ok {
+		b.order = append(b.order, key)
+	}
+	b.counts[key] += rows
+}
+
+// countBulk counts the rows changed by the statement of db in bulk mode, once
+// they are committed when it runs in a Transaction
+func countBulk(operation func(db *gorm.DB) string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		b := bulkOf(db.Statement.Context)
+		if b == nil || isAuditTable(db, db.Statement.Table) || db.Error != nil || db.DryRun || !auditsModel(db) {
+			return
+		}
+		if db.RowsAffected > 0 {
+			// the rows of a transaction count once it commits
+			table, op, rows := db.Statement.Table, operation(db), db.RowsAffected
+			afterCommit(db, func() { b.add(table, op, rows) })
+		}
+	}
+}
+
+// FinishBulk writes the summary entries of the changes made in the bulk mode
+// of ctx since it began, or since the last FinishBulk, and returns them. It
+// is a no-op for contexts without BeginBulk.
+func FinishBulk(ctx context.Context, db *gorm.DB) ([]AuditLog, error) {
+	b := bulkOf(ctx)
+	if b == nil {
+		return nil, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.order) == 0 {
+		return nil, nil
+	}
+
+	db = db.WithContext(ctx)
+	duration := time.Since(b.start)
+	logs := make([]AuditLog, 0, len(b.order))
+	for _, key := range b.order {
+		rows := b.counts[key]
+		entry := AuditLog{
+			Id:            newID(),
+			TableName:     key[0],
+			OperationType: key[1],
+			UserId:        getCurrentUser(db),
+			Domain:        configFor(db).domains[key[0]],
+			Source:        SourceFrom(ctx),
+			TenantId:      TenantFrom(ctx),
+			Summary:       fmt.Sprintf("%s of %d rows in bulk", key[1], rows),
+		}
+		entry.SetMetadata("bulk", true)
+		entry.SetMetadata("bulk_id", b.id)
+		entry.SetMetadata("rows", rows)
+		entry.SetMetadata("duration_ms", duration.Milliseconds())
+		entry.SetMetadata("started_at", b.start.UTC().Format(time.RFC3339))
+		if service := configFor(db).serviceName; service != "" {
+			entry.SetMetadata("service", service)
+		}
+		if region := residencyOf(ctx); region != "" {
+			entry.SetMetadata("residency", region)
+		}
+		logs = append(logs, entry)
+	}
+	if err := saveAuditLogs(db, logs); err != nil {
+		return nil, err
+	}
+	b.start, b.counts, b.order = time.Now(), map[[2]string]int64{}, nil
+	return logs, nil
+}
@@ -0,0 +1,53 @@
+package audited
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestBulk(t *testing.T) {
+	store := NewMemoryStore()
+	db := statementFor(t, &Invoice{})
+	if err := RegisterCallbacks(db, WithMemoryStore(store)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := BeginBulk(WithSource(context.Background(), "backfill:invoice_totals"), db.Session(&gorm.Session{DryRun: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = db.WithContext(ctx)
+	if auditsStatement(db) {
+		t.Fatal("expected no entry per row in bulk mode")
+	}
+
+	count := countBulk(func(*gorm.DB) string { return OperationUpdate })
+	for _, rows := range []int64{500, 0, 250} {
+		db.RowsAffected = rows
+		count(db)
+	}
+	logs, err := FinishBulk(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || len(store.Entries()) != 1 {
+		t.Fatalf("got %d summary entries, %d stored, want 1", len(logs), len(store.Entries()))
+	}
+	entry := logs[0]
+	if entry.TableName != "invoices" || entry.OperationType != OperationUpdate || entry.Source != "backfill:invoice_totals" {
+		t.Fatalf("got summary entry %+v", entry)
+	}
+	if rows := entry.Metadata["rows"]; rows != int64(750) {
+		t.Fatalf("got %v rows, want 750", rows)
+	}
+	if id := entry.Metadata["bulk_id"]; id != bulkOf(ctx).id {
+		t.Fatalf("got bulk id %v, want the one of the start", id)
+	}
+	if logs, _ := FinishBulk(ctx, db); logs != nil {
+		t.Fatalf("got %v summary entries without changes since the last", logs)
+	}
+	if logs, _ := FinishBulk(context.Background(), db); logs != nil {
+		t.Fatal("got summary entries without bulk mode")
+	}
+}
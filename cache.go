@@ -0,0 +1,142 @@
+package audited
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TrailCache caches the trails of objects for TrailFor, e.g. in process with
+// NewLRUTrailCache or shared in Redis
+type TrailCache interface {
+	Get(ctx context.Context, key string) ([]AuditLog, bool)
+	Set(ctx context.Context, key string, trail []AuditLog)
+	Delete(ctx context.Context, key string)
+}
+
+// TrailCacheStore makes TrailFor read through the cache, nil disables it. The
+// trail of an object is invalidated when an entry is written for it by this
+// process, once the transaction of the change commits when it runs in a
+// Transaction, and when retention, quotas, the cold tier or purges remove or
+// move its entries; entries written by other processes are only seen once
+// cached trails expire, unless the cache is shared.
+var TrailCacheStore TrailCache
+
+// WithCacheNamespace identifies the audit trail of the database in the keys
+// of TrailCacheStore, so databases with the same audit table sharing a cache,
+// e.g. one per tenant, don't read the trails of each other
+func WithCacheNamespace(namespace string) Option {
+	return func(o *options) {
+		o.cacheNamespace = namespace
+	}
+}
+
+// TrailCacheKey returns the key the trail of an object is cached under, scoped
+// by the cache namespace and the audit table of db
+func TrailCacheKey(db *gorm.DB, table, objectId string) string {
+	return "audited:trail:" + configFor(db).cacheNamespace + ":" + auditTable(db) + ":" + table + ":" + objectId
+}
+
+// invalidateTrails drops the cached trails of the objects of logs, right away
+// and again once the transaction of db commits when it runs in a Transaction,
+// so a trail read in between is dropped too
+func invalidateTrails(db *gorm.DB, logs []AuditLog) {
+	cache := TrailCacheStore
+	if cache == nil || len(logs) == 0 {
+		return
+	}
+	ctx := db.Statement.Context
+	drop := func() {
+		for _, entry := range logs {
+			cache.Delete(ctx, TrailCacheKey(db, entry.TableName, entry.ObjectId))
+		}
+	}
+	drop()
+	if heldUntilCommit(db) {
+		afterCommit(db, drop)
+	}
+}
+
+// cachedObjects returns the objects of the entries matching cond, whose
+// cached trails go stale when the entries are removed or moved, none when
+// trails aren't cached
+func cachedObjects(db *gorm.DB, cond string, args ...interface{}) ([]AuditLog, error) {
+	if TrailCacheStore == nil {
+		return nil, nil
+	}
+	var objects []AuditLog
+	err := QueryOperations(db).Select("DISTINCT table_name, object_id").Where(cond, args...).Scan(&objects).Error
+	return objects, err
+}
+
+// LRUTrailCache is an in process TrailCache keeping the most recently used
+// trails for up to a TTL
+type LRUTrailCache struct {
+	size int
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+}
+
+type cachedTrail struct {
+	key     string
+	trail   []AuditLog
+	expires time.Time
+}
+
+// NewLRUTrailCache returns a cache of up to size trails, each kept for up to
+// ttl, which bounds how stale a trail written to by other processes can get
+func NewLRUTrailCache(size int, ttl time.Duration) *LRUTrailCache {
+	return &LRUTrailCache{size: size, ttl: ttl, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// Get returns a copy of the trail cached under key
+func (c *LRUTrailCache) Get(ctx context.Context, key string) ([]AuditLog, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	cached := element.Value.(*cachedTrail)
+	if time.Now().After(cached.expires) {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return append([]AuditLog(nil), cached.trail...), true
+}
+
+// Set caches trail under key, evicting the least recently used trail when full
+func (c *LRUTrailCache) Set(ctx context.Context, key string, trail []AuditLog) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached := &cachedTrail{key: key, trail: append([]AuditLog(nil), trail...), expires: time.Now().Add(c.ttl)}
+	if element, ok := c.items[key]; ok {
+		element.Value = cached
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.order.PushFront(cached)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cachedTrail).key)
+	}
+}
+
+// Delete drops the trail cached under key
+func (c *LRUTrailCache) Delete(ctx context.Context, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.order.Remove(element)
+		delete(c.items, key)
+	}
+}
@@ -0,0 +1,72 @@
+package audited
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLRUTrailCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUTrailCache(2, time.Hour)
+	cache.Set(ctx, "a", []AuditLog{{Id: "1"}})
+	cache.Set(ctx, "b", []AuditLog{{Id: "2"}})
+	if _, ok := cache.Get(ctx, "a"); !ok {
+		t.Fatal("a is not cached")
+	}
+	// b is now the least recently used
+	cache.Set(ctx, "c", []AuditLog{{Id: "3"}})
+	if _, ok := cache.Get(ctx, "b"); ok {
+		t.Fatal("least recently used trail was not evicted")
+	}
+	trail, ok := cache.Get(ctx, "a")
+	if !ok || len(trail) != 1 || trail[0].Id != "1" {
+		t.Fatalf("got trail %v", trail)
+	}
+	trail[0].Id = "changed"
+	if again, _ := cache.Get(ctx, "a"); again[0].Id != "1" {
+		t.Fatal("changing a returned trail changed the cache")
+	}
+
+	expiring := NewLRUTrailCache(2, -time.Second)
+	expiring.Set(ctx, "a", nil)
+	if _, ok := expiring.Get(ctx, "a"); ok {
+		t.Fatal("expired trail was returned")
+	}
+}
+
+func TestInvalidateTrails(t *testing.T) {
+	defer func(previous TrailCache) { TrailCacheStore = previous }(TrailCacheStore)
+	ctx := context.Background()
+	cache := NewLRUTrailCache(10, time.Hour)
+	TrailCacheStore = cache
+	db := statementFor(t, &Invoice{})
+	cache.Set(ctx, TrailCacheKey(db, "orders", "1"), []AuditLog{{Id: "1"}})
+	cache.Set(ctx, TrailCacheKey(db, "orders", "2"), []AuditLog{{Id: "2"}})
+
+	invalidateTrails(db, []AuditLog{{TableName: "orders", ObjectId: "1"}})
+	if _, ok := cache.Get(ctx, TrailCacheKey(db, "orders", "1")); ok {
+		t.Fatal("trail of the written object is still cached")
+	}
+	if _, ok := cache.Get(ctx, TrailCacheKey(db, "orders", "2")); !ok {
+		t.Fatal("trail of another object was invalidated")
+	}
+}
+
+func TestTrailCacheKey(t *testing.T) {
+	keys := map[string]bool{}
+	for _, opts := range [][]Option{
+		nil,
+		{WithTableName("billing_audit_logs")},
+		{WithCacheNamespace("tenant-a")},
+		{WithCacheNamespace("tenant-b")},
+	} {
+		db := statementFor(t, &Invoice{})
+		RegisterCallbacks(db, opts...)
+		key := TrailCacheKey(db, "orders", "1")
+		if keys[key] {
+			t.Fatalf("key %s shared by two audit trails", key)
+		}
+		keys[key] = true
+	}
+}
@@ -0,0 +1,27 @@
+package audited
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+	}{
+		{"sorted keys", `{ "b": 1, "a": {"d": [1, 2], "c": null} }`, `{"a":{"c":null,"d":[1,2]},"b":1}`},
+		{"utf-16 key order", `{"\ufb01": 1, "😀": 2, "z": 3}`, `{"z":3,"😀":2,"ﬁ":1}`},
+		{"numbers", `[1.50, 0.10, 1e3, -0, 0.0, 1E-7, 1e21, 123456789012345678901234567890, 0.1000000000000000055]`,
+			`[1.5,0.1,1000,0,0,1e-7,1e+21,123456789012345678901234567890,0.1000000000000000055]`},
+		{"strings", `"<a href=\"x\">é\t\u0001 </a>"`, "\"<a href=\\\"x\\\">é\\t\\u0001 </a>\""},
+	}
+	for _, c := range cases {
+		got, err := Canonicalize([]byte(c.in))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if string(got) != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+	if _, err := Canonicalize([]byte(`{"a": 1} {}`)); err == nil {
+		t.Error("accepted trailing data")
+	}
+}
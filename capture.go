@@ -0,0 +1,86 @@
+package audited
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Capturer is implemented by models with capture code generated by
+// cmd/auditgen, their snapshots and object ids are taken without reflection.
+// Models with a formatter registered are still captured with reflection, as
+// are models with hashed fields on a database with a redaction key, which
+// the generated code doesn't have.
+type Capturer interface {
+	// AuditSnapshot returns the snapshot of the object with the `audited`
+	// tags of its fields applied
+	AuditSnapshot() map[string]interface{}
+	// AuditObjectId returns the object id of the primary key of the object,
+	// empty when it isn't set
+	AuditObjectId() string
+}
+
+// capturerOf returns the generated capture code of obj, if any
+func capturerOf(obj reflect.Value) (Capturer, bool) {
+	obj = reflect.Indirect(obj)
+	if !obj.IsValid() || !obj.CanInterface() {
+		return nil, false
+	}
+	c, ok := obj.Interface().(Capturer)
+	if !ok || hasFormatters(obj.Type()) {
+		return nil, false
+	}
+	return c, true
+}
+
+// CaptureValue returns v as it is stored in snapshots, for generated capture
+// code: times, decimals, sql.Null types and friends in their typed form,
+// other values as encoding/json writes them
+func CaptureValue(v interface{}) interface{} {
+	if encoded, ok := encodeValue(reflect.ValueOf(v)); ok {
+		return encoded
+	}
+	data, err := json.Marshal(map[string]interface{}{"v": v})
+	if err != nil {
+		return nil
+	}
+	decoded, err := decodeSnapshot(data)
+	if err != nil {
+		return nil
+	}
+	return decoded["v"]
+}
+
+// IsEmptyValue reports whether encoding/json leaves v out of a field tagged
+// omitempty, for generated capture code
+func IsEmptyValue(v interface{}) bool {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Ptr:
+		return value.IsZero()
+	}
+	return false
+}
+
+// Redact replaces the value of key in data per strategy, RedactMask or
+// RedactHash without a key, for generated capture code
+func Redact(strategy, key string, data map[string]interface{}) {
+	redact(nil, strategy, key, data)
+}
+
+// FormatObjectId formats a primary key value as an object id, for generated
+// capture code
+func FormatObjectId(key interface{}) string {
+	return formatObjectId(key)
+}
+
+// CompositeObjectId returns the object id of a primary key of several
+// columns, by column name, for generated capture code
+func CompositeObjectId(key map[string]interface{}) string {
+	return string(prepareData(key))
+}
@@ -0,0 +1,101 @@
+package audited
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type Shipment struct {
+	Id        uint      `json:"id"`
+	Carrier   string    `json:"carrier"`
+	Recipient string    `json:"recipient" audited:"mask"`
+	Weight    int       `json:"weight,omitempty"`
+	ShippedAt time.Time `json:"shipped_at"`
+}
+
+// as written by cmd/auditgen
+func (m Shipment) AuditSnapshot() map[string]interface{} {
+	data := make(map[string]interface{}, 5)
+	data["id"] = json.Number(strconv.FormatUint(uint64(m.Id), 10))
+	data["carrier"] = m.Carrier
+	data["recipient"] = m.Recipient
+	if m.Weight != 0 {
+		data["weight"] = json.Number(strconv.FormatInt(int64(m.Weight), 10))
+	}
+	data["shipped_at"] = CaptureValue(m.ShippedAt)
+	Redact(RedactMask, "recipient", data)
+	return data
+}
+
+func (m Shipment) AuditObjectId() string {
+	if m.Id == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(m.Id), 10)
+}
+
+func TestCapturer(t *testing.T) {
+	shipment := &Shipment{Id: 7, Carrier: "ups", Recipient: "Ann", ShippedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	db := statementFor(t, shipment)
+	RegisterCallbacks(db, WithRedaction(db.Statement.Table, map[string]string{"carrier": RedactHash}))
+
+	captured, err := snapshot(db, reflect.ValueOf(shipment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := capturerOf(reflect.ValueOf(shipment)); !ok {
+		t.Fatal("generated capture code not used")
+	}
+	if captured["carrier"] == "ups" {
+		t.Fatal("redaction of the config not applied to the generated snapshot")
+	}
+	if objId := rowObjectId(db, reflect.ValueOf(shipment), captured); objId != "7" {
+		t.Fatalf("got object id %q, want 7", objId)
+	}
+
+	// the generated snapshot is the one taken with reflection
+	reflected, err := json.Marshal(shipment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := decodeSnapshot(reflected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyFieldOptions(db, reflect.ValueOf(shipment), want)
+	redact(nil, RedactHash, "carrier", want)
+	if !reflect.DeepEqual(captured, want) {
+		t.Fatalf("got %v, want %v", captured, want)
+	}
+
+	// formatters need reflection
+	RegisterFormatter(Shipment{}, "Carrier", func(v interface{}) interface{} { return v })
+	defer func() {
+		formatters.Lock()
+		delete(formatters.m, reflect.TypeOf(Shipment{}))
+		formatters.Unlock()
+	}()
+	if _, ok := capturerOf(reflect.ValueOf(shipment)); ok {
+		t.Fatal("generated capture code used for a model with a formatter")
+	}
+}
+
+func TestCaptureValue(t *testing.T) {
+	var nilTime *time.Time
+	for _, tc := range []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{nilTime, nil},
+		{1.5, json.Number("1.5")},
+		{[]string{"a"}, []interface{}{"a"}},
+		{time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), encodeTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))},
+	} {
+		if got := CaptureValue(tc.value); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("CaptureValue(%v) = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
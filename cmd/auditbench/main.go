@@ -0,0 +1,243 @@
+// Command auditbench generates write workloads against a postgres database
+// through the audit callbacks and reports throughput and latency, to size
+// hardware before enabling auditing in production.
+//
+//	auditbench -dsn "postgres://localhost/bench" -tables 4 -payload 2048 -concurrency 16 -duration 1m
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	mrand "math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mleonidas/audited"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type benchRow struct {
+	Id        string `gorm:"primaryKey"`
+	Payload   string
+	Revision  int
+	UpdatedAt time.Time
+}
+
+type config struct {
+	dsn         string
+	tables      int
+	payload     int
+	concurrency int
+	duration    time.Duration
+	updates     float64
+	deletes     float64
+	baseline    bool
+	setup       bool
+}
+
+type result struct {
+	op      string
+	latency time.Duration
+	err     error
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.dsn, "dsn", os.Getenv("AUDITBENCH_DSN"), "postgres DSN of the target database")
+	flag.IntVar(&cfg.tables, "tables", 1, "number of tables to spread writes over")
+	flag.IntVar(&cfg.payload, "payload", 512, "payload size of each row in bytes")
+	flag.IntVar(&cfg.concurrency, "concurrency", 8, "number of concurrent writers")
+	flag.DurationVar(&cfg.duration, "duration", 30*time.Second, "how long to generate load")
+	flag.Float64Var(&cfg.updates, "updates", 0.6, "fraction of operations that update an existing row")
+	flag.Float64Var(&cfg.deletes, "deletes", 0.1, "fraction of operations that delete an existing row")
+	flag.BoolVar(&cfg.baseline, "baseline", false, "run without the audit callbacks, for comparison")
+	flag.BoolVar(&cfg.setup, "setup", true, "create the bench tables and the audit table if missing")
+	flag.Parse()
+
+	if cfg.dsn == "" {
+		log.Fatal("auditbench: -dsn is required")
+	}
+
+	db, err := gorm.Open(postgres.Open(cfg.dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		log.Fatalf("auditbench: %s", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.SetMaxOpenConns(cfg.concurrency * 2)
+	}
+	if cfg.setup {
+		if err := setup(db, cfg.tables); err != nil {
+			log.Fatalf("auditbench: setup: %s", err)
+		}
+	}
+	if !cfg.baseline {
+		if err := audited.RegisterCallbacks(db); err != nil {
+			log.Fatalf("auditbench: %s", err)
+		}
+	}
+
+	results := run(db, cfg)
+	report(results, cfg)
+}
+
+func setup(db *gorm.DB, tables int) error {
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS audit_logs(
+		id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+		table_name varchar,
+		operation_type varchar,
+		object_id varchar,
+		data jsonb,
+		old_data jsonb,
+		user_id varchar,
+		owner_id varchar,
+		domain varchar,
+		actor_ip varchar,
+		user_agent varchar,
+		request_id varchar,
+		session_id varchar,
+		source varchar,
+		tenant_id varchar,
+		root_table varchar,
+		root_object_id varchar,
+		summary varchar,
+		idempotency_key varchar NOT NULL DEFAULT '',
+		metadata jsonb,
+		seq bigserial UNIQUE,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`).Error; err != nil {
+		return err
+	}
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS audit_field_changes(
+		id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+		audit_id uuid,
+		table_name varchar,
+		object_id varchar,
+		field varchar,
+		old_value jsonb,
+		new_value jsonb,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`).Error; err != nil {
+		return err
+	}
+	for i := 0; i < tables; i++ {
+		if err := db.Table(tableName(i)).AutoMigrate(&benchRow{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func run(db *gorm.DB, cfg config) []result {
+	ctx, cancel := context.WithTimeout(
+		context.WithValue(context.Background(), audited.ContextKeyEmail, "auditbench"),
+		cfg.duration,
+	)
+	defer cancel()
+
+	payload := make([]byte, cfg.payload/2+1)
+	if _, err := rand.Read(payload); err != nil {
+		log.Fatalf("auditbench: %s", err)
+	}
+	body := hex.EncodeToString(payload)[:cfg.payload]
+
+	var (
+		mu      sync.Mutex
+		results []result
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < cfg.concurrency; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := mrand.New(mrand.NewSource(seed))
+			var ids []string
+			var local []result
+			for ctx.Err() == nil {
+				table := tableName(rng.Intn(cfg.tables))
+				tx := db.WithContext(ctx).Table(table)
+				start := time.Now()
+				var r result
+
+				switch p := rng.Float64(); {
+				case len(ids) > 0 && p < cfg.deletes:
+					i := rng.Intn(len(ids))
+					r = result{op: "delete", err: tx.Delete(&benchRow{Id: ids[i]}).Error}
+					ids = append(ids[:i], ids[i+1:]...)
+				case len(ids) > 0 && p < cfg.deletes+cfg.updates:
+					row := benchRow{Id: ids[rng.Intn(len(ids))], Payload: body, Revision: rng.Int()}
+					r = result{op: "update", err: tx.Model(&row).Updates(&row).Error}
+				default:
+					row := benchRow{Id: uuid.NewString(), Payload: body}
+					r = result{op: "create", err: tx.Create(&row).Error}
+					ids = append(ids, row.Id)
+				}
+				r.latency = time.Since(start)
+				if ctx.Err() == nil {
+					local = append(local, r)
+				}
+			}
+			mu.Lock()
+			results = append(results, local...)
+			mu.Unlock()
+		}(time.Now().UnixNano() + int64(w))
+	}
+	wg.Wait()
+	return results
+}
+
+func report(results []result, cfg config) {
+	byOp := map[string][]time.Duration{}
+	errors := map[string]int{}
+	for _, r := range results {
+		if r.err != nil {
+			errors[r.op]++
+			continue
+		}
+		byOp[r.op] = append(byOp[r.op], r.latency)
+		byOp["all"] = append(byOp["all"], r.latency)
+	}
+
+	mode := "audited"
+	if cfg.baseline {
+		mode = "baseline"
+	}
+	fmt.Printf("mode=%s tables=%d payload=%dB concurrency=%d duration=%s\n",
+		mode, cfg.tables, cfg.payload, cfg.concurrency, cfg.duration)
+	fmt.Printf("%-8s %10s %10s %10s %10s %10s %8s\n", "op", "count", "ops/s", "p50", "p95", "p99", "errors")
+	for _, op := range []string{"create", "update", "delete", "all"} {
+		latencies := byOp[op]
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		errCount := errors[op]
+		if op == "all" {
+			errCount = errors["create"] + errors["update"] + errors["delete"]
+		}
+		fmt.Printf("%-8s %10d %10.1f %10s %10s %10s %8d\n", op, len(latencies),
+			float64(len(latencies))/cfg.duration.Seconds(),
+			percentile(latencies, 0.50), percentile(latencies, 0.95), percentile(latencies, 0.99), errCount)
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Microsecond)
+}
+
+func tableName(i int) string {
+	return fmt.Sprintf("auditbench_rows_%d", i)
+}
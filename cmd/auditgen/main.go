@@ -0,0 +1,480 @@
+// Command auditgen generates the capture code of models, implementing
+// audited.Capturer, so their snapshots and object ids are taken without
+// reflection. Run it with go generate in the package of the models:
+//
+//	//go:generate go run github.com/mleonidas/audited/cmd/auditgen -type User,Invoice
+//
+// The capture code of the first type and the others is written to
+// user_audited.go. Fields of types other than strings, booleans and integers
+// are still encoded with audited.CaptureValue. Models with fields tagged
+// `audited:"binary=..."` or `audited:"consent=..."` aren't supported, they
+// are captured with reflection.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+func main() {
+	typeNames := flag.String("type", "", "comma separated names of the models to generate capture code for")
+	output := flag.String("output", "", "file to write, <first type>_audited.go by default")
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	names := strings.Split(*typeNames, ",")
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	if *output == "" {
+		*output = filepath.Join(dir, strings.ToLower(names[0])+"_audited.go")
+	}
+
+	pkg, files, err := parsePackage(dir, filepath.Base(*output))
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := generate(pkg, files, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*output, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parsePackage parses the go files of the package in dir, leaving out tests
+// and the generated file
+func parsePackage(dir, generated string) (string, []*ast.File, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", nil, err
+	}
+	fset := token.NewFileSet()
+	var pkg string
+	var files []*ast.File
+	for _, path := range matches {
+		name := filepath.Base(path)
+		if strings.HasSuffix(name, "_test.go") || name == generated {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return "", nil, err
+		}
+		if pkg == "" {
+			pkg = file.Name.Name
+		}
+		if file.Name.Name == pkg {
+			files = append(files, file)
+		}
+	}
+	if pkg == "" {
+		return "", nil, fmt.Errorf("no go files in %s", dir)
+	}
+	return pkg, files, nil
+}
+
+// field is a field of a model as it appears in its snapshot
+type field struct {
+	path      string // selector of the field on the model, e.g. Model.ID
+	key       string // key in the snapshot
+	typ       ast.Expr
+	omitEmpty bool
+	redact    string
+	primary   bool
+	column    string
+	depth     int
+}
+
+// gormModel are the fields of the embedded gorm.Model
+var gormModel = []field{
+	{path: "ID", key: "ID", typ: ast.NewIdent("uint"), primary: true, column: "id"},
+	{path: "CreatedAt", key: "CreatedAt", typ: &ast.SelectorExpr{X: ast.NewIdent("time"), Sel: ast.NewIdent("Time")}, column: "created_at"},
+	{path: "UpdatedAt", key: "UpdatedAt", typ: &ast.SelectorExpr{X: ast.NewIdent("time"), Sel: ast.NewIdent("Time")}, column: "updated_at"},
+	{path: "DeletedAt", key: "DeletedAt", typ: &ast.SelectorExpr{X: ast.NewIdent("gorm"), Sel: ast.NewIdent("DeletedAt")}, column: "deleted_at"},
+}
+
+var naming = schema.NamingStrategy{}
+
+// structFields returns the fields of the snapshots of the struct st, as
+// encoding/json writes them
+func structFields(structs map[string]*ast.StructType, st *ast.StructType, prefix string, depth int) ([]field, error) {
+	var fields []field
+	for _, f := range st.Fields.List {
+		tag := reflect.StructTag("")
+		if f.Tag != nil {
+			unquoted, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(unquoted)
+		}
+		jsonName, jsonOpts, _ := strings.Cut(tag.Get("json"), ",")
+		if jsonName == "-" && jsonOpts == "" {
+			continue
+		}
+		if hasOption(jsonOpts, "string") {
+			return nil, errors.New("field tagged json:\",string\" is captured with reflection")
+		}
+		audited := parseTag(tag.Get("audited"))
+		if _, ok := audited["-"]; ok {
+			continue
+		}
+		for _, unsupported := range []string{"binary", "consent"} {
+			if _, ok := audited[unsupported]; ok {
+				return nil, fmt.Errorf("field tagged audited:%q is captured with reflection", unsupported)
+			}
+		}
+
+		if len(f.Names) == 0 {
+			embedded, err := embeddedFields(structs, f.Type, prefix, depth)
+			if err != nil {
+				return nil, err
+			}
+			if jsonName == "" {
+				fields = append(fields, embedded...)
+				continue
+			}
+			return nil, fmt.Errorf("embedded field tagged json:%q is captured with reflection", jsonName)
+		}
+
+		for _, name := range f.Names {
+			if !name.IsExported() {
+				continue
+			}
+			fd := field{path: prefix + name.Name, key: name.Name, typ: f.Type, depth: depth}
+			if jsonName != "" {
+				fd.key = jsonName
+			}
+			fd.omitEmpty = hasOption(jsonOpts, "omitempty")
+			for _, strategy := range []string{"mask", "hash"} {
+				if _, ok := audited[strategy]; ok {
+					fd.redact = strategy
+					break
+				}
+			}
+			fd.column, fd.primary = gormColumn(tag.Get("gorm"), name.Name)
+			fields = append(fields, fd)
+		}
+	}
+	return fields, nil
+}
+
+// embeddedFields returns the fields promoted from the embedded field of type
+// typ
+func embeddedFields(structs map[string]*ast.StructType, typ ast.Expr, prefix string, depth int) ([]field, error) {
+	switch t := typ.(type) {
+	case *ast.Ident:
+		st, ok := structs[t.Name]
+		if !ok {
+			return nil, fmt.Errorf("embedded %s isn't a struct of the package", t.Name)
+		}
+		return structFields(structs, st, prefix+t.Name+".", depth+1)
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && pkg.Name == "gorm" && t.Sel.Name == "Model" {
+			fields := make([]field, len(gormModel))
+			for i, f := range gormModel {
+				f.path = prefix + "Model." + f.path
+				f.depth = depth + 1
+				fields[i] = f
+			}
+			return fields, nil
+		}
+	}
+	return nil, fmt.Errorf("embedded %s is captured with reflection", exprString(typ))
+}
+
+// gormColumn returns the column of the field name and whether it is tagged as
+// a primary key
+func gormColumn(tag, name string) (column string, primary bool) {
+	column = naming.ColumnName("", name)
+	for _, setting := range strings.Split(tag, ";") {
+		key, value, _ := strings.Cut(setting, ":")
+		switch strings.ToUpper(strings.TrimSpace(key)) {
+		case "COLUMN":
+			column = strings.TrimSpace(value)
+		case "PRIMARYKEY", "PRIMARY_KEY":
+			primary = true
+		}
+	}
+	return column, primary
+}
+
+// parseTag splits an `audited` struct tag into its options, as the plugin does
+func parseTag(tag string) map[string]string {
+	opts := map[string]string{}
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(part, "=")
+		opts[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return opts
+}
+
+func hasOption(opts, option string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// dominant returns the fields encoding/json writes, the shallowest of those
+// with the same key
+func dominant(fields []field) ([]field, error) {
+	shallowest := map[string]int{}
+	count := map[string]int{}
+	for _, f := range fields {
+		if d, ok := shallowest[f.key]; !ok || f.depth < d {
+			shallowest[f.key] = f.depth
+			count[f.key] = 0
+		}
+		if f.depth == shallowest[f.key] {
+			count[f.key]++
+		}
+	}
+	var kept []field
+	for _, f := range fields {
+		if f.depth != shallowest[f.key] {
+			continue
+		}
+		if count[f.key] > 1 {
+			return nil, fmt.Errorf("ambiguous field %s", f.key)
+		}
+		kept = append(kept, f)
+	}
+	return kept, nil
+}
+
+// primaryFields returns the fields of the primary key as gorm resolves it:
+// those tagged primaryKey, or else the id column
+func primaryFields(fields []field) []field {
+	var primary []field
+	for _, f := range fields {
+		if f.primary {
+			primary = append(primary, f)
+		}
+	}
+	if len(primary) > 0 {
+		return primary
+	}
+	for _, f := range fields {
+		if f.column == "id" {
+			return []field{f}
+		}
+	}
+	return nil
+}
+
+var (
+	intTypes  = map[string]bool{"int": true, "int8": true, "int16": true, "int32": true, "int64": true}
+	uintTypes = map[string]bool{"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true}
+)
+
+// builtin returns the name of the predeclared type typ, empty for others
+func builtin(typ ast.Expr) string {
+	if ident, ok := typ.(*ast.Ident); ok && types.Universe.Lookup(ident.Name) != nil {
+		return ident.Name
+	}
+	return ""
+}
+
+type generator struct {
+	buf                                bytes.Buffer
+	usesAudited, usesJSON, usesStrconv bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// value returns the expression of the snapshot value of the field at path
+func (g *generator) value(path string, typ ast.Expr) string {
+	switch name := builtin(typ); {
+	case name == "string" || name == "bool":
+		return path
+	case intTypes[name]:
+		g.usesJSON, g.usesStrconv = true, true
+		return fmt.Sprintf("json.Number(strconv.FormatInt(int64(%s), 10))", path)
+	case uintTypes[name]:
+		g.usesJSON, g.usesStrconv = true, true
+		return fmt.Sprintf("json.Number(strconv.FormatUint(uint64(%s), 10))", path)
+	}
+	g.usesAudited = true
+	return fmt.Sprintf("audited.CaptureValue(%s)", path)
+}
+
+// nonEmpty returns the condition under which encoding/json writes the field
+// at path tagged omitempty
+func (g *generator) nonEmpty(path string, typ ast.Expr) string {
+	name := builtin(typ)
+	switch {
+	case name == "string":
+		return path + ` != ""`
+	case name == "bool":
+		return path
+	case intTypes[name] || uintTypes[name] || name == "float32" || name == "float64":
+		return path + " != 0"
+	}
+	switch t := typ.(type) {
+	case *ast.StarExpr, *ast.InterfaceType:
+		return path + " != nil"
+	case *ast.MapType:
+		return "len(" + path + ") > 0"
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return "len(" + path + ") > 0"
+		}
+	}
+	g.usesAudited = true
+	return "!audited.IsEmptyValue(" + path + ")"
+}
+
+func (g *generator) objectId(primary []field) {
+	switch {
+	case len(primary) == 0:
+		g.printf("return \"\"\n")
+	case len(primary) == 1:
+		f, path := primary[0], "m."+primary[0].path
+		switch name := builtin(f.typ); {
+		case name == "string":
+			g.printf("return %s\n", path)
+		case intTypes[name]:
+			g.usesStrconv = true
+			g.printf("if %s == 0 {\nreturn \"\"\n}\nreturn strconv.FormatInt(int64(%s), 10)\n", path, path)
+		case uintTypes[name]:
+			g.usesStrconv = true
+			g.printf("if %s == 0 {\nreturn \"\"\n}\nreturn strconv.FormatUint(uint64(%s), 10)\n", path, path)
+		default:
+			g.usesAudited = true
+			g.printf("return audited.FormatObjectId(%s)\n", path)
+		}
+	default:
+		g.usesAudited = true
+		g.printf("return audited.CompositeObjectId(map[string]interface{}{\n")
+		for _, f := range primary {
+			g.printf("%q: m.%s,\n", f.column, f.path)
+		}
+		g.printf("})\n")
+	}
+}
+
+func (g *generator) model(name string, fields []field) {
+	g.printf("\n// AuditSnapshot returns the snapshot of m, see audited.Capturer\n")
+	g.printf("func (m %s) AuditSnapshot() map[string]interface{} {\n", name)
+	g.printf("data := make(map[string]interface{}, %d)\n", len(fields))
+	for _, f := range fields {
+		path := "m." + f.path
+		if f.omitEmpty {
+			g.printf("if %s {\n", g.nonEmpty(path, f.typ))
+		}
+		g.printf("data[%q] = %s\n", f.key, g.value(path, f.typ))
+		if f.omitEmpty {
+			g.printf("}\n")
+		}
+	}
+	for _, f := range fields {
+		if f.redact != "" {
+			g.usesAudited = true
+			strategy := "audited.RedactMask"
+			if f.redact == "hash" {
+				strategy = "audited.RedactHash"
+			}
+			g.printf("audited.Redact(%s, %q, data)\n", strategy, f.key)
+		}
+	}
+	g.printf("return data\n}\n")
+
+	g.printf("\n// AuditObjectId returns the object id of m, see audited.Capturer\n")
+	g.printf("func (m %s) AuditObjectId() string {\n", name)
+	g.objectId(primaryFields(fields))
+	g.printf("}\n")
+}
+
+// generate returns the capture code of the models names of the package pkg
+// made of files
+func generate(pkg string, files []*ast.File, names []string) ([]byte, error) {
+	structs := map[string]*ast.StructType{}
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if spec, ok := n.(*ast.TypeSpec); ok {
+				if st, ok := spec.Type.(*ast.StructType); ok {
+					structs[spec.Name.Name] = st
+				}
+			}
+			return true
+		})
+	}
+
+	g := &generator{}
+	var errs []error
+	for _, name := range names {
+		st, ok := structs[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: no such struct", name))
+			continue
+		}
+		fields, err := structFields(structs, st, "", 0)
+		if err == nil {
+			fields, err = dominant(fields)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		g.model(name, fields)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	var imports []string
+	if g.usesJSON {
+		imports = append(imports, `"encoding/json"`)
+	}
+	if g.usesStrconv {
+		imports = append(imports, `"strconv"`)
+	}
+	if g.usesAudited {
+		if len(imports) > 0 {
+			imports = append(imports, "")
+		}
+		imports = append(imports, `"github.com/mleonidas/audited"`)
+	}
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by auditgen; DO NOT EDIT.\n\npackage %s\n", pkg)
+	if len(imports) > 0 {
+		fmt.Fprintf(&src, "\nimport (\n%s\n)\n", strings.Join(imports, "\n"))
+	}
+	src.Write(g.buf.Bytes())
+	return format.Source(src.Bytes())
+}
+
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	format.Node(&buf, token.NewFileSet(), expr)
+	return buf.String()
+}
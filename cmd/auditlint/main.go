@@ -0,0 +1,15 @@
+// Command auditlint reports the writes of packages that bypass auditing, see
+// package auditlint:
+//
+//	auditlint ./...
+//	go vet -vettool=$(which auditlint) ./...
+package main
+
+import (
+	"github.com/mleonidas/audited/auditlint"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(auditlint.Analyzer)
+}
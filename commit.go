@@ -0,0 +1,89 @@
+package audited
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var contextKeyCommitHooks = ContextKey("audited_commit_hooks")
+
+// commitHooks is the work held until the commit of a Transaction
+type commitHooks struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+func (h *commitHooks) add(fn func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fns = append(h.fns, fn)
+}
+
+func (h *commitHooks) run() {
+	h.mu.Lock()
+	fns := h.fns
+	h.fns = nil
+	h.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+func commitHooksOf(ctx context.Context) *commitHooks {
+	if ctx == nil {
+		return nil
+	}
+	hooks, _ := ctx.Value(contextKeyCommitHooks).(*commitHooks)
+	return hooks
+}
+
+// Transaction runs fc in a transaction of db, as db.Transaction does, and
+// holds the work of the audit callbacks that must only happen once the changes
+// are committed until it commits, e.g. the entries written to the database of
+// WithAuditDB, the trails dropped from TrailCacheStore and the wake-ups of
+// Watch. None of it happens when it rolls back. A Transaction nested in
+// another holds its work until the outer one commits.
+func Transaction(db *gorm.DB, fc func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	outer, hooks := commitHooksOf(ctx), &commitHooks{}
+	err := db.WithContext(context.WithValue(ctx, contextKeyCommitHooks, hooks)).Transaction(fc, opts...)
+	if err != nil {
+		return err
+	}
+	if outer != nil && inTransaction(db) {
+		outer.add(hooks.run)
+		return nil
+	}
+	hooks.run()
+	return nil
+}
+
+// afterCommit runs fn once the transaction of db commits when db runs in a
+// Transaction, and right away otherwise: outside of a transaction the change
+// of db is already committed, in a transaction not started by Transaction
+// when it commits is unknown, see uncommitted
+func afterCommit(db *gorm.DB, fn func()) {
+	if heldUntilCommit(db) {
+		commitHooksOf(db.Statement.Context).add(fn)
+		return
+	}
+	fn()
+}
+
+// heldUntilCommit reports whether db runs in a Transaction, whose commit the
+// work of afterCommit waits for
+func heldUntilCommit(db *gorm.DB) bool {
+	return inTransaction(db) && commitHooksOf(db.Statement.Context) != nil
+}
+
+// uncommitted reports whether the change of db runs in a transaction whose
+// commit can't be waited for, one not started by Transaction
+func uncommitted(db *gorm.DB) bool {
+	return inTransaction(db) && !heldUntilCommit(db)
+}
@@ -0,0 +1,184 @@
+package audited
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// ConfigSchema is the JSON schema of Config, usable as the openAPIV3Schema of
+// the spec of a CustomResourceDefinition
+//
+//go:embed config.schema.json
+var ConfigSchema []byte
+
+// Config is the audit policy of a service in a form managed declaratively,
+// e.g. in a ConfigMap or as the spec of a custom resource, see LoadConfig.
+// Its fields follow the Kubernetes API conventions.
+type Config struct {
+	TableName         string                       `json:"tableName,omitempty"`
+	IncludeTables     []string                     `json:"includeTables,omitempty"`
+	ExcludeTables     []string                     `json:"excludeTables,omitempty"`
+	OptIn             bool                         `json:"optIn,omitempty"`
+	ChangedFieldsOnly bool                         `json:"changedFieldsOnly,omitempty"`
+	JSONPatch         bool                         `json:"jsonPatch,omitempty"`
+	ColdTierAfter     Duration                     `json:"coldTierAfter,omitempty"`
+	Domains           map[string][]string          `json:"domains,omitempty"`
+	ResidencyTables   map[string]string            `json:"residencyTables,omitempty"`
+	Classifications   map[string][]string          `json:"classifications,omitempty"`
+	Redactions        map[string]map[string]string `json:"redactions,omitempty"`
+	EncryptionKeyId   string                       `json:"encryptionKeyId,omitempty"`
+	Retention         *RetentionConfig             `json:"retention,omitempty"`
+	Quotas            *QuotaConfig                 `json:"quotas,omitempty"`
+}
+
+// RetentionConfig is the RetentionPolicy of a Config
+type RetentionConfig struct {
+	Default         Duration            `json:"default,omitempty"`
+	Tables          map[string]Duration `json:"tables,omitempty"`
+	Classifications map[string]Duration `json:"classifications,omitempty"`
+	Domains         map[string]Duration `json:"domains,omitempty"`
+}
+
+// QuotaConfig is the QuotaOptions of a Config
+type QuotaConfig struct {
+	MaxEntries int64            `json:"maxEntries,omitempty"`
+	Tenants    map[string]int64 `json:"tenants,omitempty"`
+	Overflow   string           `json:"overflow,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "2160h" in a
+// Config
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("audited: duration must be a string such as \"720h\": %w", err)
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if duration < 0 {
+		return fmt.Errorf("audited: negative duration %s", s)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// LoadConfig reads a Config encoded in JSON from r, unknown fields are errors
+func LoadConfig(r io.Reader) (*Config, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	config := &Config{}
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("audited: invalid config: %w", err)
+	}
+	return config, nil
+}
+
+// LoadConfigFile reads the Config in the file at path, e.g. a mounted
+// ConfigMap
+func LoadConfigFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadConfig(f)
+}
+
+// Options returns the options of c, for RegisterCallbacks
+func (c *Config) Options() []Option {
+	var opts []Option
+	if c.TableName != "" {
+		opts = append(opts, WithTableName(c.TableName))
+	}
+	if len(c.IncludeTables) > 0 {
+		opts = append(opts, WithIncludeTables(c.IncludeTables...))
+	}
+	if len(c.ExcludeTables) > 0 {
+		opts = append(opts, WithExcludeTables(c.ExcludeTables...))
+	}
+	if c.OptIn {
+		opts = append(opts, WithOptIn())
+	}
+	if c.ChangedFieldsOnly {
+		opts = append(opts, WithChangedFieldsOnly())
+	}
+	if c.JSONPatch {
+		opts = append(opts, WithJSONPatch())
+	}
+	if c.ColdTierAfter > 0 {
+		opts = append(opts, WithColdTier(time.Duration(c.ColdTierAfter)))
+	}
+	// in order, so a table listed in two domains is always in the same one
+	domains := make([]string, 0, len(c.Domains))
+	for domain := range c.Domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		opts = append(opts, WithDomain(domain, c.Domains[domain]...))
+	}
+	for region, table := range c.ResidencyTables {
+		opts = append(opts, WithResidencyTable(region, table))
+	}
+	for table, fields := range c.Redactions {
+		opts = append(opts, WithRedaction(table, fields))
+	}
+	if c.EncryptionKeyId != "" {
+		opts = append(opts, WithEncryption(c.EncryptionKeyId))
+	}
+	return opts
+}
+
+// RegisterClassifications registers the classifications of c, see
+// RegisterClassification
+func (c *Config) RegisterClassifications() {
+	for label, tables := range c.Classifications {
+		RegisterClassification(label, tables...)
+	}
+}
+
+// RetentionPolicy returns the retention policy of c, nil when it has none
+func (c *Config) RetentionPolicy() *RetentionPolicy {
+	if c.Retention == nil {
+		return nil
+	}
+	return &RetentionPolicy{
+		Default:         time.Duration(c.Retention.Default),
+		Tables:          durations(c.Retention.Tables),
+		Classifications: durations(c.Retention.Classifications),
+		Domains:         durations(c.Retention.Domains),
+	}
+}
+
+// QuotaOptions returns the tenant quotas of c, nil when it has none. Set
+// OnOverflow on them to alert.
+func (c *Config) QuotaOptions() *QuotaOptions {
+	if c.Quotas == nil {
+		return nil
+	}
+	return &QuotaOptions{MaxEntries: c.Quotas.MaxEntries, Tenants: c.Quotas.Tenants, Overflow: c.Quotas.Overflow}
+}
+
+func durations(m map[string]Duration) map[string]time.Duration {
+	if m == nil {
+		return nil
+	}
+	converted := make(map[string]time.Duration, len(m))
+	for key, d := range m {
+		converted[key] = time.Duration(d)
+	}
+	return converted
+}
@@ -0,0 +1,85 @@
+package audited
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `{
+	"tableName": "billing_audit_logs",
+	"excludeTables": ["sessions"],
+	"changedFieldsOnly": true,
+	"coldTierAfter": "2160h",
+	"domains": {"billing": ["invoices", "plans"]},
+	"residencyTables": {"eu": "audit_logs_eu"},
+	"retention": {"default": "8760h", "domains": {"billing": "61320h"}},
+	"quotas": {"maxEntries": 100000, "tenants": {"acme": 500000}, "overflow": "summarize"}
+}`
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newOptions(config.Options())
+	if o.tableName != "billing_audit_logs" || !o.skipTables["sessions"] || !o.changedOnly ||
+		o.coldAfter != 90*24*time.Hour || o.domains["plans"] != "billing" || o.residency["eu"] != "audit_logs_eu" {
+		t.Fatalf("got options %+v", o)
+	}
+	year := 365 * 24 * time.Hour
+	want := &RetentionPolicy{Default: year, Domains: map[string]time.Duration{"billing": 7 * year}}
+	if got := config.RetentionPolicy(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got retention %+v, want %+v", got, want)
+	}
+	quotas := &QuotaOptions{MaxEntries: 100000, Tenants: map[string]int64{"acme": 500000}, Overflow: OverflowSummarize}
+	if got := config.QuotaOptions(); !reflect.DeepEqual(got, quotas) {
+		t.Fatalf("got quotas %+v, want %+v", got, quotas)
+	}
+
+	for _, invalid := range []string{`{"tableNmae": "x"}`, `{"coldTierAfter": 90}`, `{"coldTierAfter": "-1h"}`} {
+		if _, err := LoadConfig(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected %s to be invalid", invalid)
+		}
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	var schema struct {
+		Properties map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(ConfigSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	keys := func(m interface{}) []string {
+		var keys []string
+		for _, key := range reflect.ValueOf(m).MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	fields := func(typ reflect.Type) []string {
+		var names []string
+		for i := 0; i < typ.NumField(); i++ {
+			name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	if got, want := keys(schema.Properties), fields(reflect.TypeOf(Config{})); !reflect.DeepEqual(got, want) {
+		t.Errorf("schema has properties %v, Config has %v", got, want)
+	}
+	if got, want := keys(schema.Properties["retention"].Properties), fields(reflect.TypeOf(RetentionConfig{})); !reflect.DeepEqual(got, want) {
+		t.Errorf("schema has retention properties %v, RetentionConfig has %v", got, want)
+	}
+	if got, want := keys(schema.Properties["quotas"].Properties), fields(reflect.TypeOf(QuotaConfig{})); !reflect.DeepEqual(got, want) {
+		t.Errorf("schema has quotas properties %v, QuotaConfig has %v", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+// ConsentChecker tells whether the person an object is about consented to a
+// category of their personal data being kept
+type ConsentChecker interface {
+	HasConsent(ctx context.Context, table, objectId, category string) (bool, error)
+}
+
+// ConsentService is consulted for fields tagged with e.g.
+// `audited:"consent=marketing"`, their values are masked in the snapshot
+// unless consent was given. It is optional, without it the fields are kept.
+var ConsentService ConsentChecker
+
+// Consent decisions, recorded by category in the "consent" metadata of entries
+const (
+	ConsentGranted = "granted"
+	ConsentMasked  = "masked"
+)
+
+const settingConsent = "audited:consent"
+
+// applyConsent masks the fields of data, the snapshot of obj, whose category,
+// given by key in categories, has no consent and returns the decision taken
+// per category. A failing ConsentService counts as no consent.
+func applyConsent(ctx context.Context, s *schema.Schema, obj reflect.Value, data map[string]interface{}, categories map[string]string) map[string]string {
+	if ConsentService == nil || len(categories) == 0 {
+		return nil
+	}
+	// the object id of its entries, numeric and composite keys included
+	objectId := getKeyFromData("id", data)
+	if key, ok := primaryKey(ctx, s, obj); ok {
+		objectId = key.objectId()
+	}
+	table := s.Table
+	decisions := map[string]string{}
+	for key, category := range categories {
+		decision, ok := decisions[category]
+		if !ok {
+			decision = ConsentMasked
+			granted, err := ConsentService.HasConsent(ctx, table, objectId, category)
+			if err != nil {
+				log.Println(fmt.Errorf("error checking consent for %s: %s", category, err.Error()))
+			} else if granted {
+				decision = ConsentGranted
+			}
+			decisions[category] = decision
+		}
+		if decision == ConsentMasked {
+			data[key] = defaultMaskValue
+		}
+	}
+	return decisions
+}
@@ -0,0 +1,92 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type Subscriber struct {
+	Id    string `json:"id"`
+	Email string `json:"email" audited:"consent=marketing"`
+	Phone string `json:"phone" audited:"consent=contact"`
+	Plan  string `json:"plan"`
+}
+
+type fakeConsent map[string]error
+
+func (f fakeConsent) HasConsent(ctx context.Context, table, objectId, category string) (bool, error) {
+	err, ok := f[table+"/"+objectId+"/"+category]
+	return ok && err == nil, err
+}
+
+func TestConsentMasksFields(t *testing.T) {
+	defer func(previous ConsentChecker) { ConsentService = previous }(ConsentService)
+	ConsentService = fakeConsent{
+		"subscribers/s-1/marketing": nil,
+		"subscribers/s-1/contact":   errors.New("consent service down"),
+	}
+
+	subscriber := &Subscriber{Id: "s-1", Email: "ann@example.com", Phone: "555-0100", Plan: "pro"}
+	db := statementFor(t, subscriber)
+	data, err := snapshot(db, reflect.ValueOf(subscriber))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["email"] != "ann@example.com" || data["phone"] != defaultMaskValue || data["plan"] != "pro" {
+		t.Fatalf("got snapshot %v", data)
+	}
+	decisions, _ := db.InstanceGet(settingConsent)
+	want := map[string]string{"marketing": ConsentGranted, "contact": ConsentMasked}
+	if !reflect.DeepEqual(decisions, want) {
+		t.Fatalf("got decisions %v, want %v", decisions, want)
+	}
+}
+
+func TestConsentWithoutService(t *testing.T) {
+	subscriber := &Subscriber{Id: "s-1", Email: "ann@example.com"}
+	db := statementFor(t, subscriber)
+	data, err := snapshot(db, reflect.ValueOf(subscriber))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["email"] != "ann@example.com" {
+		t.Fatalf("got snapshot %v", data)
+	}
+	if _, ok := db.InstanceGet(settingConsent); ok {
+		t.Fatal("decisions recorded without a consent service")
+	}
+}
+
+type NumberedSubscriber struct {
+	Id    uint   `json:"id"`
+	Email string `json:"email" audited:"consent=marketing"`
+}
+
+type ListSubscriber struct {
+	ListId string `json:"list_id" gorm:"primaryKey"`
+	UserId int    `json:"user_id" gorm:"primaryKey"`
+	Email  string `json:"email" audited:"consent=marketing"`
+}
+
+func TestConsentObjectIds(t *testing.T) {
+	defer func(previous ConsentChecker) { ConsentService = previous }(ConsentService)
+	ConsentService = fakeConsent{
+		"numbered_subscribers/42/marketing":                         nil,
+		`list_subscribers/{"list_id":"news","user_id":7}/marketing`: nil,
+	}
+
+	for _, model := range []interface{}{
+		&NumberedSubscriber{Id: 42, Email: "ann@example.com"},
+		&ListSubscriber{ListId: "news", UserId: 7, Email: "ann@example.com"},
+	} {
+		data, err := snapshot(statementFor(t, model), reflect.ValueOf(model))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data["email"] != "ann@example.com" {
+			t.Errorf("%T: consent not found by object id, got snapshot %v", model, data)
+		}
+	}
+}
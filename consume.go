@@ -0,0 +1,166 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CheckpointsTable stores the position of each consumer, see Consume
+const CheckpointsTable = "audit_checkpoints"
+
+const (
+	defaultConsumerBatchSize    = 100
+	defaultConsumerPollInterval = time.Second
+	defaultConsumerGapTimeout   = 10 * time.Second
+)
+
+// ConsumerOptions configures a consumer of audit entries
+type ConsumerOptions struct {
+	// Name identifies the consumer, its checkpoint is stored under it
+	Name string
+	// BatchSize is the maximum number of entries handled at once, defaults to 100
+	BatchSize int
+	// PollInterval is the wait after reading no new entries, defaults to a second
+	PollInterval time.Duration
+	// GapTimeout is how long entries after a gap in the sequence are held back
+	// waiting for the transaction that owns the gap to commit, defaults to 10
+	// seconds. Entries of a gap that commit later than that are never handled.
+	GapTimeout time.Duration
+}
+
+// ConsumerHandler handles a batch of entries in sequence order. It runs in the
+// transaction that advances the checkpoint, writes made through tx commit
+// together with it. Returning an error rolls back and stops the consumer.
+type ConsumerHandler func(ctx context.Context, tx *gorm.DB, entries []AuditLog) error
+
+type checkpoint struct {
+	Name      string `gorm:"primaryKey"`
+	Seq       int64
+	UpdatedAt time.Time
+}
+
+// Consume hands the entries after the stored checkpoint of opts.Name to handler
+// and advances the checkpoint past them, until ctx is done or handler fails.
+// The checkpoint row is locked while a batch is handled, so several instances
+// of a consumer can run without handling an entry twice.
+func Consume(ctx context.Context, db *gorm.DB, opts ConsumerOptions, handler ConsumerHandler) error {
+	db, opts, err := startConsumer(ctx, db, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		handled, err := consumeBatch(ctx, db, opts, handler)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if handled == opts.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.PollInterval):
+		}
+	}
+}
+
+// ConsumePending hands the entries after the stored checkpoint of opts.Name to
+// handler as Consume does, until none are left, and returns the number of
+// entries handled, e.g. to consume from a Job run by a scheduler
+func ConsumePending(ctx context.Context, db *gorm.DB, opts ConsumerOptions, handler ConsumerHandler) (int, error) {
+	db, opts, err := startConsumer(ctx, db, opts)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for {
+		handled, err := consumeBatch(ctx, db, opts, handler)
+		total += handled
+		if err != nil || handled < opts.BatchSize {
+			return total, err
+		}
+	}
+}
+
+// startConsumer fills in the defaults of opts and creates the checkpoint of
+// the consumer
+func startConsumer(ctx context.Context, db *gorm.DB, opts ConsumerOptions) (*gorm.DB, ConsumerOptions, error) {
+	if opts.Name == "" {
+		return nil, opts, errors.New("audited: consumer name is required")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultConsumerBatchSize
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultConsumerPollInterval
+	}
+	if opts.GapTimeout <= 0 {
+		opts.GapTimeout = defaultConsumerGapTimeout
+	}
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	err := db.Table(CheckpointsTable).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&checkpoint{Name: opts.Name, UpdatedAt: time.Now()}).Error
+	return db, opts, err
+}
+
+// consumeBatch hands the next batch of entries to handler and advances the
+// checkpoint past them, it returns the number of entries handled
+func consumeBatch(ctx context.Context, db *gorm.DB, opts ConsumerOptions, handler ConsumerHandler) (int, error) {
+	handled := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var current checkpoint
+		if err := tx.Table(CheckpointsTable).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("name = ?", opts.Name).Take(&current).Error; err != nil {
+			return err
+		}
+		entries, err := EntriesAfter(tx, current.Seq, opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		entries = consumable(current.Seq, entries, opts.GapTimeout, time.Now())
+		if len(entries) == 0 {
+			return nil
+		}
+		if err := handler(ctx, tx, entries); err != nil {
+			return err
+		}
+		handled = len(entries)
+		return tx.Table(CheckpointsTable).Where("name = ?", opts.Name).
+			Updates(map[string]interface{}{"seq": entries[len(entries)-1].Seq, "updated_at": time.Now()}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return handled, nil
+}
+
+// Checkpoint returns the sequence number up to which the consumer name has
+// handled entries
+func Checkpoint(db *gorm.DB, name string) (int64, error) {
+	var current checkpoint
+	err := auditConn(db).Session(&gorm.Session{NewDB: true}).Table(CheckpointsTable).
+		Where("name = ?", name).Take(&current).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return current.Seq, err
+}
+
+// consumable returns the entries, given in sequence order after cursor, up to
+// the first gap that is younger than gapTimeout
+func consumable(cursor int64, entries []AuditLog, gapTimeout time.Duration, now time.Time) []AuditLog {
+	for i, entry := range entries {
+		if entry.Seq > cursor+1 && now.Sub(entry.CreatedAt) < gapTimeout {
+			return entries[:i]
+		}
+		cursor = entry.Seq
+	}
+	return entries
+}
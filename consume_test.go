@@ -0,0 +1,29 @@
+package audited
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConsumable(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	entries := []AuditLog{
+		{Seq: 1, CreatedAt: now.Add(-time.Minute)},
+		{Seq: 3, CreatedAt: now.Add(-time.Minute)},
+		{Seq: 4, CreatedAt: now.Add(-time.Second)},
+		{Seq: 6, CreatedAt: now.Add(-time.Second)},
+		{Seq: 7, CreatedAt: now},
+	}
+
+	// the gap before 3 is older than the timeout, the one before 6 is not
+	got := consumable(0, entries, 10*time.Second, now)
+	if len(got) != 3 || got[2].Seq != 4 {
+		t.Fatalf("got %v, want the entries up to seq 4", got)
+	}
+	if got := consumable(0, entries, time.Millisecond, now); len(got) != len(entries) {
+		t.Fatalf("got %d entries, want all once the gaps time out", len(got))
+	}
+	if got := consumable(2, entries[1:], time.Hour, now); len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+}
@@ -0,0 +1,123 @@
+package audited
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// coverageBatchSize is the number of rows of a model read at once by Coverage
+const coverageBatchSize = 1000
+
+// CoverageReport tells how many rows of a table have at least one audit entry
+type CoverageReport struct {
+	TableName string   `json:"table_name"`
+	Rows      int64    `json:"rows"`
+	Audited   int64    `json:"audited"`
+	Ratio     float64  `json:"ratio"`
+	Unaudited []string `json:"unaudited"`
+}
+
+// Coverage reports what fraction of the current rows of model have at least
+// one audit entry, and lists the object ids of those that have none. It helps
+// validating backfills and finding rows written before auditing was enabled.
+// Rows with a single column key are matched to their entries in the database,
+// with an anti-join, unless the entries are in another database (see
+// WithAuditDB); the others are read in batches and looked up by object id.
+func Coverage(db *gorm.DB, model interface{}) (*CoverageReport, error) {
+	db = db.Session(&gorm.Session{NewDB: true})
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	if len(stmt.Schema.PrimaryFields) == 0 {
+		return nil, fmt.Errorf("audited: %s has no primary key", stmt.Schema.Name)
+	}
+	report := &CoverageReport{TableName: stmt.Schema.Table}
+	if err := db.Unscoped().Model(model).Count(&report.Rows).Error; err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(stmt.Schema.PrimaryFields))
+	for i, field := range stmt.Schema.PrimaryFields {
+		columns[i] = field.DBName
+	}
+	rows := db.Unscoped().Model(model).Select(columns)
+	antiJoin := len(columns) == 1 && auditConn(db).Callback() == db.Callback()
+	if antiJoin {
+		quote, entries := db.Statement.Quote, operationsTable(db)
+		rows = rows.Where("NOT EXISTS (?)", QueryOperations(db).Select("1").
+			Where("table_name = ?", report.TableName).
+			Where(quote(entries+".object_id")+" = CAST("+quote(report.TableName+"."+columns[0])+
+				" AS "+textType(db)+")"))
+	}
+
+	for offset := 0; ; offset += coverageBatchSize {
+		batch := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+		if err := rows.Session(&gorm.Session{}).Order(strings.Join(columns, ", ")).
+			Limit(coverageBatchSize).Offset(offset).Find(batch.Interface()).Error; err != nil {
+			return nil, err
+		}
+		ids := objectIds(db, stmt.Schema, batch.Elem())
+		if antiJoin {
+			report.Unaudited = append(report.Unaudited, ids...)
+		} else if err := appendUnaudited(db, report, ids); err != nil {
+			return nil, err
+		}
+		if batch.Elem().Len() < coverageBatchSize {
+			break
+		}
+	}
+	report.Audited = report.Rows - int64(len(report.Unaudited))
+	if report.Rows > 0 {
+		report.Ratio = float64(report.Audited) / float64(report.Rows)
+	}
+	return report, nil
+}
+
+// appendUnaudited adds the ids without an entry to the report
+func appendUnaudited(db *gorm.DB, report *CoverageReport, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var found []string
+	if err := QueryOperations(db).Where("table_name = ? AND object_id IN ?", report.TableName, ids).
+		Distinct("object_id").Pluck("object_id", &found).Error; err != nil {
+		return err
+	}
+	audited := make(map[string]struct{}, len(found))
+	for _, id := range found {
+		audited[id] = struct{}{}
+	}
+	for _, id := range ids {
+		if _, ok := audited[id]; !ok {
+			report.Unaudited = append(report.Unaudited, id)
+		}
+	}
+	return nil
+}
+
+// objectIds returns the object ids of the rows of a slice of the model of s
+func objectIds(db *gorm.DB, s *schema.Schema, rows reflect.Value) []string {
+	ids := make([]string, 0, rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		if key, ok := primaryKey(db.Statement.Context, s, rows.Index(i)); ok {
+			ids = append(ids, key.objectId())
+		}
+	}
+	return ids
+}
+
+// textType is the type values are cast to to compare them with an object id
+func textType(db *gorm.DB) string {
+	switch db.Dialector.Name() {
+	case "mysql":
+		return "CHAR"
+	case "sqlserver":
+		return "NVARCHAR(MAX)"
+	}
+	return "TEXT"
+}
@@ -0,0 +1,144 @@
+package audited
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// EventFormat is the shape entries are published in
+type EventFormat int
+
+const (
+	// EventFormatNative publishes entries as the JSON of AuditLog
+	EventFormatNative EventFormat = iota
+	// EventFormatDebezium publishes entries in the envelope of Debezium change
+	// events, as written by its JSON converter with schemas disabled, so CDC
+	// consumers and sink connectors can read them without translation
+	EventFormatDebezium
+)
+
+// PublishFormat is the format MarshalEvent encodes entries in
+var PublishFormat = EventFormatNative
+
+// DebeziumServerName is the logical name of the source in Debezium events,
+// Debezium's topic.prefix
+var DebeziumServerName = "audited"
+
+// DebeziumEvent is the payload of a Debezium change event
+type DebeziumEvent struct {
+	Before      json.RawMessage `json:"before"`
+	After       json.RawMessage `json:"after"`
+	Source      DebeziumSource  `json:"source"`
+	Op          string          `json:"op"`
+	TsMs        int64           `json:"ts_ms"`
+	Transaction interface{}     `json:"transaction"`
+}
+
+// DebeziumSource describes where a Debezium event comes from. AuditId and
+// UserId are not part of Debezium's source block, consumers ignore them.
+type DebeziumSource struct {
+	Connector string `json:"connector"`
+	Name      string `json:"name"`
+	TsMs      int64  `json:"ts_ms"`
+	Snapshot  string `json:"snapshot"`
+	Db        string `json:"db"`
+	Table     string `json:"table"`
+	Sequence  string `json:"sequence"`
+	AuditId   ID     `json:"audit_id"`
+	UserId    string `json:"user_id"`
+}
+
+var debeziumOps = map[string]string{
+	OperationCreate: "c",
+	OperationUpdate: "u",
+	OperationDelete: "d",
+	// the row of a soft delete stays, it is gone for the application
+	OperationSoftDelete: "d",
+	OperationHardDelete: "d",
+	OperationRestore:    "u",
+	OperationUpsert:     "u",
+}
+
+// ToDebezium returns entry as a Debezium event. before is the data of the
+// object before an update, usually the data of its previous entry; creates
+// have no before and deletes no after.
+func ToDebezium(entry AuditLog, before datatypes.JSON) DebeziumEvent {
+	event := DebeziumEvent{
+		Op:   debeziumOps[entry.OperationType],
+		TsMs: entry.CreatedAt.UnixMilli(),
+		Source: DebeziumSource{
+			Connector: "audited",
+			Name:      DebeziumServerName,
+			TsMs:      entry.CreatedAt.UnixMilli(),
+			Snapshot:  "false",
+			Table:     entry.TableName,
+			AuditId:   entry.Id,
+			UserId:    entry.UserId,
+		},
+	}
+	if entry.Seq != 0 {
+		event.Source.Sequence = strconv.FormatInt(entry.Seq, 10)
+	}
+	switch entry.OperationType {
+	case OperationCreate, OperationUpsert:
+		event.After = rawJSON(entry.Data)
+	case OperationUpdate, OperationRestore:
+		event.Before = rawJSON(before)
+		event.After = rawJSON(entry.Data)
+	case OperationDelete, OperationSoftDelete, OperationHardDelete:
+		event.Before = rawJSON(entry.Data)
+	}
+	return event
+}
+
+// MarshalEvent encodes entry in PublishFormat. For Debezium events of updates
+// the data before the update is read from the previous entry of the object,
+// db may be nil to leave it empty.
+func MarshalEvent(db *gorm.DB, entry AuditLog) ([]byte, error) {
+	if PublishFormat != EventFormatDebezium {
+		return json.Marshal(entry)
+	}
+	if db == nil {
+		return json.Marshal(ToDebezium(entry, nil))
+	}
+	var before datatypes.JSON
+	if entry.OperationType == OperationUpdate {
+		var err error
+		if before, err = dataBefore(db, entry); err != nil {
+			return nil, err
+		}
+	}
+	event := ToDebezium(entry, before)
+	event.Source.Db = db.Migrator().CurrentDatabase()
+	return json.Marshal(event)
+}
+
+// dataBefore returns the data of the object of entry before it, its old data
+// or else the data of the entry of the object preceding it
+func dataBefore(db *gorm.DB, entry AuditLog) (datatypes.JSON, error) {
+	if len(entry.OldData) > 0 {
+		return entry.OldData, nil
+	}
+	query := Query(db).Where("table_name = ? AND object_id = ?", entry.TableName, entry.ObjectId)
+	if entry.Seq != 0 {
+		query = query.Where("seq < ?", entry.Seq).Order("seq DESC")
+	} else {
+		query = query.Where("created_at < ?", entry.CreatedAt).Order("created_at DESC")
+	}
+	var previous []AuditLog
+	if err := query.Limit(1).Find(&previous).Error; err != nil || len(previous) == 0 {
+		return nil, err
+	}
+	return previous[0].Data, nil
+}
+
+// rawJSON returns data as a raw message, keeping an empty value null
+func rawJSON(data datatypes.JSON) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.RawMessage(data)
+}
@@ -0,0 +1,45 @@
+package audited
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+func TestToDebezium(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entry := AuditLog{
+		Id:            "a1",
+		TableName:     "orders",
+		OperationType: OperationUpdate,
+		ObjectId:      "7",
+		Data:          datatypes.JSON(`{"id":"7","status":"paid"}`),
+		UserId:        "ann@example.com",
+		Seq:           42,
+		CreatedAt:     at,
+	}
+	event := ToDebezium(entry, datatypes.JSON(`{"id":"7","status":"new"}`))
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"before":{"id":"7","status":"new"},"after":{"id":"7","status":"paid"},` +
+		`"source":{"connector":"audited","name":"audited","ts_ms":1704164645000,"snapshot":"false",` +
+		`"db":"","table":"orders","sequence":"42","audit_id":"a1","user_id":"ann@example.com"},` +
+		`"op":"u","ts_ms":1704164645000,"transaction":null}`
+	if string(encoded) != want {
+		t.Fatalf("got\n%s\nwant\n%s", encoded, want)
+	}
+
+	entry.OperationType = OperationDelete
+	if event := ToDebezium(entry, nil); event.Op != "d" || event.After != nil || string(event.Before) != string(entry.Data) {
+		t.Fatalf("unexpected delete event %+v", event)
+	}
+	entry.OperationType = OperationCreate
+	if event := ToDebezium(entry, nil); event.Op != "c" || event.Before != nil || string(event.After) != string(entry.Data) {
+		t.Fatalf("unexpected create event %+v", event)
+	}
+}
@@ -0,0 +1,156 @@
+package audited
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"gorm.io/datatypes"
+)
+
+// FieldValue is the state of a single field in a snapshot. It tells a field
+// that is missing from one that is NULL, and NULL from a zero value.
+type FieldValue struct {
+	Present bool        `json:"present"`
+	Null    bool        `json:"null"`
+	Value   interface{} `json:"value,omitempty"`
+}
+
+// Change is the difference of a single field between two snapshots. Changes
+// inside a nested document found by DeepDiffer have the JSON Pointer of the
+// changed member as Path. Changes of an array, e.g. a Postgres array or a
+// serialized slice, list the elements Added and Removed.
+type Change struct {
+	Field   string        `json:"field"`
+	Path    string        `json:"path,omitempty"`
+	From    FieldValue    `json:"from"`
+	To      FieldValue    `json:"to"`
+	Added   []interface{} `json:"added,omitempty"`
+	Removed []interface{} `json:"removed,omitempty"`
+}
+
+// Cleared reports whether the field went from a value to NULL
+func (c Change) Cleared() bool {
+	return c.From.Present && !c.From.Null && c.To.Null
+}
+
+// Diff returns the fields that differ between two snapshots, sorted by field name
+func Diff(before, after datatypes.JSON) ([]Change, error) {
+	oldMap, err := decodeSnapshot(before)
+	if err != nil {
+		return nil, err
+	}
+	newMap, err := decodeSnapshot(after)
+	if err != nil {
+		return nil, err
+	}
+	return diffMaps(oldMap, newMap), nil
+}
+
+func diffMaps(before, after map[string]interface{}) []Change {
+	changes := []Change{}
+	for _, field := range unionKeys(before, after) {
+		from, to := fieldValue(before, field), fieldValue(after, field)
+		if sameValue(from, to) {
+			continue
+		}
+		changes = append(changes, newChange(field, "", from, to))
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+func newChange(field, path string, from, to FieldValue) Change {
+	change := Change{Field: field, Path: path, From: from, To: to}
+	before, fromOk := from.Value.([]interface{})
+	after, toOk := to.Value.([]interface{})
+	if fromOk && toOk {
+		change.Added, change.Removed = arrayElements(before, after)
+	}
+	return change
+}
+
+// arrayElements returns the elements of after missing from before and those
+// of before missing from after, a repeated element counting once per copy
+func arrayElements(before, after []interface{}) (added, removed []interface{}) {
+	counts := map[string]int{}
+	for _, v := range before {
+		counts[elementKey(v)]++
+	}
+	for _, v := range after {
+		key := elementKey(v)
+		if counts[key] > 0 {
+			counts[key]--
+			continue
+		}
+		added = append(added, v)
+	}
+	for _, v := range before {
+		key := elementKey(v)
+		if counts[key] > 0 {
+			counts[key]--
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+// elementKey identifies an array element by its canonical JSON
+func elementKey(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	if canonical, err := Canonicalize(data); err == nil {
+		return string(canonical)
+	}
+	return string(data)
+}
+
+// changedFields returns the fields that differ between the before and after
+// snapshots of an object of table, with its id
+func changedFields(table string, before, after datatypes.JSON) (datatypes.JSON, datatypes.JSON, error) {
+	oldMap, err := decodeTableSnapshot(table, before)
+	if err != nil {
+		return before, after, err
+	}
+	newMap, err := decodeTableSnapshot(table, after)
+	if err != nil {
+		return before, after, err
+	}
+	oldChanged, newChanged := map[string]interface{}{}, map[string]interface{}{}
+	for _, change := range diffMaps(oldMap, newMap) {
+		if change.From.Present {
+			oldChanged[change.Field] = change.From.Value
+		}
+		if change.To.Present {
+			newChanged[change.Field] = change.To.Value
+		}
+	}
+	if id, ok := newMap["id"]; ok {
+		oldChanged["id"], newChanged["id"] = id, id
+	}
+	return prepareData(oldChanged), prepareData(newChanged), nil
+}
+
+func fieldValue(data map[string]interface{}, field string) FieldValue {
+	value, ok := data[field]
+	if !ok {
+		return FieldValue{}
+	}
+	return FieldValue{Present: true, Null: value == nil, Value: value}
+}
+
+// decodeSnapshot decodes a stored snapshot, keeping numbers as json.Number
+func decodeSnapshot(data datatypes.JSON) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if len(data) == 0 || string(data) == "null" {
+		return m, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
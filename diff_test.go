@@ -0,0 +1,122 @@
+package audited
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestDiffNullAndZero(t *testing.T) {
+	before := []byte(`{"name": "gear", "note": "fragile", "count": 0, "active": true, "removed": 1}`)
+	after := []byte(`{"name": "", "note": null, "count": 0, "active": false, "added": null}`)
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]Change{}
+	for _, c := range changes {
+		got[c.Field] = c
+	}
+
+	if _, ok := got["count"]; ok {
+		t.Errorf("unchanged field is in the diff")
+	}
+	if c := got["name"]; c.Cleared() || c.To.Null || c.To.Value != "" {
+		t.Errorf("name set to empty: got %+v", c)
+	}
+	if c := got["note"]; !c.Cleared() {
+		t.Errorf("note cleared: got %+v", c)
+	}
+	if c := got["removed"]; !c.From.Present || c.To.Present {
+		t.Errorf("removed field: got %+v", c)
+	}
+	if c := got["added"]; c.From.Present || !c.To.Present || !c.To.Null {
+		t.Errorf("added null field: got %+v", c)
+	}
+	if len(changes) != 5 {
+		t.Errorf("got %d changes, want 5: %+v", len(changes), changes)
+	}
+	for i := 1; i < len(changes); i++ {
+		if changes[i-1].Field > changes[i].Field {
+			t.Errorf("changes are not sorted by field")
+		}
+	}
+}
+
+func TestIsNullable(t *testing.T) {
+	type notNullable struct {
+		Valid bool
+		Value string
+	}
+	cases := map[string]struct {
+		value interface{}
+		want  bool
+	}{
+		"sql.NullString":  {sql.NullString{}, true},
+		"sql.NullInt64":   {sql.NullInt64{}, true},
+		"sql.NullTime":    {sql.NullTime{}, true},
+		"Valid first":     {notNullable{}, false},
+		"string":          {"", false},
+		"struct of three": {struct{ A, B, Valid bool }{}, false},
+	}
+	for name, c := range cases {
+		if got := isNullable(reflect.TypeOf(c.value)); got != c.want {
+			t.Errorf("%s: got %t, want %t", name, got, c.want)
+		}
+	}
+}
+
+func TestEncodeValueNullable(t *testing.T) {
+	if got, ok := encodeValue(reflect.ValueOf(sql.NullString{})); !ok || got != nil {
+		t.Errorf("invalid NullString: got %v, %t", got, ok)
+	}
+	if got, ok := encodeValue(reflect.ValueOf(sql.NullString{String: "", Valid: true})); !ok || got != "" {
+		t.Errorf("empty NullString: got %v, %t", got, ok)
+	}
+	if got, ok := encodeValue(reflect.ValueOf(sql.NullInt64{Int64: 0, Valid: true})); !ok || got != int64(0) {
+		t.Errorf("zero NullInt64: got %v, %t", got, ok)
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	before := []byte(`{"id": "7", "name": "gear", "price": 10, "note": "fragile", "stock": 3}`)
+	after := []byte(`{"id": "7", "name": "gear", "price": 12, "note": null, "stock": 3}`)
+	oldData, newData, err := changedFields("products", before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(oldData) != `{"id":"7","note":"fragile","price":10}` {
+		t.Errorf("got old data %s", oldData)
+	}
+	if string(newData) != `{"id":"7","note":null,"price":12}` {
+		t.Errorf("got data %s", newData)
+	}
+}
+
+func TestDiffArrayElements(t *testing.T) {
+	changes, err := Diff(
+		datatypes.JSON(`{"roles":["viewer","editor","viewer"],"ids":[1,2],"tags":null}`),
+		datatypes.JSON(`{"roles":["viewer","admin"],"ids":[2.0,1],"tags":["a"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("got changes %+v", changes)
+	}
+	// reordered elements are neither added nor removed
+	if ids := changes[0]; ids.Field != "ids" || ids.Added != nil || ids.Removed != nil {
+		t.Errorf("got %+v", ids)
+	}
+	roles := changes[1]
+	if got, _ := json.Marshal([]interface{}{roles.Added, roles.Removed}); string(got) != `[["admin"],["viewer","editor"]]` {
+		t.Errorf("got roles elements %s", got)
+	}
+	// an array set from null has no elements listed
+	if tags := changes[2]; tags.Added != nil || tags.Removed != nil {
+		t.Errorf("got %+v", tags)
+	}
+}
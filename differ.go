@@ -0,0 +1,156 @@
+package audited
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Differ compares two snapshots of an object, see RegisterDiffer
+type Differ func(before, after map[string]interface{}) []Change
+
+// ShallowDiffer compares snapshots field by field, a changed nested document
+// is a single change of its field. It is the default Differ.
+func ShallowDiffer(before, after map[string]interface{}) []Change {
+	return diffMaps(before, after)
+}
+
+// DeepDiffer compares snapshots down to the leaves of nested documents, e.g.
+// of JSON columns, a change inside one has the JSON Pointer (RFC 6901) of the
+// changed member as its Path, sorted by path
+func DeepDiffer(before, after map[string]interface{}) []Change {
+	changes := []Change{}
+	deepDiff(&changes, "", "", before, after)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func deepDiff(changes *[]Change, field, path string, before, after map[string]interface{}) {
+	for _, key := range unionKeys(before, after) {
+		f, p := field, path+"/"+escapePointer(key)
+		if f == "" {
+			f = key
+		}
+		from, to := fieldValue(before, key), fieldValue(after, key)
+		if sameValue(from, to) {
+			continue
+		}
+		fromDoc, fromOk := from.Value.(map[string]interface{})
+		toDoc, toOk := to.Value.(map[string]interface{})
+		if fromOk && toOk {
+			deepDiff(changes, f, p, fromDoc, toDoc)
+			continue
+		}
+		*changes = append(*changes, newChange(f, p, from, to))
+	}
+}
+
+var differs = struct {
+	sync.RWMutex
+	m map[string]Differ
+}{m: map[string]Differ{}}
+
+// RegisterDiffer compares the snapshots of table with differ, e.g.
+// DeepDiffer for a table with large JSON columns
+func RegisterDiffer(table string, differ Differ) {
+	differs.Lock()
+	defer differs.Unlock()
+	differs.m[table] = differ
+}
+
+func differFor(table string) Differ {
+	differs.RLock()
+	defer differs.RUnlock()
+	if differ, ok := differs.m[table]; ok {
+		return differ
+	}
+	return ShallowDiffer
+}
+
+// PatchOperation is an operation of a JSON Patch (RFC 6902)
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	From  string      `json:"from,omitempty"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON leaves the value out of remove, move and copy operations, and
+// keeps a null one in the others
+func (o PatchOperation) MarshalJSON() ([]byte, error) {
+	switch o.Op {
+	case "remove":
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	case "move", "copy":
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			From string `json:"from"`
+			Path string `json:"path"`
+		}{o.Op, o.From, o.Path})
+	}
+	type operation PatchOperation
+	return json.Marshal(operation(o))
+}
+
+// JSONPatch is a JSON Patch (RFC 6902) document
+type JSONPatch []PatchOperation
+
+// PatchOf returns changes as a JSON Patch turning the before snapshot into the
+// after one
+func PatchOf(changes []Change) JSONPatch {
+	patch := make(JSONPatch, 0, len(changes))
+	for _, change := range changes {
+		path := change.Path
+		if path == "" {
+			path = "/" + escapePointer(change.Field)
+		}
+		switch {
+		case !change.To.Present:
+			patch = append(patch, PatchOperation{Op: "remove", Path: path})
+		case !change.From.Present:
+			patch = append(patch, PatchOperation{Op: "add", Path: path, Value: change.To.Value})
+		default:
+			patch = append(patch, PatchOperation{Op: "replace", Path: path, Value: change.To.Value})
+		}
+	}
+	return patch
+}
+
+// EntryPatch returns the JSON Patch from the data of before to the data of
+// after, two entries of a table, computed by the Differ of the table
+func EntryPatch(before, after AuditLog) (JSONPatch, error) {
+	changes, err := DiffEntries(before, after)
+	if err != nil {
+		return nil, err
+	}
+	return PatchOf(changes), nil
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func sameValue(from, to FieldValue) bool {
+	return from.Present == to.Present && from.Null == to.Null && reflect.DeepEqual(from.Value, to.Value)
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// escapePointer escapes a member name for a JSON Pointer
+func escapePointer(key string) string {
+	return pointerEscaper.Replace(key)
+}
@@ -0,0 +1,48 @@
+package audited
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestDeepDifferPatch(t *testing.T) {
+	defer func() {
+		differs.Lock()
+		delete(differs.m, "accounts")
+		differs.Unlock()
+	}()
+	before := AuditLog{TableName: "accounts", Data: datatypes.JSON(
+		`{"id":"1","settings":{"theme":"dark","notify":{"email":true,"sms":false},"a/b":1},"tags":["x"],"note":"hi"}`)}
+	after := AuditLog{TableName: "accounts", Data: datatypes.JSON(
+		`{"id":"1","settings":{"theme":"light","notify":{"email":true},"a/b":null,"lang":"fr"},"tags":["x","y"]}`)}
+
+	shallow, err := EntryPatch(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := json.Marshal(shallow); string(got) != `[{"op":"remove","path":"/note"},`+
+		`{"op":"replace","path":"/settings","value":{"a/b":null,"lang":"fr","notify":{"email":true},"theme":"light"}},`+
+		`{"op":"replace","path":"/tags","value":["x","y"]}]` {
+		t.Errorf("got shallow patch %s", got)
+	}
+
+	RegisterDiffer("accounts", DeepDiffer)
+	deep, err := EntryPatch(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := json.Marshal(deep); string(got) != `[{"op":"remove","path":"/note"},`+
+		`{"op":"replace","path":"/settings/a~1b","value":null},`+
+		`{"op":"add","path":"/settings/lang","value":"fr"},`+
+		`{"op":"remove","path":"/settings/notify/sms"},`+
+		`{"op":"replace","path":"/settings/theme","value":"light"},`+
+		`{"op":"replace","path":"/tags","value":["x","y"]}]` {
+		t.Errorf("got deep patch %s", got)
+	}
+	changes, _ := DiffEntries(before, after)
+	if changes[1].Field != "settings" || changes[1].Path != "/settings/a~1b" {
+		t.Errorf("got change %+v", changes[1])
+	}
+}
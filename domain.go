@@ -0,0 +1,26 @@
+package audited
+
+import (
+	"gorm.io/gorm"
+)
+
+// WithDomain groups tables into the business domain domain, e.g. "billing",
+// stored in the domain of their entries. Audits can then be read, see
+// InDomain, and retained, see RetentionPolicy.Domains, per domain. A table is
+// in a single domain, the last one given.
+func WithDomain(domain string, tables ...string) Option {
+	return func(o *options) {
+		for _, table := range tables {
+			o.domains[table] = domain
+		}
+	}
+}
+
+// InDomain is a scope selecting the entries of the tables of domain, e.g.
+//
+//	audited.Query(db).Scopes(audited.InDomain("billing")).Find(&entries)
+func InDomain(domain string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("domain = ?", domain)
+	}
+}
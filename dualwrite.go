@@ -0,0 +1,129 @@
+package audited
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WithServiceName records name in the "service" metadata of every entry
+// written through the database, identifying the writer of a table shared by
+// several services, see DetectDualWrites
+func WithServiceName(name string) Option {
+	return func(o *options) {
+		o.serviceName = name
+	}
+}
+
+const defaultDualWriteWindow = 5 * time.Second
+
+// DualWriteOptions selects the entries compared by DetectDualWrites
+type DualWriteOptions struct {
+	// Tables written by both services
+	Tables []string
+	Since  time.Time
+	// Window is how close two changes of an object by different services must
+	// be to count as concurrent, defaults to 5 seconds
+	Window time.Duration
+	// IgnoreFields differ between the writes of the services without it being
+	// a conflict, e.g. updated_at
+	IgnoreFields []string
+}
+
+// DualWriteConflict is a pair of concurrent changes of an object by different
+// services that disagree on its data
+type DualWriteConflict struct {
+	TableName string   `json:"table_name"`
+	ObjectId  string   `json:"object_id"`
+	First     AuditLog `json:"first"`
+	Second    AuditLog `json:"second"`
+	Changes   []Change `json:"changes"`
+}
+
+// DetectDualWrites compares the changes of the objects of opts.Tables made by
+// different services, per WithServiceName, and returns the concurrent ones that
+// disagree, e.g. while migrating writes of a table to a new service that runs
+// in shadow next to the old one.
+func DetectDualWrites(ctx context.Context, db *gorm.DB, opts DualWriteOptions) ([]DualWriteConflict, error) {
+	if opts.Window <= 0 {
+		opts.Window = defaultDualWriteWindow
+	}
+	query := Query(Reader(db.WithContext(ctx))).Where("table_name IN ?", opts.Tables)
+	if !opts.Since.IsZero() {
+		query = query.Where("created_at >= ?", opts.Since)
+	}
+	detector := newDualWriteDetector(opts)
+	if err := eachEntry(query.Session(&gorm.Session{}), func(entry AuditLog) error {
+		detector.add(entry)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return detector.conflicts, nil
+}
+
+type dualWriteDetector struct {
+	window    time.Duration
+	ignore    map[string]bool
+	latest    map[watchKey]map[string]AuditLog
+	conflicts []DualWriteConflict
+}
+
+func newDualWriteDetector(opts DualWriteOptions) *dualWriteDetector {
+	ignore := map[string]bool{}
+	for _, field := range opts.IgnoreFields {
+		ignore[field] = true
+	}
+	return &dualWriteDetector{window: opts.Window, ignore: ignore, latest: map[watchKey]map[string]AuditLog{}}
+}
+
+// add compares entry, given in sequence order, with the latest change of its
+// object by every other service
+func (d *dualWriteDetector) add(entry AuditLog) {
+	service, _ := entry.Metadata["service"].(string)
+	if service == "" {
+		return
+	}
+	key := watchKey{entry.TableName, entry.ObjectId}
+	if d.latest[key] == nil {
+		d.latest[key] = map[string]AuditLog{}
+	}
+	for other, previous := range d.latest[key] {
+		if other == service || entry.CreatedAt.Sub(previous.CreatedAt) > d.window {
+			continue
+		}
+		if changes := d.disagreement(previous, entry); len(changes) > 0 {
+			d.conflicts = append(d.conflicts, DualWriteConflict{
+				TableName: entry.TableName,
+				ObjectId:  entry.ObjectId,
+				First:     previous,
+				Second:    entry,
+				Changes:   changes,
+			})
+		}
+	}
+	d.latest[key][service] = entry
+}
+
+// disagreement returns the fields two changes of an object disagree on
+func (d *dualWriteDetector) disagreement(a, b AuditLog) []Change {
+	var changes []Change
+	if a.OperationType != b.OperationType {
+		changes = append(changes, Change{
+			Field: "operation_type",
+			From:  FieldValue{Present: true, Value: a.OperationType},
+			To:    FieldValue{Present: true, Value: b.OperationType},
+		})
+	}
+	diff, err := DiffEntries(a, b)
+	if err != nil {
+		return changes
+	}
+	for _, change := range diff {
+		if !d.ignore[change.Field] {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,46 @@
+package audited
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+func TestDualWriteDetector(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	write := func(service, object string, offset time.Duration, data string) AuditLog {
+		return AuditLog{
+			TableName:     "orders",
+			ObjectId:      object,
+			OperationType: OperationUpdate,
+			Data:          datatypes.JSON(data),
+			Metadata:      map[string]interface{}{"service": service},
+			CreatedAt:     start.Add(offset),
+		}
+	}
+	d := newDualWriteDetector(DualWriteOptions{Window: 5 * time.Second, IgnoreFields: []string{"updated_at"}})
+	for _, entry := range []AuditLog{
+		// both services agree, apart from the ignored field
+		write("old", "1", 0, `{"status":"paid","updated_at":"a"}`),
+		write("new", "1", time.Second, `{"status":"paid","updated_at":"b"}`),
+		// the services disagree
+		write("old", "2", 0, `{"status":"paid"}`),
+		write("new", "2", 2*time.Second, `{"status":"failed"}`),
+		// too far apart to be concurrent
+		write("old", "3", 0, `{"status":"paid"}`),
+		write("new", "3", time.Minute, `{"status":"refunded"}`),
+		// the same service changing an object twice
+		write("old", "4", 0, `{"status":"paid"}`),
+		write("old", "4", time.Second, `{"status":"refunded"}`),
+	} {
+		d.add(entry)
+	}
+	if len(d.conflicts) != 1 {
+		t.Fatalf("got %d conflicts, want 1: %+v", len(d.conflicts), d.conflicts)
+	}
+	conflict := d.conflicts[0]
+	if conflict.ObjectId != "2" || len(conflict.Changes) != 1 || conflict.Changes[0].Field != "status" {
+		t.Fatalf("got conflict %+v", conflict)
+	}
+}
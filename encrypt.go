@@ -0,0 +1,343 @@
+package audited
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// KeyProvider supplies the data keys payloads are encrypted with. A data key
+// is stored wrapped, encrypted by a master key that never leaves the provider,
+// next to the payloads it encrypts, e.g. a KMS or the transit engine of Vault.
+type KeyProvider interface {
+	// KeyID identifies the master key of the provider
+	KeyID() string
+	// GenerateDataKey returns a new 256 bit data key and its wrapped form
+	GenerateDataKey(ctx context.Context) (key, wrapped []byte, err error)
+	// DecryptDataKey returns the data key of a wrapped form returned by GenerateDataKey
+	DecryptDataKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+var keyProviders = struct {
+	sync.RWMutex
+	byID map[string]KeyProvider
+}{byID: map[string]KeyProvider{}}
+
+// RegisterKeyProvider makes the key of p available for encrypting and
+// decrypting payloads. Keep the providers of retired keys registered for as
+// long as payloads encrypted with them are read, see Rekey.
+func RegisterKeyProvider(p KeyProvider) {
+	keyProviders.Lock()
+	defer keyProviders.Unlock()
+	keyProviders.byID[p.KeyID()] = p
+}
+
+func keyProvider(keyID string) (KeyProvider, error) {
+	keyProviders.RLock()
+	defer keyProviders.RUnlock()
+	p, ok := keyProviders.byID[keyID]
+	if !ok {
+		return nil, fmt.Errorf("audited: no key provider registered for key %q", keyID)
+	}
+	return p, nil
+}
+
+// WithEncryption encrypts the payloads of the entries of the database with the
+// registered key keyID, without it they are stored in plain text
+func WithEncryption(keyID string) Option {
+	return func(o *options) {
+		o.encryptionKeyID = keyID
+	}
+}
+
+// encryptionKeyID returns the key new payloads of db are encrypted with
+func encryptionKeyID(db *gorm.DB) string {
+	return configFor(db).encryptionKeyID
+}
+
+// DataKeyTTL is how long a data key encrypts new payloads before a new one is
+// generated, so the provider is not called on every write
+var DataKeyTTL = time.Hour
+
+// ErrKeyUnavailable is returned for payloads whose data key can't be unwrapped
+var ErrKeyUnavailable = errors.New("audited: data key unavailable")
+
+// ErrUnencryptedPayload is returned for the entries of a database with
+// encryption that would store data in plain text next to their payloads, the
+// summaries and field changes of RegisterSummary and RegisterLongFormat
+var ErrUnencryptedPayload = errors.New("audited: summaries and field changes can't be encrypted")
+
+// encryptedPayload is stored in place of the data of an encrypted entry
+type encryptedPayload struct {
+	KeyID      string `json:"kid"`
+	DataKey    []byte `json:"dek"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+	// Bound payloads are authenticated with the id of their entry and their
+	// column, so they can't be moved to another entry or column; payloads
+	// written before are not
+	Bound bool `json:"bound,omitempty"`
+}
+
+const encryptedPayloadKey = "$encrypted"
+
+type dataKey struct {
+	keyID   string
+	key     []byte
+	wrapped []byte
+	expires time.Time
+}
+
+var dataKeys = struct {
+	sync.Mutex
+	current   map[string]*dataKey
+	unwrapped map[string][]byte
+}{current: map[string]*dataKey{}, unwrapped: map[string][]byte{}}
+
+// currentDataKey returns the data key new payloads are encrypted with under
+// keyID, generating one when there is none or it expired
+func currentDataKey(ctx context.Context, keyID string) (*dataKey, error) {
+	dataKeys.Lock()
+	defer dataKeys.Unlock()
+	if k := dataKeys.current[keyID]; k != nil && time.Now().Before(k.expires) {
+		return k, nil
+	}
+	p, err := keyProvider(keyID)
+	if err != nil {
+		return nil, err
+	}
+	key, wrapped, err := p.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	k := &dataKey{keyID: keyID, key: key, wrapped: wrapped, expires: time.Now().Add(DataKeyTTL)}
+	dataKeys.current[keyID] = k
+	dataKeys.unwrapped[keyID+"/"+string(wrapped)] = key
+	return k, nil
+}
+
+// unwrapDataKey returns the data key of payload, unwrapping it once per key
+func unwrapDataKey(ctx context.Context, payload encryptedPayload) ([]byte, error) {
+	cacheKey := payload.KeyID + "/" + string(payload.DataKey)
+	dataKeys.Lock()
+	key, ok := dataKeys.unwrapped[cacheKey]
+	dataKeys.Unlock()
+	if ok {
+		return key, nil
+	}
+	p, err := keyProvider(payload.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = p.DecryptDataKey(ctx, payload.DataKey); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeyUnavailable, err.Error())
+	}
+	dataKeys.Lock()
+	dataKeys.unwrapped[cacheKey] = key
+	dataKeys.Unlock()
+	return key, nil
+}
+
+// payloadAAD returns the additional data binding the payload of column to the
+// entry id, nil for entries without an id yet
+func payloadAAD(id ID, column string) []byte {
+	if id == "" {
+		return nil
+	}
+	return []byte(string(id) + "/" + column)
+}
+
+// encryptPayload encrypts data with a data key of keyID, bound to aad
+func encryptPayload(ctx context.Context, keyID string, aad []byte, data datatypes.JSON) (datatypes.JSON, error) {
+	k, err := currentDataKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]encryptedPayload{encryptedPayloadKey: {
+		KeyID:      k.keyID,
+		DataKey:    k.wrapped,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, data, aad),
+		Bound:      aad != nil,
+	}})
+}
+
+// DecryptPayload returns the plain text of column, data or old_data, of the
+// entry id read without gorm, e.g. with database/sql from a view, which is
+// returned as is when it isn't encrypted
+func DecryptPayload(ctx context.Context, id ID, column string, data []byte) ([]byte, error) {
+	return decryptPayload(ctx, payloadAAD(id, column), data)
+}
+
+// decryptPayload returns the plain text of data bound to aad, which is
+// returned as is when it isn't encrypted
+func decryptPayload(ctx context.Context, aad []byte, data datatypes.JSON) (datatypes.JSON, error) {
+	payload, ok := parseEncryptedPayload(data)
+	if !ok {
+		return data, nil
+	}
+	key, err := unwrapDataKey(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if !payload.Bound {
+		aad = nil
+	}
+	plain, err := aead.Open(nil, payload.Nonce, payload.Ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("audited: error decrypting payload: %w", err)
+	}
+	return plain, nil
+}
+
+func parseEncryptedPayload(data datatypes.JSON) (encryptedPayload, bool) {
+	if !bytes.Contains(data, []byte(encryptedPayloadKey)) {
+		return encryptedPayload{}, false
+	}
+	var wrapper map[string]*encryptedPayload
+	if err := json.Unmarshal(data, &wrapper); err != nil || len(wrapper) != 1 || wrapper[encryptedPayloadKey] == nil {
+		return encryptedPayload{}, false
+	}
+	return *wrapper[encryptedPayloadKey], true
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// AfterFind decrypts the data of entries read with gorm
+func (l *AuditLog) AfterFind(tx *gorm.DB) error {
+	data, err := decryptPayload(tx.Statement.Context, payloadAAD(l.Id, "data"), l.Data)
+	if err != nil {
+		return err
+	}
+	l.Data = data
+	if l.OldData, err = decryptPayload(tx.Statement.Context, payloadAAD(l.Id, "old_data"), l.OldData); err != nil {
+		return err
+	}
+	return nil
+}
+
+// StaticKeyProvider wraps data keys with a master key held in memory, for
+// development and tests; use a KMS or Vault in production
+type StaticKeyProvider struct {
+	id        string
+	masterKey []byte
+}
+
+// NewStaticKeyProvider returns a provider wrapping data keys with masterKey,
+// which must be 16, 24 or 32 bytes long
+func NewStaticKeyProvider(id string, masterKey []byte) *StaticKeyProvider {
+	return &StaticKeyProvider{id: id, masterKey: masterKey}
+}
+
+// KeyID returns the id the provider was created with
+func (p *StaticKeyProvider) KeyID() string {
+	return p.id
+}
+
+// GenerateDataKey returns a random data key wrapped with the master key
+func (p *StaticKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newGCM(p.masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return key, aead.Seal(nonce, nonce, key, nil), nil
+}
+
+// DecryptDataKey unwraps a data key with the master key
+func (p *StaticKeyProvider) DecryptDataKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(p.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("audited: wrapped data key too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+}
+
+// checkEncryption refuses logs whose summary or field changes would be
+// stored in plain text next to their encrypted payloads
+func checkEncryption(db *gorm.DB, logs []AuditLog) error {
+	if encryptionKeyID(db) == "" {
+		return nil
+	}
+	for _, entry := range logs {
+		if entry.Summary != "" || len(entry.changes) > 0 {
+			return fmt.Errorf("%w: entry %s of %s", ErrUnencryptedPayload, entry.Id, entry.TableName)
+		}
+	}
+	return nil
+}
+
+// encryptLogs encrypts the data and old data of logs in place with keyID,
+// bound to their ids, and returns a function restoring their plain text
+func encryptLogs(ctx context.Context, keyID string, logs []AuditLog) (restore func(), err error) {
+	plain, plainOld := make([]datatypes.JSON, len(logs)), make([]datatypes.JSON, len(logs))
+	restore = func() {
+		for i := range logs {
+			if plain[i] != nil {
+				logs[i].Data = plain[i]
+			}
+			if plainOld[i] != nil {
+				logs[i].OldData = plainOld[i]
+			}
+		}
+	}
+	for i := range logs {
+		if len(logs[i].Data) > 0 {
+			encrypted, err := encryptPayload(ctx, keyID, payloadAAD(logs[i].Id, "data"), logs[i].Data)
+			if err != nil {
+				restore()
+				return nil, err
+			}
+			plain[i] = logs[i].Data
+			logs[i].Data = encrypted
+		}
+		if len(logs[i].OldData) > 0 {
+			encrypted, err := encryptPayload(ctx, keyID, payloadAAD(logs[i].Id, "old_data"), logs[i].OldData)
+			if err != nil {
+				restore()
+				return nil, err
+			}
+			plainOld[i] = logs[i].OldData
+			logs[i].OldData = encrypted
+		}
+	}
+	return restore, nil
+}
@@ -0,0 +1,139 @@
+package audited
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestEncryptLogs(t *testing.T) {
+	RegisterKeyProvider(NewStaticKeyProvider("test-key", bytes.Repeat([]byte{1}, 32)))
+
+	ctx := context.Background()
+	plain := datatypes.JSON(`{"id":"7","email":"ann@example.com"}`)
+	old := datatypes.JSON(`{"id":"7","email":"ann@example.org"}`)
+	logs := []AuditLog{{Id: "entry-1", Data: plain, OldData: old}, {}}
+	restore, err := encryptLogs(ctx, "test-key", logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := logs[0].Data
+	if bytes.Contains(encrypted, []byte("ann@example.com")) {
+		t.Fatalf("payload stored in plain text: %s", encrypted)
+	}
+	if bytes.Contains(logs[0].OldData, []byte("ann@example.org")) {
+		t.Fatalf("old data stored in plain text: %s", logs[0].OldData)
+	}
+	if logs[1].Data != nil || logs[1].OldData != nil {
+		t.Fatalf("empty payload encrypted: %s", logs[1].Data)
+	}
+	restore()
+	if string(logs[0].Data) != string(plain) || string(logs[0].OldData) != string(old) {
+		t.Fatalf("got %s and %s after restore", logs[0].Data, logs[0].OldData)
+	}
+
+	decrypted, err := decryptPayload(ctx, payloadAAD("entry-1", "data"), encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != string(plain) {
+		t.Fatalf("got %s, want %s", decrypted, plain)
+	}
+	if data, err := decryptPayload(ctx, payloadAAD("entry-1", "data"), plain); err != nil || string(data) != string(plain) {
+		t.Fatalf("plain text payload changed: %s, %v", data, err)
+	}
+}
+
+func TestDecryptUnknownKey(t *testing.T) {
+	provider := NewStaticKeyProvider("retired-key", bytes.Repeat([]byte{2}, 32))
+	_, wrapped, err := provider.GenerateDataKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewStaticKeyProvider("retired-key", bytes.Repeat([]byte{3}, 32))
+	if _, err := other.DecryptDataKey(context.Background(), wrapped); err == nil {
+		t.Fatal("unwrapped a data key with the wrong master key")
+	}
+	RegisterKeyProvider(other)
+	payload := encryptedPayload{KeyID: "retired-key", DataKey: wrapped}
+	if _, err := unwrapDataKey(context.Background(), payload); !errors.Is(err, ErrKeyUnavailable) {
+		t.Fatalf("got %v, want ErrKeyUnavailable", err)
+	}
+}
+
+func TestWithEncryption(t *testing.T) {
+	RegisterKeyProvider(NewStaticKeyProvider("tenant-key", bytes.Repeat([]byte{2}, 32)))
+	db := statementFor(t, &Invoice{})
+	if keyID := encryptionKeyID(db); keyID != "" {
+		t.Fatalf("got key %q without encryption", keyID)
+	}
+	RegisterCallbacks(db, WithEncryption("tenant-key"))
+	if keyID := encryptionKeyID(db); keyID != "tenant-key" {
+		t.Fatalf("got key %q, want tenant-key", keyID)
+	}
+
+	ctx := context.Background()
+	logs := []AuditLog{{Id: "entry-1", Data: datatypes.JSON(`{"id":"7"}`)}}
+	if _, err := encryptLogs(ctx, encryptionKeyID(db), logs); err != nil {
+		t.Fatal(err)
+	}
+	payload, ok := parseEncryptedPayload(logs[0].Data)
+	if !ok || payload.KeyID != "tenant-key" {
+		t.Fatalf("payload not encrypted with tenant-key: %s", logs[0].Data)
+	}
+	plain, err := DecryptPayload(ctx, "entry-1", "data", logs[0].Data)
+	if err != nil || string(plain) != `{"id":"7"}` {
+		t.Fatalf("got %s, %v", plain, err)
+	}
+}
+
+func TestPayloadBoundToEntry(t *testing.T) {
+	RegisterKeyProvider(NewStaticKeyProvider("test-key", bytes.Repeat([]byte{1}, 32)))
+	ctx := context.Background()
+	logs := []AuditLog{{Id: "entry-1", Data: datatypes.JSON(`{"total":2}`), OldData: datatypes.JSON(`{"total":1}`)}}
+	if _, err := encryptLogs(ctx, "test-key", logs); err != nil {
+		t.Fatal(err)
+	}
+	// a payload moved to another entry or column doesn't decrypt
+	if _, err := decryptPayload(ctx, payloadAAD("entry-2", "data"), logs[0].Data); err == nil {
+		t.Fatal("decrypted the payload of another entry")
+	}
+	if _, err := decryptPayload(ctx, payloadAAD("entry-1", "data"), logs[0].OldData); err == nil {
+		t.Fatal("decrypted the old data as the data")
+	}
+	entry := AuditLog{Id: "entry-2", Data: logs[0].Data}
+	if err := entry.AfterFind(statementFor(t, &Invoice{})); err == nil {
+		t.Fatal("read an entry with the payload of another")
+	}
+
+	// payloads encrypted before they were bound still decrypt
+	legacy, err := encryptPayload(ctx, "test-key", nil, datatypes.JSON(`{"total":3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := decryptPayload(ctx, payloadAAD("entry-1", "data"), legacy); err != nil || string(plain) != `{"total":3}` {
+		t.Fatalf("got %s, %v", plain, err)
+	}
+}
+
+func TestCheckEncryption(t *testing.T) {
+	db := statementFor(t, &Invoice{})
+	logs := []AuditLog{{Id: "entry-1", TableName: "invoices", Summary: "total changed"}}
+	if err := checkEncryption(db, logs); err != nil {
+		t.Fatalf("got %v without encryption", err)
+	}
+	RegisterCallbacks(db, WithEncryption("test-key"))
+	if err := checkEncryption(db, logs); !errors.Is(err, ErrUnencryptedPayload) {
+		t.Fatalf("got %v, want ErrUnencryptedPayload for a summary", err)
+	}
+	logs = []AuditLog{{Id: "entry-1", TableName: "invoices", changes: []FieldChange{{Field: "total"}}}}
+	if err := checkEncryption(db, logs); !errors.Is(err, ErrUnencryptedPayload) {
+		t.Fatalf("got %v, want ErrUnencryptedPayload for field changes", err)
+	}
+	if err := checkEncryption(db, []AuditLog{{Id: "entry-1"}}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Enricher adds information to an audit entry before it is written, e.g. the
+// department or team of the actor looked up from a directory
+type Enricher func(ctx context.Context, entry *AuditLog) error
+
+var enrichers = struct {
+	sync.RWMutex
+	list []Enricher
+}{}
+
+// EnrichTimeout bounds the time enrichers get for an entry. Enrichment runs
+// before the entry is written, inside the transaction of the change, so keep
+// it short and back directory lookups with a cache. Entries whose enrichment
+// doesn't finish in time are written without it.
+var EnrichTimeout = 50 * time.Millisecond
+
+// RegisterEnricher adds an enricher run on every entry, in registration order
+func RegisterEnricher(e Enricher) {
+	enrichers.Lock()
+	defer enrichers.Unlock()
+	enrichers.list = append(enrichers.list, e)
+}
+
+// SetMetadata sets a metadata value of the entry
+func (l *AuditLog) SetMetadata(key string, value interface{}) {
+	if l.Metadata == nil {
+		l.Metadata = map[string]interface{}{}
+	}
+	l.Metadata[key] = value
+}
+
+// enrich runs the registered enrichers on a copy of entry in their own
+// goroutine, so a slow directory can't hold the write for longer than
+// EnrichTimeout, and applies their changes when they finish in time. The
+// context given to enrichers is cancelled at the timeout.
+func enrich(ctx context.Context, entry *AuditLog) {
+	enrichers.RLock()
+	list := enrichers.list
+	enrichers.RUnlock()
+	if len(list) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, EnrichTimeout)
+	defer cancel()
+
+	enriched := *entry
+	enriched.Metadata = make(map[string]interface{}, len(entry.Metadata))
+	for k, v := range entry.Metadata {
+		enriched.Metadata[k] = v
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, e := range list {
+			if err := e(ctx, &enriched); err != nil {
+				log.Println(fmt.Errorf("error enriching audit log: %s", err.Error()))
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+		if len(enriched.Metadata) == 0 {
+			enriched.Metadata = entry.Metadata
+		}
+		*entry = enriched
+	case <-ctx.Done():
+		log.Println("audit log enrichment timed out, writing entry without it")
+	}
+}
@@ -0,0 +1,58 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func withEnrichers(t *testing.T, list ...Enricher) {
+	enrichers.Lock()
+	saved := enrichers.list
+	enrichers.list = list
+	enrichers.Unlock()
+	t.Cleanup(func() {
+		enrichers.Lock()
+		enrichers.list = saved
+		enrichers.Unlock()
+	})
+}
+
+func TestEnrich(t *testing.T) {
+	withEnrichers(t,
+		func(ctx context.Context, entry *AuditLog) error {
+			entry.SetMetadata("team", "sales")
+			return nil
+		},
+		func(ctx context.Context, entry *AuditLog) error {
+			return errors.New("directory unavailable")
+		},
+		func(ctx context.Context, entry *AuditLog) error {
+			entry.SetMetadata("department", "emea")
+			return nil
+		},
+	)
+	entry := &AuditLog{UserId: "ana"}
+	enrich(context.Background(), entry)
+	if entry.Metadata["team"] != "sales" || entry.Metadata["department"] != "emea" {
+		t.Errorf("got metadata %v", entry.Metadata)
+	}
+}
+
+func TestEnrichTimeout(t *testing.T) {
+	withEnrichers(t, func(ctx context.Context, entry *AuditLog) error {
+		entry.SetMetadata("team", "sales")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	entry := &AuditLog{UserId: "ana", Metadata: map[string]interface{}{"source": "api"}}
+	start := time.Now()
+	enrich(context.Background(), entry)
+	if elapsed := time.Since(start); elapsed > EnrichTimeout+50*time.Millisecond {
+		t.Errorf("enrichment held the write for %s", elapsed)
+	}
+	if len(entry.Metadata) != 1 || entry.Metadata["source"] != "api" {
+		t.Errorf("timed out enrichment changed the entry: %v", entry.Metadata)
+	}
+}
@@ -0,0 +1,7 @@
+// Package e2e is the end to end compatibility suite of the audit callbacks,
+// run against real postgres, mysql and sqlite databases. Databases come from
+// the E2E_POSTGRES_DSN and E2E_MYSQL_DSN environment variables, or are started
+// as docker containers with dockertest when those are unset. sqlite runs on a temporary file.
+//
+//	go test -tags e2e ./e2e -e2e.dialects postgres,mysql,sqlite
+package e2e
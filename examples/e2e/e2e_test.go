@@ -0,0 +1,76 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mleonidas/audited"
+	"github.com/mleonidas/audited/examples/internal/exampledb"
+	"gorm.io/gorm"
+)
+
+var (
+	dialects  = flag.String("e2e.dialects", "postgres,mysql,sqlite", "comma separated dialects to test")
+	useDocker = flag.Bool("e2e.docker", true, "start databases in docker when no DSN is set")
+)
+
+func TestE2E(t *testing.T) {
+	for _, dialect := range strings.Split(*dialects, ",") {
+		dialect = strings.TrimSpace(dialect)
+		t.Run(dialect, func(t *testing.T) {
+			db := open(t, dialect)
+			for _, s := range scenarios {
+				t.Run(s.name, func(t *testing.T) {
+					s.run(t, db)
+				})
+			}
+		})
+	}
+}
+
+func open(t *testing.T, dialect string) *gorm.DB {
+	t.Helper()
+	var dsn string
+	switch dialect {
+	case "postgres", "mysql":
+		dsn = os.Getenv("E2E_" + strings.ToUpper(dialect) + "_DSN")
+		if dsn == "" {
+			if !*useDocker {
+				t.Skipf("E2E_%s_DSN is not set", strings.ToUpper(dialect))
+			}
+			var err error
+			if dsn, err = startContainer(t, dialect); err != nil {
+				t.Fatal(err)
+			}
+		}
+	case "sqlite":
+		dsn = filepath.Join(t.TempDir(), "e2e.db")
+	default:
+		t.Fatalf("unsupported dialect %q", dialect)
+	}
+
+	db, err := exampledb.Open(dialect, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setup(db); err != nil {
+		t.Fatal(fmt.Errorf("setup: %w", err))
+	}
+	return db
+}
+
+func setup(db *gorm.DB) error {
+	if err := exampledb.CreateAuditTable(db); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&Widget{}); err != nil {
+		return err
+	}
+	return audited.RegisterCallbacks(db)
+}
@@ -0,0 +1,1259 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mleonidas/audited"
+	"github.com/mleonidas/audited/examples/internal/exampledb"
+	"gorm.io/gorm"
+)
+
+// Widget is the audited model used by the scenarios
+type Widget struct {
+	Id        string         `json:"id" gorm:"primaryKey;size:64"`
+	Name      string         `json:"name"`
+	Quantity  int            `json:"quantity"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at"`
+}
+
+// Part is stored in long format, see testLongFormat
+type Part struct {
+	Id       string `json:"id" gorm:"primaryKey;size:64"`
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+type scenario struct {
+	name string
+	run  func(t *testing.T, db *gorm.DB)
+}
+
+var scenarios = []scenario{
+	{"create update delete", testCreateUpdateDelete},
+	{"transaction commit", testTransactionCommit},
+	{"transaction rollback", testTransactionRollback},
+	{"soft delete", testSoftDelete},
+	{"deferred flush", testDeferredFlush},
+	{"deferred flush rollback", testDeferredFlushRollback},
+	{"bulk create", testBulkCreate},
+	{"bulk update", testBulkUpdate},
+	{"bulk delete", testBulkDelete},
+	{"tenant quota", testTenantQuota},
+	{"tenant offboarding", testTenantOffboarding},
+	{"erasure anonymize", testErasureAnonymize},
+	{"last change", testLastChange},
+	{"encrypted replica", testEncryptedReplica},
+	{"audit database commit", testAuditDBCommit},
+	{"trail cache", testTrailCache},
+	{"watch", testWatch},
+	{"bulk mode", testBulkMode},
+	{"data subject request", testDataSubjectRequest},
+	{"replicate job", testReplicateJob},
+	{"coverage", testCoverage},
+	{"admin actions", testAdminActions},
+	{"long format", testLongFormat},
+	{"replication", testReplication},
+	{"pseudonymized view", testPseudonymizedView},
+	{"indexes", testIndexes},
+	{"maintenance", testMaintenance},
+	{"session actor", testSessionActor},
+}
+
+func userContext(user string) context.Context {
+	return context.WithValue(context.Background(), audited.ContextKeyEmail, user)
+}
+
+func newWidget(name string) *Widget {
+	return &Widget{Id: uuid.NewString(), Name: name, Quantity: 1}
+}
+
+// expectTrail checks the operations recorded for a widget, in order
+func expectTrail(t *testing.T, db *gorm.DB, id string, operations ...string) []audited.AuditLog {
+	t.Helper()
+	trail, err := audited.TrailFor(db, "widgets", id)
+	if err != nil {
+		t.Fatalf("reading trail: %s", err)
+	}
+	if len(trail) != len(operations) {
+		t.Fatalf("got %d entries, want %d: %v", len(trail), len(operations), trail)
+	}
+	for i, entry := range trail {
+		if entry.OperationType != operations[i] {
+			t.Errorf("entry %d: got operation %s, want %s", i, entry.OperationType, operations[i])
+		}
+	}
+	return trail
+}
+
+func testCreateUpdateDelete(t *testing.T, db *gorm.DB) {
+	db = db.WithContext(userContext("e2e@example.com"))
+	w := newWidget("gear")
+	if err := db.Create(w).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if err := db.Model(w).Update("quantity", 5).Error; err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if err := db.Unscoped().Delete(w).Error; err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+
+	trail := expectTrail(t, db, w.Id, audited.OperationCreate, audited.OperationUpdate, audited.OperationHardDelete)
+	for _, entry := range trail {
+		if entry.UserId != "e2e@example.com" {
+			t.Errorf("%s: got user %q", entry.OperationType, entry.UserId)
+		}
+	}
+}
+
+func testTransactionCommit(t *testing.T, db *gorm.DB) {
+	db = db.WithContext(userContext("e2e@example.com"))
+	w := newWidget("committed")
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(w).Error
+	}); err != nil {
+		t.Fatalf("transaction: %s", err)
+	}
+	expectTrail(t, db, w.Id, audited.OperationCreate)
+}
+
+func testTransactionRollback(t *testing.T, db *gorm.DB) {
+	db = db.WithContext(userContext("e2e@example.com"))
+	w := newWidget("rolled back")
+	rollback := errors.New("rollback")
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(w).Error; err != nil {
+			return err
+		}
+		return rollback
+	}); !errors.Is(err, rollback) {
+		t.Fatalf("transaction: %v", err)
+	}
+	// the audit entry is written in the same transaction and rolled back with it
+	expectTrail(t, db, w.Id)
+}
+
+func testSoftDelete(t *testing.T, db *gorm.DB) {
+	db = db.WithContext(userContext("e2e@example.com"))
+	w := newWidget("soft")
+	if err := db.Create(w).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if err := db.Delete(w).Error; err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	var count int64
+	db.Unscoped().Model(&Widget{}).Where("id = ?", w.Id).Count(&count)
+	if count != 1 {
+		t.Errorf("soft deleted row is gone")
+	}
+	expectTrail(t, db, w.Id, audited.OperationCreate, audited.OperationSoftDelete)
+
+	if err := db.Unscoped().Model(w).Update("deleted_at", nil).Error; err != nil {
+		t.Fatalf("restore: %s", err)
+	}
+	if err := db.Unscoped().Delete(w).Error; err != nil {
+		t.Fatalf("hard delete: %s", err)
+	}
+	expectTrail(t, db, w.Id, audited.OperationCreate, audited.OperationSoftDelete,
+		audited.OperationRestore, audited.OperationHardDelete)
+}
+
+func testDeferredFlush(t *testing.T, db *gorm.DB) {
+	ctx := audited.WithDeferredFlush(userContext("e2e@example.com"))
+	w := newWidget("deferred")
+	if err := db.WithContext(ctx).Create(w).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	expectTrail(t, db, w.Id)
+	if err := audited.Flush(ctx, db); err != nil {
+		t.Fatalf("flush: %s", err)
+	}
+	expectTrail(t, db, w.Id, audited.OperationCreate)
+	if n := len(audited.FromContext(ctx).Entries()); n != 1 {
+		t.Errorf("recorder has %d entries, want 1", n)
+	}
+}
+
+func testDeferredFlushRollback(t *testing.T, db *gorm.DB) {
+	ctx := audited.WithDeferredFlush(userContext("e2e@example.com"))
+	committed, rolledBack := newWidget("deferred committed"), newWidget("deferred rolled back")
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.Create(committed).Error
+	}); err != nil {
+		t.Fatalf("transaction: %s", err)
+	}
+	rollback := errors.New("rollback")
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(rolledBack).Error; err != nil {
+			return err
+		}
+		return rollback
+	}); !errors.Is(err, rollback) {
+		t.Fatalf("transaction: %v", err)
+	}
+	if err := audited.Flush(ctx, db); err != nil {
+		t.Fatalf("flush: %s", err)
+	}
+	expectTrail(t, db, committed.Id, audited.OperationCreate)
+	expectTrail(t, db, rolledBack.Id)
+}
+
+func newWidgets(prefix string, n int) []*Widget {
+	widgets := make([]*Widget, n)
+	for i := range widgets {
+		widgets[i] = newWidget(prefix)
+	}
+	return widgets
+}
+
+func testBulkCreate(t *testing.T, db *gorm.DB) {
+	db = db.WithContext(userContext("e2e@example.com"))
+	widgets := newWidgets("bulk create", 3)
+	if err := db.Create(widgets).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	for _, w := range widgets {
+		expectTrail(t, db, w.Id, audited.OperationCreate)
+	}
+}
+
+func testBulkUpdate(t *testing.T, db *gorm.DB) {
+	db = db.WithContext(userContext("e2e@example.com"))
+	widgets := newWidgets("bulk update", 3)
+	for _, w := range widgets {
+		if err := db.Create(w).Error; err != nil {
+			t.Fatalf("create: %s", err)
+		}
+	}
+	if err := db.Model(&Widget{}).Where("name = ?", "bulk update").Updates(map[string]interface{}{"quantity": 7}).Error; err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	for _, w := range widgets {
+		expectTrail(t, db, w.Id, audited.OperationCreate, audited.OperationUpdate)
+	}
+}
+
+func testBulkDelete(t *testing.T, db *gorm.DB) {
+	db = db.WithContext(userContext("e2e@example.com"))
+	widgets := newWidgets("bulk delete", 3)
+	for _, w := range widgets {
+		if err := db.Create(w).Error; err != nil {
+			t.Fatalf("create: %s", err)
+		}
+	}
+	if err := db.Unscoped().Where("name = ?", "bulk delete").Delete(&Widget{}).Error; err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	for _, w := range widgets {
+		expectTrail(t, db, w.Id, audited.OperationCreate, audited.OperationHardDelete)
+	}
+}
+
+func testTenantQuota(t *testing.T, db *gorm.DB) {
+	tenant := uuid.NewString()
+	db = db.WithContext(audited.WithTenant(userContext("e2e@example.com"), tenant))
+	for _, w := range newWidgets("tenant quota", 5) {
+		if err := db.Create(w).Error; err != nil {
+			t.Fatalf("create: %s", err)
+		}
+	}
+	usage, err := audited.EnforceQuotas(context.Background(), db, audited.QuotaOptions{
+		Tenants:  map[string]int64{tenant: 3},
+		Overflow: audited.OverflowSummarize,
+	})
+	if err != nil {
+		t.Fatalf("enforce quotas: %s", err)
+	}
+	if len(usage) != 1 || usage[0].Entries != 5 || usage[0].Removed != 2 {
+		t.Fatalf("got usage %+v, want 2 of 5 entries removed", usage)
+	}
+
+	var entries []audited.AuditLog
+	if err := audited.Query(db).Scopes(audited.ForTenant(tenant)).Order("created_at").Find(&entries).Error; err != nil {
+		t.Fatalf("query: %s", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("got %d entries, want 3 and a summary", len(entries))
+	}
+	summary := entries[0]
+	if summary.Metadata["summarized"] != true || fmt.Sprint(summary.Metadata["rows"]) != "2" {
+		t.Fatalf("got %+v, want the summary of the 2 oldest entries", summary)
+	}
+}
+
+// regionTable creates a table with the columns of audit_logs for the entries
+// of a region
+func regionTable(t *testing.T, db *gorm.DB, table string) {
+	t.Helper()
+	ddl := "CREATE TABLE IF NOT EXISTS " + table + " (LIKE audit_logs INCLUDING ALL)"
+	switch db.Dialector.Name() {
+	case "mysql":
+		ddl = "CREATE TABLE IF NOT EXISTS " + table + " LIKE audit_logs"
+	case "sqlite":
+		// a table created from a select loses the declared column types
+		if err := db.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'audit_logs'").
+			Scan(&ddl).Error; err != nil {
+			t.Fatalf("reading the audit table: %s", err)
+		}
+		ddl = strings.Replace(strings.Replace(ddl, "audit_logs", table, 1), "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", 1)
+	}
+	if err := db.Exec(ddl).Error; err != nil {
+		t.Fatalf("creating %s: %s", table, err)
+	}
+	if db.Dialector.Name() != "sqlite" {
+		return
+	}
+	// and the trigger numbering its entries
+	var trigger string
+	if err := db.Raw("SELECT sql FROM sqlite_master WHERE type = 'trigger' AND name = 'audit_logs_seq'").
+		Scan(&trigger).Error; err != nil {
+		t.Fatalf("reading the audit table trigger: %s", err)
+	}
+	trigger = strings.Replace(strings.ReplaceAll(trigger, "audit_logs", table), "CREATE TRIGGER", "CREATE TRIGGER IF NOT EXISTS", 1)
+	if err := db.Exec(trigger).Error; err != nil {
+		t.Fatalf("creating the trigger of %s: %s", table, err)
+	}
+}
+
+// reopen returns a new connection to the database of db, audited with opts
+func reopen(t *testing.T, db *gorm.DB, opts ...audited.Option) *gorm.DB {
+	t.Helper()
+	reopened, err := gorm.Open(db.Dialector, &gorm.Config{Logger: db.Logger})
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	if err := audited.RegisterCallbacks(reopened, opts...); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+	return reopened
+}
+
+func testTenantOffboarding(t *testing.T, db *gorm.DB) {
+	regionTable(t, db, "audit_logs_eu")
+	regional := reopen(t, db, audited.WithResidencyTable("eu", "audit_logs_eu"))
+	db = reopen(t, db, audited.WithResidencyTable("eu", "audit_logs_eu"), audited.WithMaintainLatest())
+
+	// the entries of the leaving tenant are in the audit table and the table
+	// of its region, which isn't routed with WithMaintainLatest, and hours ago
+	// so they are rolled up
+	leaving, staying := uuid.NewString(), uuid.NewString()
+	eu := regional.WithContext(audited.WithResidency(audited.WithTenant(userContext("e2e@example.com"), leaving), "eu"))
+	if err := eu.Create(newWidget("tenant offboarding")).Error; err != nil {
+		t.Fatalf("create in region: %s", err)
+	}
+	widgets := map[string][]*Widget{}
+	for tenant, n := range map[string]int{leaving: 3, staying: 2} {
+		tdb := db.WithContext(audited.WithTenant(userContext("e2e@example.com"), tenant))
+		widgets[tenant] = newWidgets("tenant offboarding", n)
+		for _, w := range widgets[tenant] {
+			if err := tdb.Create(w).Error; err != nil {
+				t.Fatalf("create: %s", err)
+			}
+		}
+	}
+	hour := time.Now().Add(-3 * time.Hour).Truncate(time.Hour).Add(30 * time.Minute)
+	if err := audited.Query(db).Where("tenant_id IN ?", []string{leaving, staying}).
+		Update("created_at", hour).Error; err != nil {
+		t.Fatalf("backdating: %s", err)
+	}
+	if _, err := audited.Rollup(context.Background(), db); err != nil {
+		t.Fatalf("rollup: %s", err)
+	}
+
+	manifest, err := audited.ExportTenant(context.Background(), db, leaving, audited.ExportOptions{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("export: %s", err)
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0].Rows != 3 || manifest.Tenant != leaving {
+		t.Fatalf("got manifest %+v, want the 3 entries of the tenant", manifest)
+	}
+
+	purged, err := audited.PurgeTenant(context.Background(), db, leaving)
+	if err != nil {
+		t.Fatalf("purge: %s", err)
+	}
+	if purged != 4 {
+		t.Fatalf("got %d entries purged, want 4", purged)
+	}
+	for tenant, want := range map[string]int64{leaving: 0, staying: 2} {
+		for _, tdb := range []*gorm.DB{db, eu} {
+			var n int64
+			if err := audited.Query(tdb).Scopes(audited.ForTenant(tenant)).Count(&n).Error; err != nil {
+				t.Fatalf("count: %s", err)
+			}
+			if tdb == eu {
+				want = 0
+			}
+			if n != want {
+				t.Errorf("got %d entries of tenant %s, want %d", n, tenant, want)
+			}
+		}
+	}
+
+	for tenant, want := range map[string]int{leaving: 0, staying: 2} {
+		ids := make([]string, len(widgets[tenant]))
+		for i, w := range widgets[tenant] {
+			ids[i] = w.Id
+		}
+		latest, err := audited.LastChanges(db, "widgets", ids)
+		if err != nil {
+			t.Fatalf("last changes: %s", err)
+		}
+		if len(latest) != want {
+			t.Errorf("got %d last changes of tenant %s, want %d", len(latest), tenant, want)
+		}
+	}
+
+	var counted int64
+	if err := db.Table(audited.RollupsTable).Where("hour = ?", hour.Truncate(time.Hour)).
+		Select("COALESCE(SUM(count), 0)").Scan(&counted).Error; err != nil {
+		t.Fatalf("reading rollups: %s", err)
+	}
+	if counted != 2 {
+		t.Errorf("got %d entries rolled up, want the 2 of the staying tenant", counted)
+	}
+}
+
+func testErasureAnonymize(t *testing.T, db *gorm.DB) {
+	ctx := audited.WithRequestInfo(userContext("e2e@example.com"), audited.RequestInfo{
+		ActorIP: "203.0.113.7", UserAgent: "e2e", RequestId: uuid.NewString(), SessionId: uuid.NewString(),
+	})
+	w := newWidget("erasure anonymize")
+	if err := db.WithContext(audited.WithIdempotencyKey(ctx, uuid.NewString())).Create(w).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if err := db.WithContext(ctx).Model(w).Update("quantity", 2).Error; err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	// the owner and root object are set by registrations global to the
+	// process, so they are filled in directly
+	if err := audited.Query(db).Where("object_id = ?", w.Id).
+		Updates(map[string]interface{}{"owner_id": "owner", "root_object_id": "root"}).Error; err != nil {
+		t.Fatalf("setting owner: %s", err)
+	}
+
+	if err := audited.RequestErasure(ctx, db, "widgets", w.Id, audited.PurgeAnonymize); err != nil {
+		t.Fatalf("request erasure: %s", err)
+	}
+	if _, err := audited.PurgeHistory(context.Background(), db); err != nil {
+		t.Fatalf("purge: %s", err)
+	}
+	trail := expectTrail(t, db, w.Id, audited.OperationCreate, audited.OperationUpdate, audited.OperationPurge)
+	for _, entry := range trail[:2] {
+		pii := map[string]string{
+			"data": string(entry.Data), "old_data": string(entry.OldData), "user_id": entry.UserId,
+			"owner_id": entry.OwnerId, "root_object_id": entry.RootObjectId, "actor_ip": entry.ActorIP,
+			"user_agent": entry.UserAgent, "request_id": entry.RequestId, "session_id": entry.SessionId,
+			"idempotency_key": entry.IdempotencyKey, "summary": entry.Summary,
+		}
+		for column, value := range pii {
+			if value != "" && value != "null" {
+				t.Errorf("%s entry: got %s %q, want it empty", entry.OperationType, column, value)
+			}
+		}
+		if len(entry.Metadata) > 0 {
+			t.Errorf("%s entry: got metadata %v, want it empty", entry.OperationType, entry.Metadata)
+		}
+	}
+}
+
+func testLastChange(t *testing.T, db *gorm.DB) {
+	db = reopen(t, db, audited.WithMaintainLatest())
+	ann, bob := db.WithContext(userContext("ann@example.com")), db.WithContext(userContext("bob@example.com"))
+	first, second := newWidget("last change"), newWidget("last change")
+	if err := ann.Create([]*Widget{first, second}).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if err := bob.Model(first).Update("quantity", 2).Error; err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if err := bob.Model(first).Update("quantity", 3).Error; err != nil {
+		t.Fatalf("update: %s", err)
+	}
+
+	latest, err := audited.LastChanges(db, "widgets", []string{first.Id, second.Id, uuid.NewString()})
+	if err != nil {
+		t.Fatalf("last changes: %s", err)
+	}
+	if len(latest) != 2 {
+		t.Fatalf("got %d last changes, want the 2 widgets", len(latest))
+	}
+	if got := latest[first.Id]; got.OperationType != audited.OperationUpdate || got.UserId != "bob@example.com" ||
+		got.Version != 3 {
+		t.Errorf("got %+v, want the second update by bob as version 3", got)
+	}
+	if got := latest[second.Id]; got.OperationType != audited.OperationCreate || got.UserId != "ann@example.com" ||
+		got.Version != 1 {
+		t.Errorf("got %+v, want the create by ann as version 1", got)
+	}
+
+	// the erasure drops the last change of bob for the tombstone
+	if err := audited.RequestErasure(userContext("dpo@example.com"), db, "widgets", first.Id, audited.PurgeDelete); err != nil {
+		t.Fatalf("request erasure: %s", err)
+	}
+	if _, err := audited.PurgeHistory(context.Background(), db); err != nil {
+		t.Fatalf("purge: %s", err)
+	}
+	latest, err = audited.LastChanges(db, "widgets", []string{first.Id})
+	if err != nil {
+		t.Fatalf("last changes: %s", err)
+	}
+	if got := latest[first.Id]; got.OperationType != audited.OperationPurge || got.UserId != "dpo@example.com" ||
+		got.Version != 1 {
+		t.Errorf("got %+v, want the tombstone as version 1", got)
+	}
+}
+
+// separateDB returns another connection to the database of db, without audit
+// callbacks, or to a database of its own on sqlite, where its writes would wait
+// for the transactions of db to release their lock
+func separateDB(t *testing.T, db *gorm.DB) *gorm.DB {
+	t.Helper()
+	if db.Dialector.Name() != "sqlite" {
+		other, err := gorm.Open(db.Dialector, &gorm.Config{Logger: db.Logger})
+		if err != nil {
+			t.Fatalf("open: %s", err)
+		}
+		return other
+	}
+	other, err := exampledb.Open("sqlite", filepath.Join(t.TempDir(), "separate.db"))
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	if err := exampledb.CreateAuditTable(other); err != nil {
+		t.Fatalf("create audit tables: %s", err)
+	}
+	return other
+}
+
+// replicaDB returns a database to replicate the entries of db to and its
+// audit table, a table next to audit_logs except on sqlite, see separateDB
+func replicaDB(t *testing.T, db *gorm.DB) (*gorm.DB, string) {
+	t.Helper()
+	if db.Dialector.Name() != "sqlite" {
+		regionTable(t, db, "audit_replica")
+		return reopen(t, db, audited.WithTableName("audit_replica")), "audit_replica"
+	}
+	return separateDB(t, db), "audit_logs"
+}
+
+func testEncryptedReplica(t *testing.T, db *gorm.DB) {
+	audited.RegisterKeyProvider(audited.NewStaticKeyProvider("e2e-key", bytes.Repeat([]byte{7}, 32)))
+	source := reopen(t, db, audited.WithEncryption("e2e-key"))
+	widget := newWidget("encrypted replica")
+	if err := source.WithContext(userContext("ann@example.com")).Create(widget).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	replica, table := replicaDB(t, db)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := audited.Replicate(ctx, source, audited.ReplicationOptions{
+		ConsumerOptions: audited.ConsumerOptions{Name: "replica-" + uuid.NewString(), PollInterval: 10 * time.Millisecond},
+		Target:          replica,
+		Report: func(lag audited.ReplicationLag) {
+			if lag.Entries == 0 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("replicate: %v, want it to catch up", err)
+	}
+
+	// the replica stores the payload encrypted, and decrypts it when read
+	var stored []audited.AuditLog
+	if err := replica.Session(&gorm.Session{SkipHooks: true}).Table(table).
+		Where("object_id = ?", widget.Id).Find(&stored).Error; err != nil {
+		t.Fatalf("read replica: %s", err)
+	}
+	if len(stored) != 1 || !strings.Contains(string(stored[0].Data), "$encrypted") ||
+		strings.Contains(string(stored[0].Data), "encrypted replica") {
+		t.Fatalf("got %+v, want the encrypted create", stored)
+	}
+	var entries []audited.AuditLog
+	if err := audited.Query(replica).Where("object_id = ?", widget.Id).Find(&entries).Error; err != nil {
+		t.Fatalf("query replica: %s", err)
+	}
+	if len(entries) != 1 || !strings.Contains(string(entries[0].Data), "encrypted replica") {
+		t.Fatalf("got %+v, want the create decrypted", entries)
+	}
+}
+
+func testAuditDBCommit(t *testing.T, db *gorm.DB) {
+	auditDB := separateDB(t, db)
+	db = reopen(t, db, audited.WithAuditDB(auditDB)).WithContext(userContext("e2e@example.com"))
+	committed, rolledBack, plain := newWidget("audit db"), newWidget("audit db"), newWidget("audit db")
+	if err := audited.Transaction(db, func(tx *gorm.DB) error {
+		if err := tx.Create(committed).Error; err != nil {
+			return err
+		}
+		// held until the commit
+		expectTrail(t, db, committed.Id)
+		return nil
+	}); err != nil {
+		t.Fatalf("transaction: %s", err)
+	}
+	expectTrail(t, db, committed.Id, audited.OperationCreate)
+
+	rollback := errors.New("rollback")
+	if err := audited.Transaction(db, func(tx *gorm.DB) error {
+		if err := tx.Create(rolledBack).Error; err != nil {
+			return err
+		}
+		return rollback
+	}); !errors.Is(err, rollback) {
+		t.Fatalf("transaction: %v", err)
+	}
+	expectTrail(t, db, rolledBack.Id)
+
+	// gorm transactions can't be waited for, their entries are flagged
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(plain).Error; err != nil {
+			return err
+		}
+		return rollback
+	}); !errors.Is(err, rollback) {
+		t.Fatalf("transaction: %v", err)
+	}
+	trail := expectTrail(t, db, plain.Id, audited.OperationCreate)
+	if uncommitted, _ := trail[0].Metadata["uncommitted"].(bool); !uncommitted {
+		t.Errorf("got metadata %v, want the entry flagged uncommitted", trail[0].Metadata)
+	}
+	if trail := expectTrail(t, db, committed.Id, audited.OperationCreate); trail[0].Metadata["uncommitted"] != nil {
+		t.Errorf("got metadata %v for a committed entry", trail[0].Metadata)
+	}
+}
+
+func testTrailCache(t *testing.T, db *gorm.DB) {
+	defer func(previous audited.TrailCache) { audited.TrailCacheStore = previous }(audited.TrailCacheStore)
+	audited.TrailCacheStore = audited.NewLRUTrailCache(100, time.Hour)
+	tenant := uuid.NewString()
+	db = db.WithContext(audited.WithTenant(userContext("e2e@example.com"), tenant))
+	w := newWidget("trail cache")
+	if err := db.Create(w).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	expectTrail(t, db, w.Id, audited.OperationCreate)
+
+	// the trail read again before the commit is dropped once it commits
+	if err := audited.Transaction(db, func(tx *gorm.DB) error {
+		if err := tx.Model(w).Update("quantity", 2).Error; err != nil {
+			return err
+		}
+		expectTrail(t, db, w.Id, audited.OperationCreate)
+		return nil
+	}); err != nil {
+		t.Fatalf("transaction: %s", err)
+	}
+	expectTrail(t, db, w.Id, audited.OperationCreate, audited.OperationUpdate)
+
+	// so is the trail of entries evicted over a quota
+	if _, err := audited.EnforceQuotas(context.Background(), db, audited.QuotaOptions{
+		Tenants:  map[string]int64{tenant: 1},
+		Overflow: audited.OverflowEvict,
+	}); err != nil {
+		t.Fatalf("enforce quotas: %s", err)
+	}
+	expectTrail(t, db, w.Id, audited.OperationUpdate)
+}
+
+func testWatch(t *testing.T, db *gorm.DB) {
+	db = db.WithContext(userContext("e2e@example.com"))
+	w := newWidget("watch")
+	if err := db.Create(w).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	got, done := make(chan audited.AuditLog, 100), make(chan error, 1)
+	go func() {
+		done <- audited.Watch(ctx, db, "widgets", w.Id, func(ctx context.Context, entry audited.AuditLog) {
+			got <- entry
+		})
+	}()
+
+	// entries of a rolled back transaction are never seen
+	rollback := errors.New("rollback")
+	if err := audited.Transaction(db, func(tx *gorm.DB) error {
+		if err := tx.Model(w).Update("quantity", 0).Error; err != nil {
+			return err
+		}
+		return rollback
+	}); !errors.Is(err, rollback) {
+		t.Fatalf("transaction: %v", err)
+	}
+	// updated until the watcher, which may still be starting, sees one
+	var entry audited.AuditLog
+	deadline := time.After(10 * time.Second)
+	for quantity := 2; entry.Id == ""; quantity++ {
+		if err := db.Model(w).Update("quantity", quantity).Error; err != nil {
+			t.Fatalf("update: %s", err)
+		}
+		select {
+		case entry = <-got:
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("the watcher saw no entry")
+		}
+	}
+	if entry.OperationType != audited.OperationUpdate || strings.Contains(string(entry.Data), `"quantity":0`) {
+		t.Errorf("got %s %s, want a committed update", entry.OperationType, entry.Data)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("watch: %v", err)
+	}
+}
+
+func testBulkMode(t *testing.T, db *gorm.DB) {
+	widgets := newWidgets("bulk mode", 3)
+	if err := db.WithContext(userContext("e2e@example.com")).Create(widgets).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	since := time.Now().Add(-time.Minute)
+	ctx, err := audited.BeginBulk(audited.WithSource(userContext("e2e@example.com"), "backfill:"+uuid.NewString()), db)
+	if err != nil {
+		t.Fatalf("begin bulk: %s", err)
+	}
+	bulk := db.WithContext(ctx)
+
+	// the rows of a rolled back transaction don't count
+	rollback := errors.New("rollback")
+	if err := audited.Transaction(bulk, func(tx *gorm.DB) error {
+		if err := tx.Model(&Widget{}).Where("name = ?", "bulk mode").Update("quantity", 0).Error; err != nil {
+			return err
+		}
+		return rollback
+	}); !errors.Is(err, rollback) {
+		t.Fatalf("transaction: %v", err)
+	}
+	if err := audited.Transaction(bulk, func(tx *gorm.DB) error {
+		return tx.Model(&Widget{}).Where("name = ?", "bulk mode").Update("quantity", 2).Error
+	}); err != nil {
+		t.Fatalf("transaction: %s", err)
+	}
+	summaries, err := audited.FinishBulk(ctx, db)
+	if err != nil {
+		t.Fatalf("finish bulk: %s", err)
+	}
+	if len(summaries) != 1 || fmt.Sprint(summaries[0].Metadata["rows"]) != "3" {
+		t.Fatalf("got %+v, want one summary of the 3 committed rows", summaries)
+	}
+	expectTrail(t, db, widgets[0].Id, audited.OperationCreate)
+
+	// the start of bulk mode is in the meta-audit trail
+	actions, err := audited.MetaTrail(db, since)
+	if err != nil {
+		t.Fatalf("meta trail: %s", err)
+	}
+	started := false
+	for _, action := range actions {
+		started = started || action.Action == audited.MetaBulk &&
+			fmt.Sprint(action.Details["bulk_id"]) == fmt.Sprint(summaries[0].Metadata["bulk_id"])
+	}
+	if !started {
+		t.Errorf("got meta trail %+v, want the start of bulk mode", actions)
+	}
+}
+
+func testDataSubjectRequest(t *testing.T, db *gorm.DB) {
+	ann, bob := "ann-"+uuid.NewString()+"@example.com", "bob-"+uuid.NewString()+"@example.com"
+	owned, other := newWidget("data subject request"), newWidget("data subject request")
+	if err := db.WithContext(userContext(ann)).Create(owned).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if err := db.WithContext(userContext(bob)).Create(other).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if err := db.WithContext(userContext(bob)).Model(owned).Update("quantity", 2).Error; err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	// the owner is set by a registration global to the process, so it is
+	// filled in directly
+	for id, owner := range map[string]string{owned.Id: ann, other.Id: bob} {
+		if err := audited.Query(db).Where("object_id = ?", id).Update("owner_id", owner).Error; err != nil {
+			t.Fatalf("setting owner: %s", err)
+		}
+	}
+
+	dpo := userContext("dpo@example.com")
+	report, err := audited.SubjectExport(dpo, db, audited.SubjectSpec{Email: ann})
+	if err != nil {
+		t.Fatalf("subject export: %s", err)
+	}
+	if len(report.Acted) != 1 || report.Acted[0].ObjectId != owned.Id {
+		t.Errorf("got acted %+v, want the create by ann", report.Acted)
+	}
+	if len(report.Owned) != 2 || report.Owned[0].ObjectId != owned.Id || report.Owned[1].UserId != bob {
+		t.Errorf("got owned %+v, want the create and the update by bob of the widget of ann", report.Owned)
+	}
+
+	objects, err := audited.RequestSubjectErasure(dpo, db, audited.SubjectSpec{Email: ann}, audited.PurgeDelete)
+	if err != nil {
+		t.Fatalf("request subject erasure: %s", err)
+	}
+	if objects != 1 {
+		t.Errorf("got %d objects queued, want the widget of ann", objects)
+	}
+	if _, err := audited.PurgeHistory(context.Background(), db); err != nil {
+		t.Fatalf("purge: %s", err)
+	}
+	expectTrail(t, db, owned.Id, audited.OperationPurge)
+	expectTrail(t, db, other.Id, audited.OperationCreate)
+}
+
+func testReplicateJob(t *testing.T, db *gorm.DB) {
+	replica, _ := replicaDB(t, db)
+	name := "replica-" + uuid.NewString()
+	// a gap left by a rolled back transaction of another scenario isn't
+	// waited for
+	job := audited.ReplicateJob(audited.ReplicationOptions{
+		ConsumerOptions: audited.ConsumerOptions{Name: name, BatchSize: 2, GapTimeout: time.Millisecond},
+		Target:          replica,
+	})
+	for _, widget := range []*Widget{newWidget("replicate job"), newWidget("replicate job")} {
+		if err := db.WithContext(userContext("e2e@example.com")).Create(widget).Error; err != nil {
+			t.Fatalf("create: %s", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if err := job.Run(context.Background(), db); err != nil {
+			t.Fatalf("replicate job: %s", err)
+		}
+		var entries []audited.AuditLog
+		if err := audited.Query(replica).Where("object_id = ?", widget.Id).Find(&entries).Error; err != nil {
+			t.Fatalf("query replica: %s", err)
+		}
+		if len(entries) != 1 || entries[0].OperationType != audited.OperationCreate {
+			t.Fatalf("got %+v, want the create shipped", entries)
+		}
+	}
+	lag, err := audited.ReplicationStatus(db, name)
+	if err != nil {
+		t.Fatalf("replication status: %s", err)
+	}
+	if lag.Entries != 0 || lag.Seq == 0 {
+		t.Errorf("got lag %+v, want the replica caught up", lag)
+	}
+}
+
+func testCoverage(t *testing.T, db *gorm.DB) {
+	plain, err := gorm.Open(db.Dialector, &gorm.Config{Logger: db.Logger})
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	// the entries are matched by an anti-join, or looked up by id in the
+	// database of WithAuditDB
+	dbs := map[string]*gorm.DB{
+		"anti-join":      db,
+		"audit database": reopen(t, db, audited.WithAuditDB(separateDB(t, db))),
+	}
+	for name, db := range dbs {
+		covered, uncovered := newWidget("coverage"), newWidget("coverage")
+		if err := db.WithContext(userContext("e2e@example.com")).Create(covered).Error; err != nil {
+			t.Fatalf("%s: create: %s", name, err)
+		}
+		if err := plain.Create(uncovered).Error; err != nil {
+			t.Fatalf("%s: create without auditing: %s", name, err)
+		}
+		report, err := audited.Coverage(db, &Widget{})
+		if err != nil {
+			t.Fatalf("%s: coverage: %s", name, err)
+		}
+		missing := map[string]bool{}
+		for _, id := range report.Unaudited {
+			missing[id] = true
+		}
+		if !missing[uncovered.Id] || missing[covered.Id] {
+			t.Errorf("%s: got unaudited %v, want %s and not %s", name, report.Unaudited, uncovered.Id, covered.Id)
+		}
+		if report.Audited != report.Rows-int64(len(report.Unaudited)) {
+			t.Errorf("%s: got report %+v", name, report)
+		}
+	}
+}
+
+func testAdminActions(t *testing.T, db *gorm.DB) {
+	audited.RegisterKeyProvider(audited.NewStaticKeyProvider("e2e-admin-old", bytes.Repeat([]byte{8}, 32)))
+	audited.RegisterKeyProvider(audited.NewStaticKeyProvider("e2e-admin-new", bytes.Repeat([]byte{9}, 32)))
+	since := time.Now().Add(-time.Minute)
+	dpo := userContext("dpo@example.com")
+	erased, encrypted := newWidget("admin actions"), newWidget("admin actions")
+	if err := db.WithContext(userContext("e2e@example.com")).Create(erased).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if err := reopen(t, db, audited.WithEncryption("e2e-admin-old")).
+		WithContext(userContext("e2e@example.com")).Create(encrypted).Error; err != nil {
+		t.Fatalf("create encrypted: %s", err)
+	}
+
+	if err := audited.RequestErasure(dpo, db, "widgets", erased.Id, audited.PurgeDelete); err != nil {
+		t.Fatalf("request erasure: %s", err)
+	}
+	if _, err := audited.PurgeHistory(context.Background(), db); err != nil {
+		t.Fatalf("purge: %s", err)
+	}
+	dir := t.TempDir()
+	if _, err := audited.Export(dpo, db, audited.ExportOptions{Dir: dir, TableName: "widgets"}); err != nil {
+		t.Fatalf("export: %s", err)
+	}
+	status, err := audited.Rekey(dpo, db, "e2e-admin-old", "e2e-admin-new", 100)
+	if err != nil {
+		t.Fatalf("rekey: %s", err)
+	}
+	if status.Rekeyed != 1 {
+		t.Errorf("got %d payloads rekeyed, want the data of the encrypted create", status.Rekeyed)
+	}
+	if err := audited.RecordAdminAction(dpo, db, "legal_hold", map[string]interface{}{"object_id": encrypted.Id}); err != nil {
+		t.Fatalf("record admin action: %s", err)
+	}
+
+	// the operations of this scenario, told from those of the others by
+	// their details, in the order they were made
+	actions, err := audited.MetaTrail(db, since)
+	if err != nil {
+		t.Fatalf("meta trail: %s", err)
+	}
+	var got []string
+	for _, action := range actions {
+		details := action.Details
+		if details["object_id"] == erased.Id || details["object_id"] == encrypted.Id || details["dir"] == dir ||
+			details["new_key_id"] == "e2e-admin-new" {
+			got = append(got, action.Action)
+			if action.Action != audited.MetaPurge && action.UserId != "dpo@example.com" {
+				t.Errorf("%s: got user %q, want the dpo", action.Action, action.UserId)
+			}
+		}
+		if action.Action == audited.MetaRekey && details["new_key_id"] == "e2e-admin-new" &&
+			fmt.Sprint(details["rekeyed"]) != "1" {
+			t.Errorf("got rekey details %v, want 1 payload rekeyed", details)
+		}
+	}
+	want := []string{audited.MetaErasure, audited.MetaPurge, audited.MetaExport, audited.MetaRekey, "legal_hold"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got meta trail %v, want %v", got, want)
+	}
+}
+
+func testLongFormat(t *testing.T, db *gorm.DB) {
+	if err := db.AutoMigrate(&Part{}); err != nil {
+		t.Fatalf("migrate: %s", err)
+	}
+	audited.RegisterLongFormat(&Part{})
+	db = db.WithContext(userContext("e2e@example.com"))
+	part := &Part{Id: uuid.NewString(), Name: "long format", Quantity: 1}
+	if err := db.Create(part).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if err := db.Model(part).Update("quantity", 5).Error; err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	// the field changes are written with their entry and rolled back with it
+	rollback := errors.New("rollback")
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(part).Update("quantity", 9).Error; err != nil {
+			return err
+		}
+		return rollback
+	}); !errors.Is(err, rollback) {
+		t.Fatalf("transaction: %v", err)
+	}
+
+	trail, err := audited.TrailFor(db, "parts", part.Id)
+	if err != nil {
+		t.Fatalf("reading trail: %s", err)
+	}
+	if len(trail) != 2 {
+		t.Fatalf("got %d entries, want the create and the committed update", len(trail))
+	}
+	changes, err := audited.FieldHistory(db, "parts", part.Id, "quantity")
+	if err != nil {
+		t.Fatalf("field history: %s", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes of the quantity, want 2: %+v", len(changes), changes)
+	}
+	for i, want := range [][2]string{{"", "1"}, {"1", "5"}} {
+		if got := [2]string{string(changes[i].OldValue), string(changes[i].NewValue)}; got != want {
+			t.Errorf("change %d: got %q, want %q", i, got, want)
+		}
+		if changes[i].AuditId != trail[i].Id {
+			t.Errorf("change %d: got entry %s, want %s", i, changes[i].AuditId, trail[i].Id)
+		}
+	}
+	names, err := audited.FieldHistory(db, "parts", part.Id, "name")
+	if err != nil {
+		t.Fatalf("field history: %s", err)
+	}
+	if len(names) != 1 {
+		t.Errorf("got %d changes of the name, want the create", len(names))
+	}
+}
+
+func testReplication(t *testing.T, db *gorm.DB) {
+	replica, table := replicaDB(t, db)
+	ctx := context.Background()
+	name := "replica-" + uuid.NewString()
+	ship := func(name string) {
+		t.Helper()
+		if err := audited.ReplicateJob(audited.ReplicationOptions{
+			ConsumerOptions: audited.ConsumerOptions{Name: name, GapTimeout: time.Millisecond},
+			Target:          replica,
+		}).Run(ctx, db); err != nil {
+			t.Fatalf("replicate: %s", err)
+		}
+	}
+	shipped := func(id string) int64 {
+		t.Helper()
+		var n int64
+		if err := audited.QueryOperations(replica).Where("object_id = ?", id).Count(&n).Error; err != nil {
+			t.Fatalf("count replica: %s", err)
+		}
+		return n
+	}
+
+	w := newWidget("replication")
+	if err := db.WithContext(userContext("e2e@example.com")).Create(w).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	ship(name)
+	lag, err := audited.ReplicationStatus(db, name)
+	if err != nil {
+		t.Fatalf("replication status: %s", err)
+	}
+	if lag.Entries != 0 || lag.Head != lag.Seq || lag.Behind != 0 {
+		t.Errorf("got lag %+v, want the replica caught up", lag)
+	}
+
+	// the lag counts the entries written since
+	if err := db.WithContext(userContext("e2e@example.com")).Model(w).Update("quantity", 2).Error; err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	behind, err := audited.ReplicationStatus(db, name)
+	if err != nil {
+		t.Fatalf("replication status: %s", err)
+	}
+	if behind.Seq != lag.Seq || behind.Head <= lag.Seq || behind.Entries != behind.Head-behind.Seq {
+		t.Errorf("got lag %+v after %+v, want the update not shipped", behind, lag)
+	}
+
+	// entries shipped again by another replication aren't duplicated
+	time.Sleep(5 * time.Millisecond)
+	ship(name)
+	ship("replica-" + uuid.NewString())
+	if n := shipped(w.Id); n != 2 {
+		t.Errorf("got %d entries of the widget in the replica, want 2", n)
+	}
+
+	// the entries of a region only ship to the table of the region
+	regionTable(t, db, "audit_logs_eu")
+	regionTable(t, replica, table+"_eu")
+	source := reopen(t, db, audited.WithResidencyTable("eu", "audit_logs_eu"))
+	eu := audited.WithResidency(userContext("e2e@example.com"), "eu")
+	regional := newWidget("replication")
+	if err := source.WithContext(eu).Create(regional).Error; err != nil {
+		t.Fatalf("create in region: %s", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	regionalReplica := reopen(t, replica, audited.WithResidencyTable("eu", table+"_eu"))
+	for _, target := range []struct {
+		db   *gorm.DB
+		want error
+	}{{replica, audited.ErrResidencyViolation}, {regionalReplica, nil}} {
+		err := audited.ReplicateJob(audited.ReplicationOptions{
+			ConsumerOptions: audited.ConsumerOptions{Name: "replica-eu-" + uuid.NewString(), GapTimeout: time.Millisecond},
+			Target:          target.db,
+		}).Run(eu, source)
+		if !errors.Is(err, target.want) {
+			t.Fatalf("replicate region: got %v, want %v", err, target.want)
+		}
+	}
+	if n := shipped(regional.Id); n != 0 {
+		t.Errorf("got %d entries of the region in the default table of the replica", n)
+	}
+	var n int64
+	if err := audited.QueryOperations(regionalReplica.WithContext(eu)).Where("object_id = ?", regional.Id).
+		Count(&n).Error; err != nil {
+		t.Fatalf("count region of replica: %s", err)
+	}
+	if n != 1 {
+		t.Errorf("got %d entries of the region in its table of the replica, want 1", n)
+	}
+}
+
+func testPseudonymizedView(t *testing.T, db *gorm.DB) {
+	if db.Dialector.Name() != "postgres" {
+		t.Skip("the view is only supported on postgres")
+	}
+	w := newWidget("pseudonymized view")
+	if err := db.WithContext(userContext("e2e@example.com")).Create(w).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if err := audited.CreatePseudonymizedView(db, audited.ViewOptions{
+		Name:       "widgets_for_analysts",
+		DropKeys:   []string{"deleted_at"},
+		MaskKeys:   []string{"name"},
+		HashKeys:   []string{"id"},
+		Salt:       "pepper",
+		HashUserId: true,
+	}); err != nil {
+		t.Fatalf("create view: %s", err)
+	}
+
+	var rows []struct {
+		UserId string
+		Data   string
+	}
+	if err := db.Table("widgets_for_analysts").Where("object_id = ?", w.Id).Find(&rows).Error; err != nil {
+		t.Fatalf("read view: %s", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows, want the create", len(rows))
+	}
+	if rows[0].UserId == "e2e@example.com" || len(rows[0].UserId) != 64 {
+		t.Errorf("got user %q, want it hashed", rows[0].UserId)
+	}
+	data := rows[0].Data
+	if strings.Contains(data, "deleted_at") || strings.Contains(data, w.Id) || strings.Contains(data, "pseudonymized view") ||
+		!strings.Contains(data, `"***"`) || !strings.Contains(data, `"quantity": 1`) {
+		t.Errorf("got data %s, want the name masked, the id hashed and deleted_at dropped", data)
+	}
+}
+
+func testIndexes(t *testing.T, db *gorm.DB) {
+	opts := audited.IndexOptions{CreatedAtBRIN: true, HotTables: []string{"widgets"}, Owners: true, Roots: true}
+	// existing indexes are left alone
+	for i := 0; i < 2; i++ {
+		if err := audited.CreateIndexes(db, opts); err != nil {
+			t.Fatalf("create indexes: %s", err)
+		}
+	}
+	indexes := []string{"audit_logs_trail_idx", "audit_logs_owner_idx", "audit_logs_root_idx", "audit_logs_created_at_idx"}
+	switch db.Dialector.Name() {
+	case "postgres":
+		indexes[3] = "audit_logs_created_at_brin"
+		indexes = append(indexes, "audit_logs_widgets_trail_idx")
+	case "sqlite":
+		indexes = append(indexes, "audit_logs_widgets_trail_idx")
+	}
+	for _, index := range indexes {
+		if !db.Migrator().HasIndex("audit_logs", index) {
+			t.Errorf("no index %s", index)
+		}
+	}
+
+	w := newWidget("indexes")
+	if err := db.WithContext(userContext("e2e@example.com")).Create(w).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	plan, err := audited.ExplainTrail(db, "widgets", w.Id)
+	if err != nil {
+		t.Fatalf("explain trail: %s", err)
+	}
+	if plan == "" {
+		t.Fatal("got an empty plan")
+	}
+	// postgres may scan a table this small, the others use an index
+	if db.Dialector.Name() != "postgres" && !strings.Contains(strings.ToLower(plan), "idx") {
+		t.Errorf("got plan %q, want the trail read through an index", plan)
+	}
+}
+
+func testMaintenance(t *testing.T, db *gorm.DB) {
+	ctx := userContext("e2e@example.com")
+	expired, kept, erased := newWidget("maintenance"), newWidget("maintenance"), newWidget("maintenance")
+	if err := db.WithContext(ctx).Create([]*Widget{expired, kept, erased}).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if err := audited.Query(db).Where("object_id = ?", expired.Id).
+		Update("created_at", time.Now().Add(-48*time.Hour)).Error; err != nil {
+		t.Fatalf("backdating: %s", err)
+	}
+	if err := audited.RequestErasure(ctx, db, "widgets", erased.Id, audited.PurgeDelete); err != nil {
+		t.Fatalf("request erasure: %s", err)
+	}
+
+	// retention and purges run on every dialect
+	if err := audited.RunMaintenance(context.Background(), db, audited.MaintenanceOptions{
+		Retention:    &audited.RetentionPolicy{Tables: map[string]time.Duration{"widgets": 24 * time.Hour}},
+		PurgeHistory: true,
+	}); err != nil {
+		t.Fatalf("run maintenance: %s", err)
+	}
+	expectTrail(t, db, expired.Id)
+	expectTrail(t, db, kept.Id, audited.OperationCreate)
+	expectTrail(t, db, erased.Id, audited.OperationPurge)
+
+	err := audited.RunMaintenance(context.Background(), db, audited.MaintenanceOptions{Analyze: true})
+	if db.Dialector.Name() != "postgres" {
+		if !errors.Is(err, audited.ErrUnsupportedDialect) {
+			t.Fatalf("analyze: got %v, want ErrUnsupportedDialect", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("analyze: %s", err)
+	}
+	reports, err := audited.Maintenance(db)
+	if err != nil {
+		t.Fatalf("maintenance report: %s", err)
+	}
+	if len(reports) != 1 || reports[0].Table != "audit_logs" || reports[0].TotalBytes == 0 ||
+		len(reports[0].Indexes) == 0 {
+		t.Errorf("got reports %+v, want the audit table", reports)
+	}
+}
+
+func testSessionActor(t *testing.T, db *gorm.DB) {
+	// the user is resolved once for all the rows of a statement
+	resolved := 0
+	counted := reopen(t, db, audited.WithUserResolver(func(ctx context.Context, db *gorm.DB) (string, error) {
+		resolved++
+		return "resolver@example.com", nil
+	}))
+	widgets := newWidgets("session actor", 3)
+	if err := counted.Create(widgets).Error; err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if resolved != 1 {
+		t.Errorf("got the user resolved %d times for 3 rows, want once", resolved)
+	}
+	expectTrail(t, db, widgets[2].Id, audited.OperationCreate)
+
+	var set string
+	switch db.Dialector.Name() {
+	case "postgres":
+		set = "SET LOCAL app.actor = 'session@example.com'"
+	case "mysql":
+		set = "SET @app.actor = 'session@example.com'"
+	default:
+		t.Skip("the session actor is read from postgres and mysql only")
+	}
+	defer func(previous string) { audited.SessionActor = previous }(audited.SessionActor)
+	audited.SessionActor = "app.actor"
+	bulk := newWidgets("session actor", 2)
+	if err := db.WithContext(userContext("e2e@example.com")).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(set).Error; err != nil {
+			return err
+		}
+		return tx.Create(bulk).Error
+	}); err != nil {
+		t.Fatalf("transaction: %s", err)
+	}
+	for _, w := range bulk {
+		if trail := expectTrail(t, db, w.Id, audited.OperationCreate); trail[0].UserId != "session@example.com" {
+			t.Errorf("got user %q, want the user of the database session", trail[0].UserId)
+		}
+	}
+}
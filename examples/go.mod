@@ -0,0 +1,28 @@
+module github.com/mleonidas/audited/examples
+
+go 1.21.0
+
+require (
+	github.com/google/uuid v1.3.1
+	github.com/mleonidas/audited v0.0.0
+	github.com/ory/dockertest/v3 v3.10.0
+	gorm.io/driver/mysql v1.5.0
+	gorm.io/driver/postgres v1.5.2
+	gorm.io/driver/sqlite v1.5.0
+	gorm.io/gorm v1.25.4
+)
+
+require (
+	github.com/go-sql-driver/mysql v1.7.0 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jackc/pgx/v5 v5.3.1 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/mattn/go-sqlite3 v1.14.15 // indirect
+	golang.org/x/crypto v0.8.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	gorm.io/datatypes v1.2.0 // indirect
+)
+
+replace github.com/mleonidas/audited => ../
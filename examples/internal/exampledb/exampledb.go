@@ -0,0 +1,295 @@
+// Package exampledb opens the databases used by the examples and creates the
+// audit tables for each supported dialect.
+package exampledb
+
+import (
+	"fmt"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open connects to dsn with the driver named by dialect: postgres, mysql or sqlite
+func Open(dialect, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch dialect {
+	case "postgres":
+		dialector = postgres.Open(dsn)
+	case "mysql":
+		dialector = mysql.Open(dsn)
+	case "sqlite":
+		dialector = sqlite.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported dialect %q", dialect)
+	}
+	return gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Warn)})
+}
+
+var auditTables = map[string][]string{
+	"postgres": {`CREATE TABLE IF NOT EXISTS audit_logs(
+		id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+		table_name varchar,
+		operation_type varchar,
+		object_id varchar,
+		data jsonb,
+		old_data jsonb,
+		user_id varchar,
+		owner_id varchar,
+		domain varchar,
+		actor_ip varchar,
+		user_agent varchar,
+		request_id varchar,
+		session_id varchar,
+		source varchar,
+		tenant_id varchar,
+		root_table varchar,
+		root_object_id varchar,
+		summary varchar,
+		idempotency_key varchar NOT NULL DEFAULT '',
+		metadata jsonb,
+		seq bigserial UNIQUE,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`, `CREATE TABLE IF NOT EXISTS audit_field_changes(
+		id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+		audit_id uuid,
+		table_name varchar,
+		object_id varchar,
+		field varchar,
+		old_value jsonb,
+		new_value jsonb,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`, `CREATE TABLE IF NOT EXISTS audit_checkpoints(
+		name varchar PRIMARY KEY,
+		seq bigint NOT NULL DEFAULT 0,
+		updated_at timestamptz NOT NULL DEFAULT now()
+	)`, `CREATE TABLE IF NOT EXISTS audit_anchors(
+		seq bigint PRIMARY KEY,
+		chain_hash bytea,
+		notary varchar,
+		proof bytea,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`, `CREATE TABLE IF NOT EXISTS audit_merkle_roots(
+		seq_to bigint PRIMARY KEY,
+		seq_from bigint NOT NULL,
+		size integer NOT NULL,
+		root bytea,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`, `CREATE TABLE IF NOT EXISTS audit_purges(
+		table_name varchar,
+		object_id varchar,
+		mode varchar,
+		requested_by varchar,
+		requested_at timestamptz,
+		purged_at timestamptz,
+		entries bigint NOT NULL DEFAULT 0,
+		PRIMARY KEY (table_name, object_id)
+	)`, `CREATE TABLE IF NOT EXISTS audit_meta_logs(
+		id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+		action varchar,
+		user_id varchar,
+		details jsonb,
+		created_at timestamptz NOT NULL DEFAULT now()
+	)`, `CREATE TABLE IF NOT EXISTS audit_latest(
+		table_name varchar,
+		object_id varchar,
+		audit_id uuid,
+		operation_type varchar,
+		user_id varchar,
+		version bigint NOT NULL DEFAULT 0,
+		changed_at timestamptz,
+		PRIMARY KEY (table_name, object_id)
+	)`, `CREATE TABLE IF NOT EXISTS audit_rollups(
+		hour timestamptz,
+		table_name varchar,
+		domain varchar,
+		operation_type varchar,
+		user_id varchar,
+		count bigint NOT NULL DEFAULT 0,
+		PRIMARY KEY (hour, table_name, domain, operation_type, user_id)
+	)`},
+	"mysql": {`CREATE TABLE IF NOT EXISTS audit_logs(
+		id char(36) PRIMARY KEY,
+		table_name varchar(255),
+		operation_type varchar(32),
+		object_id varchar(255),
+		data json,
+		old_data json,
+		user_id varchar(255),
+		owner_id varchar(255),
+		domain varchar(255),
+		actor_ip varchar(255),
+		user_agent varchar(255),
+		request_id varchar(255),
+		session_id varchar(255),
+		source varchar(255),
+		tenant_id varchar(255),
+		root_table varchar(255),
+		root_object_id varchar(255),
+		summary text,
+		idempotency_key varchar(255) NOT NULL DEFAULT '',
+		metadata json,
+		seq bigint NOT NULL AUTO_INCREMENT UNIQUE,
+		created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+	)`, `CREATE TABLE IF NOT EXISTS audit_field_changes(
+		id char(36) PRIMARY KEY,
+		audit_id char(36),
+		table_name varchar(255),
+		object_id varchar(255),
+		field varchar(255),
+		old_value json,
+		new_value json,
+		created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+	)`, `CREATE TABLE IF NOT EXISTS audit_checkpoints(
+		name varchar(255) PRIMARY KEY,
+		seq bigint NOT NULL DEFAULT 0,
+		updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+	)`, `CREATE TABLE IF NOT EXISTS audit_anchors(
+		seq bigint PRIMARY KEY,
+		chain_hash varbinary(32),
+		notary varchar(255),
+		proof blob,
+		created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+	)`, `CREATE TABLE IF NOT EXISTS audit_merkle_roots(
+		seq_to bigint PRIMARY KEY,
+		seq_from bigint NOT NULL,
+		size int NOT NULL,
+		root varbinary(32),
+		created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+	)`, `CREATE TABLE IF NOT EXISTS audit_purges(
+		table_name varchar(255),
+		object_id varchar(255),
+		mode varchar(32),
+		requested_by varchar(255),
+		requested_at datetime(6),
+		purged_at datetime(6),
+		entries bigint NOT NULL DEFAULT 0,
+		PRIMARY KEY (table_name, object_id)
+	)`, `CREATE TABLE IF NOT EXISTS audit_meta_logs(
+		id char(36) PRIMARY KEY,
+		action varchar(64),
+		user_id varchar(255),
+		details json,
+		created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+	)`, `CREATE TABLE IF NOT EXISTS audit_latest(
+		table_name varchar(255),
+		object_id varchar(255),
+		audit_id char(36),
+		operation_type varchar(32),
+		user_id varchar(255),
+		version bigint NOT NULL DEFAULT 0,
+		changed_at datetime(6),
+		PRIMARY KEY (table_name, object_id)
+	)`, `CREATE TABLE IF NOT EXISTS audit_rollups(
+		hour datetime(6),
+		table_name varchar(255),
+		domain varchar(255),
+		operation_type varchar(32),
+		user_id varchar(255),
+		count bigint NOT NULL DEFAULT 0,
+		PRIMARY KEY (hour, table_name, domain, operation_type, user_id)
+	)`},
+	"sqlite": {`CREATE TABLE IF NOT EXISTS audit_logs(
+		id text PRIMARY KEY,
+		table_name text,
+		operation_type text,
+		object_id text,
+		data text,
+		old_data text,
+		user_id text,
+		owner_id text,
+		domain text,
+		actor_ip text,
+		user_agent text,
+		request_id text,
+		session_id text,
+		source text,
+		tenant_id text,
+		root_table text,
+		root_object_id text,
+		summary text,
+		idempotency_key text NOT NULL DEFAULT '',
+		metadata text,
+		seq integer UNIQUE,
+		created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+		// sqlite has no sequences, entries are numbered after the insert
+		`CREATE TRIGGER IF NOT EXISTS audit_logs_seq AFTER INSERT ON audit_logs WHEN NEW.seq IS NULL
+	BEGIN
+		UPDATE audit_logs SET seq = (SELECT COALESCE(MAX(seq), 0) + 1 FROM audit_logs) WHERE rowid = NEW.rowid;
+	END`,
+		`CREATE TABLE IF NOT EXISTS audit_field_changes(
+		id text PRIMARY KEY,
+		audit_id text,
+		table_name text,
+		object_id text,
+		field text,
+		old_value text,
+		new_value text,
+		created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`, `CREATE TABLE IF NOT EXISTS audit_checkpoints(
+		name text PRIMARY KEY,
+		seq integer NOT NULL DEFAULT 0,
+		updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`, `CREATE TABLE IF NOT EXISTS audit_anchors(
+		seq integer PRIMARY KEY,
+		chain_hash blob,
+		notary text,
+		proof blob,
+		created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`, `CREATE TABLE IF NOT EXISTS audit_merkle_roots(
+		seq_to integer PRIMARY KEY,
+		seq_from integer NOT NULL,
+		size integer NOT NULL,
+		root blob,
+		created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`, `CREATE TABLE IF NOT EXISTS audit_purges(
+		table_name text,
+		object_id text,
+		mode text,
+		requested_by text,
+		requested_at datetime,
+		purged_at datetime,
+		entries integer NOT NULL DEFAULT 0,
+		PRIMARY KEY (table_name, object_id)
+	)`, `CREATE TABLE IF NOT EXISTS audit_meta_logs(
+		id text PRIMARY KEY,
+		action text,
+		user_id text,
+		details text,
+		created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`, `CREATE TABLE IF NOT EXISTS audit_latest(
+		table_name text,
+		object_id text,
+		audit_id text,
+		operation_type text,
+		user_id text,
+		version integer NOT NULL DEFAULT 0,
+		changed_at datetime,
+		PRIMARY KEY (table_name, object_id)
+	)`, `CREATE TABLE IF NOT EXISTS audit_rollups(
+		hour datetime,
+		table_name text,
+		domain text,
+		operation_type text,
+		user_id text,
+		count integer NOT NULL DEFAULT 0,
+		PRIMARY KEY (hour, table_name, domain, operation_type, user_id)
+	)`},
+}
+
+// CreateAuditTable creates the audit tables if they don't exist
+func CreateAuditTable(db *gorm.DB) error {
+	ddls, ok := auditTables[db.Dialector.Name()]
+	if !ok {
+		return fmt.Errorf("unsupported dialect %q", db.Dialector.Name())
+	}
+	for _, ddl := range ddls {
+		if err := db.Exec(ddl).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// Command service is a small orders API showing the audit callbacks in a real
+// service: the acting user comes from a request header, the entries of each
+// request are flushed in one insert, and an order's trail is served next to it.
+// With AUDIT_TOKEN_KEY set the trail needs an API token with the
+// read:table:orders scope.
+//
+//	DB_DIALECT=sqlite DB_DSN=orders.db go run ./service
+//	curl -H 'X-User-Email: ana@example.com' -d '{"customer":"acme","total":1200}' localhost:8080/orders
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mleonidas/audited"
+	"github.com/mleonidas/audited/examples/internal/exampledb"
+	"gorm.io/gorm"
+)
+
+// Order is the audited model of the example
+type Order struct {
+	Id        string         `json:"id" gorm:"primaryKey"`
+	Customer  string         `json:"customer"`
+	Status    string         `json:"status"`
+	Total     int64          `json:"total"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at"`
+}
+
+type server struct {
+	db    *gorm.DB
+	trail http.Handler
+}
+
+func main() {
+	dialect, dsn := getenv("DB_DIALECT", "sqlite"), getenv("DB_DSN", "orders.db")
+	db, err := exampledb.Open(dialect, dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := exampledb.CreateAuditTable(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Order{}); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Use(audited.New()); err != nil {
+		log.Fatal(err)
+	}
+
+	s := &server{db: db}
+	s.trail = http.HandlerFunc(s.serveTrail)
+	if key := os.Getenv("AUDIT_TOKEN_KEY"); key != "" {
+		if len(key) < audited.MinTokenKeySize {
+			log.Fatal(audited.ErrWeakTokenKey)
+		}
+		s.trail = audited.RequireScope(&audited.TokenIssuer{Key: []byte(key)}, audited.ScopeReadTable("orders"))(s.trail)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orders", s.orders)
+	mux.HandleFunc("/orders/", s.order)
+
+	addr := getenv("ADDR", ":8080")
+	log.Printf("listening on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, withUser(audited.Middleware(db)(mux))))
+}
+
+// withUser puts the acting user of the request where the audit callbacks read it
+func withUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if email := r.Header.Get("X-User-Email"); email != "" {
+			r = r.WithContext(context.WithValue(r.Context(), audited.ContextKeyEmail, email))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// orders handles POST /orders
+func (s *server) orders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var order Order
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	order.Id = uuid.NewString()
+	if order.Status == "" {
+		order.Status = "pending"
+	}
+	if err := s.db.WithContext(r.Context()).Create(&order).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, order)
+}
+
+// order handles GET, PUT and DELETE /orders/{id} and GET /orders/{id}/trail
+func (s *server) order(w http.ResponseWriter, r *http.Request) {
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/orders/"), "/")
+	db := s.db.WithContext(r.Context())
+
+	if rest == "trail" && r.Method == http.MethodGet {
+		s.trail.ServeHTTP(w, r)
+		return
+	}
+
+	var order Order
+	if err := db.First(&order, "id = ?", id).Error; err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, order)
+	case http.MethodPut:
+		var changes Order
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := db.Model(&order).Updates(Order{Status: changes.Status, Total: changes.Total}).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, order)
+	case http.MethodDelete:
+		if err := db.Delete(&order).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveTrail handles GET /orders/{id}/trail
+func (s *server) serveTrail(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/orders/"), "/")
+	trail, err := audited.TrailFor(s.db.WithContext(r.Context()), "orders", id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, trail)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
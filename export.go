@@ -0,0 +1,368 @@
+package audited
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExportFormat is the file format of an export
+type ExportFormat string
+
+const (
+	// ExportJSONL writes one JSON entry per line
+	ExportJSONL ExportFormat = "jsonl"
+	// ExportCSV writes one entry per row, data and metadata as JSON
+	ExportCSV ExportFormat = "csv"
+)
+
+// ManifestFile is the name of the manifest written with an export
+const ManifestFile = "manifest.json"
+
+const defaultExportFileRows = 100000
+
+// ExportOptions selects the entries exported and how they are written
+type ExportOptions struct {
+	// Dir the files are written to, it is created if needed
+	Dir string
+	// Format defaults to ExportJSONL
+	Format    ExportFormat
+	TableName string
+	Domain    string
+	Since     time.Time
+	Until     time.Time
+	// Source selects the changes tagged with it, see WithSource
+	Source string
+	// HumanOnly leaves out the changes tagged with a source, made by
+	// migrations and batch jobs
+	HumanOnly bool
+	// Tenant selects the changes made for it, see WithTenant and ExportTenant
+	Tenant string
+	// FileRows is the maximum number of entries per file, defaults to 100000
+	FileRows int
+	// Manifest writes manifest.json with the checksum of every file
+	Manifest bool
+	// Signer signs the manifest, e.g. an ed25519.PrivateKey; optional
+	Signer crypto.Signer
+}
+
+// ExportManifest lists the files of an export so recipients can verify them
+type ExportManifest struct {
+	CreatedAt time.Time      `json:"created_at"`
+	Format    ExportFormat   `json:"format"`
+	TableName string         `json:"table_name,omitempty"`
+	Tenant    string         `json:"tenant,omitempty"`
+	Since     *time.Time     `json:"since,omitempty"`
+	Until     *time.Time     `json:"until,omitempty"`
+	Files     []ExportedFile `json:"files"`
+	// Signature is the signature of the manifest without it, ed25519 keys
+	// sign the manifest, other keys its SHA-256 digest
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// ExportedFile is a file of an export
+type ExportedFile struct {
+	Name   string `json:"name"`
+	Rows   int    `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+var csvHeader = []string{"id", "seq", "table_name", "operation_type", "object_id", "user_id", "owner_id",
+	"root_table", "root_object_id", "domain", "actor_ip", "user_agent", "request_id", "session_id", "source",
+	"tenant_id", "summary", "idempotency_key", "created_at", "data", "old_data", "metadata"}
+
+// Export writes the entries selected by opts, in sequence order, to files in
+// opts.Dir and returns their manifest, which is also written to the directory
+// when opts.Manifest is set
+func Export(ctx context.Context, db *gorm.DB, opts ExportOptions) (*ExportManifest, error) {
+	if opts.Format == "" {
+		opts.Format = ExportJSONL
+	}
+	if opts.Format != ExportJSONL && opts.Format != ExportCSV {
+		return nil, fmt.Errorf("audited: unsupported export format %q", opts.Format)
+	}
+	if opts.FileRows <= 0 {
+		opts.FileRows = defaultExportFileRows
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	manifest := &ExportManifest{CreatedAt: time.Now().UTC(), Format: opts.Format, TableName: opts.TableName,
+		Tenant: opts.Tenant}
+	query := Query(Reader(db.WithContext(ctx)))
+	if opts.TableName != "" {
+		query = query.Where("table_name = ?", opts.TableName)
+	}
+	if opts.Domain != "" {
+		query = query.Where("domain = ?", opts.Domain)
+	}
+	if opts.Source != "" {
+		query = query.Scopes(FromSource(opts.Source))
+	}
+	if opts.HumanOnly {
+		query = query.Scopes(HumanChanges())
+	}
+	if opts.Tenant != "" {
+		query = query.Scopes(ForTenant(opts.Tenant))
+	}
+	if !opts.Since.IsZero() {
+		query = query.Where("created_at >= ?", opts.Since)
+		manifest.Since = &opts.Since
+	}
+	if !opts.Until.IsZero() {
+		query = query.Where("created_at < ?", opts.Until)
+		manifest.Until = &opts.Until
+	}
+
+	w := &exportWriter{dir: opts.Dir, format: opts.Format, fileRows: opts.FileRows}
+	err := eachEntry(query.Session(&gorm.Session{}), w.write)
+	if closeErr := w.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest.Files = w.files
+
+	if opts.Signer != nil {
+		if err := manifest.sign(opts.Signer); err != nil {
+			return nil, err
+		}
+	}
+	rows := 0
+	for _, file := range manifest.Files {
+		rows += file.Rows
+	}
+	if err := RecordAdminAction(ctx, db, MetaExport, map[string]interface{}{
+		"dir": opts.Dir, "format": string(opts.Format), "table_name": opts.TableName,
+		"tenant": opts.Tenant, "files": len(manifest.Files), "rows": rows,
+	}); err != nil {
+		return nil, err
+	}
+	if opts.Manifest {
+		encoded, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(opts.Dir, ManifestFile), encoded, 0o644); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// eachEntry calls fn with the entries of query in batches, in sequence order
+func eachEntry(query *gorm.DB, fn func(entry AuditLog) error) error {
+	var cursor int64
+	for {
+		var batch []AuditLog
+		if err := query.Where("seq > ?", cursor).Order("seq").Limit(defaultSeqLimit).Find(&batch).Error; err != nil {
+			return err
+		}
+		for _, entry := range batch {
+			if err := fn(entry); err != nil {
+				return err
+			}
+			cursor = entry.Seq
+		}
+		if len(batch) < defaultSeqLimit {
+			return nil
+		}
+	}
+}
+
+// signedBytes returns the bytes the signature of the manifest covers
+func (m ExportManifest) signedBytes() ([]byte, error) {
+	m.Signature = nil
+	return json.Marshal(m)
+}
+
+func (m *ExportManifest) sign(signer crypto.Signer) error {
+	message, err := m.signedBytes()
+	if err != nil {
+		return err
+	}
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		opts = crypto.Hash(0)
+	} else {
+		digest := sha256.Sum256(message)
+		message = digest[:]
+	}
+	m.Signature, err = signer.Sign(rand.Reader, message, opts)
+	return err
+}
+
+// VerifyExport checks the files of the export in dir against its manifest and,
+// when publicKey is not nil, the signature of the manifest
+func VerifyExport(dir string, publicKey crypto.PublicKey) (*ExportManifest, error) {
+	encoded, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest ExportManifest
+	if err := json.Unmarshal(encoded, &manifest); err != nil {
+		return nil, err
+	}
+	if publicKey != nil {
+		if err := manifest.verifySignature(publicKey); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range manifest.Files {
+		sum, size, err := fileSHA256(filepath.Join(dir, file.Name))
+		if err != nil {
+			return nil, err
+		}
+		if sum != file.SHA256 || size != file.Bytes {
+			return nil, fmt.Errorf("audited: checksum mismatch for %s", file.Name)
+		}
+	}
+	return &manifest, nil
+}
+
+func (m ExportManifest) verifySignature(publicKey crypto.PublicKey) error {
+	if len(m.Signature) == 0 {
+		return errors.New("audited: manifest is not signed")
+	}
+	message, err := m.signedBytes()
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(message)
+	valid := false
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, message, m.Signature)
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], m.Signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], m.Signature) == nil
+	default:
+		return fmt.Errorf("audited: unsupported public key %T", publicKey)
+	}
+	if !valid {
+		return errors.New("audited: invalid manifest signature")
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// exportWriter writes entries to numbered files, hashing them as they are written
+type exportWriter struct {
+	dir      string
+	format   ExportFormat
+	fileRows int
+	files    []ExportedFile
+
+	file    *os.File
+	hash    hash.Hash
+	counter *countingWriter
+	csv     *csv.Writer
+	rows    int
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (w *exportWriter) write(entry AuditLog) error {
+	if w.file == nil || w.rows >= w.fileRows {
+		if err := w.close(); err != nil {
+			return err
+		}
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	w.rows++
+	if w.format == ExportCSV {
+		metadata, err := json.Marshal(entry.Metadata)
+		if err != nil {
+			return err
+		}
+		return w.csv.Write([]string{string(entry.Id), strconv.FormatInt(entry.Seq, 10), entry.TableName,
+			entry.OperationType, entry.ObjectId, entry.UserId, entry.OwnerId, entry.RootTable, entry.RootObjectId,
+			entry.Domain, entry.ActorIP, entry.UserAgent, entry.RequestId, entry.SessionId, entry.Source,
+			entry.TenantId, entry.Summary, entry.IdempotencyKey, entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+			string(entry.Data), string(entry.OldData), string(metadata)})
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.counter.Write(append(line, '\n'))
+	return err
+}
+
+func (w *exportWriter) open() error {
+	name := fmt.Sprintf("audit-%05d.%s", len(w.files)+1, w.format)
+	f, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return err
+	}
+	w.file, w.hash, w.rows = f, sha256.New(), 0
+	w.counter = &countingWriter{w: io.MultiWriter(f, w.hash)}
+	w.files = append(w.files, ExportedFile{Name: name})
+	if w.format == ExportCSV {
+		w.csv = csv.NewWriter(w.counter)
+		return w.csv.Write(csvHeader)
+	}
+	return nil
+}
+
+func (w *exportWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	file := &w.files[len(w.files)-1]
+	file.Rows, file.Bytes, file.SHA256 = w.rows, w.counter.n, hex.EncodeToString(w.hash.Sum(nil))
+	err := w.file.Close()
+	w.file, w.csv = nil, nil
+	return err
+}
@@ -0,0 +1,118 @@
+package audited
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func writeTestExport(t *testing.T, format ExportFormat) (string, *ExportManifest) {
+	dir := t.TempDir()
+	w := &exportWriter{dir: dir, format: format, fileRows: 2}
+	for i, id := range []ID{"1", "2", "3"} {
+		entry := AuditLog{Id: id, Seq: int64(i + 1), TableName: "orders", OperationType: OperationCreate,
+			Data: datatypes.JSON(`{"id":"7","note":"a, \"quoted\" value"}`), TenantId: "acme"}
+		if err := w.write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir, &ExportManifest{Format: format, Files: w.files}
+}
+
+func TestExportWriter(t *testing.T) {
+	dir, manifest := writeTestExport(t, ExportCSV)
+	if len(manifest.Files) != 2 || manifest.Files[0].Rows != 2 || manifest.Files[1].Rows != 1 {
+		t.Fatalf("unexpected files %+v", manifest.Files)
+	}
+	f, err := os.Open(filepath.Join(dir, manifest.Files[0].Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0][0] != "id" || len(records[0]) != len(records[2]) {
+		t.Fatalf("unexpected records %q", records)
+	}
+	row := map[string]string{}
+	for i, column := range records[0] {
+		row[column] = records[2][i]
+	}
+	if row["data"] != `{"id":"7","note":"a, \"quoted\" value"}` || row["tenant_id"] != "acme" {
+		t.Fatalf("unexpected row %q", row)
+	}
+	for _, column := range []string{"old_data", "owner_id", "domain", "source", "actor_ip", "user_agent",
+		"request_id", "session_id"} {
+		if _, ok := row[column]; !ok {
+			t.Errorf("no %s column in the header %q", column, records[0])
+		}
+	}
+	for _, file := range manifest.Files {
+		sum, size, err := fileSHA256(filepath.Join(dir, file.Name))
+		if err != nil || sum != file.SHA256 || size != file.Bytes {
+			t.Fatalf("manifest of %s doesn't match the file: %+v", file.Name, file)
+		}
+	}
+}
+
+func TestVerifyExport(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	for name, signer := range map[string]struct {
+		sign   func(m *ExportManifest) error
+		public interface{}
+	}{
+		"ed25519": {func(m *ExportManifest) error { return m.sign(edKey) }, edKey.Public()},
+		"ecdsa":   {func(m *ExportManifest) error { return m.sign(ecKey) }, ecKey.Public()},
+	} {
+		dir, manifest := writeTestExport(t, ExportJSONL)
+		if err := signer.sign(manifest); err != nil {
+			t.Fatal(name, err)
+		}
+		writeManifest(t, dir, manifest)
+		if _, err := VerifyExport(dir, signer.public); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		// a changed file fails the checksum
+		path := filepath.Join(dir, manifest.Files[1].Name)
+		if err := os.WriteFile(path, []byte("{}\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := VerifyExport(dir, nil); err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Fatalf("%s: got %v, want a checksum mismatch", name, err)
+		}
+
+		// a changed manifest fails the signature
+		manifest.Files[0].Rows++
+		writeManifest(t, dir, manifest)
+		if _, err := VerifyExport(dir, signer.public); err == nil || !strings.Contains(err.Error(), "signature") {
+			t.Fatalf("%s: got %v, want an invalid signature", name, err)
+		}
+	}
+}
+
+func writeManifest(t *testing.T, dir string, manifest *ExportManifest) {
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), encoded, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,72 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInjectedFault is returned by audit writes failed by WithFaults
+var ErrInjectedFault = errors.New("audited: injected fault")
+
+// Faults describes failures injected into audit writes. It is meant for tests
+// checking how an application copes with a slow or failing audit store.
+type Faults struct {
+	// Latency is added to every write
+	Latency time.Duration
+	// FailureRate is the fraction of writes, between 0 and 1, that fail
+	FailureRate float64
+	// PartialBatch makes writes of more than PartialBatch entries fail after
+	// only the first PartialBatch entries were written
+	PartialBatch int
+	// Err is returned by failed writes, defaults to ErrInjectedFault
+	Err error
+}
+
+// WithFaults makes the audit writes of the database misbehave as described by
+// f. It is meant for tests, on a database opened for the test, e.g.
+//
+//	audited.RegisterCallbacks(db, audited.WithFaults(audited.Faults{FailureRate: 1}))
+//
+// Audit writes fail open: the changes of the application are kept, the
+// failures are logged and the entries not written are missing from the trail
+// and from the Recorder.
+func WithFaults(f Faults) Option {
+	if f.Err == nil {
+		f.Err = ErrInjectedFault
+	}
+	return func(o *options) {
+		o.faults = &f
+	}
+}
+
+// injectFaults applies the faults of db to a write of logs. It returns the
+// logs that should still be written and the error the write should report.
+func injectFaults(db *gorm.DB, logs []AuditLog) ([]AuditLog, error) {
+	f := configFor(db).faults
+	if f == nil {
+		return logs, nil
+	}
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if f.Latency > 0 {
+		select {
+		case <-time.After(f.Latency):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if f.FailureRate > 0 && rand.Float64() < f.FailureRate {
+		return nil, f.Err
+	}
+	if f.PartialBatch > 0 && len(logs) > f.PartialBatch {
+		return logs[:f.PartialBatch], f.Err
+	}
+	return logs, nil
+}
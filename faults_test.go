@@ -0,0 +1,41 @@
+package audited
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestFaults(t *testing.T) {
+	open := func(opts ...Option) *gorm.DB {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := RegisterCallbacks(db, opts...); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	logs := []AuditLog{{Id: newID()}, {Id: newID()}}
+
+	partial := open(WithFaults(Faults{PartialBatch: 1}))
+	written, err := injectFaults(partial, logs)
+	if len(written) != 1 || !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("got %d entries written and error %v, want the first one and an injected fault", len(written), err)
+	}
+
+	failing := errors.New("audit store down")
+	written, err = injectFaults(open(WithFaults(Faults{FailureRate: 1, Err: failing})), logs)
+	if len(written) != 0 || !errors.Is(err, failing) {
+		t.Fatalf("got %d entries written and error %v, want none and the fault", len(written), err)
+	}
+
+	// the faults are scoped to their database
+	written, err = injectFaults(open(), logs)
+	if len(written) != 2 || err != nil {
+		t.Fatalf("got %d entries written and error %v without faults", len(written), err)
+	}
+}
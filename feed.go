@@ -0,0 +1,150 @@
+package audited
+
+import (
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultFeedWindow = 5 * time.Minute
+	defaultFeedLimit  = 500
+)
+
+// FeedOptions filters the entries of an activity feed and controls how they are collapsed
+type FeedOptions struct {
+	TableName string
+	Domain    string
+	ObjectId  string
+	UserId    string
+	Since     time.Time
+	Until     time.Time
+	// Source selects the changes tagged with it, see WithSource
+	Source string
+	// HumanOnly leaves out the changes tagged with a source, made by
+	// migrations and batch jobs
+	HumanOnly bool
+	// Window collapses edits by the same user on the same object that happen
+	// within it of each other into one item, defaults to 5 minutes
+	Window time.Duration
+	// Limit is the maximum number of audit entries read, defaults to 500
+	Limit int
+}
+
+// FeedItem is one or more consecutive changes by a user on an object
+type FeedItem struct {
+	TableName  string     `json:"table_name"`
+	ObjectId   string     `json:"object_id"`
+	UserId     string     `json:"user_id"`
+	Operations []string   `json:"operations"`
+	Fields     []string   `json:"fields"`
+	Start      time.Time  `json:"start"`
+	End        time.Time  `json:"end"`
+	Entries    []AuditLog `json:"entries"`
+}
+
+// Count returns the number of audit entries collapsed into the item
+func (i FeedItem) Count() int {
+	return len(i.Entries)
+}
+
+// Feed returns the most recent activity, newest first, with edits by the same
+// user on the same object collapsed per FeedOptions.Window, so a burst of edits
+// reads as a single "edited 5 fields" item
+func Feed(db *gorm.DB, opts FeedOptions) ([]FeedItem, error) {
+	if opts.Window <= 0 {
+		opts.Window = defaultFeedWindow
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultFeedLimit
+	}
+
+	query := Query(Reader(db))
+	if opts.TableName != "" {
+		query = query.Where("table_name = ?", opts.TableName)
+	}
+	if opts.Domain != "" {
+		query = query.Where("domain = ?", opts.Domain)
+	}
+	if opts.Source != "" {
+		query = query.Scopes(FromSource(opts.Source))
+	}
+	if opts.HumanOnly {
+		query = query.Scopes(HumanChanges())
+	}
+	if opts.ObjectId != "" {
+		query = query.Where("object_id = ?", opts.ObjectId)
+	}
+	if opts.UserId != "" {
+		query = query.Where("user_id = ?", opts.UserId)
+	}
+	if !opts.Since.IsZero() {
+		query = query.Where("created_at >= ?", opts.Since)
+	}
+	if !opts.Until.IsZero() {
+		query = query.Where("created_at < ?", opts.Until)
+	}
+
+	var entries []AuditLog
+	if err := query.Order("created_at DESC").Limit(opts.Limit).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return collapseFeed(entries, opts.Window), nil
+}
+
+// collapseFeed groups entries, given newest first, into feed items
+func collapseFeed(entries []AuditLog, window time.Duration) []FeedItem {
+	type objectKey struct{ table, object string }
+
+	var items []*FeedItem
+	// the group of an object stays open until another user edits the object
+	// or the window passes
+	open := map[objectKey]*FeedItem{}
+	previous := map[objectKey]AuditLog{}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		object := objectKey{entry.TableName, entry.ObjectId}
+
+		item, ok := open[object]
+		if !ok || item.UserId != entry.UserId || entry.CreatedAt.Sub(item.End) > window {
+			item = &FeedItem{
+				TableName: entry.TableName,
+				ObjectId:  entry.ObjectId,
+				UserId:    entry.UserId,
+				Start:     entry.CreatedAt,
+			}
+			open[object] = item
+			items = append(items, item)
+		}
+		item.End = entry.CreatedAt
+		item.Entries = append(item.Entries, entry)
+		item.Operations = appendUnique(item.Operations, entry.OperationType)
+
+		if prev, ok := previous[object]; ok && entry.OperationType == OperationUpdate {
+			if changes, err := DiffEntries(prev, entry); err == nil {
+				for _, change := range changes {
+					item.Fields = appendUnique(item.Fields, change.Field)
+				}
+			}
+		}
+		previous[object] = entry
+	}
+
+	feed := make([]FeedItem, len(items))
+	for i, item := range items {
+		feed[i] = *item
+	}
+	sort.SliceStable(feed, func(i, j int) bool { return feed[i].End.After(feed[j].End) })
+	return feed
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
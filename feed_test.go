@@ -0,0 +1,71 @@
+package audited
+
+import (
+	"testing"
+	"time"
+)
+
+func feedEntry(user, object, operation string, at time.Time, data string) AuditLog {
+	return AuditLog{
+		TableName:     "orders",
+		ObjectId:      object,
+		UserId:        user,
+		OperationType: operation,
+		CreatedAt:     at,
+		Data:          []byte(data),
+	}
+}
+
+// newestFirst reverses entries given oldest first, as Feed reads them
+func newestFirst(entries ...AuditLog) []AuditLog {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+func TestCollapseFeed(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	feed := collapseFeed(newestFirst(
+		feedEntry("ana", "o-1", OperationCreate, at(0), `{"status": "new", "total": 1}`),
+		feedEntry("ana", "o-1", OperationUpdate, at(1), `{"status": "paid", "total": 1}`),
+		feedEntry("ana", "o-1", OperationUpdate, at(2), `{"status": "paid", "total": 2}`),
+		feedEntry("ana", "o-2", OperationCreate, at(3), `{"status": "new"}`),
+		// outside the window of the last edit
+		feedEntry("ana", "o-1", OperationUpdate, at(20), `{"status": "sent", "total": 2}`),
+	), 5*time.Minute)
+
+	if len(feed) != 3 {
+		t.Fatalf("got %d items, want 3: %+v", len(feed), feed)
+	}
+	if feed[0].ObjectId != "o-1" || feed[0].Count() != 1 {
+		t.Errorf("newest item: got %s with %d entries", feed[0].ObjectId, feed[0].Count())
+	}
+	if feed[1].ObjectId != "o-2" {
+		t.Errorf("second item: got %s", feed[1].ObjectId)
+	}
+	burst := feed[2]
+	if burst.Count() != 3 || len(burst.Operations) != 2 || len(burst.Fields) != 2 {
+		t.Errorf("burst: got %d entries, operations %v, fields %v", burst.Count(), burst.Operations, burst.Fields)
+	}
+}
+
+func TestCollapseFeedInterleavedUsers(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	feed := collapseFeed(newestFirst(
+		feedEntry("alice", "o-1", OperationUpdate, start, `{"status": "a"}`),
+		feedEntry("bob", "o-1", OperationUpdate, start.Add(time.Minute), `{"status": "b"}`),
+		feedEntry("alice", "o-1", OperationUpdate, start.Add(2*time.Minute), `{"status": "c"}`),
+	), 5*time.Minute)
+
+	if len(feed) != 3 {
+		t.Fatalf("got %d items, want 3: %+v", len(feed), feed)
+	}
+	for i, user := range []string{"alice", "bob", "alice"} {
+		if feed[i].UserId != user || feed[i].Count() != 1 {
+			t.Errorf("item %d: got %s with %d entries, want %s with 1", i, feed[i].UserId, feed[i].Count(), user)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package audited
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// TagName is the struct tag used to configure how a field is audited,
+// `audited:"-"` leaves the field out of snapshots
+const TagName = "audited"
+
+// Binary field strategies, selected with e.g. `audited:"binary=checksum"`
+const (
+	// BinaryOmit leaves the field out of the snapshot
+	BinaryOmit = "omit"
+	// BinaryChecksum stores the size and sha256 checksum of the content
+	BinaryChecksum = "checksum"
+	// BinaryRef stores a content-addressed reference, and the content itself in BlobStore if set
+	BinaryRef = "ref"
+)
+
+// BlobStorer persists binary content referenced by BinaryRef fields
+type BlobStorer interface {
+	Put(ctx context.Context, ref string, content []byte) error
+}
+
+// BlobStore receives the content of BinaryRef fields, it is optional
+var BlobStore BlobStorer
+
+// parseTag splits an `audited` struct tag into its options, e.g.
+// `audited:"binary=ref,mask"` becomes {"binary": "ref", "mask": ""}
+func parseTag(tag string) map[string]string {
+	opts := map[string]string{}
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(part, "=")
+		opts[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return opts
+}
+
+// jsonKey returns the key encoding/json uses for the field, or "" if the field is not encoded
+func jsonKey(field *schema.Field) string {
+	tag := field.StructField.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// applyFieldOptions rewrites the snapshot of obj according to the `audited`
+// tags of its fields, it returns the consent decisions taken by category
+func applyFieldOptions(db *gorm.DB, obj reflect.Value, data map[string]interface{}) map[string]string {
+	ctx, s := db.Statement.Context, db.Statement.Schema
+	if s == nil {
+		return nil
+	}
+	obj = reflect.Indirect(obj)
+	if obj.Kind() != reflect.Struct {
+		return nil
+	}
+	var consentKeys map[string]string
+	for _, field := range s.Fields {
+		key := jsonKey(field)
+		if key == "" {
+			continue
+		}
+		if _, ok := data[key]; !ok {
+			continue
+		}
+		if field.Tag.Get(TagName) == "-" {
+			delete(data, key)
+			continue
+		}
+		value := field.ReflectValueOf(ctx, obj)
+		if encoded, ok := encodeValue(value); ok {
+			data[key] = encoded
+		}
+		opts := parseTag(field.Tag.Get(TagName))
+		if strategy, ok := opts["binary"]; ok {
+			// the strategy decides how the content is stored, a formatter
+			// would store the content again
+			applyBinaryStrategy(ctx, strategy, key, value, data)
+			if _, kept := data[key]; !kept {
+				continue
+			}
+		} else if formatter := formatterFor(s.ModelType, field.Name); formatter != nil {
+			data[key] = formatter(interfaceOf(value))
+		}
+		for _, strategy := range []string{RedactMask, RedactHash} {
+			if _, ok := opts[strategy]; ok {
+				redact(configFor(db).redactionKey, strategy, key, data)
+				break
+			}
+		}
+		if category := opts["consent"]; category != "" {
+			if consentKeys == nil {
+				consentKeys = map[string]string{}
+			}
+			consentKeys[key] = category
+		}
+	}
+	return applyConsent(ctx, s, obj, data, consentKeys)
+}
+
+func applyBinaryStrategy(ctx context.Context, strategy, key string, value reflect.Value, data map[string]interface{}) {
+	content, ok := binaryContent(value)
+	if !ok {
+		return
+	}
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	switch strategy {
+	case BinaryOmit:
+		delete(data, key)
+	case BinaryChecksum:
+		data[key] = map[string]interface{}{"size": len(content), "sha256": digest}
+	case BinaryRef:
+		ref := "sha256:" + digest
+		if BlobStore != nil {
+			if err := BlobStore.Put(ctx, ref, content); err != nil {
+				log.Println(fmt.Errorf("error storing blob %s: %s", ref, err.Error()))
+			}
+		}
+		data[key] = map[string]interface{}{"size": len(content), "ref": ref}
+	default:
+		log.Printf("unknown binary strategy %q on field %s", strategy, key)
+	}
+}
+
+// interfaceOf returns the value behind any pointers, or nil
+func interfaceOf(value reflect.Value) interface{} {
+	value = reflect.Indirect(value)
+	if !value.IsValid() {
+		return nil
+	}
+	return value.Interface()
+}
+
+func binaryContent(value reflect.Value) ([]byte, bool) {
+	value = reflect.Indirect(value)
+	switch {
+	case !value.IsValid():
+		return nil, false
+	case value.Kind() == reflect.String:
+		return []byte(value.String()), true
+	case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8:
+		return value.Bytes(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,135 @@
+package audited
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+type Credential struct {
+	Id           string `json:"id"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash" audited:"-"`
+	Token        []byte `audited:"-"`
+}
+
+func TestSnapshotExcludedFields(t *testing.T) {
+	credential := &Credential{Id: "c1", Email: "ann@example.com", PasswordHash: "$2a$10$x", Token: []byte("secret")}
+	data, err := snapshot(statementFor(t, credential), reflect.ValueOf(credential))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"id": "c1", "email": "ann@example.com"}
+	if !reflect.DeepEqual(data, want) {
+		t.Fatalf("got %v, want %v", data, want)
+	}
+}
+
+type Patient struct {
+	Id    string `json:"id"`
+	Name  string `json:"name" audited:"mask"`
+	Email string `json:"email" audited:"hash"`
+	Phone string `json:"phone"`
+	Notes *string
+}
+
+func TestSnapshotRedaction(t *testing.T) {
+	patient := &Patient{Id: "p1", Name: "Ann", Email: "ann@example.com", Phone: "555-0100"}
+	tx := statementFor(t, patient)
+	if err := RegisterCallbacks(tx, WithRedactionKey([]byte("k")),
+		WithRedaction("patients", map[string]string{"phone": RedactHash, "Notes": RedactMask})); err != nil {
+		t.Fatal(err)
+	}
+	data, err := snapshot(tx, reflect.ValueOf(patient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("k")
+	email, phone := redactionHash(key, []byte(`"ann@example.com"`)), redactionHash(key, []byte(`"555-0100"`))
+	want := map[string]interface{}{"id": "p1", "name": "***", "email": email, "phone": phone, "Notes": nil}
+	if !reflect.DeepEqual(data, want) {
+		t.Fatalf("got %v, want %v", data, want)
+	}
+
+	patient.Email = "ann@example.org"
+	changed, err := snapshot(tx, reflect.ValueOf(patient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed["email"] == data["email"] {
+		t.Fatal("expected the hash of the changed email to change")
+	}
+}
+
+type blobStoreFunc func(ctx context.Context, ref string, content []byte) error
+
+func (f blobStoreFunc) Put(ctx context.Context, ref string, content []byte) error {
+	return f(ctx, ref, content)
+}
+
+func TestApplyBinaryStrategy(t *testing.T) {
+	defer func(store BlobStorer) { BlobStore = store }(BlobStore)
+	stored := map[string][]byte{}
+	BlobStore = blobStoreFunc(func(ctx context.Context, ref string, content []byte) error {
+		stored[ref] = content
+		return nil
+	})
+	content := []byte("%PDF-1.7")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	for strategy, want := range map[string]interface{}{
+		BinaryChecksum: map[string]interface{}{"size": len(content), "sha256": digest},
+		BinaryRef:      map[string]interface{}{"size": len(content), "ref": "sha256:" + digest},
+		"zip":          "raw",
+	} {
+		data := map[string]interface{}{"file": "raw"}
+		applyBinaryStrategy(context.Background(), strategy, "file", reflect.ValueOf(content), data)
+		if !reflect.DeepEqual(data["file"], want) {
+			t.Errorf("%s: got %v, want %v", strategy, data["file"], want)
+		}
+	}
+	if string(stored["sha256:"+digest]) != string(content) {
+		t.Errorf("content not stored in the blob store, got %v", stored)
+	}
+
+	data := map[string]interface{}{"file": "raw"}
+	applyBinaryStrategy(context.Background(), BinaryOmit, "file", reflect.ValueOf(&content), data)
+	if _, ok := data["file"]; ok {
+		t.Errorf("omitted field kept: %v", data)
+	}
+	// values that aren't binary are left as they are
+	data = map[string]interface{}{"file": nil}
+	applyBinaryStrategy(context.Background(), BinaryOmit, "file", reflect.ValueOf((*[]byte)(nil)), data)
+	if _, ok := data["file"]; !ok {
+		t.Errorf("nil field omitted: %v", data)
+	}
+}
+
+type Attachment struct {
+	Id       string `json:"id"`
+	Content  []byte `json:"content" audited:"binary=omit"`
+	Checksum []byte `json:"checksum" audited:"binary=checksum"`
+}
+
+func TestBinaryStrategyWithFormatter(t *testing.T) {
+	format := func(value interface{}) interface{} { return "formatted" }
+	for _, field := range []string{"Content", "Checksum"} {
+		if err := RegisterFormatter(&Attachment{}, field, format); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attachment := &Attachment{Id: "a1", Content: []byte("secret"), Checksum: []byte("abc")}
+	data, err := snapshot(statementFor(t, attachment), reflect.ValueOf(attachment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := data["content"]; ok {
+		t.Errorf("formatter stored an omitted field: %v", data)
+	}
+	if checksum, ok := data["checksum"].(map[string]interface{}); !ok || checksum["size"] != 3 {
+		t.Errorf("formatter replaced the checksum: %v", data)
+	}
+}
@@ -0,0 +1,154 @@
+package audited
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const defaultFileSinkBytes = 100 << 20
+
+// rotatedLayout is the time suffix of rotated files
+const rotatedLayout = "20060102T150405.000000000"
+
+// FileSink appends entries as newline-delimited JSON, one event per line in
+// PublishFormat, to a file rotated by size, for log collectors such as
+// fluentd or vector to ship. A rotated file is renamed to the path with the
+// time of its rotation appended, e.g. audit.jsonl.20240102T150405.000000000.
+type FileSink struct {
+	// DB is used to read the data before updates for Debezium events, without
+	// it their before is left empty
+	DB   *gorm.DB
+	Path string
+	// MaxBytes is the size a file is rotated at, 100MB by default
+	MaxBytes int64
+	// MaxBackups is the number of rotated files kept, the oldest are removed,
+	// zero keeps them all
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink returns a sink appending to the file at path, created on the
+// first write
+func NewFileSink(path string) *FileSink {
+	return &FileSink{Path: path, MaxBytes: defaultFileSinkBytes}
+}
+
+// Write appends one line per entry, the lines of a batch in a single write so
+// a batch isn't split across files
+func (s *FileSink) Write(ctx context.Context, entries []AuditLog) error {
+	var buf []byte
+	for _, entry := range entries {
+		line, err := MarshalEvent(s.DB, entry)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	maxBytes := s.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultFileSinkBytes
+	}
+	if s.size > 0 && s.size+int64(len(buf)) > maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(buf)
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the file, the next write opens it again, e.g. after an
+// external tool moved it away
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the file and opens a new one in its place
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+	if err := os.Rename(s.Path, s.Path+"."+time.Now().UTC().Format(rotatedLayout)); err != nil {
+		return err
+	}
+	if err := s.prune(); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// prune removes the oldest rotated files over MaxBackups. Only the files
+// named after the path with a rotation time appended are looked at, the other
+// files of the directory, e.g. the position file of a collector, are kept.
+func (s *FileSink) prune() error {
+	if s.MaxBackups <= 0 {
+		return nil
+	}
+	files, err := os.ReadDir(filepath.Dir(s.Path))
+	if err != nil {
+		return err
+	}
+	prefix := filepath.Base(s.Path) + "."
+	var rotated []string
+	for _, file := range files {
+		suffix, ok := strings.CutPrefix(file.Name(), prefix)
+		if !ok || file.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(rotatedLayout, suffix); err == nil {
+			rotated = append(rotated, file.Name())
+		}
+	}
+	// the time suffixes sort in rotation order
+	sort.Strings(rotated)
+	for len(rotated) > s.MaxBackups {
+		if err := os.Remove(filepath.Join(filepath.Dir(s.Path), rotated[0])); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package audited
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	// glob metacharacters in the path are matched literally
+	path := filepath.Join(t.TempDir(), "audit[1]", "audit.jsonl")
+	// files of the collectors next to the rotated ones are kept
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"audit.jsonl.pos", "audit.jsonl.gz"} {
+		if err := os.WriteFile(filepath.Join(filepath.Dir(path), name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink := NewFileSink(path)
+	sink.MaxBytes = 200
+	sink.MaxBackups = 2
+	defer sink.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := sink.Write(context.Background(), []AuditLog{{TableName: "invoices", ObjectId: "1", OperationType: OperationUpdate}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotated []string
+	names := map[string]bool{}
+	for _, file := range files {
+		names[file.Name()] = true
+		if _, err := time.Parse(rotatedLayout, strings.TrimPrefix(file.Name(), "audit.jsonl.")); err == nil {
+			rotated = append(rotated, filepath.Join(filepath.Dir(path), file.Name()))
+		}
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("got rotated files %v, want the 2 newest kept", rotated)
+	}
+	if !names["audit.jsonl.pos"] || !names["audit.jsonl.gz"] {
+		t.Fatalf("pruned files that weren't rotated, left %v", names)
+	}
+
+	for _, name := range append(rotated, path) {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, _ := file.Stat()
+		if info.Size() > sink.MaxBytes {
+			t.Errorf("%s has %d bytes, want at most %d", name, info.Size(), sink.MaxBytes)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var entry AuditLog
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.ObjectId != "1" {
+				t.Errorf("%s: got line %q", name, scanner.Text())
+			}
+		}
+		file.Close()
+	}
+}
@@ -0,0 +1,72 @@
+package audited
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// FieldFormatter returns the value stored in the snapshot for a field, it gets
+// the Go value of the field (nil for nil pointers)
+type FieldFormatter func(value interface{}) interface{}
+
+var formatters = struct {
+	sync.RWMutex
+	m map[reflect.Type]map[string]FieldFormatter
+}{m: map[reflect.Type]map[string]FieldFormatter{}}
+
+// RegisterFormatter sets the formatter used for the named Go field of model
+func RegisterFormatter(model interface{}, field string, formatter FieldFormatter) error {
+	typ := modelType(model)
+	if typ.Kind() != reflect.Struct {
+		return fmt.Errorf("audited: formatter model must be a struct, got %s", typ)
+	}
+	if _, ok := typ.FieldByName(field); !ok {
+		return fmt.Errorf("audited: %s has no field %s", typ, field)
+	}
+
+	formatters.Lock()
+	defer formatters.Unlock()
+	if formatters.m[typ] == nil {
+		formatters.m[typ] = map[string]FieldFormatter{}
+	}
+	formatters.m[typ][field] = formatter
+	return nil
+}
+
+func formatterFor(typ reflect.Type, field string) FieldFormatter {
+	formatters.RLock()
+	defer formatters.RUnlock()
+	return formatters.m[typ][field]
+}
+
+// hasFormatters reports whether a formatter is registered for a field of typ
+func hasFormatters(typ reflect.Type) bool {
+	formatters.RLock()
+	defer formatters.RUnlock()
+	return len(formatters.m[typ]) > 0
+}
+
+// EnumLabels returns a formatter storing integer or string enums with their
+// label, e.g. {"value": 3, "label": "shipped"}. Unknown values get an empty
+// label, values of another type than the keys of labels are left as they are.
+func EnumLabels[K comparable](labels map[K]string) FieldFormatter {
+	return func(value interface{}) interface{} {
+		key, ok := value.(K)
+		if !ok {
+			return value
+		}
+		return map[string]interface{}{
+			"value": value,
+			"label": labels[key],
+		}
+	}
+}
+
+func modelType(model interface{}) reflect.Type {
+	typ := reflect.TypeOf(model)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
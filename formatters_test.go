@@ -0,0 +1,62 @@
+package audited
+
+import (
+	"reflect"
+	"testing"
+)
+
+type OrderStatus int
+
+const (
+	StatusPending OrderStatus = iota + 1
+	StatusPaid
+	StatusShipped
+)
+
+func TestEnumLabels(t *testing.T) {
+	format := EnumLabels(map[OrderStatus]string{
+		StatusPending: "pending",
+		StatusPaid:    "paid",
+		StatusShipped: "shipped",
+	})
+
+	cases := []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{StatusShipped, map[string]interface{}{"value": StatusShipped, "label": "shipped"}},
+		{3, 3},
+		{OrderStatus(9), map[string]interface{}{"value": OrderStatus(9), "label": ""}},
+		{nil, nil},
+		{"paid", "paid"},
+	}
+	for _, c := range cases {
+		if got := format(c.value); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got %v, want %v", c.value, got, c.want)
+		}
+	}
+
+	// an int isn't converted to a string key as a rune
+	byName := EnumLabels(map[string]string{"A": "archived"})
+	if got := byName(65); got != 65 {
+		t.Errorf("got %v for 65, want it unlabeled", got)
+	}
+}
+
+func TestRegisterFormatter(t *testing.T) {
+	type Shipment struct {
+		Status OrderStatus
+	}
+	if err := RegisterFormatter(&Shipment{}, "Missing", nil); err == nil {
+		t.Errorf("registered a formatter for a missing field")
+	}
+	if err := RegisterFormatter(3, "Status", nil); err == nil {
+		t.Errorf("registered a formatter for a non struct")
+	}
+	if err := RegisterFormatter(&Shipment{}, "Status", EnumLabels(map[OrderStatus]string{StatusPaid: "paid"})); err != nil {
+		t.Fatal(err)
+	}
+	if formatterFor(reflect.TypeOf(Shipment{}), "Status") == nil {
+		t.Errorf("formatter not found")
+	}
+}
@@ -0,0 +1,56 @@
+package audited
+
+import (
+	"context"
+	"net"
+)
+
+var contextKeyClientIP = ContextKey("audited_client_ip")
+
+// Location is where a client IP is located
+type Location struct {
+	Country string
+	City    string
+}
+
+// GeoResolver looks up the location of an IP address
+type GeoResolver interface {
+	Resolve(ctx context.Context, ip net.IP) (Location, error)
+}
+
+// WithClientIP returns a copy of ctx carrying the IP address of the client
+// making the change. Middleware sets it from the request.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, contextKeyClientIP, ip)
+}
+
+// ClientIP returns the client IP address of ctx, or "" if it has none
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(contextKeyClientIP).(string)
+	return ip
+}
+
+// GeoEnricher returns an Enricher storing the client IP of the change with its
+// country and city, as resolved by resolver:
+//
+//	audited.RegisterEnricher(audited.GeoEnricher(resolver))
+func GeoEnricher(resolver GeoResolver) Enricher {
+	return func(ctx context.Context, entry *AuditLog) error {
+		ip := net.ParseIP(ClientIP(ctx))
+		if ip == nil {
+			return nil
+		}
+		entry.SetMetadata("client_ip", ip.String())
+		loc, err := resolver.Resolve(ctx, ip)
+		if err != nil {
+			return err
+		}
+		if loc.Country != "" {
+			entry.SetMetadata("country", loc.Country)
+		}
+		if loc.City != "" {
+			entry.SetMetadata("city", loc.City)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,57 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+type geoResolverFunc func(ctx context.Context, ip net.IP) (Location, error)
+
+func (f geoResolverFunc) Resolve(ctx context.Context, ip net.IP) (Location, error) {
+	return f(ctx, ip)
+}
+
+func TestGeoEnricher(t *testing.T) {
+	enrich := GeoEnricher(geoResolverFunc(func(ctx context.Context, ip net.IP) (Location, error) {
+		switch ip.String() {
+		case "203.0.113.7":
+			return Location{Country: "DE", City: "Berlin"}, nil
+		case "2001:db8::1":
+			return Location{Country: "NL"}, nil
+		}
+		return Location{}, errors.New("address not found")
+	}))
+
+	for _, c := range []struct {
+		ip      string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"not an ip", nil, false},
+		{"203.0.113.7", map[string]interface{}{"client_ip": "203.0.113.7", "country": "DE", "city": "Berlin"}, false},
+		{"2001:0db8:0000::1", map[string]interface{}{"client_ip": "2001:db8::1", "country": "NL"}, false},
+		// the address is kept when it can't be located
+		{"198.51.100.1", map[string]interface{}{"client_ip": "198.51.100.1"}, true},
+	} {
+		entry := &AuditLog{}
+		err := enrich(WithClientIP(context.Background(), c.ip), entry)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%q: got error %v", c.ip, err)
+		}
+		if len(entry.Metadata) != len(c.want) {
+			t.Errorf("%q: got metadata %v, want %v", c.ip, entry.Metadata, c.want)
+		}
+		for key, value := range c.want {
+			if entry.Metadata[key] != value {
+				t.Errorf("%q: got %s %v, want %v", c.ip, key, entry.Metadata[key], value)
+			}
+		}
+	}
+
+	if ip := ClientIP(context.Background()); ip != "" {
+		t.Errorf("got client ip %q without one", ip)
+	}
+}
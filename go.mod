@@ -4,6 +4,9 @@ go 1.21.0
 
 require (
 	github.com/google/uuid v1.3.1
+	github.com/oschwald/geoip2-golang v1.9.0
+	golang.org/x/tools v0.7.0
+	google.golang.org/protobuf v1.33.0
 	gorm.io/datatypes v1.2.0
 	gorm.io/driver/postgres v1.5.2
 	gorm.io/gorm v1.25.4
@@ -16,7 +19,10 @@ require (
 	github.com/jackc/pgx/v5 v5.3.1 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/oschwald/maxminddb-golang v1.12.0 // indirect
 	golang.org/x/crypto v0.8.0 // indirect
+	golang.org/x/mod v0.10.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	gorm.io/driver/mysql v1.4.7 // indirect
 )
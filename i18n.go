@@ -0,0 +1,118 @@
+package audited
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Translator provides display labels for operation types and field names, it
+// is meant for screens and handlers presenting the audit trail
+type Translator interface {
+	// Operation returns the label of an operation type, e.g. "UPDATE"
+	Operation(lang, operation string) string
+	// Field returns the label of a snapshot field of table
+	Field(lang, table, field string) string
+}
+
+// Catalog is a Translator backed by static maps. Lookups fall back to the base
+// language ("pt" for "pt-BR") and then to the untranslated identifier.
+type Catalog struct {
+	// Operations maps lang -> operation type -> label
+	Operations map[string]map[string]string
+	// Fields maps lang -> table -> field -> label, use "*" as table for labels shared by all tables
+	Fields map[string]map[string]map[string]string
+}
+
+// DefaultTranslator is used by Localize when no translator is given
+var DefaultTranslator Translator = Catalog{}
+
+// Operation implements Translator
+func (c Catalog) Operation(lang, operation string) string {
+	for _, l := range langFallbacks(lang) {
+		if label, ok := c.Operations[l][operation]; ok {
+			return label
+		}
+	}
+	return operation
+}
+
+// Field implements Translator
+func (c Catalog) Field(lang, table, field string) string {
+	for _, l := range langFallbacks(lang) {
+		if label, ok := c.Fields[l][table][field]; ok {
+			return label
+		}
+		if label, ok := c.Fields[l]["*"][field]; ok {
+			return label
+		}
+	}
+	return field
+}
+
+// LocalizedLog is an audit log together with its display labels
+type LocalizedLog struct {
+	AuditLog
+	Operation string            `json:"operation"`
+	Fields    map[string]string `json:"fields"`
+}
+
+// Localize returns entry with its operation type and snapshot field names
+// translated to lang. A nil translator uses DefaultTranslator.
+func Localize(t Translator, lang string, entry AuditLog) LocalizedLog {
+	if t == nil {
+		t = DefaultTranslator
+	}
+	localized := LocalizedLog{
+		AuditLog:  entry,
+		Operation: t.Operation(lang, entry.OperationType),
+		Fields:    map[string]string{},
+	}
+	data, err := EntryData(entry)
+	if err != nil {
+		return localized
+	}
+	for field := range data {
+		localized.Fields[field] = t.Field(lang, entry.TableName, field)
+	}
+	return localized
+}
+
+// RequestLanguage returns the preferred language of an http request from its
+// Accept-Language header, or fallback when there is none
+func RequestLanguage(r *http.Request, fallback string) string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		// q=0 marks a language as not acceptable
+		if q > 0 {
+			langs = append(langs, weighted{lang: lang, q: q})
+		}
+	}
+	if len(langs) == 0 {
+		return fallback
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	return langs[0].lang
+}
+
+func langFallbacks(lang string) []string {
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		return []string{lang, base}
+	}
+	return []string{lang}
+}
@@ -0,0 +1,97 @@
+package audited
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+var testCatalog = Catalog{
+	Operations: map[string]map[string]string{
+		"pt":    {OperationUpdate: "Alteração"},
+		"pt-BR": {OperationCreate: "Criação"},
+	},
+	Fields: map[string]map[string]map[string]string{
+		"pt": {
+			"orders": {"total": "Valor total"},
+			"*":      {"total": "Total", "status": "Situação"},
+		},
+	},
+}
+
+func TestCatalog(t *testing.T) {
+	for _, tc := range []struct {
+		lang, operation, want string
+	}{
+		{"pt-BR", OperationCreate, "Criação"},
+		{"pt-BR", OperationUpdate, "Alteração"},
+		{"pt", OperationCreate, OperationCreate},
+		{"de", OperationUpdate, OperationUpdate},
+	} {
+		if got := testCatalog.Operation(tc.lang, tc.operation); got != tc.want {
+			t.Errorf("%s %s: got %q, want %q", tc.lang, tc.operation, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		lang, table, field, want string
+	}{
+		{"pt-BR", "orders", "total", "Valor total"},
+		{"pt", "invoices", "total", "Total"},
+		{"pt", "orders", "status", "Situação"},
+		{"pt", "orders", "note", "note"},
+		{"en", "orders", "total", "total"},
+	} {
+		if got := testCatalog.Field(tc.lang, tc.table, tc.field); got != tc.want {
+			t.Errorf("%s %s.%s: got %q, want %q", tc.lang, tc.table, tc.field, got, tc.want)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	entry := AuditLog{TableName: "orders", OperationType: OperationUpdate,
+		Data: datatypes.JSON(`{"id":"7","total":10,"status":"paid"}`)}
+	localized := Localize(testCatalog, "pt-BR", entry)
+	if localized.Operation != "Alteração" || localized.OperationType != OperationUpdate {
+		t.Errorf("got operation %q of %q", localized.Operation, localized.OperationType)
+	}
+	want := map[string]string{"id": "id", "total": "Valor total", "status": "Situação"}
+	if len(localized.Fields) != len(want) {
+		t.Errorf("got fields %v, want %v", localized.Fields, want)
+	}
+	for field, label := range want {
+		if localized.Fields[field] != label {
+			t.Errorf("%s: got %q, want %q", field, localized.Fields[field], label)
+		}
+	}
+
+	// without a translator the identifiers are kept, an entry without data
+	// has no fields
+	localized = Localize(nil, "pt", AuditLog{OperationType: OperationCreate, Data: datatypes.JSON(`not json`)})
+	if localized.Operation != OperationCreate || len(localized.Fields) != 0 {
+		t.Errorf("got %+v", localized)
+	}
+}
+
+func TestRequestLanguage(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                                "en",
+		"pt-BR":                           "pt-BR",
+		"fr;q=0.5, de;q=0.8, *":           "de",
+		"de;q=0.8, pt-BR, pt;q=0.9":       "pt-BR",
+		"es, fr":                          "es",
+		"*;q=0.5":                         "en",
+		"fr;q=0":                          "en",
+		" nl ; q=0.3 ,it;q=bad":           "it",
+		"da, en-GB;q=0.8, en;q=0.7":       "da",
+		"en-US;q=0.1, zh-Hant;q=0.2, ja;": "ja",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			r.Header.Set("Accept-Language", header)
+		}
+		if got := RequestLanguage(r, "en"); got != want {
+			t.Errorf("%q: got %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var contextKeyIdempotency = ContextKey("audited_idempotency_key")
+
+// idempotencyScope is one execution of a logical operation under a key. It
+// counts the operations done per table and object, so that repeating an
+// operation within the execution isn't mistaken for a replay.
+type idempotencyScope struct {
+	key     string
+	mu      sync.Mutex
+	seen    map[string]int
+	written map[string]int
+}
+
+// WithIdempotencyKey returns a copy of ctx carrying the idempotency key of the
+// logical operation being performed. When the operation is retried with the
+// same key, entries already written by an earlier execution for the same
+// table, object and operation are not written again.
+//
+// Executions are matched by the order of their operations: the second update
+// of an object under a key is a replay only if an earlier execution wrote two
+// updates of it. A retry doing more than the original records the extra
+// operations.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, contextKeyIdempotency, &idempotencyScope{
+		key:     key,
+		seen:    map[string]int{},
+		written: map[string]int{},
+	})
+}
+
+func getIdempotencyKey(ctx context.Context) string {
+	if scope := idempotencyScopeOf(ctx); scope != nil {
+		return scope.key
+	}
+	return ""
+}
+
+func idempotencyScopeOf(ctx context.Context) *idempotencyScope {
+	scope, _ := ctx.Value(contextKeyIdempotency).(*idempotencyScope)
+	return scope
+}
+
+// isReplay reports whether an earlier execution under the idempotency key of
+// entry already wrote it: the n-th operation of this execution on a table and
+// object is a replay when more than the n-1 entries of this execution are
+// stored for them
+func isReplay(db *gorm.DB, entry *AuditLog) bool {
+	scope := idempotencyScopeOf(db.Statement.Context)
+	if scope == nil || entry.IdempotencyKey == "" {
+		return false
+	}
+	stored, err := countOperation(db, entry)
+	if err != nil {
+		log.Println(fmt.Errorf("error checking audit log idempotency: %s", err.Error()))
+		return false
+	}
+
+	op := entry.TableName + "\x00" + entry.ObjectId + "\x00" + entry.OperationType
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	scope.seen[op]++
+	if stored-scope.written[op] >= scope.seen[op] {
+		return true
+	}
+	scope.written[op]++
+	return false
+}
+
+// countOperation returns the number of entries stored or held back with the
+// idempotency key, table, object and operation of entry
+func countOperation(db *gorm.DB, entry *AuditLog) (int, error) {
+	count := 0
+	if recorder := FromContext(db.Statement.Context); recorder.deferred() {
+		for _, pending := range recorder.Pending() {
+			if sameOperation(pending, *entry) {
+				count++
+			}
+		}
+	}
+
+	if store := memoryStoreOf(db); store != nil {
+		return count + store.countOperation(*entry), nil
+	}
+
+	var stored int64
+	if err := QueryOperations(db.Session(&gorm.Session{SkipHooks: true})).
+		Where("idempotency_key = ? AND table_name = ? AND object_id = ? AND operation_type = ?",
+			entry.IdempotencyKey, entry.TableName, entry.ObjectId, entry.OperationType).
+		Count(&stored).
+		Error; err != nil {
+		return 0, err
+	}
+	return count + int(stored), nil
+}
+
+func sameOperation(a, b AuditLog) bool {
+	return a.IdempotencyKey == b.IdempotencyKey &&
+		a.TableName == b.TableName &&
+		a.ObjectId == b.ObjectId &&
+		a.OperationType == b.OperationType
+}
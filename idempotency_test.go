@@ -0,0 +1,65 @@
+package audited
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestIsReplay(t *testing.T) {
+	store := NewMemoryStore()
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterCallbacks(db, WithMemoryStore(store)); err != nil {
+		t.Fatal(err)
+	}
+
+	// execute runs the updates of one execution under key and returns which
+	// of them were replays
+	execute := func(key string, updates int) []bool {
+		tx := db.WithContext(WithIdempotencyKey(context.Background(), key))
+		var replays []bool
+		for i := 0; i < updates; i++ {
+			entry := AuditLog{
+				TableName:      "widgets",
+				ObjectId:       "w-1",
+				OperationType:  OperationUpdate,
+				IdempotencyKey: key,
+			}
+			replay := isReplay(tx, &entry)
+			if !replay {
+				store.add(entry)
+			}
+			replays = append(replays, replay)
+		}
+		return replays
+	}
+
+	cases := []struct {
+		name    string
+		key     string
+		updates int
+		want    []bool
+	}{
+		{"first execution records repeated updates", "k1", 2, []bool{false, false}},
+		{"retry records nothing", "k1", 2, []bool{true, true}},
+		{"retry doing more records the extra update", "k1", 3, []bool{true, true, false}},
+		{"other key", "k2", 1, []bool{false}},
+	}
+	for _, c := range cases {
+		got := execute(c.key, c.updates)
+		for i := range c.want {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+	if n := len(store.Entries()); n != 4 {
+		t.Errorf("stored %d entries, want 4", n)
+	}
+}
@@ -0,0 +1,127 @@
+package audited
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ID is the ID of an audit log: the text form of a UUID, or of an integer when
+// the id column is a bigserial
+type ID string
+
+// Value stores an empty ID as NULL, leaving it to the column default
+func (id ID) Value() (driver.Value, error) {
+	if id == "" {
+		return nil, nil
+	}
+	return string(id), nil
+}
+
+// Scan reads UUID and integer ids
+func (id *ID) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*id = ""
+	case int64:
+		*id = ID(strconv.FormatInt(v, 10))
+	case string:
+		*id = ID(v)
+	case []byte:
+		if len(v) == 16 {
+			*id = ID(uuid.UUID(v).String())
+		} else {
+			*id = ID(v)
+		}
+	default:
+		return fmt.Errorf("audited: cannot scan %T into an ID", src)
+	}
+	return nil
+}
+
+// UUID parses the ID as a UUID
+func (id ID) UUID() (uuid.UUID, error) {
+	return uuid.Parse(string(id))
+}
+
+// IDGenerator returns the ID of a new audit log
+type IDGenerator func() uuid.UUID
+
+// NewID generates the IDs of audit logs. It defaults to time ordered UUIDv7s,
+// which keep inserts into the primary key index local instead of spreading them
+// over the whole B-tree like random UUIDv4s. Set it to nil to leave the ID to
+// the column default of the audit table, e.g. for a bigserial id column.
+var NewID IDGenerator = NewUUIDv7
+
+// NewUUIDv4 returns a random UUID
+func NewUUIDv4() uuid.UUID {
+	return uuid.New()
+}
+
+// NewUUIDv7 returns a UUID starting with the current unix time in milliseconds
+// as described in RFC 9562
+func NewUUIDv7() uuid.UUID {
+	id := timeOrderedID()
+	id[6] = (id[6] & 0x0f) | 0x70 // version 7
+	id[8] = (id[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return id
+}
+
+// NewULID returns a ULID in its 128 bit binary form, so it can be stored in the
+// uuid column. Use ULIDString to get its canonical text form.
+func NewULID() uuid.UUID {
+	return timeOrderedID()
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDString returns the 26 character Crockford base32 form of a ULID
+func ULIDString(id uuid.UUID) string {
+	var b strings.Builder
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	// 130 bits of output for 128 bits of input, the first character holds 3 bits
+	for i := 25; i >= 0; i-- {
+		shift := uint(i * 5)
+		var v uint64
+		switch {
+		case shift >= 64:
+			v = hi >> (shift - 64)
+		case shift > 59:
+			v = lo>>shift | hi<<(64-shift)
+		default:
+			v = lo >> shift
+		}
+		b.WriteByte(crockford[v&0x1f])
+	}
+	return b.String()
+}
+
+// timeOrderedID returns 48 bits of unix milliseconds followed by 80 random bits
+func timeOrderedID() uuid.UUID {
+	var id uuid.UUID
+	if _, err := rand.Read(id[6:]); err != nil {
+		return uuid.New()
+	}
+	ms := uint64(time.Now().UnixMilli())
+	id[0] = byte(ms >> 40)
+	id[1] = byte(ms >> 32)
+	id[2] = byte(ms >> 24)
+	id[3] = byte(ms >> 16)
+	id[4] = byte(ms >> 8)
+	id[5] = byte(ms)
+	return id
+}
+
+func newID() ID {
+	if NewID == nil {
+		return ""
+	}
+	return ID(NewID().String())
+}
@@ -0,0 +1,74 @@
+package audited
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestULIDString(t *testing.T) {
+	cases := map[string]uuid.UUID{
+		"00000000000000000000000000": {},
+		"7ZZZZZZZZZZZZZZZZZZZZZZZZZ": {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"0000000000000000000000000Z": {15: 0x1f},
+		"00000000000000000000000010": {15: 0x20},
+	}
+	for want, id := range cases {
+		if got := ULIDString(id); got != want {
+			t.Errorf("%x: got %s, want %s", id[:], got, want)
+		}
+	}
+
+	// the timestamp of the example ULID of the spec, 01ARYZ6S41TSV4RRFFQ69G5FAV
+	var id uuid.UUID
+	ms := uint64(1469918176385)
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	if got := ULIDString(id)[:10]; got != "01ARYZ6S41" {
+		t.Errorf("got timestamp %s, want 01ARYZ6S41", got)
+	}
+}
+
+func TestNewUUIDv7(t *testing.T) {
+	before := time.Now().UnixMilli()
+	first := NewUUIDv7()
+	time.Sleep(2 * time.Millisecond)
+	second := NewUUIDv7()
+
+	if first.Version() != 7 || first.Variant() != uuid.RFC4122 {
+		t.Errorf("got version %d variant %s", first.Version(), first.Variant())
+	}
+	ms := int64(first[0])<<40 | int64(first[1])<<32 | int64(first[2])<<24 | int64(first[3])<<16 | int64(first[4])<<8 | int64(first[5])
+	if ms < before || ms > time.Now().UnixMilli() {
+		t.Errorf("got timestamp %d, want about %d", ms, before)
+	}
+	if bytes.Compare(first[:], second[:]) >= 0 {
+		t.Errorf("ids are not time ordered: %s, %s", first, second)
+	}
+}
+
+func TestIDScan(t *testing.T) {
+	u := uuid.MustParse("0190163d-8694-739b-aea5-966c26f8ad91")
+	cases := []struct {
+		src  interface{}
+		want ID
+	}{
+		{int64(42), "42"},
+		{u.String(), ID(u.String())},
+		{u[:], ID(u.String())},
+		{[]byte("0190163d-8694-739b-aea5-966c26f8ad91"), ID(u.String())},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		var id ID
+		if err := id.Scan(c.src); err != nil || id != c.want {
+			t.Errorf("%v: got %q, %v, want %q", c.src, id, err, c.want)
+		}
+	}
+	if value, err := ID("").Value(); value != nil || err != nil {
+		t.Errorf("empty id: got %v, %v, want NULL", value, err)
+	}
+}
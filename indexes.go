@@ -0,0 +1,144 @@
+package audited
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// IndexOptions selects the indexes created by CreateIndexes
+type IndexOptions struct {
+	// CreatedAtBRIN indexes created_at with a BRIN index on postgres, which
+	// stays tiny on an append only table; other dialects get a B-tree index
+	CreatedAtBRIN bool
+	// HotTables get their own partial index on (object_id, created_at), on
+	// dialects without partial indexes the trail index already covers them
+	HotTables []string
+	// Owners indexes (owner_id, created_at) for OwnedTrail
+	Owners bool
+	// Roots indexes (root_table, root_object_id, created_at) for TreeTrail
+	Roots bool
+}
+
+var indexNameReplacer = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// CreateIndexes creates the indexes used by trail lookups on the audit table,
+// plus the optional ones in opts. Existing indexes are left alone.
+func CreateIndexes(db *gorm.DB, opts IndexOptions) error {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true})
+	dialect := db.Dialector.Name()
+	quote := db.Statement.Quote
+	table := operationsTable(db)
+
+	indexes := map[string]string{
+		table + "_trail_idx": fmt.Sprintf("CREATE INDEX %s ON %s (table_name, object_id, created_at)",
+			quote(table+"_trail_idx"), quote(table)),
+	}
+	if opts.CreatedAtBRIN {
+		name := table + "_created_at_idx"
+		using := ""
+		if dialect == "postgres" {
+			name, using = table+"_created_at_brin", " USING brin"
+		}
+		indexes[name] = fmt.Sprintf("CREATE INDEX %s ON %s%s (created_at)", quote(name), quote(table), using)
+	}
+	if opts.Owners {
+		indexes[table+"_owner_idx"] = fmt.Sprintf("CREATE INDEX %s ON %s (owner_id, created_at)",
+			quote(table+"_owner_idx"), quote(table))
+	}
+	if opts.Roots {
+		indexes[table+"_root_idx"] = fmt.Sprintf("CREATE INDEX %s ON %s (root_table, root_object_id, created_at)",
+			quote(table+"_root_idx"), quote(table))
+	}
+	if dialect == "postgres" || dialect == "sqlite" {
+		for _, hot := range opts.HotTables {
+			name := fmt.Sprintf("%s_%s_trail_idx", table, strings.ToLower(indexNameReplacer.ReplaceAllString(hot, "_")))
+			indexes[name] = fmt.Sprintf("CREATE INDEX %s ON %s (object_id, created_at) WHERE table_name = %s",
+				quote(name), quote(table), quoteLiteral(hot))
+		}
+	}
+
+	for name, ddl := range indexes {
+		if db.Migrator().HasIndex(table, name) {
+			continue
+		}
+		if err := db.Exec(ddl).Error; err != nil {
+			return fmt.Errorf("error creating index %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// TrailFor returns the audit entries of an object, oldest first, read through
+// TrailCacheStore when it is set. Trails of a region (see WithResidency) are
+// never cached.
+func TrailFor(db *gorm.DB, table, objectId string) ([]AuditLog, error) {
+	cache, ctx, key := TrailCacheStore, db.Statement.Context, TrailCacheKey(db, table, objectId)
+	if residencyOf(ctx) != "" {
+		cache = nil
+	}
+	if cache != nil {
+		if entries, ok := cache.Get(ctx, key); ok {
+			return entries, nil
+		}
+	}
+	var entries []AuditLog
+	if err := trailQuery(db, table, objectId).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.Set(ctx, key, entries)
+	}
+	return entries, nil
+}
+
+func trailQuery(db *gorm.DB, table, objectId string) *gorm.DB {
+	return Query(db).
+		Where("table_name = ? AND object_id = ?", table, objectId).
+		Order("created_at")
+}
+
+// ExplainTrail returns the query plan the database picks for TrailFor, to
+// check the trail lookups are served by an index
+func ExplainTrail(db *gorm.DB, table, objectId string) (string, error) {
+	query := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return trailQuery(tx, table, objectId).Find(&[]AuditLog{})
+	})
+
+	explain := "EXPLAIN "
+	if db.Dialector.Name() == "sqlite" {
+		explain = "EXPLAIN QUERY PLAN "
+	}
+	rows, err := db.Session(&gorm.Session{NewDB: true}).Raw(explain + query).Rows()
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	var plan []string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		line := make([]string, 0, len(values))
+		for _, v := range values {
+			if v.Valid {
+				line = append(line, v.String)
+			}
+		}
+		plan = append(plan, strings.Join(line, " "))
+	}
+	return strings.Join(plan, "\n"), rows.Err()
+}
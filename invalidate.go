@@ -0,0 +1,70 @@
+package audited
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// CacheKeyFunc returns the cache keys made stale by an entry
+type CacheKeyFunc func(entry AuditLog) []string
+
+// CacheInvalidator deletes cache keys, e.g. with DEL in Redis or Delete in
+// Memcached
+type CacheInvalidator interface {
+	Invalidate(ctx context.Context, keys []string) error
+}
+
+var cacheKeys = struct {
+	sync.RWMutex
+	m map[string][]CacheKeyFunc
+}{m: map[string][]CacheKeyFunc{}}
+
+// RegisterCacheKeys adds a function mapping the entries of table to the cache
+// keys they make stale, for CacheInvalidation
+func RegisterCacheKeys(table string, keys CacheKeyFunc) {
+	cacheKeys.Lock()
+	defer cacheKeys.Unlock()
+	cacheKeys.m[table] = append(cacheKeys.m[table], keys)
+}
+
+// CacheInvalidation invalidates the cache keys of entries through Invalidator,
+// once per batch. Hand Handle to Consume so caches are invalidated after the
+// change commits, invalidating earlier lets a concurrent read cache the data
+// from before it.
+type CacheInvalidation struct {
+	Invalidator CacheInvalidator
+}
+
+// Handle is a ConsumerHandler invalidating the keys of entries
+func (c *CacheInvalidation) Handle(ctx context.Context, tx *gorm.DB, entries []AuditLog) error {
+	return c.Write(ctx, entries)
+}
+
+// Write invalidates the keys of entries, so it can also be registered as a sink
+func (c *CacheInvalidation) Write(ctx context.Context, entries []AuditLog) error {
+	if keys := staleKeys(entries); len(keys) > 0 {
+		return c.Invalidator.Invalidate(ctx, keys)
+	}
+	return nil
+}
+
+// staleKeys returns the distinct cache keys of entries, in order
+func staleKeys(entries []AuditLog) []string {
+	cacheKeys.RLock()
+	defer cacheKeys.RUnlock()
+	var keys []string
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		for _, fn := range cacheKeys.m[entry.TableName] {
+			for _, key := range fn(entry) {
+				if !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+	return keys
+}
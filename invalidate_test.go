@@ -0,0 +1,46 @@
+package audited
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type invalidatorFunc func(ctx context.Context, keys []string) error
+
+func (f invalidatorFunc) Invalidate(ctx context.Context, keys []string) error {
+	return f(ctx, keys)
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	defer func() {
+		cacheKeys.Lock()
+		cacheKeys.m = map[string][]CacheKeyFunc{}
+		cacheKeys.Unlock()
+	}()
+	RegisterCacheKeys("orders", func(entry AuditLog) []string {
+		return []string{"order:" + entry.ObjectId, "orders:list"}
+	})
+
+	var invalidated [][]string
+	c := &CacheInvalidation{Invalidator: invalidatorFunc(func(ctx context.Context, keys []string) error {
+		invalidated = append(invalidated, keys)
+		return nil
+	})}
+	entries := []AuditLog{
+		{TableName: "orders", ObjectId: "1"},
+		{TableName: "orders", ObjectId: "2"},
+		{TableName: "orders", ObjectId: "1"},
+		{TableName: "users", ObjectId: "1"},
+	}
+	if err := c.Handle(context.Background(), nil, entries); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"order:1", "orders:list", "order:2"}}
+	if !reflect.DeepEqual(invalidated, want) {
+		t.Fatalf("got invalidations %v, want %v", invalidated, want)
+	}
+	if err := c.Write(context.Background(), entries[3:]); err != nil || len(invalidated) != 1 {
+		t.Fatalf("entries without keys invalidated %v, %v", invalidated, err)
+	}
+}
@@ -0,0 +1,156 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Job is scheduled work on the audit trail, such as retention or rollups. Jobs
+// are run by RunJobs, or by an external scheduler such as cron, river or
+// temporal calling Run on each tick.
+type Job interface {
+	// Name identifies the job in logs and schedulers
+	Name() string
+	// Run does one pass of the work
+	Run(ctx context.Context, db *gorm.DB) error
+}
+
+type jobFunc struct {
+	name string
+	run  func(ctx context.Context, db *gorm.DB) error
+}
+
+func (j jobFunc) Name() string { return j.name }
+
+func (j jobFunc) Run(ctx context.Context, db *gorm.DB) error { return j.run(ctx, db) }
+
+// NewJob returns a Job named name running run, for work of the app to run
+// with the jobs of this package
+func NewJob(name string, run func(ctx context.Context, db *gorm.DB) error) Job {
+	return jobFunc{name: name, run: run}
+}
+
+// RetentionJob applies policy, see ApplyRetention
+func RetentionJob(policy RetentionPolicy) Job {
+	return NewJob("retention", func(ctx context.Context, db *gorm.DB) error {
+		_, err := ApplyRetention(ctx, db, policy)
+		return err
+	})
+}
+
+// RollupJob rolls up the hours ended since the last run, see Rollup
+func RollupJob() Job {
+	return NewJob("rollup", func(ctx context.Context, db *gorm.DB) error {
+		_, err := Rollup(ctx, db)
+		return err
+	})
+}
+
+// MaintenanceJob runs a maintenance pass, see RunMaintenance
+func MaintenanceJob(opts MaintenanceOptions) Job {
+	return NewJob("maintenance", func(ctx context.Context, db *gorm.DB) error {
+		return RunMaintenance(ctx, db, opts)
+	})
+}
+
+// PurgeJob carries out the pending purges, see PurgeHistory
+func PurgeJob() Job {
+	return NewJob("purge", func(ctx context.Context, db *gorm.DB) error {
+		_, err := PurgeHistory(ctx, db)
+		return err
+	})
+}
+
+// ColdTierJob moves old entries to the cold table, see MoveToColdTier
+func ColdTierJob() Job {
+	return NewJob("cold_tier", func(ctx context.Context, db *gorm.DB) error {
+		_, err := MoveToColdTier(ctx, db)
+		return err
+	})
+}
+
+// AnchorJob anchors the chain of entries with notary, see AnchorChain
+func AnchorJob(notary Notary) Job {
+	return NewJob("anchor", func(ctx context.Context, db *gorm.DB) error {
+		_, err := AnchorChain(ctx, db, notary)
+		return err
+	})
+}
+
+// QuotaJob applies the overflow of the tenants over their quota, see
+// EnforceQuotas
+func QuotaJob(opts QuotaOptions) Job {
+	return NewJob("quota", func(ctx context.Context, db *gorm.DB) error {
+		_, err := EnforceQuotas(ctx, db, opts)
+		return err
+	})
+}
+
+// ReplicateJob ships the entries committed since the last run, see Replicate
+func ReplicateJob(opts ReplicationOptions) Job {
+	return NewJob("replicate", func(ctx context.Context, db *gorm.DB) error {
+		handler, err := replicationHandler(ctx, opts)
+		if err != nil {
+			return err
+		}
+		_, err = ConsumePending(ctx, db, opts.ConsumerOptions, handler)
+		return err
+	})
+}
+
+// ScheduledJob is a Job run by RunJobs every Interval
+type ScheduledJob struct {
+	Job      Job
+	Interval time.Duration
+}
+
+// RunJobs runs each of jobs every its interval, the first time an interval
+// after it is called, until ctx is done. Jobs run one at a time in a single
+// goroutine, those due together in the order given; errors are logged. Jobs
+// without a positive interval are left out.
+func RunJobs(ctx context.Context, db *gorm.DB, jobs ...ScheduledJob) {
+	scheduled := make([]ScheduledJob, 0, len(jobs))
+	for _, job := range jobs {
+		if job.Interval > 0 {
+			scheduled = append(scheduled, job)
+		}
+	}
+	jobs = scheduled
+	next := make([]time.Time, len(jobs))
+	now := time.Now()
+	for i, job := range jobs {
+		next[i] = now.Add(job.Interval)
+	}
+	for len(jobs) > 0 {
+		timer := time.NewTimer(time.Until(earliest(next)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		for i, job := range jobs {
+			if time.Now().Before(next[i]) {
+				continue
+			}
+			if err := job.Job.Run(ctx, db); err != nil {
+				log.Println(fmt.Errorf("error running audit job %s: %s", job.Job.Name(), err.Error()))
+			}
+			next[i] = time.Now().Add(job.Interval)
+		}
+	}
+}
+
+func earliest(times []time.Time) time.Time {
+	first := times[0]
+	for _, t := range times[1:] {
+		if t.Before(first) {
+			first = t
+		}
+	}
+	return first
+}
@@ -0,0 +1,70 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestRunJobs(t *testing.T) {
+	var mu sync.Mutex
+	runs := map[string]int{}
+	job := func(name string, err error) Job {
+		return NewJob(name, func(ctx context.Context, db *gorm.DB) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[name]++
+			return err
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		RunJobs(ctx, nil,
+			ScheduledJob{Job: job("fast", errors.New("failing")), Interval: 10 * time.Millisecond},
+			ScheduledJob{Job: job("slow", nil), Interval: 40 * time.Millisecond},
+			ScheduledJob{Job: job("never", nil)},
+		)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunJobs didn't return when its context was done")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if runs["fast"] < 4 || runs["slow"] < 1 || runs["slow"] > 2 || runs["never"] != 0 {
+		t.Fatalf("got runs %v", runs)
+	}
+	if runs["fast"] <= runs["slow"] {
+		t.Fatalf("expected the fast job to run more often, got runs %v", runs)
+	}
+}
+
+func TestJobNames(t *testing.T) {
+	for _, tt := range []struct {
+		job  Job
+		name string
+	}{
+		{RetentionJob(RetentionPolicy{}), "retention"},
+		{RollupJob(), "rollup"},
+		{MaintenanceJob(MaintenanceOptions{}), "maintenance"},
+		{PurgeJob(), "purge"},
+		{ColdTierJob(), "cold_tier"},
+		{AnchorJob(nil), "anchor"},
+		{QuotaJob(QuotaOptions{}), "quota"},
+		{ReplicateJob(ReplicationOptions{}), "replicate"},
+	} {
+		if got := tt.job.Name(); got != tt.name {
+			t.Errorf("got name %q, want %q", got, tt.name)
+		}
+	}
+}
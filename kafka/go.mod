@@ -0,0 +1,22 @@
+module github.com/mleonidas/audited/kafka
+
+go 1.21.0
+
+require (
+	github.com/mleonidas/audited v0.0.0
+	github.com/segmentio/kafka-go v0.4.47
+	gorm.io/datatypes v1.2.0
+	gorm.io/gorm v1.25.4
+)
+
+require (
+	github.com/go-sql-driver/mysql v1.7.0 // indirect
+	github.com/google/uuid v1.3.1 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	gorm.io/driver/mysql v1.4.7 // indirect
+)
+
+replace github.com/mleonidas/audited => ../
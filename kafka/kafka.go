@@ -0,0 +1,110 @@
+// Package kafka publishes audit entries to a Kafka topic, so downstream
+// consumers can build materialized views of the audit trail
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/mleonidas/audited"
+	"github.com/segmentio/kafka-go"
+	"gorm.io/gorm"
+)
+
+// Config locates the topic entries are published to
+type Config struct {
+	// Brokers are the addresses of the brokers to bootstrap from, e.g.
+	// kafka-1:9092
+	Brokers []string
+	// Topic entries are published to, it must exist unless the brokers
+	// create topics automatically
+	Topic string
+	// Transport connects to the brokers, e.g. with TLS or SASL, the default
+	// transport of kafka-go when nil
+	Transport kafka.RoundTripper
+	// BatchTimeout is how long messages are held to fill a batch, 10ms by
+	// default
+	BatchTimeout time.Duration
+}
+
+// Sink is an audited.AuditSink publishing a message per entry, in
+// audited.PublishFormat. Messages are keyed by the table and object id of
+// their entry, so the changes of an object land in one partition in order.
+// Hand Handle to audited.Consume so only committed entries are published:
+// registered with audited.RegisterSink it publishes the entries of changes
+// that roll back, while their transaction waits for the brokers.
+type Sink struct {
+	// DB is used to read the data before updates for Debezium events, without
+	// it their before is left empty
+	DB *gorm.DB
+
+	writer *kafka.Writer
+}
+
+var _ audited.AuditSink = (*Sink)(nil)
+
+// New returns a sink publishing to the topic of cfg
+func New(cfg Config) *Sink {
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = 10 * time.Millisecond
+	}
+	return NewWithWriter(&kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Transport:    cfg.Transport,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: cfg.BatchTimeout,
+	})
+}
+
+// NewWithWriter returns a sink publishing with writer, whose Balancer should
+// hash the keys of messages to keep the changes of an object in order
+func NewWithWriter(writer *kafka.Writer) *Sink {
+	return &Sink{writer: writer}
+}
+
+// Write publishes entries and waits for the brokers to acknowledge them
+func (s *Sink) Write(ctx context.Context, entries []audited.AuditLog) error {
+	messages, err := s.messages(entries)
+	if err != nil {
+		return err
+	}
+	return s.writer.WriteMessages(ctx, messages...)
+}
+
+// Handle is an audited.ConsumerHandler publishing entries, a batch that
+// fails to publish is handed again
+func (s *Sink) Handle(ctx context.Context, tx *gorm.DB, entries []audited.AuditLog) error {
+	return s.Write(ctx, entries)
+}
+
+// Close publishes the pending messages and closes the connections to the
+// brokers
+func (s *Sink) Close() error {
+	return s.writer.Close()
+}
+
+func (s *Sink) messages(entries []audited.AuditLog) ([]kafka.Message, error) {
+	messages := make([]kafka.Message, 0, len(entries))
+	for _, entry := range entries {
+		value, err := audited.MarshalEvent(s.DB, entry)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, kafka.Message{
+			Key:   []byte(Key(entry)),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: "table_name", Value: []byte(entry.TableName)},
+				{Key: "operation_type", Value: []byte(entry.OperationType)},
+			},
+		})
+	}
+	return messages, nil
+}
+
+// Key returns the message key of entry, its table name and object id
+func Key(entry audited.AuditLog) string {
+	return entry.TableName + ":" + entry.ObjectId
+}
@@ -0,0 +1,37 @@
+package kafka
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mleonidas/audited"
+	"gorm.io/datatypes"
+)
+
+func TestMessages(t *testing.T) {
+	sink := New(Config{Brokers: []string{"localhost:9092"}, Topic: "audit"})
+	entries := []audited.AuditLog{
+		{Id: "1", TableName: "orders", OperationType: audited.OperationCreate, ObjectId: "7", Data: datatypes.JSON(`{"id":"7"}`)},
+		{Id: "2", TableName: "orders", OperationType: audited.OperationUpdate, ObjectId: "8", Data: datatypes.JSON(`{"id":"8"}`)},
+	}
+	messages, err := sink.messages(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(messages))
+	}
+	if got := string(messages[1].Key); got != "orders:8" {
+		t.Fatalf("got key %q, want orders:8", got)
+	}
+	if got := string(messages[1].Headers[1].Value); got != audited.OperationUpdate {
+		t.Fatalf("got operation header %q", got)
+	}
+	var decoded audited.AuditLog
+	if err := json.Unmarshal(messages[0].Value, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Id != "1" || decoded.ObjectId != "7" {
+		t.Fatalf("got event %+v", decoded)
+	}
+}
@@ -0,0 +1,110 @@
+package audited
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ObjectId returns the object id the entries of model, a struct or a pointer
+// to one, are written with, to look up its trail
+func ObjectId(db *gorm.DB, model interface{}) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return "", err
+	}
+	key, ok := primaryKey(context.Background(), stmt.Schema, reflect.ValueOf(model))
+	if !ok {
+		return "", gorm.ErrPrimaryKeyRequired
+	}
+	return key.objectId(), nil
+}
+
+// objectKey is the primary key of an object, one value per column
+type objectKey struct {
+	columns []string
+	values  []interface{}
+}
+
+// objectId formats the key as an object id: the value of a single column
+// key, or a canonical JSON object of the columns of a composite one, e.g.
+// {"line":2,"order_id":"o-1"}
+func (k objectKey) objectId() string {
+	if len(k.columns) == 1 {
+		return formatObjectId(k.values[0])
+	}
+	values := make(map[string]interface{}, len(k.columns))
+	for i, column := range k.columns {
+		values[column] = k.values[i]
+	}
+	return string(prepareData(values))
+}
+
+// where restricts query to the object of the key
+func (k objectKey) where(query *gorm.DB) *gorm.DB {
+	return query.Where(k.condition())
+}
+
+// condition matches the object of the key
+func (k objectKey) condition() clause.Expression {
+	eqs := make([]clause.Expression, len(k.columns))
+	for i, column := range k.columns {
+		eqs[i] = clause.Eq{Column: clause.Column{Name: column}, Value: k.values[i]}
+	}
+	return clause.And(eqs...)
+}
+
+// primaryKey returns the primary key of the object in value, a struct of the
+// model of s, ok is false when it isn't set. Models without a parsed schema
+// fall back to their Id field.
+func primaryKey(ctx context.Context, s *schema.Schema, value reflect.Value) (key objectKey, ok bool) {
+	value = reflect.Indirect(value)
+	if value.Kind() != reflect.Struct {
+		return key, false
+	}
+	if s == nil || len(s.PrimaryFields) == 0 {
+		field := value.FieldByName("Id")
+		if !field.IsValid() || field.IsZero() {
+			return key, false
+		}
+		return objectKey{columns: []string{"id"}, values: []interface{}{field.Interface()}}, true
+	}
+	for _, field := range s.PrimaryFields {
+		v, zero := field.ValueOf(ctx, value)
+		ok = ok || !zero
+		key.columns = append(key.columns, field.DBName)
+		key.values = append(key.values, v)
+	}
+	return key, ok
+}
+
+// formatObjectId formats a primary key value as an object id, e.g. 42 for
+// an uint key instead of "<uint Value>"
+func formatObjectId(key interface{}) string {
+	value := reflect.ValueOf(key)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	if !value.IsValid() {
+		return ""
+	}
+	switch v := value.Interface().(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case json.Number:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value.Interface())
+}
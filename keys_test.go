@@ -0,0 +1,100 @@
+package audited
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type Counter struct {
+	ID    uint `gorm:"primaryKey"`
+	Count int
+}
+
+type Device struct {
+	Serial uuid.UUID `gorm:"primaryKey"`
+}
+
+type OrderLine struct {
+	OrderId string `gorm:"primaryKey"`
+	Line    int    `gorm:"primaryKey"`
+	Sku     string
+}
+
+func TestPrimaryKey(t *testing.T) {
+	serial := uuid.MustParse("7d444840-9dc0-11d1-b245-5ffdce74fad2")
+	cases := []struct {
+		name   string
+		model  interface{}
+		column string
+		id     string
+		ok     bool
+	}{
+		{"uint", &Counter{ID: 42}, "id", "42", true},
+		{"zero uint", &Counter{}, "id", "", false},
+		{"uuid", &Device{Serial: serial}, "serial", serial.String(), true},
+		{"string", &Subscriber{Id: "s-1"}, "id", "s-1", true},
+		{"composite", &OrderLine{OrderId: "o-1", Line: 2}, "order_id,line", `{"line":2,"order_id":"o-1"}`, true},
+		{"composite with a zero column", &OrderLine{OrderId: "o-1"}, "order_id,line", `{"line":0,"order_id":"o-1"}`, true},
+	}
+	for _, c := range cases {
+		db := statementFor(t, c.model)
+		key, ok := primaryKey(context.Background(), db.Statement.Schema, reflect.ValueOf(c.model))
+		if ok != c.ok || (ok && (strings.Join(key.columns, ",") != c.column || key.objectId() != c.id)) {
+			t.Errorf("%s: got %+v %v", c.name, key, ok)
+		}
+		if !ok {
+			continue
+		}
+		id, err := ObjectId(db, c.model)
+		if err != nil || id != c.id {
+			t.Errorf("%s: got object id %q, %v", c.name, id, err)
+		}
+	}
+}
+
+func TestFormatObjectId(t *testing.T) {
+	n := int64(7)
+	for _, c := range []struct {
+		key  interface{}
+		want string
+	}{
+		{uint(42), "42"},
+		{&n, "7"},
+		{json.Number("12"), "12"},
+		{"abc", "abc"},
+		{nil, ""},
+	} {
+		if got := formatObjectId(c.key); got != c.want {
+			t.Errorf("%v: got %q, want %q", c.key, got, c.want)
+		}
+	}
+}
+
+func TestStatementRows(t *testing.T) {
+	counters := []*Counter{{ID: 1}, {ID: 2}}
+	for _, c := range []struct {
+		name  string
+		model interface{}
+		ids   []uint
+	}{
+		{"object", &Counter{ID: 3}, []uint{3}},
+		{"slice", &[]Counter{{ID: 4}, {ID: 5}}, []uint{4, 5}},
+		{"slice of pointers", counters, []uint{1, 2}},
+		{"array", &[2]Counter{{ID: 6}, {ID: 7}}, []uint{6, 7}},
+	} {
+		db := statementFor(t, c.model)
+		db.Statement.ReflectValue = reflect.ValueOf(c.model)
+		var ids []uint
+		for _, row := range statementRows(db) {
+			ids = append(ids, row.Interface().(Counter).ID)
+		}
+		if !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("%s: got %v", c.name, ids)
+		}
+	}
+}
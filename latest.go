@@ -0,0 +1,95 @@
+package audited
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LatestTable holds the last change of every object, see WithMaintainLatest.
+// It is named as the tables of LayoutSplit.
+const LatestTable = "audit_latest"
+
+// WithMaintainLatest updates LatestTable with every entry written to the
+// database, in the same transaction, so "last modified by" columns of list
+// views are a primary key lookup instead of a scan of the audit table
+func WithMaintainLatest() Option {
+	return func(o *options) {
+		o.maintainLatest = true
+	}
+}
+
+// latestTable returns the LatestTable of db
+func latestTable(db *gorm.DB) string {
+	return instanceTable(db, LatestTable, "latest")
+}
+
+// LatestChange is the last change of an object. Version counts the entries
+// written for the object since it was first tracked.
+type LatestChange struct {
+	TableName     string    `json:"table_name" gorm:"primaryKey"`
+	ObjectId      string    `json:"object_id" gorm:"primaryKey"`
+	AuditId       ID        `json:"audit_id"`
+	OperationType string    `json:"operation_type"`
+	UserId        string    `json:"user_id"`
+	Version       int64     `json:"version"`
+	ChangedAt     time.Time `json:"changed_at"`
+}
+
+// LastChanges returns the last change of the objects of table with the given
+// ids, by object id; objects without entries are left out
+func LastChanges(db *gorm.DB, table string, objectIds []string) (map[string]LatestChange, error) {
+	var rows []LatestChange
+	db = auditConn(db).Session(&gorm.Session{NewDB: true})
+	if err := db.Table(latestTable(db)).
+		Where("table_name = ? AND object_id IN ?", table, objectIds).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	latest := make(map[string]LatestChange, len(rows))
+	for _, row := range rows {
+		latest[row.ObjectId] = row
+	}
+	return latest, nil
+}
+
+// updateLatest upserts the last change of the objects of logs, one statement
+// per object
+func updateLatest(tx *gorm.DB, logs []AuditLog) error {
+	type objectKey struct{ table, object string }
+	var order []objectKey
+	last := map[objectKey]LatestChange{}
+	for _, entry := range logs {
+		key := objectKey{entry.TableName, entry.ObjectId}
+		change, seen := last[key]
+		if !seen {
+			order = append(order, key)
+		}
+		last[key] = LatestChange{
+			TableName:     entry.TableName,
+			ObjectId:      entry.ObjectId,
+			AuditId:       entry.Id,
+			OperationType: entry.OperationType,
+			UserId:        entry.UserId,
+			Version:       change.Version + 1,
+			ChangedAt:     entry.CreatedAt,
+		}
+	}
+	table := latestTable(tx)
+	for _, key := range order {
+		change := last[key]
+		updates := clause.AssignmentColumns([]string{"audit_id", "operation_type", "user_id", "changed_at"})
+		updates = append(updates, clause.Assignment{
+			Column: clause.Column{Name: "version"},
+			Value:  gorm.Expr(tx.Statement.Quote(table)+".version + ?", change.Version),
+		})
+		if err := tx.Table(table).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "table_name"}, {Name: "object_id"}},
+			DoUpdates: updates,
+		}).Create(&change).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
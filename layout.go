@@ -0,0 +1,188 @@
+package audited
+
+import (
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Layout is how audit entries are stored
+type Layout int
+
+const (
+	// LayoutSingleTable stores entries in AuditTable, or the table given to
+	// WithTableName
+	LayoutSingleTable Layout = iota
+	// LayoutSplit stores entries without their data in OperationsTable and
+	// their data in PayloadsTable, joined by id, so listing and filtering
+	// entries never reads the large payloads
+	LayoutSplit
+)
+
+// Default tables of LayoutSplit, see splitTable
+const (
+	OperationsTable = "audit_operations"
+	PayloadsTable   = "audit_payloads"
+)
+
+// WithStorageLayout writes and reads the entries of the database in layout
+// instead of LayoutSingleTable
+func WithStorageLayout(layout Layout) Option {
+	return func(o *options) {
+		o.layout = layout
+	}
+}
+
+// splitLayout reports whether db stores entries in LayoutSplit
+func splitLayout(db *gorm.DB) bool {
+	return configFor(db).layout == LayoutSplit
+}
+
+// splitTable returns the table of db named table by default, with the table
+// prefix of its NamingStrategy, or the table given to WithTableName followed
+// by an underscore and suffix, e.g. billing_audit_logs_payloads
+func instanceTable(db *gorm.DB, table, suffix string) string {
+	if name := configFor(db).tableName; name != "" {
+		return name + "_" + suffix
+	}
+	return tablePrefix(db) + table
+}
+
+type auditPayload struct {
+	Id      ID `gorm:"primaryKey"`
+	Data    datatypes.JSON
+	OldData datatypes.JSON
+}
+
+// Query returns a query over the audit entries with their data, hiding the
+// storage layout:
+//
+//	var entries []audited.AuditLog
+//	audited.Query(db).Where("user_id = ?", user).Order("created_at DESC").Find(&entries)
+func Query(db *gorm.DB) *gorm.DB {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true})
+	if cold, ok := coldTable(db); ok {
+		return tieredQuery(db, cold)
+	}
+	if !splitLayout(db) {
+		return db.Table(auditTable(db))
+	}
+	quote, operations, payloads := db.Statement.Quote, operationsTable(db), payloadTable(db)
+	return db.Table(operations).
+		Select(quote(operations) + ".*, " + quote(payloads+".data") + ", " + quote(payloads+".old_data")).
+		Joins("LEFT JOIN " + quote(payloads) + " ON " +
+			quote(payloads+".id") + " = " + quote(operations+".id"))
+}
+
+// QueryOperations returns a query over the audit entries without their data,
+// for listing and filtering. Data is left empty on the entries it finds.
+func QueryOperations(db *gorm.DB) *gorm.DB {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true})
+	if cold, ok := coldTable(db); ok {
+		return tieredQuery(db, cold).Omit("data", "old_data")
+	}
+	if !splitLayout(db) {
+		return db.Table(auditTable(db)).Omit("data", "old_data")
+	}
+	return db.Table(operationsTable(db))
+}
+
+// isAuditTable reports whether table stores audit entries of db, in any layout
+func isAuditTable(db *gorm.DB, table string) bool {
+	if cold, ok := coldTable(db); ok && table == cold {
+		return true
+	}
+	return table == AuditTable || table == auditTable(db) || table == OperationsTable || table == PayloadsTable ||
+		table == instanceTable(db, OperationsTable, "operations") || table == instanceTable(db, PayloadsTable, "payloads") ||
+		table == FieldChangesTable || table == fieldChangesTable(db) || table == CheckpointsTable ||
+		table == AnchorsTable || table == MerkleRootsTable || table == PurgesTable || table == MetaAuditTable ||
+		table == LatestTable || table == latestTable(db) || table == RollupsTable || table == rollupsTable(db)
+}
+
+// storageTables returns the tables entries are stored in
+func storageTables(db *gorm.DB) []string {
+	if splitLayout(db) {
+		return []string{operationsTable(db), payloadTable(db)}
+	}
+	return tiered(db, auditTable(db))
+}
+
+// operationsTable returns the table holding the indexed columns of entries
+func operationsTable(db *gorm.DB) string {
+	if splitLayout(db) {
+		return instanceTable(db, OperationsTable, "operations")
+	}
+	return auditTable(db)
+}
+
+const defaultInsertBatchSize = 500
+
+// WithInsertBatchSize inserts at most size entries of the database per
+// statement instead of 500, more are inserted in batches of this size in a
+// transaction
+func WithInsertBatchSize(size int) Option {
+	return func(o *options) {
+		o.insertBatchSize = size
+	}
+}
+
+// insertBatchSize returns the most entries of db inserted by a statement
+func insertBatchSize(db *gorm.DB) int {
+	if size := configFor(db).insertBatchSize; size > 0 {
+		return size
+	}
+	return defaultInsertBatchSize
+}
+
+// insertAuditLogs inserts logs in the storage layout, with their field changes
+func insertAuditLogs(db *gorm.DB, logs []AuditLog) error {
+	if err := checkResidency(db, logs); err != nil {
+		return err
+	}
+	if err := checkEncryption(db, logs); err != nil {
+		return err
+	}
+	if keyID := encryptionKeyID(db); keyID != "" {
+		restore, err := encryptLogs(db.Statement.Context, keyID, logs)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+	size, latest := insertBatchSize(db), configFor(db).maintainLatest
+	if !splitLayout(db) && !hasFieldChanges(logs) && !latest {
+		return db.Table(auditTable(db)).CreateInBatches(&logs, size).Error
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if !splitLayout(tx) {
+			if err := tx.Table(auditTable(tx)).CreateInBatches(&logs, size).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Table(operationsTable(tx)).Omit("Data", "OldData").CreateInBatches(&logs, size).Error; err != nil {
+				return err
+			}
+			payloads := make([]auditPayload, len(logs))
+			for i, l := range logs {
+				payloads[i] = auditPayload{Id: l.Id, Data: l.Data, OldData: l.OldData}
+			}
+			if err := tx.Table(payloadTable(tx)).CreateInBatches(&payloads, size).Error; err != nil {
+				return err
+			}
+		}
+		if err := insertFieldChanges(tx, logs); err != nil {
+			return err
+		}
+		if !latest {
+			return nil
+		}
+		return updateLatest(tx, logs)
+	})
+}
+
+// payloadTable returns the table holding the data of entries
+func payloadTable(db *gorm.DB) string {
+	if splitLayout(db) {
+		return instanceTable(db, PayloadsTable, "payloads")
+	}
+	return auditTable(db)
+}
@@ -0,0 +1,147 @@
+package audited
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// FieldChangesTable holds the field changes of long format models. It is
+// named as the tables of LayoutSplit.
+const FieldChangesTable = "audit_field_changes"
+
+// fieldChangesTable returns the FieldChangesTable of db
+func fieldChangesTable(db *gorm.DB) string {
+	return instanceTable(db, FieldChangesTable, "field_changes")
+}
+
+// FieldChange is a single changed field of an audit entry, stored one row per
+// field for models registered with RegisterLongFormat. OldValue is nil for
+// fields set by a create and NewValue for fields removed by a delete.
+type FieldChange struct {
+	Id        ID             `json:"id" gorm:"primaryKey;default:(-)"`
+	AuditId   ID             `json:"audit_id"`
+	TableName string         `json:"table_name"`
+	ObjectId  string         `json:"object_id"`
+	Field     string         `json:"field"`
+	OldValue  datatypes.JSON `json:"old_value"`
+	NewValue  datatypes.JSON `json:"new_value"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+var longFormat = struct {
+	sync.RWMutex
+	models map[reflect.Type]bool
+}{models: map[reflect.Type]bool{}}
+
+// RegisterLongFormat stores the changes of models one row per changed field in
+// FieldChangesTable, in addition to their audit entries, so the history of a
+// field is a plain indexed lookup, see FieldHistory
+func RegisterLongFormat(models ...interface{}) {
+	longFormat.Lock()
+	defer longFormat.Unlock()
+	for _, model := range models {
+		longFormat.models[modelType(model)] = true
+	}
+}
+
+func isLongFormat(db *gorm.DB) bool {
+	if db.Statement.Schema == nil {
+		return false
+	}
+	longFormat.RLock()
+	defer longFormat.RUnlock()
+	return longFormat.models[db.Statement.Schema.ModelType]
+}
+
+// usesLongFormat reports whether any model is registered with RegisterLongFormat
+func usesLongFormat() bool {
+	longFormat.RLock()
+	defer longFormat.RUnlock()
+	return len(longFormat.models) > 0
+}
+
+// FieldHistory returns the changes of a field of an object, oldest first
+func FieldHistory(db *gorm.DB, table, objectId, field string) ([]FieldChange, error) {
+	var changes []FieldChange
+	db = auditConn(db).Session(&gorm.Session{NewDB: true})
+	err := db.Table(fieldChangesTable(db)).
+		Where("table_name = ? AND object_id = ? AND field = ?", table, objectId, field).
+		Order("created_at").
+		Find(&changes).
+		Error
+	return changes, err
+}
+
+// fieldChanges returns the fields changed by entry, updates are compared with
+// the data of the previous entry of the object
+func fieldChanges(entry *AuditLog, previousData datatypes.JSON) ([]FieldChange, error) {
+	data, err := decodeTableSnapshot(entry.TableName, entry.Data)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	switch entry.OperationType {
+	case OperationCreate:
+		changes = diffMaps(map[string]interface{}{}, data)
+	case OperationDelete, OperationSoftDelete, OperationHardDelete:
+		changes = diffMaps(data, map[string]interface{}{})
+	default:
+		previous, err := decodeTableSnapshot(entry.TableName, previousData)
+		if err != nil {
+			return nil, err
+		}
+		changes = diffMaps(previous, data)
+	}
+
+	rows := make([]FieldChange, 0, len(changes))
+	for _, c := range changes {
+		rows = append(rows, FieldChange{
+			Id:        newID(),
+			TableName: entry.TableName,
+			ObjectId:  entry.ObjectId,
+			Field:     c.Field,
+			OldValue:  fieldJSON(c.From),
+			NewValue:  fieldJSON(c.To),
+		})
+	}
+	return rows, nil
+}
+
+func fieldJSON(v FieldValue) datatypes.JSON {
+	if !v.Present {
+		return nil
+	}
+	data, _ := json.Marshal(v.Value)
+	return data
+}
+
+func hasFieldChanges(logs []AuditLog) bool {
+	for _, l := range logs {
+		if len(l.changes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// insertFieldChanges inserts the field changes of logs, pointing them at
+// their entries
+func insertFieldChanges(db *gorm.DB, logs []AuditLog) error {
+	var rows []FieldChange
+	for _, l := range logs {
+		for _, c := range l.changes {
+			c.AuditId = l.Id
+			c.CreatedAt = l.CreatedAt
+			rows = append(rows, c)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return db.Table(fieldChangesTable(db)).Create(&rows).Error
+}
@@ -0,0 +1,44 @@
+package audited
+
+import (
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestFieldChanges(t *testing.T) {
+	previous := datatypes.JSON(`{"id":"7","status":"open","total":10}`)
+	for _, tc := range []struct {
+		operation string
+		data      string
+		want      map[string][2]string
+	}{
+		{OperationCreate, `{"id":"7","status":"open"}`,
+			map[string][2]string{"id": {"", `"7"`}, "status": {"", `"open"`}}},
+		{OperationUpdate, `{"id":"7","status":"paid","total":10,"note":null}`,
+			map[string][2]string{"status": {`"open"`, `"paid"`}, "note": {"", "null"}}},
+		{OperationHardDelete, `{"id":"7","total":10}`,
+			map[string][2]string{"id": {`"7"`, ""}, "total": {"10", ""}}},
+	} {
+		entry := &AuditLog{TableName: "orders", ObjectId: "7", OperationType: tc.operation, Data: datatypes.JSON(tc.data)}
+		changes, err := fieldChanges(entry, previous)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.operation, err)
+		}
+		got := map[string][2]string{}
+		for _, c := range changes {
+			if c.TableName != "orders" || c.ObjectId != "7" || c.Id == "" {
+				t.Errorf("%s: change of %s not keyed to its object: %+v", tc.operation, c.Field, c)
+			}
+			got[c.Field] = [2]string{string(c.OldValue), string(c.NewValue)}
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got changes %v, want %v", tc.operation, got, tc.want)
+		}
+		for field, want := range tc.want {
+			if got[field] != want {
+				t.Errorf("%s: got %s changed %q, want %q", tc.operation, field, got[field], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaintenanceReport describes the size and health of a table storing audit entries
+type MaintenanceReport struct {
+	Table          string            `json:"table"`
+	TableBytes     int64             `json:"table_bytes"`
+	IndexBytes     int64             `json:"index_bytes"`
+	TotalBytes     int64             `json:"total_bytes"`
+	LiveTuples     int64             `json:"live_tuples"`
+	DeadTuples     int64             `json:"dead_tuples"`
+	DeadTupleRatio float64           `json:"dead_tuple_ratio"`
+	LastVacuum     *time.Time        `json:"last_vacuum"`
+	LastAnalyze    *time.Time        `json:"last_analyze"`
+	Indexes        []IndexReport     `json:"indexes"`
+	Partitions     []PartitionReport `json:"partitions"`
+}
+
+// IndexReport describes an index of the audit table. LeafDensity is only
+// known when the pgstattuple extension is installed, a low density on a
+// B-tree index means it is bloated.
+type IndexReport struct {
+	Name        string   `json:"name"`
+	Bytes       int64    `json:"bytes"`
+	Scans       int64    `json:"scans"`
+	LeafDensity *float64 `json:"leaf_density"`
+}
+
+// PartitionReport describes a partition of the audit table
+type PartitionReport struct {
+	Name  string `json:"name"`
+	Bound string `json:"bound"`
+	Bytes int64  `json:"bytes"`
+}
+
+// MaintenanceOptions selects the work done by RunMaintenance
+type MaintenanceOptions struct {
+	// Analyze refreshes the planner statistics of the audit table
+	Analyze bool
+	// DetachOlderThan detaches range partitions whose upper bound is older than
+	// it, zero keeps all partitions attached
+	DetachOlderThan time.Duration
+	// Retention deletes entries past their TTL, on every dialect
+	Retention *RetentionPolicy
+	// PurgeHistory carries out the pending purges of the history of objects,
+	// on every dialect, see RegisterCascadePurge
+	PurgeHistory bool
+	// ColdTier moves entries to the cold table, on every dialect, see
+	// WithColdTier
+	ColdTier bool
+	// Quotas applies the overflow of the tenants over their quota, on every
+	// dialect, see EnforceQuotas
+	Quotas *QuotaOptions
+}
+
+// Maintenance reports the size, dead tuple ratio, index sizes and partitions
+// of each table of the storage layout. Only postgres is supported.
+func Maintenance(db *gorm.DB) ([]MaintenanceReport, error) {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true})
+	if db.Dialector.Name() != "postgres" {
+		return nil, ErrUnsupportedDialect
+	}
+	var reports []MaintenanceReport
+	for _, table := range storageTables(db) {
+		report, err := tableMaintenance(db, table)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+func tableMaintenance(db *gorm.DB, table string) (*MaintenanceReport, error) {
+	report := &MaintenanceReport{}
+
+	if err := db.Raw(`SELECT pg_table_size(c.oid) AS table_bytes,
+			pg_indexes_size(c.oid) AS index_bytes,
+			pg_total_relation_size(c.oid) AS total_bytes,
+			COALESCE(s.n_live_tup, 0) AS live_tuples,
+			COALESCE(s.n_dead_tup, 0) AS dead_tuples,
+			GREATEST(s.last_vacuum, s.last_autovacuum) AS last_vacuum,
+			GREATEST(s.last_analyze, s.last_autoanalyze) AS last_analyze
+		FROM pg_class c LEFT JOIN pg_stat_user_tables s ON s.relid = c.oid
+		WHERE c.oid = to_regclass(?)`, table).
+		Scan(report).Error; err != nil {
+		return nil, err
+	}
+	if total := report.LiveTuples + report.DeadTuples; total > 0 {
+		report.DeadTupleRatio = float64(report.DeadTuples) / float64(total)
+	}
+
+	if err := db.Raw(`SELECT s.indexrelname AS name, pg_relation_size(s.indexrelid) AS bytes, s.idx_scan AS scans
+		FROM pg_stat_user_indexes s WHERE s.relid = to_regclass(?) ORDER BY s.indexrelname`, table).
+		Scan(&report.Indexes).Error; err != nil {
+		return nil, err
+	}
+	for i := range report.Indexes {
+		var density float64
+		// pgstatindex needs the pgstattuple extension, without it density stays unknown
+		if err := db.Raw("SELECT avg_leaf_density FROM pgstatindex(?)", report.Indexes[i].Name).
+			Row().Scan(&density); err == nil {
+			report.Indexes[i].LeafDensity = &density
+		}
+	}
+
+	if err := db.Raw(`SELECT c.relname AS name, pg_get_expr(c.relpartbound, c.oid) AS bound,
+			pg_total_relation_size(c.oid) AS bytes
+		FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = to_regclass(?) ORDER BY c.relname`, table).
+		Scan(&report.Partitions).Error; err != nil {
+		return nil, err
+	}
+	report.Table = table
+	return report, nil
+}
+
+var partitionUpperBound = regexp.MustCompile(`TO \('([^']+)'\)`)
+
+// RunMaintenance runs one pass of the maintenance selected by opts. Partitions
+// are detached one statement at a time so a failure leaves the others in place.
+// Only retention, purges and tiering are supported on other dialects than
+// postgres.
+func RunMaintenance(ctx context.Context, db *gorm.DB, opts MaintenanceOptions) error {
+	if opts.Retention != nil {
+		if _, err := ApplyRetention(ctx, db, *opts.Retention); err != nil {
+			return err
+		}
+	}
+	if opts.PurgeHistory {
+		if _, err := PurgeHistory(ctx, db); err != nil {
+			return err
+		}
+	}
+	if opts.ColdTier {
+		if _, err := MoveToColdTier(ctx, db); err != nil {
+			return err
+		}
+	}
+	if opts.Quotas != nil {
+		if _, err := EnforceQuotas(ctx, db, *opts.Quotas); err != nil {
+			return err
+		}
+	}
+	if !opts.Analyze && opts.DetachOlderThan <= 0 {
+		return nil
+	}
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	if db.Dialector.Name() != "postgres" {
+		return ErrUnsupportedDialect
+	}
+	quote := db.Statement.Quote
+
+	if opts.DetachOlderThan > 0 {
+		reports, err := Maintenance(db)
+		if err != nil {
+			return err
+		}
+		cutoff := time.Now().Add(-opts.DetachOlderThan)
+		for _, report := range reports {
+			if err := detachPartitions(db, report, cutoff); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.Analyze {
+		for _, table := range storageTables(db) {
+			if err := db.Exec("ANALYZE " + quote(table)).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// detachPartitions detaches the range partitions of the table of report whose
+// upper bound is before cutoff
+func detachPartitions(db *gorm.DB, report MaintenanceReport, cutoff time.Time) error {
+	quote := db.Statement.Quote
+	for _, partition := range report.Partitions {
+		match := partitionUpperBound.FindStringSubmatch(partition.Bound)
+		if match == nil {
+			continue
+		}
+		upper, err := parsePartitionBound(match[1])
+		if err != nil || upper.After(cutoff) {
+			continue
+		}
+		if err := db.Exec(fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s",
+			quote(report.Table), quote(partition.Name))).Error; err != nil {
+			return fmt.Errorf("error detaching partition %s: %w", partition.Name, err)
+		}
+	}
+	return nil
+}
+
+func parsePartitionBound(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05-07", "2006-01-02 15:04:05", time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized partition bound %q", s)
+}
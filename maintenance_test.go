@@ -0,0 +1,36 @@
+package audited
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartitionUpperBound(t *testing.T) {
+	for bound, want := range map[string]string{
+		"FOR VALUES FROM ('2024-01-01 00:00:00+00') TO ('2024-02-01 00:00:00+00')": "2024-02-01T00:00:00Z",
+		"FOR VALUES FROM ('2024-01-01 00:00:00') TO ('2024-02-01 00:00:00')":       "2024-02-01T00:00:00Z",
+		"FOR VALUES FROM ('2024-01-01') TO ('2024-02-01')":                         "2024-02-01T00:00:00Z",
+		"FOR VALUES FROM ('2024-01-01 00:00:00+02') TO ('2024-02-01 00:00:00+02')": "2024-01-31T22:00:00Z",
+		"FOR VALUES IN ('eu')": "",
+		"DEFAULT":              "",
+	} {
+		match := partitionUpperBound.FindStringSubmatch(bound)
+		if match == nil {
+			if want != "" {
+				t.Errorf("%s: no upper bound", bound)
+			}
+			continue
+		}
+		upper, err := parsePartitionBound(match[1])
+		if err != nil {
+			t.Errorf("%s: %s", bound, err)
+			continue
+		}
+		if got := upper.UTC().Format(time.RFC3339); got != want {
+			t.Errorf("%s: got %s, want %s", bound, got, want)
+		}
+	}
+	if _, err := parsePartitionBound("MAXVALUE"); err == nil {
+		t.Error("unrecognized bound parsed")
+	}
+}
@@ -0,0 +1,51 @@
+// Package maxmind resolves client locations for audit entries from a MaxMind
+// GeoIP2 or GeoLite2 city database
+package maxmind
+
+import (
+	"context"
+	"net"
+
+	"github.com/mleonidas/audited"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Resolver is an audited.GeoResolver backed by a MaxMind database
+type Resolver struct {
+	reader *geoip2.Reader
+	// Language of the city names, English by default
+	Language string
+}
+
+var _ audited.GeoResolver = (*Resolver)(nil)
+
+// Open opens the city database at path
+func Open(path string) (*Resolver, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(reader), nil
+}
+
+// New returns a Resolver reading from an open database
+func New(reader *geoip2.Reader) *Resolver {
+	return &Resolver{reader: reader, Language: "en"}
+}
+
+// Resolve returns the ISO country code and the city name of ip
+func (r *Resolver) Resolve(ctx context.Context, ip net.IP) (audited.Location, error) {
+	city, err := r.reader.City(ip)
+	if err != nil {
+		return audited.Location{}, err
+	}
+	return audited.Location{
+		Country: city.Country.IsoCode,
+		City:    city.City.Names[r.Language],
+	}, nil
+}
+
+// Close closes the database
+func (r *Resolver) Close() error {
+	return r.reader.Close()
+}
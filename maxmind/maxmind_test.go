@@ -0,0 +1,84 @@
+package maxmind
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// mmdbString, mmdbUint and mmdbMap encode values of the data section of a
+// MaxMind DB, see https://maxmind.github.io/MaxMind-DB/
+func mmdbString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func mmdbUint(v uint16) []byte {
+	return []byte{5<<5 | 2, byte(v >> 8), byte(v)}
+}
+
+func mmdbMap(pairs ...[]byte) []byte {
+	encoded := []byte{7<<5 | byte(len(pairs)/2)}
+	for _, pair := range pairs {
+		encoded = append(encoded, pair...)
+	}
+	return encoded
+}
+
+// cityDatabase returns an IPv4 city database locating every address in
+// Berlin, its search tree is a single node pointing both ways at the record
+func cityDatabase() []byte {
+	record := []byte{0, 0, 17} // node count + 16 + offset 0 in the data section
+	var db bytes.Buffer
+	db.Write(record)
+	db.Write(record)
+	db.Write(make([]byte, 16))
+	db.Write(mmdbMap(
+		mmdbString("city"), mmdbMap(mmdbString("names"), mmdbMap(mmdbString("en"), mmdbString("Berlin"))),
+		mmdbString("country"), mmdbMap(mmdbString("iso_code"), mmdbString("DE")),
+	))
+	db.WriteString("\xAB\xCD\xEFMaxMind.com")
+	db.Write(mmdbMap(
+		mmdbString("node_count"), mmdbUint(1),
+		mmdbString("record_size"), mmdbUint(24),
+		mmdbString("ip_version"), mmdbUint(4),
+		mmdbString("database_type"), mmdbString("GeoLite2-City"),
+		mmdbString("binary_format_major_version"), mmdbUint(2),
+		mmdbString("binary_format_minor_version"), mmdbUint(0),
+	))
+	return db.Bytes()
+}
+
+func TestResolve(t *testing.T) {
+	reader, err := geoip2.FromBytes(cityDatabase())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(reader)
+	defer r.Close()
+
+	loc, err := r.Resolve(context.Background(), net.ParseIP("203.0.113.7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Country != "DE" || loc.City != "Berlin" {
+		t.Errorf("got %+v, want Berlin, DE", loc)
+	}
+
+	// cities without a name in the language are left out
+	r.Language = "fr"
+	if loc, err := r.Resolve(context.Background(), net.ParseIP("203.0.113.7")); err != nil || loc.City != "" || loc.Country != "DE" {
+		t.Errorf("got %+v, %v, want only the country", loc, err)
+	}
+	if _, err := r.Resolve(context.Background(), net.ParseIP("2001:db8::1")); err == nil {
+		t.Error("IPv6 address resolved in an IPv4 database")
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	if _, err := Open("testdata/missing.mmdb"); err == nil {
+		t.Fatal("opened a missing database")
+	}
+}
@@ -0,0 +1,123 @@
+package audited
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MemoryStore keeps audit entries in memory instead of the audit table, see
+// WithMemoryStore. The rows of a database using it are snapshotted from the
+// values passed to gorm rather than read back, so services can be unit tested
+// without a real database, including with dry run sessions.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries []AuditLog
+	seq     int64
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// WithMemoryStore keeps the entries of the database in store instead of the
+// audit tables, e.g. for the database of a test:
+//
+//	store := audited.NewMemoryStore()
+//	audited.RegisterCallbacks(db, audited.WithMemoryStore(store))
+func WithMemoryStore(store *MemoryStore) Option {
+	return func(o *options) {
+		o.memoryStore = store
+	}
+}
+
+// memoryStoreOf returns the MemoryStore of db, nil unless set with
+// WithMemoryStore
+func memoryStoreOf(db *gorm.DB) *MemoryStore {
+	return configFor(db).memoryStore
+}
+
+// Entries returns all entries in write order
+func (s *MemoryStore) Entries() []AuditLog {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]AuditLog, len(s.entries))
+	copy(entries, s.entries)
+	return entries
+}
+
+// TrailFor returns the entries of an object in write order
+func (s *MemoryStore) TrailFor(table, objectId string) []AuditLog {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var trail []AuditLog
+	for _, entry := range s.entries {
+		if entry.TableName == table && entry.ObjectId == objectId {
+			trail = append(trail, entry)
+		}
+	}
+	return trail
+}
+
+// EntriesAfter returns up to limit entries whose Seq is greater than cursor
+func (s *MemoryStore) EntriesAfter(cursor int64, limit int) []AuditLog {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []AuditLog
+	for _, entry := range s.entries {
+		if entry.Seq > cursor && (limit <= 0 || len(entries) < limit) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Reset removes all entries
+func (s *MemoryStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+}
+
+func (s *MemoryStore) add(entries ...AuditLog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range entries {
+		if entries[i].CreatedAt.IsZero() {
+			entries[i].CreatedAt = time.Now()
+		}
+		s.seq++
+		entries[i].Seq = s.seq
+		s.entries = append(s.entries, entries[i])
+	}
+}
+
+func (s *MemoryStore) countOperation(entry AuditLog) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	count := 0
+	for _, stored := range s.entries {
+		if sameOperation(stored, entry) {
+			count++
+		}
+	}
+	return count
+}
+
+// annotate updates the metadata of the entries of the operation of match, or
+// of its idempotency key when it has no table, see annotateEntries
+func (s *MemoryStore) annotate(match AuditLog, annotate func(metadata map[string]interface{}) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, stored := range s.entries {
+		if stored.IdempotencyKey != match.IdempotencyKey || (match.TableName != "" && !sameOperation(stored, match)) {
+			continue
+		}
+		if s.entries[i].Metadata == nil {
+			s.entries[i].Metadata = map[string]interface{}{}
+		}
+		annotate(s.entries[i].Metadata)
+	}
+}
@@ -0,0 +1,203 @@
+package audited
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MerkleRootsTable stores the Merkle roots of batches of entries
+const MerkleRootsTable = "audit_merkle_roots"
+
+// MaxMerkleLeaves is the maximum number of entries under one root
+var MaxMerkleLeaves = 100000
+
+// MerkleRoot is the root of the Merkle tree, as defined by RFC 6962, over the
+// entries with a Seq from SeqFrom to SeqTo. Leaves are the EntryHash of the
+// entries in sequence order.
+type MerkleRoot struct {
+	SeqTo     int64     `json:"seq_to" gorm:"primaryKey;autoIncrement:false"`
+	SeqFrom   int64     `json:"seq_from"`
+	Size      int       `json:"size"`
+	Root      []byte    `json:"root"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// InclusionProof proves that an entry is a leaf of the tree with root Root
+type InclusionProof struct {
+	AuditId   ID       `json:"audit_id"`
+	LeafIndex int      `json:"leaf_index"`
+	TreeSize  int      `json:"tree_size"`
+	Path      [][]byte `json:"path"`
+	Root      []byte   `json:"root"`
+}
+
+// ErrNotInTree is returned by Prove for entries not covered by a root yet
+var ErrNotInTree = errors.New("audited: entry is not covered by a merkle root")
+
+// BuildMerkleRoot stores the root of the tree over the entries after the
+// latest root, up to MaxMerkleLeaves of them. Like AnchorChain it leaves
+// entries after a recent gap in the sequence for the next run, and returns nil
+// when there are no new entries. Publish the roots, e.g. with a Notary, so
+// third parties can check proofs against them.
+func BuildMerkleRoot(ctx context.Context, db *gorm.DB) (*MerkleRoot, error) {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	var latest []MerkleRoot
+	if err := db.Table(MerkleRootsTable).Order("seq_to DESC").Limit(1).Find(&latest).Error; err != nil {
+		return nil, err
+	}
+	var cursor int64
+	if len(latest) > 0 {
+		cursor = latest[0].SeqTo
+	}
+	entries, err := EntriesAfter(db, cursor, MaxMerkleLeaves)
+	if err != nil {
+		return nil, err
+	}
+	entries = consumable(cursor, entries, defaultConsumerGapTimeout, time.Now())
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	root := &MerkleRoot{
+		SeqFrom:   entries[0].Seq,
+		SeqTo:     entries[len(entries)-1].Seq,
+		Size:      len(entries),
+		Root:      merkleTreeHash(leafHashes(entries)),
+		CreatedAt: time.Now(),
+	}
+	if err := db.Table(MerkleRootsTable).Create(root).Error; err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Prove returns the inclusion proof of the entry with auditID in the tree of
+// its batch
+func Prove(ctx context.Context, db *gorm.DB, auditID ID) (*InclusionProof, error) {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	var entry AuditLog
+	if err := QueryOperations(db).Where("id = ?", auditID).Take(&entry).Error; err != nil {
+		return nil, err
+	}
+	var roots []MerkleRoot
+	if err := db.Table(MerkleRootsTable).Where("seq_from <= ? AND seq_to >= ?", entry.Seq, entry.Seq).
+		Limit(1).Find(&roots).Error; err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, ErrNotInTree
+	}
+	root := roots[0]
+	entries, err := EntriesAfter(db, root.SeqFrom-1, root.Size)
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	for i, e := range entries {
+		if e.Id == auditID {
+			index = i
+		}
+	}
+	if index < 0 || len(entries) != root.Size || entries[len(entries)-1].Seq != root.SeqTo {
+		return nil, ErrChainMismatch
+	}
+	leaves := leafHashes(entries)
+	proof := &InclusionProof{
+		AuditId:   auditID,
+		LeafIndex: index,
+		TreeSize:  len(leaves),
+		Path:      merklePath(index, leaves),
+		Root:      root.Root,
+	}
+	if !bytes.Equal(merkleTreeHash(leaves), root.Root) {
+		return nil, ErrChainMismatch
+	}
+	return proof, nil
+}
+
+// VerifyInclusion reports whether proof proves that entry is in the tree with
+// root proof.Root; compare it with the published root
+func VerifyInclusion(entry AuditLog, proof InclusionProof) bool {
+	if entry.Id != proof.AuditId || proof.LeafIndex < 0 || proof.LeafIndex >= proof.TreeSize {
+		return false
+	}
+	// RFC 9162 section 2.1.3.2
+	fn, sn := proof.LeafIndex, proof.TreeSize-1
+	r := leafHash(EntryHash(entry))
+	for _, p := range proof.Path {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, proof.Root)
+}
+
+func leafHashes(entries []AuditLog) [][]byte {
+	leaves := make([][]byte, len(entries))
+	for i, entry := range entries {
+		leaves[i] = leafHash(EntryHash(entry))
+	}
+	return leaves
+}
+
+func leafHash(data []byte) []byte {
+	sum := sha256.Sum256(append([]byte{0}, data...))
+	return sum[:]
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitPoint returns the largest power of two smaller than n
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// merkleTreeHash returns the root over leaf hashes, RFC 6962 section 2.1
+func merkleTreeHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return nodeHash(merkleTreeHash(leaves[:k]), merkleTreeHash(leaves[k:]))
+}
+
+// merklePath returns the audit path of leaf m, RFC 6962 section 2.1.1
+func merklePath(m int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := splitPoint(len(leaves))
+	if m < k {
+		return append(merklePath(m, leaves[:k]), merkleTreeHash(leaves[k:]))
+	}
+	return append(merklePath(m-k, leaves[k:]), merkleTreeHash(leaves[:k]))
+}
@@ -0,0 +1,48 @@
+package audited
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestInclusionProofs(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		entries := make([]AuditLog, size)
+		for i := range entries {
+			entries[i] = AuditLog{Id: ID(fmt.Sprint(i)), Seq: int64(i + 1), TableName: "orders",
+				Data: datatypes.JSON(fmt.Sprintf(`{"total":%d}`, i))}
+		}
+		leaves := leafHashes(entries)
+		root := merkleTreeHash(leaves)
+		for i, entry := range entries {
+			proof := InclusionProof{AuditId: entry.Id, LeafIndex: i, TreeSize: size,
+				Path: merklePath(i, leaves), Root: root}
+			if !VerifyInclusion(entry, proof) {
+				t.Fatalf("proof of leaf %d of %d doesn't verify", i, size)
+			}
+			tampered := entry
+			tampered.Data = datatypes.JSON(`{"total":-1}`)
+			if VerifyInclusion(tampered, proof) {
+				t.Fatalf("proof of leaf %d of %d verifies a changed entry", i, size)
+			}
+			if size > 1 {
+				moved := proof
+				moved.LeafIndex = (i + 1) % size
+				if VerifyInclusion(entry, moved) {
+					t.Fatalf("proof of leaf %d of %d verifies at another index", i, size)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleTreeHash(t *testing.T) {
+	a, b, c := leafHash([]byte("a")), leafHash([]byte("b")), leafHash([]byte("c"))
+	want := nodeHash(nodeHash(a, b), c)
+	if got := merkleTreeHash([][]byte{a, b, c}); !bytes.Equal(got, want) {
+		t.Fatalf("got root %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,58 @@
+package audited
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// MetaAuditTable stores the administrative operations on the audit trail
+// itself, see MetaLog
+const MetaAuditTable = "audit_meta_logs"
+
+// Administrative actions recorded in the meta-audit trail
+const (
+	MetaErasure       = "erasure"
+	MetaPurge         = "purge"
+	MetaRetention     = "retention"
+	MetaExport        = "export"
+	MetaSubjectExport = "subject_export"
+	MetaRekey         = "rekey"
+	MetaQuota         = "quota"
+	MetaTenantPurge   = "tenant_purge"
+	MetaBulk          = "bulk"
+)
+
+// MetaLog is an administrative operation on the audit trail, such as a purge
+// or an export, so the controls over the trail are accountable too
+type MetaLog struct {
+	Id        ID                `json:"id" gorm:"primaryKey;default:(-)"`
+	Action    string            `json:"action"`
+	UserId    string            `json:"user_id"`
+	Details   datatypes.JSONMap `json:"details"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// RecordAdminAction writes an administrative operation to the meta-audit
+// trail, for operations of the application such as changing what is audited
+// or placing a hold. The acting user is resolved as for audit entries. The
+// operations of this package are recorded by themselves.
+func RecordAdminAction(ctx context.Context, db *gorm.DB, action string, details map[string]interface{}) error {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: ctx})
+	return db.Table(MetaAuditTable).Create(&MetaLog{
+		Id:      newID(),
+		Action:  action,
+		UserId:  getCurrentUser(db),
+		Details: details,
+	}).Error
+}
+
+// MetaTrail returns the administrative operations since since, oldest first
+func MetaTrail(db *gorm.DB, since time.Time) ([]MetaLog, error) {
+	var logs []MetaLog
+	err := auditConn(db).Session(&gorm.Session{NewDB: true}).Table(MetaAuditTable).
+		Where("created_at >= ?", since).Order("created_at").Find(&logs).Error
+	return logs, err
+}
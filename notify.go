@@ -0,0 +1,118 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Notification is a message to the users concerned by an entry
+type Notification struct {
+	Recipients []string `json:"recipients"`
+	Text       string   `json:"text"`
+	Entry      AuditLog `json:"entry"`
+}
+
+// Notifier delivers notifications, e.g. through an email or push service
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// RecipientResolver returns the users to notify of an entry, e.g. the owner of
+// the account that changed
+type RecipientResolver func(ctx context.Context, entry AuditLog) ([]string, error)
+
+type notificationRule struct {
+	tmpl       *template.Template
+	recipients RecipientResolver
+}
+
+var notifications = struct {
+	sync.RWMutex
+	m map[string]notificationRule
+}{m: map[string]notificationRule{}}
+
+// RegisterNotification notifies the users recipients returns of the entries
+// of table, with text rendered like a summary template (see RegisterSummary)
+// that also gets the metadata of the entry, e.g.
+//
+//	Your {{.table}} settings were changed from {{.metadata.city}}
+func RegisterNotification(table, text string, recipients RecipientResolver) error {
+	tmpl, err := template.New(table).Parse(text)
+	if err != nil {
+		return fmt.Errorf("audited: invalid notification template for %s: %w", table, err)
+	}
+	notifications.Lock()
+	defer notifications.Unlock()
+	notifications.m[table] = notificationRule{tmpl: tmpl, recipients: recipients}
+	return nil
+}
+
+// NotificationSink turns entries of tables with a registered notification into
+// notifications delivered by Notifier. Register it as a sink, or hand Handle
+// to Consume to only notify of committed changes and keep slow deliveries out
+// of the write path.
+type NotificationSink struct {
+	Notifier Notifier
+	// DB is used to read the data before updates, without it old is empty
+	DB *gorm.DB
+}
+
+// Write notifies of entries, stopping at the first failing delivery
+func (s *NotificationSink) Write(ctx context.Context, entries []AuditLog) error {
+	for _, entry := range entries {
+		notification, ok, err := s.notification(ctx, entry)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := s.Notifier.Notify(ctx, notification); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handle is a ConsumerHandler notifying of entries
+func (s *NotificationSink) Handle(ctx context.Context, tx *gorm.DB, entries []AuditLog) error {
+	return s.Write(ctx, entries)
+}
+
+// notification returns the notification of entry, ok is false when there is
+// none or nobody to notify
+func (s *NotificationSink) notification(ctx context.Context, entry AuditLog) (Notification, bool, error) {
+	notifications.RLock()
+	rule, registered := notifications.m[entry.TableName]
+	notifications.RUnlock()
+	if !registered {
+		return Notification{}, false, nil
+	}
+	recipients, err := rule.recipients(ctx, entry)
+	if err != nil || len(recipients) == 0 {
+		return Notification{}, false, err
+	}
+
+	var before datatypes.JSON
+	if entry.OperationType == OperationUpdate && s.DB != nil {
+		if before, err = dataBefore(s.DB.WithContext(ctx), entry); err != nil {
+			return Notification{}, false, err
+		}
+	}
+	data, err := templateData(entry, before)
+	if err != nil {
+		return Notification{}, false, err
+	}
+	data["metadata"] = map[string]interface{}(entry.Metadata)
+	var text strings.Builder
+	if err := rule.tmpl.Execute(&text, data); err != nil {
+		return Notification{}, false, err
+	}
+	return Notification{Recipients: recipients, Text: text.String(), Entry: entry}, true, nil
+}
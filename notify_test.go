@@ -0,0 +1,57 @@
+package audited
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+type notifierFunc func(ctx context.Context, n Notification) error
+
+func (f notifierFunc) Notify(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
+
+func TestNotificationSink(t *testing.T) {
+	defer func() {
+		notifications.Lock()
+		notifications.m = map[string]notificationRule{}
+		notifications.Unlock()
+	}()
+	err := RegisterNotification("accounts",
+		"Your {{.new.plan}} account was changed from {{.metadata.city}}",
+		func(ctx context.Context, entry AuditLog) ([]string, error) {
+			if entry.OperationType != OperationUpdate {
+				return nil, nil
+			}
+			return []string{"owner-" + entry.ObjectId}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []Notification
+	sink := &NotificationSink{Notifier: notifierFunc(func(ctx context.Context, n Notification) error {
+		sent = append(sent, n)
+		return nil
+	})}
+	err = sink.Write(context.Background(), []AuditLog{
+		{TableName: "accounts", ObjectId: "1", OperationType: OperationCreate, Data: datatypes.JSON(`{"plan":"free"}`)},
+		{TableName: "accounts", ObjectId: "1", OperationType: OperationUpdate, Data: datatypes.JSON(`{"plan":"pro"}`),
+			Metadata: map[string]interface{}{"city": "Lisbon"}},
+		{TableName: "orders", ObjectId: "2", OperationType: OperationUpdate, Data: datatypes.JSON(`{}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("got %d notifications, want 1: %v", len(sent), sent)
+	}
+	if want := "Your pro account was changed from Lisbon"; sent[0].Text != want {
+		t.Fatalf("got text %q, want %q", sent[0].Text, want)
+	}
+	if len(sent[0].Recipients) != 1 || sent[0].Recipients[0] != "owner-1" {
+		t.Fatalf("got recipients %v", sent[0].Recipients)
+	}
+}
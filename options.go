@@ -0,0 +1,229 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Option configures the auditing of a database, see RegisterCallbacks
+type Option func(*options)
+
+// UserResolver returns the user acting in ctx, e.g. from the claims of the
+// request or the metadata of a gRPC call
+type UserResolver func(ctx context.Context, db *gorm.DB) (string, error)
+
+// ContextResolver resolves the acting user from the value of key in the
+// context, a string or a fmt.Stringer, as set by the auth middleware of the
+// app
+func ContextResolver(key interface{}) UserResolver {
+	return func(ctx context.Context, db *gorm.DB) (string, error) {
+		switch user := ctx.Value(key).(type) {
+		case string:
+			return user, nil
+		case fmt.Stringer:
+			return user.String(), nil
+		}
+		return "", nil
+	}
+}
+
+// ClaimsResolver resolves the acting user from claim, e.g. "sub" or "email",
+// of the JWT claims stored in the context under key as a
+// map[string]interface{}, as most JWT middlewares do
+func ClaimsResolver(key interface{}, claim string) UserResolver {
+	return func(ctx context.Context, db *gorm.DB) (string, error) {
+		claims, ok := ctx.Value(key).(map[string]interface{})
+		if !ok {
+			return "", nil
+		}
+		switch user := claims[claim].(type) {
+		case nil:
+			return "", nil
+		case string:
+			return user, nil
+		default:
+			return "", fmt.Errorf("audited: claim %s is a %T, not a string", claim, user)
+		}
+	}
+}
+
+// ChainResolvers resolves the acting user with the first of resolvers that
+// finds one, e.g. the claims of a request, then the metadata of a job. The
+// errors of resolvers are only returned when none finds a user.
+func ChainResolvers(resolvers ...UserResolver) UserResolver {
+	return func(ctx context.Context, db *gorm.DB) (string, error) {
+		var errs []error
+		for _, resolve := range resolvers {
+			user, err := resolve(ctx, db)
+			if err != nil {
+				errs = append(errs, err)
+			} else if user != "" {
+				return user, nil
+			}
+		}
+		return "", errors.Join(errs...)
+	}
+}
+
+type options struct {
+	tableName        string
+	userResolver     UserResolver
+	skipTables       map[string]bool
+	includeTables    map[string]bool
+	residency        map[string]string
+	domains          map[string]string
+	coldAfter        time.Duration
+	requestExtractor RequestExtractor
+	replica          *gorm.DB
+	auditDB          *gorm.DB
+	store            AuditSink
+	changedOnly      bool
+	jsonPatch        bool
+	optIn            bool
+	redactions       map[string]map[string]string
+	encryptionKeyID  string
+	redactionKey     []byte
+	layout           Layout
+	insertBatchSize  int
+	maintainLatest   bool
+	serviceName      string
+	cacheNamespace   string
+	async            *AsyncOptions
+	asyncWriter      *asyncWriter
+	faults           *Faults
+	memoryStore      *MemoryStore
+}
+
+// WithTableName stores the entries of the database in table instead of
+// AuditTable, for LayoutSingleTable, and names the other audit tables after
+// it, e.g. billing_audit_logs_latest. The table prefix of the NamingStrategy
+// isn't added to them.
+func WithTableName(table string) Option {
+	return func(o *options) {
+		o.tableName = table
+	}
+}
+
+// WithUserResolver resolves the acting user with resolver when it isn't set on
+// the session, before looking at the context, see getCurrentUser
+func WithUserResolver(resolver UserResolver) Option {
+	return func(o *options) {
+		o.userResolver = resolver
+	}
+}
+
+// WithSkipTables leaves the changes of tables out of the audit trail
+func WithSkipTables(tables ...string) Option {
+	return func(o *options) {
+		for _, table := range tables {
+			o.skipTables[table] = true
+		}
+	}
+}
+
+// WithExcludeTables leaves the changes of tables out of the audit trail, as
+// WithSkipTables
+func WithExcludeTables(tables ...string) Option {
+	return WithSkipTables(tables...)
+}
+
+// WithIncludeTables audits the changes of tables only, the changes of other
+// tables are left out. Tables excluded too aren't audited.
+func WithIncludeTables(tables ...string) Option {
+	return func(o *options) {
+		if o.includeTables == nil {
+			o.includeTables = map[string]bool{}
+		}
+		for _, table := range tables {
+			o.includeTables[table] = true
+		}
+	}
+}
+
+// WithChangedFieldsOnly stores only the fields an update changed, with the id
+// of the object, in the data and old data of its entry instead of the whole
+// object, shrinking the entries of wide tables. Summaries and field changes
+// still see the whole object. Such entries have the "changed_only" metadata,
+// Replay applies them on top of the previous version.
+func WithChangedFieldsOnly() Option {
+	return func(o *options) {
+		o.changedOnly = true
+	}
+}
+
+// WithJSONPatch adds the JSON Patch (RFC 6902) of updates, computed by the
+// Differ of the table, to their entries as the "json_patch" metadata, from
+// the object before the update to the object after it; the data and old data
+// stay the objects, see EntryJSONPatch, ApplyPatch and ReversePatch. It takes
+// precedence over WithChangedFieldsOnly.
+func WithJSONPatch() Option {
+	return func(o *options) {
+		o.jsonPatch = true
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{skipTables: map[string]bool{}, residency: map[string]string{}, domains: map[string]string{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+var defaultOptions = newOptions(nil)
+
+// audits reports whether the changes of table are audited
+func (o *options) audits(table string) bool {
+	if o.skipTables[table] {
+		return false
+	}
+	return o.includeTables == nil || o.includeTables[table]
+}
+
+// instances holds the options of every database with registered callbacks,
+// keyed by its callbacks which are shared by all of its sessions
+var instances sync.Map
+
+// configFor returns the options db was registered with, or the defaults
+func configFor(db *gorm.DB) *options {
+	if db == nil || db.Config == nil {
+		return defaultOptions
+	}
+	if o, ok := instances.Load(db.Callback()); ok {
+		return o.(*options)
+	}
+	return defaultOptions
+}
+
+// auditTable returns the table db stores entries in with LayoutSingleTable:
+// the table of the region of its context (see WithResidency), or AuditTable
+// with the table prefix of its NamingStrategy unless set with WithTableName
+func auditTable(db *gorm.DB) string {
+	if table, ok := residencyTable(db); ok {
+		return table
+	}
+	if table := configFor(db).tableName; table != "" {
+		return table
+	}
+	return tablePrefix(db) + AuditTable
+}
+
+// tablePrefix returns the TablePrefix of the NamingStrategy of db
+func tablePrefix(db *gorm.DB) string {
+	if db == nil || db.Config == nil {
+		return ""
+	}
+	switch namer := db.NamingStrategy.(type) {
+	case schema.NamingStrategy:
+		return namer.TablePrefix
+	case *schema.NamingStrategy:
+		return namer.TablePrefix
+	}
+	return ""
+}
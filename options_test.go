@@ -0,0 +1,233 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestRegisterCallbacksOptions(t *testing.T) {
+	open := func() *gorm.DB {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	billing, other := open(), open()
+	resolver := func(ctx context.Context, db *gorm.DB) (string, error) {
+		if ctx.Value(ContextKey("token")) == nil {
+			return "", errors.New("no token")
+		}
+		return "svc@example.com", nil
+	}
+	if err := RegisterCallbacks(billing,
+		WithTableName("billing_audit_logs"),
+		WithSkipTables("sessions", "carts"),
+		WithUserResolver(resolver),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	session := billing.Session(&gorm.Session{NewDB: true}).WithContext(context.Background())
+	if got := auditTable(session); got != "billing_audit_logs" {
+		t.Fatalf("got table %q", got)
+	}
+	if !isAuditTable(session, "billing_audit_logs") || isAuditTable(other, "billing_audit_logs") {
+		t.Fatal("expected billing_audit_logs to be an audit table of billing only")
+	}
+	if got := auditTable(other); got != AuditTable {
+		t.Fatalf("got table %q for unconfigured db", got)
+	}
+	if o := configFor(session); !o.skipTables["sessions"] || !o.skipTables["carts"] || o.skipTables["orders"] {
+		t.Fatalf("got skipped tables %v", o.skipTables)
+	}
+
+	withToken := billing.WithContext(context.WithValue(context.Background(), ContextKey("token"), "t"))
+	if got := getCurrentUser(withToken); got != "svc@example.com" {
+		t.Fatalf("got user %q", got)
+	}
+	withEmail := billing.WithContext(context.WithValue(context.Background(), ContextKeyEmail, "ann@example.com"))
+	if got := getCurrentUser(withEmail); got != "ann@example.com" {
+		t.Fatalf("got user %q when the resolver fails", got)
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(New(WithTableName("plugin_audit_logs"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.Config.Plugins["audited"]; !ok {
+		t.Fatal("expected the plugin to be registered")
+	}
+	if got := auditTable(db.Session(&gorm.Session{NewDB: true})); got != "plugin_audit_logs" {
+		t.Fatalf("got table %q", got)
+	}
+	if db.Callback().Create().Get("custom_plugin:create_audit_log") == nil {
+		t.Fatal("expected the create callback to be registered")
+	}
+}
+
+func TestAuditTableNamingStrategy(t *testing.T) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{TablePrefix: "billing_"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterCallbacks(db); err != nil {
+		t.Fatal(err)
+	}
+	if got := auditTable(db); got != "billing_audit_logs" {
+		t.Fatalf("got table %q", got)
+	}
+	if !isAuditTable(db, "billing_audit_logs") {
+		t.Fatal("expected the prefixed table to be an audit table")
+	}
+
+	named, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{
+		NamingStrategy: &schema.NamingStrategy{TablePrefix: "billing_"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterCallbacks(named, WithTableName("ledger_audit")); err != nil {
+		t.Fatal(err)
+	}
+	if got := auditTable(named); got != "ledger_audit" {
+		t.Fatalf("got table %q", got)
+	}
+}
+
+func TestInstanceTables(t *testing.T) {
+	open := func(prefix string, opts ...Option) *gorm.DB {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{
+			NamingStrategy: schema.NamingStrategy{TablePrefix: prefix},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := RegisterCallbacks(db, append(opts, WithStorageLayout(LayoutSplit))...); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	for _, c := range []struct {
+		db   *gorm.DB
+		want []string
+	}{
+		{open(""), []string{"audit_operations", "audit_payloads", "audit_latest", "audit_rollups", "audit_field_changes"}},
+		{open("billing_"), []string{"billing_audit_operations", "billing_audit_payloads", "billing_audit_latest",
+			"billing_audit_rollups", "billing_audit_field_changes"}},
+		{open("billing_", WithTableName("ledger_audit")), []string{"ledger_audit_operations", "ledger_audit_payloads",
+			"ledger_audit_latest", "ledger_audit_rollups", "ledger_audit_field_changes"}},
+	} {
+		got := []string{operationsTable(c.db), payloadTable(c.db), latestTable(c.db), rollupsTable(c.db),
+			fieldChangesTable(c.db)}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("got tables %q, want %q", got, c.want)
+		}
+		for _, table := range got {
+			if !isAuditTable(c.db, table) {
+				t.Errorf("expected %s to be an audit table", table)
+			}
+		}
+	}
+
+	single := open("")
+	if err := RegisterCallbacks(single, WithInsertBatchSize(50)); err != nil {
+		t.Fatal(err)
+	}
+	if splitLayout(single) || insertBatchSize(single) != 50 {
+		t.Fatal("expected the options registered last to apply")
+	}
+	if insertBatchSize(open("")) != defaultInsertBatchSize {
+		t.Fatal("expected the default insert batch size")
+	}
+}
+
+type subject string
+
+func (s subject) String() string { return "user:" + string(s) }
+
+func TestResolvers(t *testing.T) {
+	key, claimsKey := ContextKey("user"), ContextKey("claims")
+	resolve := ChainResolvers(ClaimsResolver(claimsKey, "email"), ContextResolver(key))
+
+	ctx := context.WithValue(context.Background(), claimsKey, map[string]interface{}{"email": "ann@example.com"})
+	if user, err := resolve(ctx, nil); err != nil || user != "ann@example.com" {
+		t.Fatalf("got user %q, %v from the claims", user, err)
+	}
+	ctx = context.WithValue(context.Background(), key, subject("42"))
+	if user, err := resolve(ctx, nil); err != nil || user != "user:42" {
+		t.Fatalf("got user %q, %v from the context value", user, err)
+	}
+	ctx = context.WithValue(context.Background(), claimsKey, map[string]interface{}{"email": 42})
+	if user, err := resolve(ctx, nil); err == nil || user != "" {
+		t.Fatalf("got user %q, %v for a claim that isn't a string", user, err)
+	}
+	ctx = context.WithValue(ctx, key, "bob@example.com")
+	if user, err := resolve(ctx, nil); err != nil || user != "bob@example.com" {
+		t.Fatalf("got user %q, %v after a failing resolver", user, err)
+	}
+	if user, err := resolve(context.Background(), nil); err != nil || user != "" {
+		t.Fatalf("got user %q, %v without a user", user, err)
+	}
+}
+
+func TestIncludeExcludeTables(t *testing.T) {
+	all := newOptions(nil)
+	if !all.audits("orders") {
+		t.Fatal("expected every table to be audited by default")
+	}
+	o := newOptions([]Option{WithIncludeTables("orders", "invoices"), WithExcludeTables("invoices", "sessions")})
+	for table, want := range map[string]bool{"orders": true, "invoices": false, "sessions": false, "carts": false} {
+		if got := o.audits(table); got != want {
+			t.Errorf("audits(%q) = %v, want %v", table, got, want)
+		}
+	}
+}
+
+func TestCurrentUserPrecedence(t *testing.T) {
+	open := func(opts ...Option) *gorm.DB {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := RegisterCallbacks(db, opts...); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	ctx := context.WithValue(context.Background(), ContextKeyEmail, "ann@example.com")
+	plain := open().WithContext(ctx)
+	resolved := open(WithUserResolver(func(ctx context.Context, db *gorm.DB) (string, error) {
+		return "resolved@example.com", nil
+	})).WithContext(ctx)
+
+	for _, c := range []struct {
+		name string
+		db   *gorm.DB
+		want string
+	}{
+		{"context", plain, "ann@example.com"},
+		{"setting over context", plain.Set(SettingUser, "cron@example.com"), "cron@example.com"},
+		{"empty setting", plain.Set(SettingUser, ""), "ann@example.com"},
+		{"resolver over context", resolved, "resolved@example.com"},
+		{"setting over resolver", resolved.Set(SettingUser, "cron@example.com"), "cron@example.com"},
+		{"no user", open().WithContext(context.Background()), "ctx-nonspecified"},
+	} {
+		if got := getCurrentUser(c.db); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
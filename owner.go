@@ -0,0 +1,65 @@
+package audited
+
+import (
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// OwnerOf returns the owner of record, a pointer to an object of its model,
+// e.g. the customer of an order. It is stored as the OwnerId of the entries of
+// the object, whoever made the change, and is how SubjectExport and
+// RequestSubjectErasure find the objects of a person.
+type OwnerOf func(record interface{}) string
+
+var owners = struct {
+	sync.RWMutex
+	m map[reflect.Type]OwnerOf
+}{m: map[reflect.Type]OwnerOf{}}
+
+// RegisterOwnerOf sets how the owner of the objects of model is found:
+//
+//	audited.RegisterOwnerOf(&Order{}, func(record interface{}) string {
+//		return record.(*Order).CustomerId
+//	})
+func RegisterOwnerOf(model interface{}, ownerOf OwnerOf) {
+	owners.Lock()
+	defer owners.Unlock()
+	owners.m[modelType(model)] = ownerOf
+}
+
+// ownerOf returns the owner of the object row, "" when its model has no
+// OwnerOf
+func ownerOf(row reflect.Value) string {
+	value := reflect.Indirect(row)
+	if !value.IsValid() {
+		return ""
+	}
+	owners.RLock()
+	resolve := owners.m[value.Type()]
+	owners.RUnlock()
+	if resolve == nil {
+		return ""
+	}
+	if row.Kind() != reflect.Ptr {
+		if row.CanAddr() {
+			row = row.Addr()
+		} else {
+			ptr := reflect.New(row.Type())
+			ptr.Elem().Set(row)
+			row = ptr
+		}
+	}
+	return resolve(row.Interface())
+}
+
+// OwnedTrail returns the entries of the objects owned by ownerId, oldest
+// first, e.g. every change to the orders of a customer
+func OwnedTrail(db *gorm.DB, ownerId string) ([]AuditLog, error) {
+	var entries []AuditLog
+	if err := Query(Reader(db)).Where("owner_id = ?", ownerId).Order("created_at").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
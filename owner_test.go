@@ -0,0 +1,36 @@
+package audited
+
+import (
+	"reflect"
+	"testing"
+)
+
+type ownedOrder struct {
+	Id         string
+	CustomerId string
+}
+
+func TestOwnerOf(t *testing.T) {
+	defer func() {
+		owners.Lock()
+		delete(owners.m, reflect.TypeOf(ownedOrder{}))
+		owners.Unlock()
+	}()
+	order := ownedOrder{Id: "o-1", CustomerId: "c-1"}
+	if got := ownerOf(reflect.ValueOf(order)); got != "" {
+		t.Fatalf("got owner %q without an OwnerOf", got)
+	}
+	RegisterOwnerOf(&ownedOrder{}, func(record interface{}) string {
+		return record.(*ownedOrder).CustomerId
+	})
+	orders := []ownedOrder{order}
+	for name, row := range map[string]reflect.Value{
+		"value":       reflect.ValueOf(order),
+		"pointer":     reflect.ValueOf(&order),
+		"addressable": reflect.ValueOf(orders).Index(0),
+	} {
+		if got := ownerOf(row); got != "c-1" {
+			t.Errorf("%s: got owner %q", name, got)
+		}
+	}
+}
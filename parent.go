@@ -0,0 +1,104 @@
+package audited
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxParentDepth bounds the walk up to the root of an object, against
+// parents declared in a cycle
+const maxParentDepth = 16
+
+type parentRef struct {
+	parent     interface{}
+	foreignKey string
+}
+
+var parents = struct {
+	sync.RWMutex
+	m map[reflect.Type]parentRef
+}{m: map[reflect.Type]parentRef{}}
+
+// RegisterParent declares that the objects of model belong to an object of
+// parent, whose primary key is held by the field foreignKey of model, e.g.
+//
+//	audited.RegisterParent(&OrderLine{}, &Order{}, "OrderId")
+//
+// Entries of model then carry the table and object id of their root, the
+// topmost parent, see TreeTrail.
+func RegisterParent(model, parent interface{}, foreignKey string) error {
+	typ := modelType(model)
+	if typ.Kind() != reflect.Struct {
+		return fmt.Errorf("audited: parent model must be a struct, got %s", typ)
+	}
+	if _, ok := typ.FieldByName(foreignKey); !ok {
+		return fmt.Errorf("audited: %s has no field %s", typ, foreignKey)
+	}
+	parents.Lock()
+	defer parents.Unlock()
+	parents.m[typ] = parentRef{parent: parent, foreignKey: foreignKey}
+	return nil
+}
+
+func parentOf(typ reflect.Type) (parentRef, bool) {
+	parents.RLock()
+	defer parents.RUnlock()
+	ref, ok := parents.m[typ]
+	return ref, ok
+}
+
+// rootOf returns the table and object id of the root of the object row, ""
+// when its model has no parent. Parents above the first are read from the
+// database.
+func rootOf(db *gorm.DB, row reflect.Value) (table, objectId string) {
+	record := reflect.Indirect(row)
+	for depth := 0; depth < maxParentDepth && record.IsValid(); depth++ {
+		ref, ok := parentOf(record.Type())
+		if !ok {
+			break
+		}
+		key := formatObjectId(record.FieldByName(ref.foreignKey).Interface())
+		if key == "" {
+			break
+		}
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(ref.parent); err != nil {
+			log.Println(fmt.Errorf("error parsing audit parent model: %s", err.Error()))
+			break
+		}
+		table, objectId = stmt.Schema.Table, key
+		if _, ok := parentOf(stmt.Schema.ModelType); !ok || db.DryRun || memoryStoreOf(db) != nil {
+			break
+		}
+		if len(stmt.Schema.PrimaryFields) != 1 {
+			break
+		}
+		parent := reflect.New(stmt.Schema.ModelType)
+		if err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Table(table).
+			Where(clause.Eq{Column: clause.Column{Name: stmt.Schema.PrimaryFields[0].DBName}, Value: key}).
+			First(parent.Interface()).Error; err != nil {
+			log.Println(fmt.Errorf("error reading audit parent: %s", err.Error()))
+			break
+		}
+		record = parent.Elem()
+	}
+	return table, objectId
+}
+
+// TreeTrail returns the entries of an object and of the objects below it,
+// see RegisterParent, oldest first, e.g. an order with its lines
+func TreeTrail(db *gorm.DB, table, objectId string) ([]AuditLog, error) {
+	var entries []AuditLog
+	if err := Query(Reader(db)).
+		Where("(table_name = ? AND object_id = ?) OR (root_table = ? AND root_object_id = ?)", table, objectId, table, objectId).
+		Order("created_at").
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,43 @@
+package audited
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type treeOrder struct{ Id string }
+
+type treeLine struct {
+	Id          uint
+	TreeOrderId string
+}
+
+func TestRootOf(t *testing.T) {
+	defer func() {
+		parents.Lock()
+		delete(parents.m, reflect.TypeOf(treeLine{}))
+		parents.Unlock()
+	}()
+	if err := RegisterParent(&treeLine{}, &treeOrder{}, "OrderId"); err == nil {
+		t.Fatal("registered a missing foreign key field")
+	}
+	if err := RegisterParent(&treeLine{}, &treeOrder{}, "TreeOrderId"); err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table, id := rootOf(db, reflect.ValueOf(&treeLine{Id: 1, TreeOrderId: "o-1"})); table != "tree_orders" || id != "o-1" {
+		t.Errorf("got root %s %s", table, id)
+	}
+	if table, id := rootOf(db, reflect.ValueOf(treeOrder{Id: "o-1"})); table != "" || id != "" {
+		t.Errorf("got root %s %s of a root", table, id)
+	}
+	if table, _ := rootOf(db, reflect.ValueOf(treeLine{Id: 2})); table != "" {
+		t.Errorf("got root %s without a parent key", table)
+	}
+}
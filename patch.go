@@ -0,0 +1,427 @@
+package audited
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/datatypes"
+)
+
+// DecodePatch decodes a JSON Patch, e.g. the "json_patch" metadata of an
+// entry written WithJSONPatch, keeping its numbers exact
+func DecodePatch(data []byte) (JSONPatch, error) {
+	var patch JSONPatch
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&patch); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// ApplyPatch returns doc with the operations of patch applied in order, doc
+// is left as it is. A failed operation, e.g. a test that doesn't hold or a
+// remove of a missing member, fails the whole patch.
+func ApplyPatch(doc map[string]interface{}, patch JSONPatch) (map[string]interface{}, error) {
+	var value interface{} = copyValue(doc)
+	for _, op := range patch {
+		var err error
+		if value, err = applyOperation(value, op); err != nil {
+			return nil, err
+		}
+	}
+	result, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("audited: patched document is a %T, not an object", value)
+	}
+	return result, nil
+}
+
+// ReversePatch returns the patch undoing patch applied to doc: applied to
+// the result of ApplyPatch(doc, patch) it gives doc back. Test operations
+// have nothing to undo and are left out.
+func ReversePatch(doc map[string]interface{}, patch JSONPatch) (JSONPatch, error) {
+	var value interface{} = copyValue(doc)
+	// the inverse of each operation, applied last to first
+	inverses := make([]JSONPatch, 0, len(patch))
+	for _, op := range patch {
+		inverse, err := inverseOperation(value, op)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = applyOperation(value, op); err != nil {
+			return nil, err
+		}
+		if tokens, _ := parsePointer(op.Path); len(tokens) > 0 && tokens[len(tokens)-1] == "-" {
+			// an append is undone at the index it was appended at
+			parent, _ := pointerValue(value, tokens[:len(tokens)-1])
+			if items, ok := parent.([]interface{}); ok {
+				appended := strings.TrimSuffix(op.Path, "-") + strconv.Itoa(len(items)-1)
+				if op.Op == "move" {
+					inverse[0].From = appended
+				} else {
+					inverse[0].Path = appended
+				}
+			}
+		}
+		inverses = append(inverses, inverse)
+	}
+	reverse := JSONPatch{}
+	for i := len(inverses) - 1; i >= 0; i-- {
+		reverse = append(reverse, inverses[i]...)
+	}
+	return reverse, nil
+}
+
+// inverseOperation returns the operations undoing op applied to doc, in the
+// order they are applied
+func inverseOperation(doc interface{}, op PatchOperation) (JSONPatch, error) {
+	switch op.Op {
+	case "add", "copy":
+		return inverseAdd(doc, op.Path)
+	case "remove":
+		old, err := valueAt(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return JSONPatch{{Op: "add", Path: op.Path, Value: copyValue(old)}}, nil
+	case "replace":
+		old, err := valueAt(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return JSONPatch{{Op: "replace", Path: op.Path, Value: copyValue(old)}}, nil
+	case "move":
+		inverse := JSONPatch{{Op: "move", From: op.Path, Path: op.From}}
+		// a member overwritten by the move is added back after it
+		replaced, err := inverseAdd(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if replaced[0].Op == "replace" {
+			inverse = append(inverse, PatchOperation{Op: "add", Path: op.Path, Value: replaced[0].Value})
+		}
+		return inverse, nil
+	case "test":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("audited: unknown patch operation %q", op.Op)
+}
+
+// inverseAdd returns the operation undoing a value added at path of doc: the
+// replaced value put back, or the added one removed
+func inverseAdd(doc interface{}, path string) (JSONPatch, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return JSONPatch{{Op: "replace", Path: path, Value: copyValue(doc)}}, nil
+	}
+	parent, err := pointerValue(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	if members, ok := parent.(map[string]interface{}); ok {
+		if old, ok := members[tokens[len(tokens)-1]]; ok {
+			return JSONPatch{{Op: "replace", Path: path, Value: copyValue(old)}}, nil
+		}
+	}
+	return JSONPatch{{Op: "remove", Path: path}}, nil
+}
+
+func applyOperation(doc interface{}, op PatchOperation) (interface{}, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		return addValue(doc, tokens, copyValue(op.Value))
+	case "remove":
+		return removeValue(doc, tokens)
+	case "replace":
+		if _, err := pointerValue(doc, tokens); err != nil {
+			return nil, err
+		}
+		return setValue(doc, tokens, copyValue(op.Value))
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := pointerValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			return addValue(doc, tokens, copyValue(value))
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("audited: cannot move %s into itself", op.From)
+		}
+		if doc, err = removeValue(doc, from); err != nil {
+			return nil, err
+		}
+		return addValue(doc, tokens, value)
+	case "test":
+		value, err := pointerValue(doc, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !equalJSON(value, op.Value) {
+			return nil, fmt.Errorf("audited: test of %s failed", op.Path)
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("audited: unknown patch operation %q", op.Op)
+}
+
+// addValue adds value at tokens of doc: a member set, or an element inserted
+// before the one at its index
+func addValue(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := pointerValue(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = value
+		return doc, nil
+	case []interface{}:
+		i := len(p)
+		if last != "-" {
+			if i, err = arrayIndex(last, len(p)+1); err != nil {
+				return nil, err
+			}
+		}
+		items := make([]interface{}, 0, len(p)+1)
+		items = append(append(append(items, p[:i]...), value), p[i:]...)
+		return setValue(doc, tokens[:len(tokens)-1], items)
+	}
+	return nil, fmt.Errorf("audited: no container at %s", formatPointer(tokens[:len(tokens)-1]))
+}
+
+func removeValue(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("audited: cannot remove the whole document")
+	}
+	parent, err := pointerValue(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := p[last]; !ok {
+			return nil, fmt.Errorf("audited: no member at %s", formatPointer(tokens))
+		}
+		delete(p, last)
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(p))
+		if err != nil {
+			return nil, err
+		}
+		items := make([]interface{}, 0, len(p)-1)
+		items = append(append(items, p[:i]...), p[i+1:]...)
+		return setValue(doc, tokens[:len(tokens)-1], items)
+	}
+	return nil, fmt.Errorf("audited: no container at %s", formatPointer(tokens[:len(tokens)-1]))
+}
+
+// setValue sets the existing location tokens of doc to value
+func setValue(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := pointerValue(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = value
+	case []interface{}:
+		i, err := arrayIndex(last, len(p))
+		if err != nil {
+			return nil, err
+		}
+		p[i] = value
+	default:
+		return nil, fmt.Errorf("audited: no container at %s", formatPointer(tokens[:len(tokens)-1]))
+	}
+	return doc, nil
+}
+
+func valueAt(doc interface{}, path string) (interface{}, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	return pointerValue(doc, tokens)
+}
+
+// pointerValue returns the value of doc at the location tokens
+func pointerValue(doc interface{}, tokens []string) (interface{}, error) {
+	value := doc
+	for i, token := range tokens {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			member, ok := v[token]
+			if !ok {
+				return nil, fmt.Errorf("audited: no member at %s", formatPointer(tokens[:i+1]))
+			}
+			value = member
+		case []interface{}:
+			index, err := arrayIndex(token, len(v))
+			if err != nil {
+				return nil, err
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("audited: no container at %s", formatPointer(tokens[:i]))
+		}
+	}
+	return value, nil
+}
+
+// arrayIndex parses token as an index of an array, below n
+func arrayIndex(token string, n int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("audited: invalid array index %q", token)
+	}
+	if i >= n {
+		return 0, fmt.Errorf("audited: array index %d out of range", i)
+	}
+	return i, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("audited: invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func formatPointer(tokens []string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteString("/" + escapePointer(token))
+	}
+	return b.String()
+}
+
+// copyValue deep copies a decoded JSON value, so patching it leaves the
+// original as it is
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = copyValue(item)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = copyValue(item)
+		}
+		return items
+	}
+	return value
+}
+
+// equalJSON compares two JSON values by their canonical form, 1.0 and 1 are
+// equal
+func equalJSON(a, b interface{}) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	ca, errA := Canonicalize(ja)
+	cb, errB := Canonicalize(jb)
+	return errA == nil && errB == nil && bytes.Equal(ca, cb)
+}
+
+// EntryJSONPatch returns the JSON Patch of an entry written WithJSONPatch,
+// from the object before the update to the object after it, and nil for other
+// entries. The data of entries written before the patch moved to the metadata
+// is the patch itself.
+func EntryJSONPatch(entry AuditLog) (JSONPatch, error) {
+	switch patch := entry.Metadata["json_patch"].(type) {
+	case string:
+		return DecodePatch([]byte(patch))
+	case bool:
+		if patch {
+			return DecodePatch(entry.Data)
+		}
+	}
+	return nil, nil
+}
+
+// patchData returns the JSON Patch from oldData to newData, two snapshots of
+// table, computed by the Differ of the table
+func patchData(table string, oldData, newData datatypes.JSON) (datatypes.JSON, error) {
+	oldMap, err := decodeSnapshot(oldData)
+	if err != nil {
+		return nil, err
+	}
+	newMap, err := decodeSnapshot(newData)
+	if err != nil {
+		return nil, err
+	}
+	patch := PatchOf(differFor(table)(oldMap, newMap))
+	sort.SliceStable(patch, func(i, j int) bool { return patch[i].Path < patch[j].Path })
+	return canonicalJSON(patch)
+}
+
+func canonicalJSON(value interface{}) (datatypes.JSON, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return Canonicalize(data)
+}
+
+// renamePatch moves the operations of a patch of table to the current names
+// of the fields they touch, see RegisterRename
+func renamePatch(table string, patch JSONPatch) JSONPatch {
+	rename := func(path string) string {
+		tokens, err := parsePointer(path)
+		if err != nil || len(tokens) == 0 {
+			return path
+		}
+		tokens[0] = currentName(table, tokens[0])
+		return formatPointer(tokens)
+	}
+	for i := range patch {
+		patch[i].Path = rename(patch[i].Path)
+		if patch[i].From != "" {
+			patch[i].From = rename(patch[i].From)
+		}
+	}
+	return patch
+}
@@ -0,0 +1,145 @@
+package audited
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func decodeDoc(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	doc, err := decodeSnapshot(datatypes.JSON(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestApplyAndReversePatch(t *testing.T) {
+	cases := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"add member", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`},
+		{"add replaces member", `{"a":1}`, `[{"op":"add","path":"/a","value":[1]}]`, `{"a":[1]}`},
+		{"insert element", `{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`},
+		{"append element", `{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`},
+		{"remove", `{"a":{"b":1,"c":2}}`, `[{"op":"remove","path":"/a/b"}]`, `{"a":{"c":2}}`},
+		{"remove element", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/0"}]`, `{"a":[2,3]}`},
+		{"replace", `{"a/b":1}`, `[{"op":"replace","path":"/a~1b","value":null}]`, `{"a/b":null}`},
+		{"move", `{"a":{"b":1},"c":2}`, `[{"op":"move","from":"/a/b","path":"/c"}]`, `{"a":{},"c":1}`},
+		{"move element", `{"a":[1,2,3]}`, `[{"op":"move","from":"/a/0","path":"/a/-"}]`, `{"a":[2,3,1]}`},
+		{"copy", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"}]`, `{"a":{"b":1},"c":{"b":1}}`},
+		{"test", `{"a":1.0}`, `[{"op":"test","path":"/a","value":1},{"op":"remove","path":"/a"}]`, `{}`},
+		{"sequence", `{"a":[1]}`,
+			`[{"op":"add","path":"/a/-","value":2},{"op":"replace","path":"/a/0","value":0},{"op":"add","path":"/b","value":"x"}]`,
+			`{"a":[0,2],"b":"x"}`},
+	}
+	for _, c := range cases {
+		doc := decodeDoc(t, c.doc)
+		patch, err := DecodePatch([]byte(c.patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := ApplyPatch(doc, patch)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if got, _ := json.Marshal(after); string(got) != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+		if got, _ := json.Marshal(doc); !reflect.DeepEqual(doc, decodeDoc(t, c.doc)) {
+			t.Errorf("%s: document changed to %s", c.name, got)
+		}
+
+		reverse, err := ReversePatch(doc, patch)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		before, err := ApplyPatch(after, reverse)
+		if err != nil {
+			t.Errorf("%s: reverse: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(before, doc) {
+			got, _ := json.Marshal(before)
+			t.Errorf("%s: got %s reversed, want %s", c.name, got, c.doc)
+		}
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	doc := decodeDoc(t, `{"a":[1],"b":1}`)
+	for _, patch := range []string{
+		`[{"op":"remove","path":"/c"}]`,
+		`[{"op":"replace","path":"/c","value":1}]`,
+		`[{"op":"add","path":"/a/2","value":1}]`,
+		`[{"op":"add","path":"/a/01","value":1}]`,
+		`[{"op":"add","path":"/b/c","value":1}]`,
+		`[{"op":"test","path":"/b","value":2}]`,
+		`[{"op":"move","from":"/a","path":"/a/0"}]`,
+		`[{"op":"add","path":"b","value":1}]`,
+		`[{"op":"undo","path":"/b"}]`,
+		`[{"op":"add","path":"/b","value":2},{"op":"remove","path":"/c"}]`,
+	} {
+		p, err := DecodePatch([]byte(patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ApplyPatch(doc, p); err == nil {
+			t.Errorf("%s: applied", patch)
+		}
+	}
+	if doc["b"] != json.Number("1") {
+		t.Errorf("document changed to %v", doc)
+	}
+}
+
+func TestPatchData(t *testing.T) {
+	before := datatypes.JSON(`{"id":"7","name":"gear","tags":["a"],"fax":"555"}`)
+	after := datatypes.JSON(`{"id":"7","name":"cog","tags":["a","b"],"sku":"G-1"}`)
+	forward, err := patchData("parts", before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(forward) != `[{"op":"remove","path":"/fax"},{"op":"replace","path":"/name","value":"cog"},`+
+		`{"op":"add","path":"/sku","value":"G-1"},{"op":"replace","path":"/tags","value":["a","b"]}]` {
+		t.Errorf("got forward patch %s", forward)
+	}
+
+	entry := AuditLog{Id: "2", TableName: "parts", OperationType: OperationUpdate, Data: after, OldData: before,
+		Metadata: datatypes.JSONMap{"json_patch": string(forward)}}
+	patch, err := EntryJSONPatch(entry)
+	if err != nil || len(patch) != 4 {
+		t.Fatalf("got %v, %v, want the 4 operations", patch, err)
+	}
+	if none, err := EntryJSONPatch(AuditLog{Data: after}); none != nil || err != nil {
+		t.Fatalf("got %v, %v for an entry without patch", none, err)
+	}
+
+	// the patches stored as data before keep replaying
+	legacy := entry
+	legacy.Data, legacy.Metadata = forward, datatypes.JSONMap{"json_patch": true}
+	if patch, err := EntryJSONPatch(legacy); err != nil || len(patch) != 4 {
+		t.Fatalf("got %v, %v, want the 4 operations of the data", patch, err)
+	}
+	for _, update := range []AuditLog{entry, legacy} {
+		entries := []AuditLog{{Id: "1", TableName: "parts", OperationType: OperationCreate, Data: before}, update}
+		versions, drift, err := replay(entries, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := json.Marshal(versions[1].Data); string(got) != `{"fax":null,"id":"7","name":"cog","sku":"G-1","tags":["a","b"]}` {
+			t.Errorf("got version %s", got)
+		}
+		if len(drift) != 2 {
+			t.Errorf("got drift %+v", drift)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package audited
+
+import "gorm.io/gorm"
+
+// Plugin installs the audit callbacks as a gorm plugin:
+//
+//	db.Use(audited.New(audited.WithSkipTables("sessions")))
+type Plugin struct {
+	opts []Option
+}
+
+// New returns a Plugin auditing the database it is used with, configured by
+// opts like RegisterCallbacks
+func New(opts ...Option) *Plugin {
+	return &Plugin{opts: opts}
+}
+
+// Name implements gorm.Plugin
+func (p *Plugin) Name() string {
+	return "audited"
+}
+
+// Initialize implements gorm.Plugin
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	return RegisterCallbacks(db, p.opts...)
+}
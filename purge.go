@@ -0,0 +1,212 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PurgesTable stores requests to purge the history of objects, which are
+// kept as tombstones once carried out
+const PurgesTable = "audit_purges"
+
+// OperationPurge is the operation of the tombstone entry written in place of
+// the purged history of an object
+const OperationPurge = "PURGE"
+
+// PurgeMode is how the history of an object is purged
+type PurgeMode string
+
+const (
+	// PurgeDelete deletes the entries of the object
+	PurgeDelete PurgeMode = "delete"
+	// PurgeAnonymize keeps the entries of the object without their data,
+	// acting user, owner, root object, request, summary and metadata
+	PurgeAnonymize PurgeMode = "anonymize"
+)
+
+// PurgeRequest is a pending or, once PurgedAt is set, carried out purge of
+// the history of an object
+type PurgeRequest struct {
+	TableName   string     `json:"table_name" gorm:"primaryKey"`
+	ObjectId    string     `json:"object_id" gorm:"primaryKey"`
+	Mode        PurgeMode  `json:"mode"`
+	RequestedBy string     `json:"requested_by"`
+	RequestedAt time.Time  `json:"requested_at"`
+	PurgedAt    *time.Time `json:"purged_at"`
+	// Entries is the number of entries purged
+	Entries int64 `json:"entries"`
+}
+
+var cascadePurges = struct {
+	sync.RWMutex
+	models map[reflect.Type]PurgeMode
+}{models: map[reflect.Type]PurgeMode{}}
+
+// RegisterCascadePurge purges the history of objects of models when they are
+// hard deleted, in the next PurgeHistory run. Soft deletes keep the history.
+func RegisterCascadePurge(mode PurgeMode, models ...interface{}) {
+	cascadePurges.Lock()
+	defer cascadePurges.Unlock()
+	for _, model := range models {
+		cascadePurges.models[modelType(model)] = mode
+	}
+}
+
+// RequestErasure queues the purge of the history of an object for the next
+// PurgeHistory run, e.g. for a right to erasure request
+func RequestErasure(ctx context.Context, db *gorm.DB, table, objectId string, mode PurgeMode) error {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: ctx})
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := queuePurge(tx, table, objectId, mode, getCurrentUser(tx)); err != nil {
+			return err
+		}
+		return RecordAdminAction(ctx, tx, MetaErasure,
+			map[string]interface{}{"table_name": table, "object_id": objectId, "mode": string(mode)})
+	})
+}
+
+// queuePurge stores a pending request, requeueing a carried out one
+func queuePurge(db *gorm.DB, table, objectId string, mode PurgeMode, user string) error {
+	return db.Table(PurgesTable).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "table_name"}, {Name: "object_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"mode", "requested_by", "requested_at", "purged_at", "entries"}),
+	}).Create(&PurgeRequest{
+		TableName:   table,
+		ObjectId:    objectId,
+		Mode:        mode,
+		RequestedBy: user,
+		RequestedAt: time.Now(),
+	}).Error
+}
+
+// cascadePurge queues the purge of the history of hard deleted objects of
+// models registered with RegisterCascadePurge
+func cascadePurge(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.RowsAffected == 0 || isAuditTable(db, db.Statement.Table) {
+		return
+	}
+	cascadePurges.RLock()
+	mode, ok := cascadePurges.models[db.Statement.Schema.ModelType]
+	cascadePurges.RUnlock()
+	if !ok || (hasSoftDelete(db) && !db.Statement.Unscoped) {
+		return
+	}
+	rows := statementRows(db)
+	if matched, ok := db.InstanceGet(settingBatchRows); ok {
+		rows = matched.([]reflect.Value)
+	}
+	for _, row := range rows {
+		key, ok := primaryKey(db.Statement.Context, db.Statement.Schema, row)
+		if !ok {
+			continue
+		}
+		if err := queuePurge(auditConn(db).Session(&gorm.Session{NewDB: true, SkipHooks: true}),
+			db.Statement.Table, key.objectId(), mode, getCurrentUser(db)); err != nil {
+			log.Println(fmt.Errorf("error queueing audit history purge: %s", err.Error()))
+		}
+	}
+}
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+func hasSoftDelete(db *gorm.DB) bool {
+	for _, field := range db.Statement.Schema.Fields {
+		if field.FieldType == deletedAtType {
+			return true
+		}
+	}
+	return false
+}
+
+// PurgeHistory carries out the pending purge requests, each in its own
+// transaction, and returns the number carried out. The field changes and last
+// change of the objects are deleted in both modes, and a tombstone entry with
+// the OperationPurge operation records that their history was removed.
+func PurgeHistory(ctx context.Context, db *gorm.DB) (int, error) {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: ctx})
+	var pending []PurgeRequest
+	if err := db.Table(PurgesTable).Where("purged_at IS NULL").Order("requested_at").
+		Find(&pending).Error; err != nil {
+		return 0, err
+	}
+	for i, request := range pending {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return purgeObject(tx, request)
+		}); err != nil {
+			return i, err
+		}
+		invalidateTrails(db, []AuditLog{{TableName: request.TableName, ObjectId: request.ObjectId}})
+	}
+	return len(pending), nil
+}
+
+func purgeObject(tx *gorm.DB, request PurgeRequest) error {
+	quote := tx.Statement.Quote
+	cond := "table_name = ? AND object_id = ? AND operation_type <> ?"
+	args := []interface{}{request.TableName, request.ObjectId, OperationPurge}
+
+	payloads, entries := "DELETE FROM "+quote(payloadTable(tx)), "DELETE FROM %s"
+	if request.Mode == PurgeAnonymize {
+		payloads = "UPDATE " + quote(payloadTable(tx)) + " SET data = NULL, old_data = NULL"
+		entries = "UPDATE %s SET user_id = '', owner_id = '', root_object_id = '', actor_ip = '', " +
+			"user_agent = '', request_id = '', session_id = '', idempotency_key = '', summary = '', metadata = NULL"
+		if !splitLayout(tx) {
+			entries += ", data = NULL, old_data = NULL"
+		}
+	}
+	if splitLayout(tx) {
+		if err := tx.Exec(payloads+" WHERE id IN (SELECT id FROM "+quote(operationsTable(tx))+" WHERE "+cond+")",
+			args...).Error; err != nil {
+			return err
+		}
+	}
+	var purged int64
+	for _, table := range tiered(tx, operationsTable(tx)) {
+		result := tx.Exec(fmt.Sprintf(entries, quote(table))+" WHERE "+cond, args...)
+		if result.Error != nil {
+			return result.Error
+		}
+		purged += result.RowsAffected
+	}
+	if usesLongFormat() {
+		if err := tx.Exec("DELETE FROM "+quote(fieldChangesTable(tx))+" WHERE table_name = ? AND object_id = ?",
+			request.TableName, request.ObjectId).Error; err != nil {
+			return err
+		}
+	}
+	// the last change keeps the acting user, the tombstone takes its place
+	if configFor(tx).maintainLatest {
+		if err := tx.Exec("DELETE FROM "+quote(latestTable(tx))+" WHERE table_name = ? AND object_id = ?",
+			request.TableName, request.ObjectId).Error; err != nil {
+			return err
+		}
+	}
+
+	tombstone := []AuditLog{{
+		Id:            newID(),
+		TableName:     request.TableName,
+		OperationType: OperationPurge,
+		ObjectId:      request.ObjectId,
+		UserId:        request.RequestedBy,
+		Metadata:      map[string]interface{}{"mode": string(request.Mode), "entries": purged},
+	}}
+	if err := insertAuditLogs(tx, tombstone); err != nil {
+		return err
+	}
+	if err := RecordAdminAction(tx.Statement.Context, tx, MetaPurge, map[string]interface{}{
+		"table_name": request.TableName, "object_id": request.ObjectId, "mode": string(request.Mode),
+		"requested_by": request.RequestedBy, "entries": purged,
+	}); err != nil {
+		return err
+	}
+	return tx.Table(PurgesTable).
+		Where("table_name = ? AND object_id = ?", request.TableName, request.ObjectId).
+		Updates(map[string]interface{}{"purged_at": time.Now(), "entries": purged}).Error
+}
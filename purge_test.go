@@ -0,0 +1,21 @@
+package audited
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type Archived struct {
+	Id        string `json:"id"`
+	DeletedAt gorm.DeletedAt
+}
+
+func TestHasSoftDelete(t *testing.T) {
+	if !hasSoftDelete(statementFor(t, &Archived{})) {
+		t.Fatal("model with a DeletedAt field is not soft deleted")
+	}
+	if hasSoftDelete(statementFor(t, &Subscriber{})) {
+		t.Fatal("model without a DeletedAt field is soft deleted")
+	}
+}
@@ -0,0 +1,225 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Overflow behaviors of a tenant storing more entries than its quota, see
+// QuotaOptions
+const (
+	// OverflowEvict deletes the oldest entries of the tenant
+	OverflowEvict = "evict"
+	// OverflowSummarize replaces the oldest entries of the tenant with a
+	// summary entry per table and operation, with the number of entries it
+	// replaces
+	OverflowSummarize = "summarize"
+	// OverflowAlert keeps the entries and only calls QuotaOptions.OnOverflow
+	OverflowAlert = "alert"
+)
+
+// QuotaOptions limits the number of entries each tenant stores, see
+// WithTenant, so a noisy tenant can't use up the storage of the audit trail
+type QuotaOptions struct {
+	// MaxEntries is the number of entries a tenant may store, zero for no
+	// limit
+	MaxEntries int64
+	// Tenants sets the quota of tenants, taking precedence over MaxEntries
+	Tenants map[string]int64
+	// Overflow is applied to the tenants over their quota, OverflowAlert by
+	// default
+	Overflow string
+	// OnOverflow is called for every tenant over its quota, e.g. to alert
+	OnOverflow func(ctx context.Context, usage TenantUsage)
+}
+
+// TenantUsage is the number of entries a tenant stored over its quota
+type TenantUsage struct {
+	TenantId string `json:"tenant_id"`
+	Entries  int64  `json:"entries"`
+	Quota    int64  `json:"quota"`
+	// Removed is the number of entries evicted or summarized
+	Removed int64 `json:"removed"`
+}
+
+func (o QuotaOptions) quota(tenant string) int64 {
+	if quota, ok := o.Tenants[tenant]; ok {
+		return quota
+	}
+	return o.MaxEntries
+}
+
+// EnforceQuotas applies the Overflow of opts to the tenants storing more
+// entries than their quota, and returns their usage. Summary entries count
+// towards the quota.
+func EnforceQuotas(ctx context.Context, db *gorm.DB, opts QuotaOptions) ([]TenantUsage, error) {
+	switch opts.Overflow {
+	case "":
+		opts.Overflow = OverflowAlert
+	case OverflowEvict, OverflowSummarize, OverflowAlert:
+	default:
+		return nil, fmt.Errorf("audited: unknown quota overflow %q", opts.Overflow)
+	}
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	var counts []TenantUsage
+	if err := QueryOperations(db).Select("tenant_id, COUNT(*) AS entries").
+		Where("tenant_id IS NOT NULL AND tenant_id <> ''").
+		Group("tenant_id").Order("tenant_id").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+
+	var over []TenantUsage
+	for _, usage := range counts {
+		usage.Quota = opts.quota(usage.TenantId)
+		if usage.Quota <= 0 || usage.Entries <= usage.Quota {
+			continue
+		}
+		if opts.Overflow != OverflowAlert {
+			removed, err := shrinkTenant(db, usage.TenantId, usage.Entries-usage.Quota, opts.Overflow == OverflowSummarize)
+			usage.Removed = removed
+			if err != nil {
+				return append(over, usage), err
+			}
+			if err := RecordAdminAction(ctx, db, MetaQuota, map[string]interface{}{
+				"tenant": usage.TenantId, "overflow": opts.Overflow, "removed": removed,
+			}); err != nil {
+				return append(over, usage), err
+			}
+		}
+		if opts.OnOverflow != nil {
+			opts.OnOverflow(ctx, usage)
+		}
+		over = append(over, usage)
+	}
+	return over, nil
+}
+
+// quotaSummary counts the entries of a table and operation replaced by a
+// summary entry
+type quotaSummary struct {
+	rows     int64
+	from, to time.Time
+}
+
+// shrinkTenant deletes the excess oldest entries of tenant in batches, and
+// writes the summary entries replacing the entries of a batch in the
+// transaction deleting them when summarize is set, so entries are never lost
+// without their summary
+func shrinkTenant(db *gorm.DB, tenant string, excess int64, summarize bool) (int64, error) {
+	var removed int64
+	for removed < excess {
+		limit := excess - removed
+		if limit > defaultSeqLimit {
+			limit = defaultSeqLimit
+		}
+		var deleted int64
+		var batch []AuditLog
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := QueryOperations(tx).Scopes(ForTenant(tenant)).Order("created_at, seq").Limit(int(limit)).
+				Find(&batch).Error; err != nil {
+				return err
+			}
+			if len(batch) == 0 {
+				return nil
+			}
+			ids := make([]ID, len(batch))
+			for i, entry := range batch {
+				ids[i] = entry.Id
+			}
+			var err error
+			if deleted, err = deleteEntryIds(tx, ids); err != nil {
+				return err
+			}
+			if !summarize {
+				return nil
+			}
+			return saveAuditLogs(tx, quotaSummaries(tenant, batch))
+		})
+		if err != nil {
+			return removed, err
+		}
+		if deleted == 0 {
+			break
+		}
+		invalidateTrails(db, batch)
+		removed += deleted
+	}
+	return removed, nil
+}
+
+// quotaSummaries returns the summary entries replacing entries of tenant, one
+// per table and operation
+func quotaSummaries(tenant string, entries []AuditLog) []AuditLog {
+	summaries := map[[2]string]*quotaSummary{}
+	var order [][2]string
+	for _, entry := range entries {
+		key := [2]string{entry.TableName, entry.OperationType}
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &quotaSummary{from: entry.CreatedAt}
+			summaries[key] = summary
+			order = append(order, key)
+		}
+		// summaries summarized again keep the count of what they replaced
+		if rows := metadataInt(entry.Metadata["rows"]); rows > 0 && entry.Metadata["summarized"] == true {
+			summary.rows += int64(rows)
+		} else {
+			summary.rows++
+		}
+		if entry.CreatedAt.Before(summary.from) {
+			summary.from = entry.CreatedAt
+		}
+		if entry.CreatedAt.After(summary.to) {
+			summary.to = entry.CreatedAt
+		}
+	}
+
+	logs := make([]AuditLog, 0, len(order))
+	for _, key := range order {
+		summary := summaries[key]
+		entry := AuditLog{
+			Id:            newID(),
+			TableName:     key[0],
+			OperationType: key[1],
+			TenantId:      tenant,
+			Summary:       fmt.Sprintf("%s of %d rows summarized over quota", key[1], summary.rows),
+			// in place of the entries it replaces
+			CreatedAt: summary.to,
+		}
+		entry.SetMetadata("summarized", true)
+		entry.SetMetadata("rows", summary.rows)
+		entry.SetMetadata("from", summary.from.UTC().Format(time.RFC3339))
+		logs = append(logs, entry)
+	}
+	return logs
+}
+
+// deleteEntryIds deletes the entries of ids, with their payloads and field
+// changes, in the storage layout
+func deleteEntryIds(db *gorm.DB, ids []ID) (int64, error) {
+	var deleted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		quote := tx.Statement.Quote
+		if splitLayout(tx) {
+			if err := tx.Exec("DELETE FROM "+quote(payloadTable(tx))+" WHERE id IN ?", ids).Error; err != nil {
+				return err
+			}
+		}
+		for _, table := range tiered(tx, operationsTable(tx)) {
+			result := tx.Exec("DELETE FROM "+quote(table)+" WHERE id IN ?", ids)
+			if result.Error != nil {
+				return result.Error
+			}
+			deleted += result.RowsAffected
+		}
+		if !usesLongFormat() {
+			return nil
+		}
+		return tx.Exec("DELETE FROM "+quote(fieldChangesTable(tx))+" WHERE audit_id IN ?", ids).Error
+	})
+	return deleted, err
+}
@@ -0,0 +1,55 @@
+package audited
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	opts := QuotaOptions{MaxEntries: 100, Tenants: map[string]int64{"acme": 1000, "trial": 0}}
+	for tenant, want := range map[string]int64{"acme": 1000, "trial": 0, "other": 100} {
+		if got := opts.quota(tenant); got != want {
+			t.Errorf("got quota %d for %s, want %d", got, tenant, want)
+		}
+	}
+
+	db := statementFor(t, &Invoice{})
+	if _, err := EnforceQuotas(context.Background(), db, QuotaOptions{Overflow: "drop"}); err == nil {
+		t.Fatal("expected an unknown overflow to fail")
+	}
+}
+
+func TestQuotaSummaries(t *testing.T) {
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	entries := []AuditLog{
+		{TableName: "invoices", OperationType: "UPDATE", CreatedAt: start},
+		{TableName: "invoices", OperationType: "UPDATE", CreatedAt: start.Add(time.Hour)},
+		{TableName: "invoices", OperationType: "CREATE", CreatedAt: start.Add(2 * time.Hour)},
+	}
+	summarized := AuditLog{TableName: "invoices", OperationType: "UPDATE", CreatedAt: start.Add(3 * time.Hour)}
+	summarized.SetMetadata("summarized", true)
+	summarized.SetMetadata("rows", int64(5))
+	entries = append(entries, summarized)
+
+	logs := quotaSummaries("acme", entries)
+	if len(logs) != 2 {
+		t.Fatalf("got %d summaries, want 2", len(logs))
+	}
+	update := logs[0]
+	if update.OperationType != "UPDATE" || update.TenantId != "acme" {
+		t.Fatalf("got summary %+v", update)
+	}
+	if rows := metadataInt(update.Metadata["rows"]); rows != 7 {
+		t.Errorf("got %d rows, want 7", rows)
+	}
+	if !update.CreatedAt.Equal(start.Add(3 * time.Hour)) {
+		t.Errorf("got created at %s, want the newest replaced entry", update.CreatedAt)
+	}
+	if from := update.Metadata["from"]; from != start.Format(time.RFC3339) {
+		t.Errorf("got from %v", from)
+	}
+	if rows := metadataInt(logs[1].Metadata["rows"]); rows != 1 {
+		t.Errorf("got %d rows created, want 1", rows)
+	}
+}
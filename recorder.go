@@ -0,0 +1,137 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var contextKeyRecorder = ContextKey("audited_recorder")
+
+// Recorder collects the audit entries written with a context, e.g. during an
+// http request, so they can be read back once the work is done
+type Recorder struct {
+	mu      sync.Mutex
+	entries []AuditLog
+	// defer writes until Flush
+	deferWrites bool
+	pending     []AuditLog
+}
+
+// WithRecorder returns a copy of ctx carrying a new Recorder
+func WithRecorder(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyRecorder, &Recorder{})
+}
+
+// WithDeferredFlush returns a copy of ctx carrying a new Recorder that holds
+// audit entries back instead of writing them, until Flush writes them all in a
+// single insert. Entries of changes made in a transaction are still written in
+// the transaction, so they are rolled back with it.
+func WithDeferredFlush(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyRecorder, &Recorder{deferWrites: true})
+}
+
+// FromContext returns the Recorder of ctx, or nil if it has none. A nil
+// Recorder has no entries.
+func FromContext(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(contextKeyRecorder).(*Recorder)
+	return r
+}
+
+// Entries returns the audit entries written so far, in write order
+func (r *Recorder) Entries() []AuditLog {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := make([]AuditLog, len(r.entries))
+	copy(entries, r.entries)
+	return entries
+}
+
+// Pending returns the entries held back by a deferred Recorder
+func (r *Recorder) Pending() []AuditLog {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := make([]AuditLog, len(r.pending))
+	copy(pending, r.pending)
+	return pending
+}
+
+// Flush writes the entries held back by the Recorder of ctx in one insert. It
+// is a no-op for contexts without a deferred Recorder.
+func Flush(ctx context.Context, db *gorm.DB) error {
+	r := FromContext(ctx)
+	if !r.deferred() {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) == 0 {
+		return nil
+	}
+	if err := saveAuditLogs(db.WithContext(ctx), r.pending); err != nil {
+		return err
+	}
+	r.entries = append(r.entries, r.pending...)
+	r.pending = nil
+	return nil
+}
+
+// Middleware defers the audit entries of each request and flushes them once
+// the handler returns. It also captures the client IP of the request, see
+// WithClientIP.
+func Middleware(db *gorm.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := WithDeferredFlush(r.Context())
+			if ClientIP(ctx) == "" {
+				if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+					ctx = WithClientIP(ctx, host)
+				}
+			}
+			if _, ok := ctx.Value(contextKeyRequest).(RequestInfo); !ok {
+				ctx = WithRequestInfo(ctx, httpRequestInfo(r))
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+			if err := Flush(ctx, db); err != nil {
+				log.Println(fmt.Errorf("error flushing audit logs: %s", err.Error()))
+			}
+		})
+	}
+}
+
+// inTransaction reports whether db runs inside a transaction
+func inTransaction(db *gorm.DB) bool {
+	_, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
+
+func (r *Recorder) deferred() bool {
+	return r != nil && r.deferWrites
+}
+
+func (r *Recorder) enqueue(entries ...AuditLog) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, entries...)
+}
+
+func (r *Recorder) record(entries ...AuditLog) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entries...)
+}
@@ -0,0 +1,96 @@
+package audited
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+
+	"gorm.io/gorm/schema"
+)
+
+// Redaction strategies, selected with the `audited:"mask"` or
+// `audited:"hash"` tag of a field, or with WithRedaction
+const (
+	// RedactMask replaces the value with "***", changes to it aren't visible
+	RedactMask = "mask"
+	// RedactHash replaces the value with its hex encoded HMAC-SHA256 keyed
+	// with the key given to WithRedactionKey, changes to it are visible
+	// without the value
+	RedactHash = "hash"
+)
+
+// WithRedactionKey keys the hashes of the RedactHash fields of the database
+// with key. Without it values are hashed with plain SHA-256, which low entropy
+// values such as emails or phone numbers can be recovered from by guessing.
+func WithRedactionKey(key []byte) Option {
+	return func(o *options) {
+		o.redactionKey = key
+	}
+}
+
+// WithRedaction redacts the fields of the snapshots of table, by key, with
+// their strategy, RedactMask or RedactHash, e.g. for models the app can't
+// tag:
+//
+//	audited.WithRedaction("users", map[string]string{"email": audited.RedactHash, "phone": audited.RedactMask})
+func WithRedaction(table string, fields map[string]string) Option {
+	return func(o *options) {
+		if o.redactions == nil {
+			o.redactions = map[string]map[string]string{}
+		}
+		if o.redactions[table] == nil {
+			o.redactions[table] = map[string]string{}
+		}
+		for key, strategy := range fields {
+			o.redactions[table][key] = strategy
+		}
+	}
+}
+
+// redact replaces the value of key in data per strategy, hashes are keyed
+// with secret, null values are kept
+func redact(secret []byte, strategy, key string, data map[string]interface{}) {
+	value, ok := data[key]
+	if !ok || value == nil {
+		return
+	}
+	switch strategy {
+	case RedactMask:
+		data[key] = defaultMaskValue
+	case RedactHash:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			data[key] = defaultMaskValue
+			return
+		}
+		data[key] = redactionHash(secret, encoded)
+	default:
+		log.Printf("unknown redaction strategy %q on field %s", strategy, key)
+		data[key] = defaultMaskValue
+	}
+}
+
+func redactionHash(secret, value []byte) string {
+	if len(secret) == 0 {
+		sum := sha256.Sum256(value)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(value)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashesFields reports whether s has fields tagged `audited:"hash"`
+func hashesFields(s *schema.Schema) bool {
+	if s == nil {
+		return false
+	}
+	for _, field := range s.Fields {
+		if _, ok := parseTag(field.Tag.Get(TagName))[RedactHash]; ok {
+			return true
+		}
+	}
+	return false
+}
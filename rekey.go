@@ -0,0 +1,137 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RekeyStatus is the progress of a Rekey run
+type RekeyStatus struct {
+	// Cursor is the Seq of the last entry looked at, a restarted run resumes
+	// after it
+	Cursor int64 `json:"cursor"`
+	// Scanned is the number of entries looked at by this run
+	Scanned int64 `json:"scanned"`
+	// Rekeyed is the number of payloads re-encrypted by this run
+	Rekeyed int64 `json:"rekeyed"`
+}
+
+// RekeyProgress is called after every batch of Rekey, e.g. to log progress
+var RekeyProgress func(status RekeyStatus)
+
+// Rekey re-encrypts the payloads encrypted with oldKeyID with newKeyID, in
+// batches of batchSize entries, each in its own transaction. The position is
+// stored as a checkpoint (see Consume) after every batch so an interrupted run
+// picks up where it stopped. Both keys need a registered KeyProvider; register
+// the callbacks WithEncryption(newKeyID) first so no new payloads are
+// encrypted with the old key while it runs. Payloads written before they were
+// bound to their entries are bound when they are re-encrypted.
+func Rekey(ctx context.Context, db *gorm.DB, oldKeyID, newKeyID string, batchSize int) (RekeyStatus, error) {
+	if oldKeyID == "" || newKeyID == "" || oldKeyID == newKeyID {
+		return RekeyStatus{}, errors.New("audited: rekey needs two different key ids")
+	}
+	if _, err := keyProvider(newKeyID); err != nil {
+		return RekeyStatus{}, err
+	}
+	if batchSize <= 0 {
+		batchSize = defaultConsumerBatchSize
+	}
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	name := "rekey:" + oldKeyID + ">" + newKeyID
+	if err := db.Table(CheckpointsTable).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&checkpoint{Name: name, UpdatedAt: time.Now()}).Error; err != nil {
+		return RekeyStatus{}, err
+	}
+
+	var status RekeyStatus
+	for {
+		scanned := 0
+		var cursor, rekeyed int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var current checkpoint
+			if err := tx.Table(CheckpointsTable).Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("name = ?", name).Take(&current).Error; err != nil {
+				return err
+			}
+			// the payloads are read as stored, without decrypting them
+			var entries []AuditLog
+			if err := Query(tx.Session(&gorm.Session{SkipHooks: true})).Where("seq > ?", current.Seq).
+				Order("seq").Limit(batchSize).Find(&entries).Error; err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				updates, err := rekeyEntry(ctx, entry, oldKeyID, newKeyID)
+				if err != nil {
+					return err
+				}
+				if len(updates) == 0 {
+					continue
+				}
+				for _, table := range tiered(tx, payloadTable(tx)) {
+					if err := tx.Table(table).Where("id = ?", entry.Id).
+						Updates(updates).Error; err != nil {
+						return err
+					}
+				}
+				rekeyed += int64(len(updates))
+			}
+			scanned, cursor = len(entries), current.Seq
+			if scanned == 0 {
+				return nil
+			}
+			cursor = entries[scanned-1].Seq
+			return tx.Table(CheckpointsTable).Where("name = ?", name).
+				Updates(map[string]interface{}{"seq": cursor, "updated_at": time.Now()}).Error
+		})
+		if err != nil {
+			return status, err
+		}
+		// counted once the batch committed
+		status.Cursor = cursor
+		status.Scanned += int64(scanned)
+		status.Rekeyed += rekeyed
+		if RekeyProgress != nil {
+			RekeyProgress(status)
+		}
+		if scanned < batchSize {
+			return status, RecordAdminAction(ctx, db, MetaRekey, map[string]interface{}{
+				"old_key_id": oldKeyID, "new_key_id": newKeyID, "scanned": status.Scanned, "rekeyed": status.Rekeyed,
+			})
+		}
+	}
+}
+
+// rekeyEntry returns the payload columns of entry encrypted with oldKeyID
+// encrypted again with newKeyID, one per payload, bound to the entry id
+func rekeyEntry(ctx context.Context, entry AuditLog, oldKeyID, newKeyID string) (map[string]interface{}, error) {
+	updates := map[string]interface{}{}
+	for column, data := range map[string]datatypes.JSON{"data": entry.Data, "old_data": entry.OldData} {
+		rekeyed, err := rekeyPayload(ctx, payloadAAD(entry.Id, column), data, oldKeyID, newKeyID)
+		if err != nil {
+			return nil, err
+		}
+		if rekeyed != nil {
+			updates[column] = rekeyed
+		}
+	}
+	return updates, nil
+}
+
+// rekeyPayload returns data encrypted with newKeyID and bound to aad if it is
+// encrypted with oldKeyID, and nil otherwise
+func rekeyPayload(ctx context.Context, aad []byte, data datatypes.JSON, oldKeyID, newKeyID string) (datatypes.JSON, error) {
+	payload, ok := parseEncryptedPayload(data)
+	if !ok || payload.KeyID != oldKeyID {
+		return nil, nil
+	}
+	plain, err := decryptPayload(ctx, aad, data)
+	if err != nil {
+		return nil, err
+	}
+	return encryptPayload(ctx, newKeyID, aad, plain)
+}
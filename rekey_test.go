@@ -0,0 +1,65 @@
+package audited
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestRekeyPayload(t *testing.T) {
+	RegisterKeyProvider(NewStaticKeyProvider("rekey-old", bytes.Repeat([]byte{4}, 32)))
+	RegisterKeyProvider(NewStaticKeyProvider("rekey-new", bytes.Repeat([]byte{5}, 32)))
+	ctx := context.Background()
+	plain := datatypes.JSON(`{"id":"7"}`)
+
+	aad := payloadAAD("entry-1", "data")
+	encrypted, err := encryptPayload(ctx, "rekey-old", aad, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rekeyed, err := rekeyPayload(ctx, aad, encrypted, "rekey-old", "rekey-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload, ok := parseEncryptedPayload(rekeyed); !ok || payload.KeyID != "rekey-new" {
+		t.Fatalf("payload not encrypted with the new key: %s", rekeyed)
+	}
+	if decrypted, err := decryptPayload(ctx, aad, rekeyed); err != nil || string(decrypted) != string(plain) {
+		t.Fatalf("got %s, %v", decrypted, err)
+	}
+
+	// payloads in plain text or encrypted with another key are left alone
+	for _, data := range []datatypes.JSON{plain, rekeyed} {
+		if again, err := rekeyPayload(ctx, aad, data, "rekey-old", "rekey-new"); err != nil || again != nil {
+			t.Fatalf("got %s, %v for %s", again, err, data)
+		}
+	}
+}
+
+func TestRekeyEntry(t *testing.T) {
+	RegisterKeyProvider(NewStaticKeyProvider("rekey-old", bytes.Repeat([]byte{4}, 32)))
+	RegisterKeyProvider(NewStaticKeyProvider("rekey-new", bytes.Repeat([]byte{5}, 32)))
+	ctx := context.Background()
+	data, _ := encryptPayload(ctx, "rekey-old", payloadAAD("entry-1", "data"), datatypes.JSON(`{"id":"7","total":2}`))
+	// written before payloads were bound to their entries
+	oldData, _ := encryptPayload(ctx, "rekey-old", nil, datatypes.JSON(`{"id":"7","total":1}`))
+
+	// every payload of an update counts
+	updates, err := rekeyEntry(ctx, AuditLog{Id: "entry-1", Data: data, OldData: oldData}, "rekey-old", "rekey-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("got %d payloads rekeyed, want 2", len(updates))
+	}
+	rebound, _ := updates["old_data"].(datatypes.JSON)
+	if payload, _ := parseEncryptedPayload(rebound); !payload.Bound {
+		t.Fatalf("old data not bound to its entry: %s", rebound)
+	}
+	updates, err = rekeyEntry(ctx, AuditLog{Id: "entry-1", Data: data}, "rekey-old", "rekey-new")
+	if err != nil || len(updates) != 1 {
+		t.Fatalf("got %v, %v, want the data rekeyed", updates, err)
+	}
+}
@@ -0,0 +1,92 @@
+package audited
+
+import (
+	"sync"
+
+	"gorm.io/datatypes"
+)
+
+var renames = struct {
+	sync.RWMutex
+	m map[string]map[string]string
+}{m: map[string]map[string]string{}}
+
+// RegisterRename records that column from of table is now named to, so the
+// snapshots written before the rename are diffed and summarized with the
+// current name instead of showing the field as removed and added
+func RegisterRename(table, from, to string) {
+	renames.Lock()
+	defer renames.Unlock()
+	if renames.m[table] == nil {
+		renames.m[table] = map[string]string{}
+	}
+	renames.m[table][from] = to
+}
+
+// currentName returns the name field of table has now, following renames
+func currentName(table, field string) string {
+	renames.RLock()
+	defer renames.RUnlock()
+	renamed := renames.m[table]
+	// a field renamed back and forth ends at the last name seen
+	for i := 0; i < len(renamed); i++ {
+		to, ok := renamed[field]
+		if !ok {
+			break
+		}
+		field = to
+	}
+	return field
+}
+
+// renameFields moves the fields of a snapshot of table to their current
+// names. A field present under both names keeps the value of the current one.
+func renameFields(table string, data map[string]interface{}) map[string]interface{} {
+	renames.RLock()
+	n := len(renames.m[table])
+	renames.RUnlock()
+	if n == 0 {
+		return data
+	}
+	for field, value := range data {
+		name := currentName(table, field)
+		if name == field {
+			continue
+		}
+		delete(data, field)
+		if _, ok := data[name]; !ok {
+			data[name] = value
+		}
+	}
+	return data
+}
+
+// decodeTableSnapshot decodes a snapshot of table with the current names of
+// its fields
+func decodeTableSnapshot(table string, data datatypes.JSON) (map[string]interface{}, error) {
+	m, err := decodeSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+	return renameFields(table, m), nil
+}
+
+// EntryData returns the data of entry with the current names of its fields,
+// see RegisterRename
+func EntryData(entry AuditLog) (map[string]interface{}, error) {
+	return decodeTableSnapshot(entry.TableName, entry.Data)
+}
+
+// DiffEntries returns the fields that differ between the data of two entries
+// of a table, with their current names, computed by the Differ of the table
+func DiffEntries(before, after AuditLog) ([]Change, error) {
+	oldMap, err := decodeTableSnapshot(before.TableName, before.Data)
+	if err != nil {
+		return nil, err
+	}
+	newMap, err := decodeTableSnapshot(after.TableName, after.Data)
+	if err != nil {
+		return nil, err
+	}
+	return differFor(after.TableName)(oldMap, newMap), nil
+}
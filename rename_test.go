@@ -0,0 +1,36 @@
+package audited
+
+import (
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestDiffEntriesRenamed(t *testing.T) {
+	defer func() {
+		renames.Lock()
+		delete(renames.m, "customers")
+		renames.Unlock()
+	}()
+	RegisterRename("customers", "mail", "email")
+	RegisterRename("customers", "email", "email_address")
+
+	before := AuditLog{TableName: "customers", Data: datatypes.JSON(`{"id":"1","mail":"a@example.com","name":"Ann"}`)}
+	after := AuditLog{TableName: "customers", Data: datatypes.JSON(`{"id":"1","email_address":"b@example.com","name":"Ann"}`)}
+	changes, err := DiffEntries(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Field != "email_address" ||
+		changes[0].From.Value != "a@example.com" || changes[0].To.Value != "b@example.com" {
+		t.Fatalf("got %+v", changes)
+	}
+
+	data, err := EntryData(AuditLog{TableName: "customers", Data: datatypes.JSON(`{"mail":"old","email_address":"new"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data["email_address"] != "new" {
+		t.Fatalf("got %v", data)
+	}
+}
@@ -0,0 +1,137 @@
+package audited
+
+import (
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of SchemaDrift
+const (
+	DriftAdded   = "added"
+	DriftRemoved = "removed"
+)
+
+// SchemaDrift is a field that appeared in or disappeared from the snapshots
+// of an object at an entry, after a column was added to or removed from its
+// table
+type SchemaDrift struct {
+	Field   string    `json:"field"`
+	Kind    string    `json:"kind"`
+	AuditId ID        `json:"audit_id"`
+	At      time.Time `json:"at"`
+}
+
+// ObjectVersion is the state of an object after an entry. Data has every
+// field seen in the history of the object: fields added to the table later
+// have their default, fields removed from it are null.
+type ObjectVersion struct {
+	Entry   AuditLog               `json:"entry"`
+	Data    map[string]interface{} `json:"data"`
+	Deleted bool                   `json:"deleted"`
+	// Changes from the previous version
+	Changes []Change `json:"changes"`
+}
+
+// ReplayOptions configures Replay
+type ReplayOptions struct {
+	// Defaults are the values of added fields in the versions written before
+	// they existed, null if missing
+	Defaults map[string]interface{}
+}
+
+// Replay returns the versions of the object of table with objectId, oldest
+// first, and the schema drift met along its history. Entries of
+// WithChangedFieldsOnly are applied on top of the previous version, the
+// patches stored as data by earlier versions of WithJSONPatch to it.
+func Replay(db *gorm.DB, table, objectId string, opts ReplayOptions) ([]ObjectVersion, []SchemaDrift, error) {
+	entries, err := TrailFor(db, table, objectId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return replay(entries, opts.Defaults)
+}
+
+func replay(entries []AuditLog, defaults map[string]interface{}) ([]ObjectVersion, []SchemaDrift, error) {
+	snapshots := make([]map[string]interface{}, len(entries))
+	firstSeen := map[string]int{}
+	// the object as last written, JSON Patches apply to it
+	current := map[string]interface{}{}
+	for i, entry := range entries {
+		data, err := entrySnapshot(entry, current)
+		if err != nil {
+			return nil, nil, err
+		}
+		snapshots[i] = data
+		if partial, _ := entry.Metadata["changed_only"].(bool); partial {
+			current = copyValue(current).(map[string]interface{})
+			for field, value := range data {
+				current[field] = value
+			}
+		} else {
+			current = data
+		}
+		for field := range data {
+			if _, ok := firstSeen[field]; !ok {
+				firstSeen[field] = i
+			}
+		}
+	}
+
+	var versions []ObjectVersion
+	var drift []SchemaDrift
+	state := map[string]interface{}{}
+	present := map[string]bool{}
+	for i, entry := range entries {
+		data := snapshots[i]
+		partial, _ := entry.Metadata["changed_only"].(bool)
+		var found []SchemaDrift
+		next := map[string]interface{}{}
+		for field, value := range state {
+			next[field] = value
+		}
+		for field, value := range data {
+			next[field] = value
+			if !present[field] && i > 0 {
+				found = append(found, SchemaDrift{Field: field, Kind: DriftAdded, AuditId: entry.Id, At: entry.CreatedAt})
+			}
+			present[field] = true
+		}
+		for field := range present {
+			if _, ok := data[field]; !ok && !partial {
+				found = append(found, SchemaDrift{Field: field, Kind: DriftRemoved, AuditId: entry.Id, At: entry.CreatedAt})
+				delete(present, field)
+				next[field] = nil
+			}
+		}
+		sort.Slice(found, func(a, b int) bool { return found[a].Field < found[b].Field })
+		drift = append(drift, found...)
+		for field, seen := range firstSeen {
+			if seen > i {
+				next[field] = defaults[field]
+			}
+		}
+		versions = append(versions, ObjectVersion{
+			Entry:   entry,
+			Data:    next,
+			Deleted: isDelete(entry.OperationType),
+			Changes: differFor(entry.TableName)(state, next),
+		})
+		state = next
+	}
+	return versions, drift, nil
+}
+
+// entrySnapshot returns the data of entry, the patch stored as the data of an
+// earlier WithJSONPatch entry applied to current
+func entrySnapshot(entry AuditLog, current map[string]interface{}) (map[string]interface{}, error) {
+	if patched, _ := entry.Metadata["json_patch"].(bool); !patched {
+		return EntryData(entry)
+	}
+	patch, err := DecodePatch(entry.Data)
+	if err != nil {
+		return nil, err
+	}
+	return ApplyPatch(current, renamePatch(entry.TableName, patch))
+}
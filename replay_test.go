@@ -0,0 +1,43 @@
+package audited
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestReplaySchemaDrift(t *testing.T) {
+	partial := datatypes.JSONMap{"changed_only": true}
+	entries := []AuditLog{
+		{Id: "1", OperationType: OperationCreate, Data: datatypes.JSON(`{"id":"7","name":"gear","fax":"555"}`)},
+		{Id: "2", OperationType: OperationUpdate, Data: datatypes.JSON(`{"id":"7","name":"gear","fax":"556"}`)},
+		// fax dropped, sku added
+		{Id: "3", OperationType: OperationUpdate, Data: datatypes.JSON(`{"id":"7","name":"gear","sku":"G-1"}`)},
+		{Id: "4", OperationType: OperationUpdate, Data: datatypes.JSON(`{"id":"7","sku":"G-2"}`), Metadata: partial},
+	}
+	versions, drift, err := replay(entries, map[string]interface{}{"sku": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`{"fax":"555","id":"7","name":"gear","sku":""}`,
+		`{"fax":"556","id":"7","name":"gear","sku":""}`,
+		`{"fax":null,"id":"7","name":"gear","sku":"G-1"}`,
+		`{"fax":null,"id":"7","name":"gear","sku":"G-2"}`,
+	}
+	for i, version := range versions {
+		got, _ := json.Marshal(version.Data)
+		if string(got) != want[i] {
+			t.Errorf("version %d: got %s, want %s", i, got, want[i])
+		}
+	}
+	if len(versions[3].Changes) != 1 || versions[3].Changes[0].Field != "sku" {
+		t.Errorf("got changes %+v", versions[3].Changes)
+	}
+	if len(drift) != 2 || drift[0] != (SchemaDrift{Field: "fax", Kind: DriftRemoved, AuditId: "3"}) ||
+		drift[1] != (SchemaDrift{Field: "sku", Kind: DriftAdded, AuditId: "3"}) {
+		t.Errorf("got drift %+v", drift)
+	}
+}
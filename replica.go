@@ -0,0 +1,29 @@
+package audited
+
+import (
+	"gorm.io/gorm"
+)
+
+// WithReadReplica runs the reports on the audit trail, such as Stats, Feed
+// and Export, on replica, a connection to a read replica of the database, so
+// they don't compete with the traffic of the primary. Entries are always
+// written, and read while writing, on the primary. Use Reader to run your
+// own queries on it.
+func WithReadReplica(replica *gorm.DB) Option {
+	return func(o *options) {
+		o.replica = replica
+	}
+}
+
+// Reader returns the read replica of db, see WithReadReplica, with the
+// context of db, or db itself, or its audit database (see WithAuditDB), when
+// it has none or is in a transaction:
+//
+//	audited.Query(audited.Reader(db)).Where("user_id = ?", user).Find(&entries)
+func Reader(db *gorm.DB) *gorm.DB {
+	replica := configFor(db).replica
+	if replica == nil || inTransaction(db) {
+		return auditConn(db)
+	}
+	return replica.Session(&gorm.Session{NewDB: true, Context: db.Statement.Context})
+}
@@ -0,0 +1,38 @@
+package audited
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestReader(t *testing.T) {
+	open := func() *gorm.DB {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	primary, replica, other := open(), open(), open()
+	if err := RegisterCallbacks(primary, WithTableName("billing_audit_logs"), WithReadReplica(replica)); err != nil {
+		t.Fatal(err)
+	}
+	if got := Reader(other); got != other {
+		t.Fatal("expected a database without replica to be its own reader")
+	}
+
+	ctx := context.WithValue(context.Background(), ContextKeyEmail, "ann@example.com")
+	reader := Reader(primary.WithContext(ctx))
+	if reader.Callback() != replica.Callback() {
+		t.Fatal("expected the reader to be the replica")
+	}
+	if reader.Statement.Context != ctx {
+		t.Fatal("expected the reader to have the context of the primary")
+	}
+	if got := auditTable(reader); got != "billing_audit_logs" {
+		t.Fatalf("got table %q on the replica", got)
+	}
+}
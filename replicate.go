@@ -0,0 +1,143 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReplicationOptions configures Replicate
+type ReplicationOptions struct {
+	// ConsumerOptions of the replication, its checkpoint is the last entry
+	// shipped
+	ConsumerOptions
+	// Target is the audit store entries are shipped to, e.g. in a secondary
+	// region
+	Target *gorm.DB
+	// Report is called with the lag of the replica after each shipped batch,
+	// e.g. to export it as a metric
+	Report func(ReplicationLag)
+}
+
+// ReplicationLag is how far a replica is behind the audit store it ships from
+type ReplicationLag struct {
+	Name string `json:"name"`
+	// Seq is the sequence number of the last entry shipped
+	Seq int64 `json:"seq"`
+	// Head is the sequence number of the last entry written
+	Head int64 `json:"head"`
+	// Entries is the number of sequence numbers not shipped yet, including
+	// gaps
+	Entries int64 `json:"entries"`
+	// Behind is the age of the oldest entry not shipped yet, zero when the
+	// replica is caught up
+	Behind time.Duration `json:"behind"`
+}
+
+// Replicate ships the committed entries of db to opts.Target in sequence
+// order, until ctx is done or a batch fails to ship. Entries are appended by
+// id, one shipped twice after a failure is left as it is, so the replica can
+// be written by other processes; it numbers the entries in its own sequence.
+// Encrypted payloads are shipped as stored, the replica needs the key
+// providers to read them. Field changes of long format models aren't shipped.
+// Entries are read from and shipped to the tables of the region of ctx, see
+// WithResidency; a batch with entries of another region, or of a region the
+// target has no table for, fails with ErrResidencyViolation.
+func Replicate(ctx context.Context, db *gorm.DB, opts ReplicationOptions) error {
+	handler, err := replicationHandler(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return Consume(ctx, db, opts.ConsumerOptions, handler)
+}
+
+// replicationHandler returns the consumer handler shipping entries to
+// opts.Target
+func replicationHandler(ctx context.Context, opts ReplicationOptions) (ConsumerHandler, error) {
+	if opts.Target == nil {
+		return nil, errors.New("audited: replication target is required")
+	}
+	target := opts.Target.Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: ctx})
+	return func(ctx context.Context, tx *gorm.DB, entries []AuditLog) error {
+		// the entries are read again as stored, handed to the handler they
+		// are decrypted
+		ids := make([]ID, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.Id
+		}
+		var stored []AuditLog
+		if err := Query(tx.Session(&gorm.Session{SkipHooks: true})).Where("id IN ?", ids).
+			Order("seq").Find(&stored).Error; err != nil {
+			return err
+		}
+		if err := checkResidency(target, stored); err != nil {
+			return err
+		}
+		if err := appendEntries(target, stored); err != nil {
+			return err
+		}
+		if opts.Report == nil {
+			return nil
+		}
+		lag, err := replicationLag(tx, opts.Name, entries[len(entries)-1].Seq)
+		if err != nil {
+			return err
+		}
+		opts.Report(lag)
+		return nil
+	}, nil
+}
+
+// ReplicationStatus returns the lag of the replication name
+func ReplicationStatus(db *gorm.DB, name string) (ReplicationLag, error) {
+	seq, err := Checkpoint(db, name)
+	if err != nil {
+		return ReplicationLag{}, err
+	}
+	return replicationLag(db, name, seq)
+}
+
+func replicationLag(db *gorm.DB, name string, seq int64) (ReplicationLag, error) {
+	var head *int64
+	if err := QueryOperations(db).Select("MAX(seq)").Row().Scan(&head); err != nil {
+		return ReplicationLag{}, err
+	}
+	var next []AuditLog
+	if err := QueryOperations(db).Where("seq > ?", seq).Order("seq").Limit(1).Find(&next).Error; err != nil {
+		return ReplicationLag{}, err
+	}
+	lag := ReplicationLag{Name: name, Seq: seq, Head: seq}
+	if head != nil && *head > seq {
+		lag.Head, lag.Entries = *head, *head-seq
+	}
+	if len(next) > 0 {
+		lag.Behind = time.Since(next[0].CreatedAt)
+	}
+	return lag, nil
+}
+
+// appendEntries inserts the entries missing from the audit store of db
+func appendEntries(db *gorm.DB, entries []AuditLog) error {
+	logs := make([]AuditLog, len(entries))
+	for i, entry := range entries {
+		entry.Seq = 0
+		logs[i] = entry
+	}
+	skip := clause.OnConflict{Columns: []clause.Column{{Name: "id"}}, DoNothing: true}
+	if !splitLayout(db) {
+		return db.Table(auditTable(db)).Clauses(skip).Create(&logs).Error
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(operationsTable(tx)).Omit("Data", "OldData").Clauses(skip).Create(&logs).Error; err != nil {
+			return err
+		}
+		payloads := make([]auditPayload, len(logs))
+		for i, l := range logs {
+			payloads[i] = auditPayload{Id: l.Id, Data: l.Data, OldData: l.OldData}
+		}
+		return tx.Table(payloadTable(tx)).Clauses(skip).Create(&payloads).Error
+	})
+}
@@ -0,0 +1,58 @@
+package audited
+
+import (
+	"context"
+	"net/http"
+)
+
+var contextKeyRequest = ContextKey("audited_request")
+
+// RequestInfo is the request a change was made in, stored on its entries to
+// correlate them with the access logs
+type RequestInfo struct {
+	ActorIP   string
+	UserAgent string
+	RequestId string
+	SessionId string
+}
+
+// RequestExtractor returns the request of ctx, see WithRequestExtractor
+type RequestExtractor func(ctx context.Context) RequestInfo
+
+// WithRequestInfo returns a copy of ctx carrying the request making the
+// change. Middleware sets it from the request.
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, contextKeyRequest, info)
+}
+
+// RequestInfoFrom returns the request set on ctx with WithRequestInfo, with
+// the ActorIP defaulting to the ClientIP of ctx
+func RequestInfoFrom(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(contextKeyRequest).(RequestInfo)
+	if info.ActorIP == "" {
+		info.ActorIP = ClientIP(ctx)
+	}
+	return info
+}
+
+// WithRequestExtractor reads the request of changes with extractor instead of
+// RequestInfoFrom, e.g. from the context values of the router or tracing
+// library of the app
+func WithRequestExtractor(extractor RequestExtractor) Option {
+	return func(o *options) {
+		o.requestExtractor = extractor
+	}
+}
+
+// requestOf returns the request of ctx, read with the extractor of o
+func requestOf(ctx context.Context, o *options) RequestInfo {
+	if o.requestExtractor != nil {
+		return o.requestExtractor(ctx)
+	}
+	return RequestInfoFrom(ctx)
+}
+
+// httpRequestInfo returns the user agent and X-Request-Id of r
+func httpRequestInfo(r *http.Request) RequestInfo {
+	return RequestInfo{UserAgent: r.UserAgent(), RequestId: r.Header.Get("X-Request-Id")}
+}
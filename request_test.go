@@ -0,0 +1,42 @@
+package audited
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestInfo(t *testing.T) {
+	ctx := WithClientIP(context.Background(), "203.0.113.7")
+	if got := RequestInfoFrom(ctx); got != (RequestInfo{ActorIP: "203.0.113.7"}) {
+		t.Fatalf("got %+v without request info", got)
+	}
+	ctx = WithRequestInfo(ctx, RequestInfo{RequestId: "r1", SessionId: "s1"})
+	want := RequestInfo{ActorIP: "203.0.113.7", RequestId: "r1", SessionId: "s1"}
+	if got := RequestInfoFrom(ctx); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	o := newOptions([]Option{WithRequestExtractor(func(ctx context.Context) RequestInfo {
+		return RequestInfo{RequestId: "trace-1"}
+	})})
+	if got := requestOf(ctx, o); got != (RequestInfo{RequestId: "trace-1"}) {
+		t.Fatalf("got %+v from the extractor", got)
+	}
+}
+
+func TestMiddlewareRequestInfo(t *testing.T) {
+	var got RequestInfo
+	handler := Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestInfoFrom(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.RemoteAddr = "198.51.100.4:5123"
+	r.Header.Set("User-Agent", "curl/8.0")
+	r.Header.Set("X-Request-Id", "req-42")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	want := RequestInfo{ActorIP: "198.51.100.4", UserAgent: "curl/8.0", RequestId: "req-42"}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var contextKeyResidency = ContextKey("audited_residency")
+
+// ErrResidencyViolation is returned when entries would be stored outside of
+// their region
+var ErrResidencyViolation = errors.New("audited: residency violation")
+
+// WithResidency tags the entries written with ctx with region, e.g. "eu".
+// They are stored in the audit table given to WithResidencyTable for region
+// and published to the sinks registered with RegisterResidencySink for it;
+// a region without a table fails the write instead of storing its entries
+// in the default table. Reads with ctx use the table of region too.
+func WithResidency(ctx context.Context, region string) context.Context {
+	return context.WithValue(ctx, contextKeyResidency, region)
+}
+
+func residencyOf(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	region, _ := ctx.Value(contextKeyResidency).(string)
+	return region
+}
+
+// WithResidencyTable stores the entries tagged with region, see WithResidency,
+// in table
+func WithResidencyTable(region, table string) Option {
+	return func(o *options) {
+		o.residency[region] = table
+	}
+}
+
+// residencyTable returns the table of the region of the context of db, ok is
+// false when it has none
+func residencyTable(db *gorm.DB) (table string, ok bool) {
+	if db == nil || db.Statement == nil {
+		return "", false
+	}
+	region := residencyOf(db.Statement.Context)
+	if region == "" {
+		return "", false
+	}
+	table, ok = configFor(db).residency[region]
+	return table, ok
+}
+
+// checkResidency fails unless logs are all of the region of the context of db
+// and are stored in its table
+func checkResidency(db *gorm.DB, logs []AuditLog) error {
+	region := residencyOf(db.Statement.Context)
+	for _, entry := range logs {
+		if tagged, _ := entry.Metadata["residency"].(string); tagged != region {
+			return fmt.Errorf("%w: entry %s of region %q written in region %q", ErrResidencyViolation, entry.Id, tagged, region)
+		}
+	}
+	if region == "" {
+		return nil
+	}
+	if _, ok := residencyTable(db); !ok {
+		return fmt.Errorf("%w: no audit table for region %q", ErrResidencyViolation, region)
+	}
+	if splitLayout(db) || configFor(db).maintainLatest || hasFieldChanges(logs) {
+		return fmt.Errorf("%w: only the single audit table is routed by region", ErrResidencyViolation)
+	}
+	return nil
+}
+
+// ResidencyViolation is an entry found in the audit table of another region
+type ResidencyViolation struct {
+	Table string   `json:"table"`
+	Entry AuditLog `json:"entry"`
+}
+
+// VerifyResidency reads the default audit table and those of the regions,
+// and returns the entries tagged with another region than the table's
+func VerifyResidency(ctx context.Context, db *gorm.DB) ([]ResidencyViolation, error) {
+	regions := []string{""}
+	for region := range configFor(db).residency {
+		regions = append(regions, region)
+	}
+	var violations []ResidencyViolation
+	for _, region := range regions {
+		regional := db.WithContext(WithResidency(ctx, region))
+		table := operationsTable(regional)
+		if err := eachEntry(Query(regional), func(entry AuditLog) error {
+			if tagged, _ := entry.Metadata["residency"].(string); tagged != region {
+				violations = append(violations, ResidencyViolation{Table: table, Entry: entry})
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return violations, nil
+}
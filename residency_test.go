@@ -0,0 +1,79 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type sinkFunc func(ctx context.Context, entries []AuditLog) error
+
+func (f sinkFunc) Write(ctx context.Context, entries []AuditLog) error {
+	return f(ctx, entries)
+}
+
+func TestResidency(t *testing.T) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterCallbacks(db, WithResidencyTable("eu", "eu_audit_logs")); err != nil {
+		t.Fatal(err)
+	}
+	eu := db.WithContext(WithResidency(context.Background(), "eu"))
+	us := db.WithContext(WithResidency(context.Background(), "us"))
+	if got := auditTable(eu); got != "eu_audit_logs" {
+		t.Fatalf("got table %q", got)
+	}
+	if got := auditTable(db); got != AuditTable {
+		t.Fatalf("got table %q without a region", got)
+	}
+
+	euEntry := AuditLog{Id: "1"}
+	euEntry.SetMetadata("residency", "eu")
+	cases := []struct {
+		name string
+		db   *gorm.DB
+		logs []AuditLog
+		ok   bool
+	}{
+		{"routed region", eu, []AuditLog{euEntry}, true},
+		{"no region", db, []AuditLog{{Id: "2"}}, true},
+		{"region without table", us, []AuditLog{{Id: "3", Metadata: map[string]interface{}{"residency": "us"}}}, false},
+		{"entry of another region", db, []AuditLog{euEntry}, false},
+		{"untagged entry in region", eu, []AuditLog{{Id: "4"}}, false},
+	}
+	for _, c := range cases {
+		err := checkResidency(c.db, c.logs)
+		if c.ok != (err == nil) || (err != nil && !errors.Is(err, ErrResidencyViolation)) {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+}
+
+func TestPublishResidency(t *testing.T) {
+	defer func(list []AuditSink, regions map[string][]AuditSink) {
+		sinks.list, sinks.regions = list, regions
+	}(sinks.list, sinks.regions)
+	sinks.list, sinks.regions = nil, map[string][]AuditSink{}
+
+	received := map[string]int{}
+	counter := func(name string) AuditSink {
+		return sinkFunc(func(ctx context.Context, entries []AuditLog) error {
+			received[name] += len(entries)
+			return nil
+		})
+	}
+	RegisterSink(counter("global"))
+	RegisterResidencySink("eu", counter("eu"))
+
+	publish(WithResidency(context.Background(), "eu"), []AuditLog{{Id: "1"}})
+	publish(context.Background(), []AuditLog{{Id: "2"}, {Id: "3"}})
+	publish(WithResidency(context.Background(), "us"), []AuditLog{{Id: "4"}})
+	if received["eu"] != 1 || received["global"] != 2 {
+		t.Fatalf("got %v", received)
+	}
+}
@@ -0,0 +1,152 @@
+package audited
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetentionPolicy sets how long entries are kept, by table, by the
+// classification of their table or by its domain, e.g.
+//
+//	audited.RetentionPolicy{
+//		Default:         365 * 24 * time.Hour,
+//		Classifications: map[string]time.Duration{"financial": 7 * 365 * 24 * time.Hour, "telemetry": 90 * 24 * time.Hour},
+//	}
+type RetentionPolicy struct {
+	// Default is the TTL of entries of tables without one, zero keeps them
+	Default time.Duration
+	// Tables sets the TTL of the entries of a table, it takes precedence over
+	// the classification of the table
+	Tables map[string]time.Duration
+	// Classifications sets the TTL of the entries of the tables classified
+	// with a label, see RegisterClassification
+	Classifications map[string]time.Duration
+	// Domains sets the TTL of the entries of the tables of a domain, see
+	// WithDomain, the classification of a table takes precedence over it
+	Domains map[string]time.Duration
+}
+
+var classifications = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// RegisterClassification classifies the data of tables with label, e.g.
+// "financial", for RetentionPolicy.Classifications
+func RegisterClassification(label string, tables ...string) {
+	classifications.Lock()
+	defer classifications.Unlock()
+	for _, table := range tables {
+		classifications.m[table] = label
+	}
+}
+
+// Classification returns the label table was classified with, or ""
+func Classification(table string) string {
+	classifications.RLock()
+	defer classifications.RUnlock()
+	return classifications.m[table]
+}
+
+// ttls returns the TTL of every table with its own rule, zero for tables
+// whose entries are kept, domains gives the domain of tables
+func (p RetentionPolicy) ttls(domains map[string]string) map[string]time.Duration {
+	ttls := map[string]time.Duration{}
+	for table, domain := range domains {
+		if ttl, ok := p.Domains[domain]; ok {
+			ttls[table] = ttl
+		}
+	}
+	classifications.RLock()
+	for table, label := range classifications.m {
+		if ttl, ok := p.Classifications[label]; ok {
+			ttls[table] = ttl
+		}
+	}
+	classifications.RUnlock()
+	for table, ttl := range p.Tables {
+		ttls[table] = ttl
+	}
+	return ttls
+}
+
+// ApplyRetention deletes the entries older than the TTL policy gives their
+// table, with their field changes, and returns the number of entries deleted.
+// Each table is purged in its own statement; on large tables prefer detaching
+// partitions, see MaintenanceOptions.DetachOlderThan.
+func ApplyRetention(ctx context.Context, db *gorm.DB, policy RetentionPolicy) (int64, error) {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	now := time.Now()
+	ttls := policy.ttls(configFor(db).domains)
+	tables := make([]string, 0, len(ttls))
+	for table := range ttls {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var deleted int64
+	for _, table := range tables {
+		if ttls[table] <= 0 {
+			continue
+		}
+		n, err := deleteEntries(db, "table_name = ? AND created_at < ?", table, now.Add(-ttls[table]))
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	if policy.Default > 0 {
+		cond, args := "created_at < ?", []interface{}{now.Add(-policy.Default)}
+		if len(tables) > 0 {
+			cond, args = "table_name NOT IN ? AND "+cond, append([]interface{}{tables}, args...)
+		}
+		n, err := deleteEntries(db, cond, args...)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+	return deleted, RecordAdminAction(ctx, db, MetaRetention, map[string]interface{}{"deleted": deleted})
+}
+
+// deleteEntries deletes the entries matching cond, a condition on the columns
+// shared by the operations and field changes tables, in the storage layout
+func deleteEntries(db *gorm.DB, cond string, args ...interface{}) (int64, error) {
+	var deleted int64
+	var objects []AuditLog
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if objects, err = cachedObjects(tx, cond, args...); err != nil {
+			return err
+		}
+		quote := tx.Statement.Quote
+		if splitLayout(tx) {
+			if err := tx.Exec("DELETE FROM "+quote(payloadTable(tx))+" WHERE id IN (SELECT id FROM "+
+				quote(operationsTable(tx))+" WHERE "+cond+")", args...).Error; err != nil {
+				return err
+			}
+		}
+		for _, table := range tiered(tx, operationsTable(tx)) {
+			result := tx.Exec("DELETE FROM "+quote(table)+" WHERE "+cond, args...)
+			if result.Error != nil {
+				return result.Error
+			}
+			deleted += result.RowsAffected
+		}
+		if !usesLongFormat() {
+			return nil
+		}
+		return tx.Exec("DELETE FROM "+quote(fieldChangesTable(tx))+" WHERE "+cond, args...).Error
+	})
+	if err == nil {
+		invalidateTrails(db, objects)
+	}
+	return deleted, err
+}
@@ -0,0 +1,61 @@
+package audited
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionTTLs(t *testing.T) {
+	defer func() {
+		classifications.Lock()
+		classifications.m = map[string]string{}
+		classifications.Unlock()
+	}()
+	RegisterClassification("financial", "invoices", "payments")
+	RegisterClassification("telemetry", "page_views")
+
+	year := 365 * 24 * time.Hour
+	policy := RetentionPolicy{
+		Default:         year,
+		Tables:          map[string]time.Duration{"payments": 10 * year, "sessions": 0},
+		Classifications: map[string]time.Duration{"financial": 7 * year, "telemetry": 90 * 24 * time.Hour},
+	}
+	want := map[string]time.Duration{
+		"invoices":   7 * year,
+		"payments":   10 * year,
+		"page_views": 90 * 24 * time.Hour,
+		"sessions":   0,
+	}
+	if got := policy.ttls(nil); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got ttls %v, want %v", got, want)
+	}
+	if got := Classification("invoices"); got != "financial" {
+		t.Fatalf("got classification %q", got)
+	}
+}
+
+func TestRetentionTTLsDomains(t *testing.T) {
+	defer func() {
+		classifications.Lock()
+		classifications.m = map[string]string{}
+		classifications.Unlock()
+	}()
+	RegisterClassification("financial", "invoices")
+
+	year := 365 * 24 * time.Hour
+	o := newOptions([]Option{WithDomain("billing", "invoices", "plans", "coupons"), WithDomain("identity", "users")})
+	policy := RetentionPolicy{
+		Tables:          map[string]time.Duration{"coupons": year},
+		Classifications: map[string]time.Duration{"financial": 7 * year},
+		Domains:         map[string]time.Duration{"billing": 3 * year},
+	}
+	want := map[string]time.Duration{
+		"invoices": 7 * year,
+		"plans":    3 * year,
+		"coupons":  year,
+	}
+	if got := policy.ttls(o.domains); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got ttls %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+package audited
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+var (
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+}
+
+// TSA is a Notary timestamping digests with an RFC 3161 time stamp authority.
+// The tokens it returns are DER encoded TimeStampTokens, verify their
+// signature against the certificate of the TSA with e.g. openssl ts -verify.
+type TSA struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewTSA returns a TSA for the time stamp authority at url
+func NewTSA(url string) *TSA {
+	return &TSA{URL: url, Client: http.DefaultClient}
+}
+
+// Name returns the URL of the TSA
+func (t *TSA) Name() string {
+	return t.URL
+}
+
+// Timestamp asks the TSA for a token over the SHA-256 digest
+func (t *TSA) Timestamp(ctx context.Context, digest []byte) ([]byte, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	query, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("audited: time stamp authority returned %s", resp.Status)
+	}
+
+	var decoded timeStampResp
+	if _, err := asn1.Unmarshal(body, &decoded); err != nil {
+		return nil, fmt.Errorf("audited: invalid time stamp response: %w", err)
+	}
+	// 0 is granted, 1 granted with modifications
+	if decoded.Status.Status > 1 || len(decoded.TimeStampToken.FullBytes) == 0 {
+		return nil, fmt.Errorf("audited: time stamp request rejected with status %d %v",
+			decoded.Status.Status, decoded.Status.StatusString)
+	}
+	token := decoded.TimeStampToken.FullBytes
+	stamped, _, err := ParseTimestampToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(stamped, digest) {
+		return nil, errors.New("audited: time stamp token is for another digest")
+	}
+	return token, nil
+}
+
+// ParseTimestampToken returns the digest an RFC 3161 token was issued for and
+// the time it was issued at. It doesn't verify the signature of the token.
+func ParseTimestampToken(token []byte) (digest []byte, genTime time.Time, err error) {
+	var content contentInfo
+	if _, err = asn1.Unmarshal(token, &content); err != nil {
+		return nil, time.Time{}, fmt.Errorf("audited: invalid time stamp token: %w", err)
+	}
+	if !content.ContentType.Equal(oidSignedData) {
+		return nil, time.Time{}, errors.New("audited: time stamp token is not signed data")
+	}
+	var signed signedData
+	if _, err = asn1.Unmarshal(content.Content.Bytes, &signed); err != nil {
+		return nil, time.Time{}, fmt.Errorf("audited: invalid time stamp token: %w", err)
+	}
+	var info tstInfo
+	if _, err = asn1.Unmarshal(signed.EncapContentInfo.EContent, &info); err != nil {
+		return nil, time.Time{}, fmt.Errorf("audited: invalid time stamp token info: %w", err)
+	}
+	return info.MessageImprint.HashedMessage, info.GenTime, nil
+}
@@ -0,0 +1,157 @@
+package audited
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RollupsTable holds the hourly counts of entries, see Rollup. It is named as
+// the tables of LayoutSplit.
+const RollupsTable = "audit_rollups"
+
+// rollupsTable returns the RollupsTable of db
+func rollupsTable(db *gorm.DB) string {
+	return instanceTable(db, RollupsTable, "rollups")
+}
+
+// RollupDelay is how long after its end an hour is rolled up, so entries of
+// transactions still open at the end of the hour are counted
+var RollupDelay = 5 * time.Minute
+
+// AuditRollup is the number of entries of an operation on a table by a user
+// in the hour starting at Hour
+type AuditRollup struct {
+	Hour          time.Time `json:"hour" gorm:"primaryKey"`
+	TableName     string    `json:"table_name" gorm:"primaryKey"`
+	Domain        string    `json:"domain" gorm:"primaryKey"`
+	OperationType string    `json:"operation_type" gorm:"primaryKey"`
+	UserId        string    `json:"user_id" gorm:"primaryKey"`
+	Count         int64     `json:"count"`
+}
+
+// Rollup counts the entries of the hours ended since the last rollup into
+// RollupsTable, per table, operation and user, and returns the number of
+// hours rolled up. Hours without entries are skipped. Stats reads the counts
+// with StatsOptions.Rollups instead of scanning the entries.
+func Rollup(ctx context.Context, db *gorm.DB) (int, error) {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	hour, err := rollupWatermark(db)
+	if err != nil {
+		return 0, err
+	}
+	end := time.Now().Add(-RollupDelay).Truncate(time.Hour)
+
+	hours := 0
+	for hour.Before(end) {
+		var next []AuditLog
+		query := QueryOperations(db).Select("created_at").Where("created_at < ?", end)
+		if !hour.IsZero() {
+			query = query.Where("created_at >= ?", hour)
+		}
+		if err := query.Order("created_at").Limit(1).Find(&next).Error; err != nil {
+			return hours, err
+		}
+		if len(next) == 0 {
+			break
+		}
+		hour = next[0].CreatedAt.Truncate(time.Hour)
+		if err := rollupHour(db, hour); err != nil {
+			return hours, err
+		}
+		hours++
+		hour = hour.Add(time.Hour)
+	}
+	return hours, nil
+}
+
+// rollupWatermark returns the end of the last hour rolled up, zero when there
+// is none
+func rollupWatermark(db *gorm.DB) (time.Time, error) {
+	var last []AuditRollup
+	if err := db.Table(rollupsTable(db)).Order("hour DESC").Limit(1).Find(&last).Error; err != nil {
+		return time.Time{}, err
+	}
+	if len(last) == 0 {
+		return time.Time{}, nil
+	}
+	return last[0].Hour.Add(time.Hour), nil
+}
+
+// rollupHour replaces the counts of the hour starting at hour
+func rollupHour(db *gorm.DB, hour time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var rollups []AuditRollup
+		if err := QueryOperations(tx).
+			Select("table_name, COALESCE(domain, '') AS domain, operation_type, COALESCE(user_id, '') AS user_id, COUNT(*) AS count").
+			Where("created_at >= ? AND created_at < ?", hour, hour.Add(time.Hour)).
+			Group("table_name, COALESCE(domain, ''), operation_type, COALESCE(user_id, '')").
+			Scan(&rollups).Error; err != nil {
+			return err
+		}
+		if err := tx.Table(rollupsTable(tx)).Where("hour = ?", hour).Delete(&AuditRollup{}).Error; err != nil {
+			return err
+		}
+		if len(rollups) == 0 {
+			return nil
+		}
+		for i := range rollups {
+			rollups[i].Hour = hour
+		}
+		return tx.Table(rollupsTable(tx)).Create(&rollups).Error
+	})
+}
+
+// rollupRange returns the hours [from, to) of [since, until) Stats reads from
+// the rollups when they end at watermark, ok is false when there are none.
+// Zero bounds are open.
+func rollupRange(since, until, watermark time.Time) (from, to time.Time, ok bool) {
+	if watermark.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+	from = since.Truncate(time.Hour)
+	if from.Before(since) {
+		from = from.Add(time.Hour)
+	}
+	to = watermark
+	if !until.IsZero() && until.Truncate(time.Hour).Before(to) {
+		to = until.Truncate(time.Hour)
+	}
+	if !from.IsZero() && !from.Before(to) {
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// rollupStats counts the entries of [from, to) selected by opts from the
+// rollups
+func rollupStats(db *gorm.DB, opts StatsOptions, from, to time.Time) ([]StatsRow, error) {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true})
+	query := db.Table(rollupsTable(db)).Where("hour < ?", to)
+	if !from.IsZero() {
+		query = query.Where("hour >= ?", from)
+	}
+	if opts.TableName != "" {
+		query = query.Where("table_name = ?", opts.TableName)
+	}
+	if opts.Domain != "" {
+		query = query.Where("domain = ?", opts.Domain)
+	}
+	if opts.UserId != "" {
+		query = query.Where("user_id = ?", opts.UserId)
+	}
+	var rows []StatsRow
+	err := query.Select("table_name, operation_type, SUM(count) AS count").
+		Group("table_name, operation_type").Scan(&rows).Error
+	return rows, err
+}
+
+// ScheduleRollups runs Rollup every interval, defaulting to an hour, until
+// ctx is done
+func ScheduleRollups(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	RunJobs(ctx, db, ScheduledJob{Job: RollupJob(), Interval: interval})
+}
@@ -0,0 +1,50 @@
+package audited
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRollupRange(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 1, hour, minute, 0, 0, time.UTC)
+	}
+	var zero time.Time
+	tests := []struct {
+		name                    string
+		since, until, watermark time.Time
+		from, to                time.Time
+		ok                      bool
+	}{
+		{"no rollups", at(1, 0), at(5, 0), zero, zero, zero, false},
+		{"open range", zero, zero, at(6, 0), zero, at(6, 0), true},
+		{"partial hours", at(1, 30), at(4, 15), at(6, 0), at(2, 0), at(4, 0), true},
+		{"after watermark", zero, at(9, 0), at(6, 0), zero, at(6, 0), true},
+		{"within an hour", at(3, 10), at(3, 50), at(6, 0), zero, zero, false},
+		{"since watermark", at(7, 0), zero, at(6, 0), zero, zero, false},
+	}
+	for _, tt := range tests {
+		from, to, ok := rollupRange(tt.since, tt.until, tt.watermark)
+		if ok != tt.ok || (ok && (!from.Equal(tt.from) || !to.Equal(tt.to))) {
+			t.Errorf("%s: got [%v, %v) %v, want [%v, %v) %v", tt.name, from, to, ok, tt.from, tt.to, tt.ok)
+		}
+	}
+}
+
+func TestMergeStats(t *testing.T) {
+	got := mergeStats([]StatsRow{
+		{TableName: "orders", OperationType: OperationUpdate, Count: 3},
+		{TableName: "invoices", OperationType: OperationCreate, Count: 1},
+		{TableName: "orders", OperationType: OperationCreate, Count: 2},
+		{TableName: "orders", OperationType: OperationUpdate, Count: 4},
+	})
+	want := []StatsRow{
+		{TableName: "invoices", OperationType: OperationCreate, Count: 1},
+		{TableName: "orders", OperationType: OperationCreate, Count: 2},
+		{TableName: "orders", OperationType: OperationUpdate, Count: 7},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package audited
+
+import (
+	"gorm.io/gorm"
+)
+
+const defaultSeqLimit = 1000
+
+// EntriesAfter returns up to limit entries whose Seq is greater than cursor,
+// in sequence order. Consumers resume from the Seq of the last entry they
+// processed. A limit of zero reads up to 1000 entries.
+func EntriesAfter(db *gorm.DB, cursor int64, limit int) ([]AuditLog, error) {
+	if limit <= 0 {
+		limit = defaultSeqLimit
+	}
+	var entries []AuditLog
+	err := Query(db).Where("seq > ?", cursor).Order("seq").Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+// SeqGap is a range of sequence numbers missing between two entries
+type SeqGap struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// SeqGaps returns the sequence numbers missing after cursor in entries, given
+// in sequence order. A gap is either a transaction that rolled back, whose
+// numbers are never reused, or one that has not committed yet, so consumers
+// that need every entry should wait and read a gap again before skipping it.
+func SeqGaps(cursor int64, entries []AuditLog) []SeqGap {
+	var gaps []SeqGap
+	for _, entry := range entries {
+		if entry.Seq > cursor+1 {
+			gaps = append(gaps, SeqGap{From: cursor + 1, To: entry.Seq - 1})
+		}
+		if entry.Seq > cursor {
+			cursor = entry.Seq
+		}
+	}
+	return gaps
+}
@@ -0,0 +1,35 @@
+package audited
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeqGaps(t *testing.T) {
+	entries := []AuditLog{{Seq: 3}, {Seq: 4}, {Seq: 7}, {Seq: 8}, {Seq: 10}}
+	got := SeqGaps(1, entries)
+	want := []SeqGap{{From: 2, To: 2}, {From: 5, To: 6}, {From: 9, To: 9}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if gaps := SeqGaps(2, entries[:2]); gaps != nil {
+		t.Fatalf("got %v, want no gaps", gaps)
+	}
+}
+
+func TestMemoryStoreSeq(t *testing.T) {
+	store := NewMemoryStore()
+	logs := []AuditLog{{ObjectId: "1"}, {ObjectId: "2"}}
+	store.add(logs...)
+	store.add(AuditLog{ObjectId: "3"})
+	if logs[0].Seq != 1 || logs[1].Seq != 2 {
+		t.Fatalf("seq not set on the added entries: %d, %d", logs[0].Seq, logs[1].Seq)
+	}
+	after := store.EntriesAfter(1, 1)
+	if len(after) != 1 || after[0].ObjectId != "2" {
+		t.Fatalf("got %v, want the entry with seq 2", after)
+	}
+	if after := store.EntriesAfter(1, 0); len(after) != 2 {
+		t.Fatalf("got %d entries, want 2", len(after))
+	}
+}
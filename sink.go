@@ -0,0 +1,100 @@
+package audited
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// AuditSink receives audit entries once they are written, see RegisterSink,
+// or writes them, see WithStore
+type AuditSink interface {
+	Write(ctx context.Context, entries []AuditLog) error
+}
+
+var sinks = struct {
+	sync.RWMutex
+	list    []AuditSink
+	regions map[string][]AuditSink
+}{regions: map[string][]AuditSink{}}
+
+// RegisterSink adds a sink receiving every written entry. Sinks are called
+// synchronously after the insert; entries written in a transaction reach them
+// before it commits, even if it later rolls back, use Consume where only
+// committed entries may be published.
+func RegisterSink(sink AuditSink) {
+	sinks.Lock()
+	defer sinks.Unlock()
+	sinks.list = append(sinks.list, sink)
+}
+
+// RegisterResidencySink adds a sink receiving the entries of region, see
+// WithResidency. They are only published to the sinks of their region.
+func RegisterResidencySink(region string, sink AuditSink) {
+	sinks.Lock()
+	defer sinks.Unlock()
+	sinks.regions[region] = append(sinks.regions[region], sink)
+}
+
+// publish hands entries to the watchers of their objects and the registered
+// sinks of their region, a failing sink doesn't stop the others
+func publish(ctx context.Context, entries []AuditLog) {
+	sinks.RLock()
+	list := sinks.list
+	if region := residencyOf(ctx); region != "" {
+		list = sinks.regions[region]
+	}
+	sinks.RUnlock()
+	if len(entries) == 0 {
+		return
+	}
+	for _, sink := range list {
+		if err := sink.Write(ctx, entries); err != nil {
+			log.Println(fmt.Errorf("error in audit sink %T: %s", sink, err.Error()))
+		}
+	}
+}
+
+// NDJSONSink writes entries as newline-delimited JSON, one event per line in
+// PublishFormat
+type NDJSONSink struct {
+	// DB is used to read the data before updates for Debezium events, without
+	// it their before is left empty
+	DB *gorm.DB
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewNDJSONSink returns a sink writing to w
+func NewNDJSONSink(w io.Writer) *NDJSONSink {
+	return &NDJSONSink{w: w}
+}
+
+// NewStdoutSink returns a sink writing to stdout, to collect audit events with
+// the log shipper of a container platform
+func NewStdoutSink() *NDJSONSink {
+	return NewNDJSONSink(os.Stdout)
+}
+
+// Write writes one line per entry, the lines of a batch in a single write so
+// they aren't interleaved with other output
+func (s *NDJSONSink) Write(ctx context.Context, entries []AuditLog) error {
+	var buf []byte
+	for _, entry := range entries {
+		line, err := MarshalEvent(s.DB, entry)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(buf)
+	return err
+}
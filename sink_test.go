@@ -0,0 +1,48 @@
+package audited
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestNDJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewNDJSONSink(&buf)
+	entries := []AuditLog{
+		{Id: "1", TableName: "orders", OperationType: OperationCreate, ObjectId: "7", Data: datatypes.JSON(`{"id":"7"}`)},
+		{Id: "2", TableName: "orders", OperationType: OperationDelete, ObjectId: "7", Data: datatypes.JSON(`{"id":"7"}`)},
+	}
+	if err := sink.Write(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	var decoded AuditLog
+	if err := json.Unmarshal([]byte(lines[1]), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Id != "2" || decoded.OperationType != OperationDelete {
+		t.Fatalf("got %+v", decoded)
+	}
+
+	PublishFormat = EventFormatDebezium
+	defer func() { PublishFormat = EventFormatNative }()
+	buf.Reset()
+	if err := sink.Write(context.Background(), entries[:1]); err != nil {
+		t.Fatal(err)
+	}
+	var event DebeziumEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Op != "c" || string(event.After) != `{"id":"7"}` {
+		t.Fatalf("got %+v", event)
+	}
+}
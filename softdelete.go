@@ -0,0 +1,49 @@
+package audited
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// settingWasDeleted holds, by object id, whether the objects of an update were
+// soft deleted before it
+const settingWasDeleted = "audited:was_deleted"
+
+// deleteOperation returns the operation type of the delete of db
+func deleteOperation(db *gorm.DB) string {
+	if db.Statement.Schema == nil || !hasSoftDelete(db) {
+		return OperationDelete
+	}
+	if db.Statement.Unscoped {
+		return OperationHardDelete
+	}
+	return OperationSoftDelete
+}
+
+// isDelete reports whether operation deletes its object
+func isDelete(operation string) bool {
+	return operation == OperationDelete || operation == OperationSoftDelete || operation == OperationHardDelete
+}
+
+// isSoftDeleted reports whether the object row has its gorm.DeletedAt set
+func isSoftDeleted(db *gorm.DB, row reflect.Value) bool {
+	if db.Statement.Schema == nil {
+		return false
+	}
+	for _, field := range db.Statement.Schema.Fields {
+		if field.FieldType != deletedAtType {
+			continue
+		}
+		value, _ := field.ValueOf(db.Statement.Context, reflect.Indirect(row))
+		deletedAt, ok := value.(gorm.DeletedAt)
+		return ok && deletedAt.Valid
+	}
+	return false
+}
+
+func wasSoftDeleted(db *gorm.DB, objId string) bool {
+	value, _ := db.InstanceGet(settingWasDeleted)
+	deleted, _ := value.(map[string]bool)
+	return deleted[objId]
+}
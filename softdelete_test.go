@@ -0,0 +1,41 @@
+package audited
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestDeleteOperation(t *testing.T) {
+	cases := []struct {
+		name string
+		db   *gorm.DB
+		want string
+	}{
+		{"soft delete", statementFor(t, &Archived{}), OperationSoftDelete},
+		{"unscoped delete", statementFor(t, &Archived{}).Unscoped(), OperationHardDelete},
+		{"no deleted at", statementFor(t, &Subscriber{}), OperationDelete},
+		{"no deleted at unscoped", statementFor(t, &Subscriber{}).Unscoped(), OperationDelete},
+	}
+	for _, c := range cases {
+		if got := deleteOperation(c.db); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIsSoftDeleted(t *testing.T) {
+	db := statementFor(t, &Archived{})
+	deleted := Archived{Id: "a", DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}
+	if !isSoftDeleted(db, reflect.ValueOf(&deleted)) {
+		t.Error("soft deleted row not reported")
+	}
+	if isSoftDeleted(db, reflect.ValueOf(Archived{Id: "b"})) {
+		t.Error("row reported soft deleted")
+	}
+	if isSoftDeleted(statementFor(t, &Subscriber{}), reflect.ValueOf(Subscriber{Id: "s"})) {
+		t.Error("row without deleted at reported soft deleted")
+	}
+}
@@ -0,0 +1,42 @@
+package audited
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+var contextKeySource = ContextKey("audited_source")
+
+// WithSource returns a copy of ctx tagging the changes made with it with
+// source, stored in the source of their entries, e.g.
+// "migration:2024_06_add_flags" for the changes of a data migration or
+// "job:nightly_reindex" for those of a batch job. Changes made by people carry
+// no source.
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, contextKeySource, source)
+}
+
+// SourceFrom returns the source set on ctx with WithSource
+func SourceFrom(ctx context.Context) string {
+	source, _ := ctx.Value(contextKeySource).(string)
+	return source
+}
+
+// FromSource is a scope selecting the entries of the changes tagged with
+// source, e.g.
+//
+//	audited.Query(db).Scopes(audited.FromSource("migration:2024_06_add_flags")).Find(&entries)
+func FromSource(source string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("source = ?", source)
+	}
+}
+
+// HumanChanges is a scope selecting the entries of the changes without a
+// source, leaving out those of migrations and batch jobs
+func HumanChanges() func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("source IS NULL OR source = ''")
+	}
+}
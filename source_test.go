@@ -0,0 +1,32 @@
+package audited
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestSource(t *testing.T) {
+	if got := SourceFrom(context.Background()); got != "" {
+		t.Fatalf("got source %q without WithSource", got)
+	}
+	ctx := WithSource(context.Background(), "migration:2024_06_add_flags")
+	if got := SourceFrom(ctx); got != "migration:2024_06_add_flags" {
+		t.Fatalf("got source %q", got)
+	}
+
+	db := statementFor(t, &Invoice{})
+	sql := Query(db).ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return tx.Scopes(FromSource("job:reindex")).Find(&[]AuditLog{})
+	})
+	if want := "SELECT * FROM `audit_logs` WHERE source = \"job:reindex\""; sql != want {
+		t.Fatalf("got query %s", sql)
+	}
+	sql = Query(db).ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return tx.Scopes(HumanChanges()).Find(&[]AuditLog{})
+	})
+	if want := "SELECT * FROM `audit_logs` WHERE source IS NULL OR source = ''"; sql != want {
+		t.Fatalf("got query %s", sql)
+	}
+}
@@ -0,0 +1,263 @@
+package audited
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StatsOptions filters the entries counted by Stats
+type StatsOptions struct {
+	TableName string
+	Domain    string
+	UserId    string
+	Since     time.Time
+	Until     time.Time
+	// Rollups reads the counts of the hours rolled up by Rollup from
+	// RollupsTable, only the entries of the other hours are scanned
+	Rollups bool
+	// Privacy adds noise to the counts so reports can be shared without
+	// revealing the activity of individual users, nil reports exact counts
+	Privacy *DifferentialPrivacy
+}
+
+// DifferentialPrivacy adds Laplace noise calibrated to Epsilon and
+// Sensitivity to every count of a report. Which groups have entries is
+// protected too: the groups reported are either the declared Groups, or those
+// whose noisy count reaches a threshold.
+type DifferentialPrivacy struct {
+	// Epsilon is the privacy budget spent by a report, smaller values add
+	// more noise. Every report spends it again, budget repeated reports
+	// accordingly.
+	Epsilon float64
+	// Sensitivity is the most one person can change a count by, e.g. the
+	// number of entries a user creates per reporting period, defaults to 1
+	Sensitivity float64
+	// Groups is the declared domain of the report: every group of it is
+	// reported, with a noisy count of zero when it has no entries, and no
+	// other group is
+	Groups []StatsGroup
+	// Delta is the probability that a group with the entries of a single
+	// person is reported without Groups, 1e-6 by default. Groups are only
+	// reported when their noisy count reaches Sensitivity plus the noise
+	// exceeded with probability Delta.
+	Delta float64
+}
+
+// StatsGroup is a table and operation counted by Stats
+type StatsGroup struct {
+	TableName     string `json:"table_name"`
+	OperationType string `json:"operation_type"`
+}
+
+const defaultPrivacyDelta = 1e-6
+
+// StatsRow is the number of entries of an operation on a table
+type StatsRow struct {
+	TableName     string `json:"table_name"`
+	OperationType string `json:"operation_type"`
+	Count         int64  `json:"count"`
+}
+
+// ErrInvalidEpsilon is returned by Stats for a DifferentialPrivacy without a
+// positive Epsilon
+var ErrInvalidEpsilon = errors.New("audited: differential privacy needs a positive epsilon")
+
+// Stats counts the entries selected by opts per table and operation. With
+// opts.Privacy the counts are noisy, rounded and never negative, and only the
+// groups it lets through are reported.
+func Stats(db *gorm.DB, opts StatsOptions) ([]StatsRow, error) {
+	if opts.Privacy != nil && !(opts.Privacy.Epsilon > 0) {
+		return nil, ErrInvalidEpsilon
+	}
+	db = Reader(db)
+	var rows []StatsRow
+	var err error
+	if opts.Rollups {
+		rows, err = statsWithRollups(db, opts)
+	} else {
+		rows, err = countEntries(db, opts, opts.Since, opts.Until)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Privacy != nil {
+		return opts.Privacy.report(rows), nil
+	}
+	return rows, nil
+}
+
+// countEntries counts the entries of [since, until) selected by opts, zero
+// bounds are open
+func countEntries(db *gorm.DB, opts StatsOptions, since, until time.Time) ([]StatsRow, error) {
+	query := QueryOperations(db)
+	if opts.TableName != "" {
+		query = query.Where("table_name = ?", opts.TableName)
+	}
+	if opts.Domain != "" {
+		query = query.Where("domain = ?", opts.Domain)
+	}
+	if opts.UserId != "" {
+		query = query.Where("user_id = ?", opts.UserId)
+	}
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+	if !until.IsZero() {
+		query = query.Where("created_at < ?", until)
+	}
+
+	var rows []StatsRow
+	if err := query.Select("table_name, operation_type, COUNT(*) AS count").
+		Group("table_name, operation_type").Order("table_name, operation_type").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// statsWithRollups counts the entries selected by opts from the rollups, and
+// from the entries for the hours around them
+func statsWithRollups(db *gorm.DB, opts StatsOptions) ([]StatsRow, error) {
+	watermark, err := rollupWatermark(db.Session(&gorm.Session{NewDB: true}))
+	if err != nil {
+		return nil, err
+	}
+	from, to, ok := rollupRange(opts.Since, opts.Until, watermark)
+	if !ok {
+		return countEntries(db, opts, opts.Since, opts.Until)
+	}
+	rows, err := rollupStats(db, opts, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Since.IsZero() && opts.Since.Before(from) {
+		before, err := countEntries(db, opts, opts.Since, from)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, before...)
+	}
+	if opts.Until.IsZero() || to.Before(opts.Until) {
+		after, err := countEntries(db, opts, to, opts.Until)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, after...)
+	}
+	return mergeStats(rows), nil
+}
+
+// mergeStats adds up the counts of rows of the same table and operation,
+// ordered by table and operation
+func mergeStats(rows []StatsRow) []StatsRow {
+	index := map[[2]string]int{}
+	var merged []StatsRow
+	for _, row := range rows {
+		key := [2]string{row.TableName, row.OperationType}
+		if i, ok := index[key]; ok {
+			merged[i].Count += row.Count
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, row)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].TableName != merged[j].TableName {
+			return merged[i].TableName < merged[j].TableName
+		}
+		return merged[i].OperationType < merged[j].OperationType
+	})
+	return merged
+}
+
+// report returns the noisy rows of the groups of p: the declared groups, with
+// the groups without entries, or the groups whose noisy count reaches the
+// threshold
+func (p DifferentialPrivacy) report(rows []StatsRow) []StatsRow {
+	if len(p.Groups) == 0 {
+		var reported []StatsRow
+		threshold := p.threshold()
+		for _, row := range rows {
+			if noisy := p.noise(row.Count); noisy >= threshold {
+				row.Count = int64(math.Round(noisy))
+				reported = append(reported, row)
+			}
+		}
+		return reported
+	}
+	counts := map[StatsGroup]int64{}
+	for _, row := range rows {
+		counts[StatsGroup{row.TableName, row.OperationType}] = row.Count
+	}
+	var reported []StatsRow
+	seen := map[StatsGroup]bool{}
+	for _, group := range p.Groups {
+		if seen[group] {
+			continue
+		}
+		seen[group] = true
+		reported = append(reported, StatsRow{
+			TableName:     group.TableName,
+			OperationType: group.OperationType,
+			Count:         p.noisy(counts[group]),
+		})
+	}
+	return mergeStats(reported)
+}
+
+func (p DifferentialPrivacy) sensitivity() float64 {
+	if p.Sensitivity <= 0 {
+		return 1
+	}
+	return p.Sensitivity
+}
+
+// threshold returns the noisy count a group reaches with probability Delta
+// when a single person made its entries
+func (p DifferentialPrivacy) threshold() float64 {
+	delta := p.Delta
+	if delta <= 0 || delta >= 1 {
+		delta = defaultPrivacyDelta
+	}
+	return p.sensitivity() + p.sensitivity()/p.Epsilon*math.Log(1/(2*delta))
+}
+
+// noise returns count with Laplace noise
+func (p DifferentialPrivacy) noise(count int64) float64 {
+	return float64(count) + laplace(p.sensitivity()/p.Epsilon, uniform())
+}
+
+// noisy returns count with Laplace noise, rounded and never negative
+func (p DifferentialPrivacy) noisy(count int64) int64 {
+	noisy := math.Round(p.noise(count))
+	if noisy < 0 {
+		return 0
+	}
+	return int64(noisy)
+}
+
+// laplace returns the sample of the Laplace distribution with the scale for
+// u, uniform in (0, 1)
+func laplace(scale, u float64) float64 {
+	u -= 0.5
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
+
+// uniform returns a random float64 in (0, 1) from crypto/rand, as noise
+// from a predictable generator could be subtracted again
+func uniform() float64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return (float64(binary.BigEndian.Uint64(b[:])>>11) + 0.5) / (1 << 53)
+}
@@ -0,0 +1,68 @@
+package audited
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLaplace(t *testing.T) {
+	if got := laplace(2, 0.5); got != 0 {
+		t.Fatalf("median sample is %v, want 0", got)
+	}
+	if a, b := laplace(2, 0.2), laplace(2, 0.8); math.Abs(a+b) > 1e-9 || a >= 0 {
+		t.Fatalf("samples %v and %v are not symmetric around 0", a, b)
+	}
+	// the median of |X| is scale*ln(2)
+	if got := laplace(2, 0.75); math.Abs(got-2*math.Ln2) > 1e-9 {
+		t.Fatalf("got %v, want %v", got, 2*math.Ln2)
+	}
+}
+
+func TestNoisyCounts(t *testing.T) {
+	privacy := DifferentialPrivacy{Epsilon: 0.5}
+	const samples = 20000
+	var sum, deviation float64
+	for i := 0; i < samples; i++ {
+		count := privacy.noisy(1000)
+		sum += float64(count)
+		deviation += math.Abs(float64(count) - 1000)
+	}
+	// the mean absolute deviation of Laplace noise is its scale, 1/epsilon
+	if mean := sum / samples; math.Abs(mean-1000) > 0.2 {
+		t.Fatalf("noisy counts average %v, want about 1000", mean)
+	}
+	if mad := deviation / samples; math.Abs(mad-2) > 0.2 {
+		t.Fatalf("mean absolute deviation %v, want about 2", mad)
+	}
+	for i := 0; i < 100; i++ {
+		if count := privacy.noisy(0); count < 0 {
+			t.Fatalf("noisy count %d is negative", count)
+		}
+	}
+}
+
+func TestPrivateGroups(t *testing.T) {
+	rows := []StatsRow{
+		{TableName: "orders", OperationType: OperationCreate, Count: 1000},
+		{TableName: "orders", OperationType: OperationDelete, Count: 1},
+		{TableName: "payroll", OperationType: OperationUpdate, Count: 1000},
+	}
+	// the declared groups are reported, whether they have entries or not
+	privacy := DifferentialPrivacy{Epsilon: 1, Groups: []StatsGroup{
+		{"orders", OperationCreate}, {"orders", OperationUpdate}, {"orders", OperationCreate},
+	}}
+	reported := privacy.report(rows)
+	if len(reported) != 2 || reported[0].OperationType != OperationCreate || reported[1].OperationType != OperationUpdate {
+		t.Fatalf("got %+v, want the 2 declared groups", reported)
+	}
+
+	// without them the group of a single entry is below the threshold
+	privacy = DifferentialPrivacy{Epsilon: 1}
+	if threshold := privacy.threshold(); math.Abs(threshold-(1+math.Log(5e5))) > 1e-9 {
+		t.Fatalf("got threshold %v", threshold)
+	}
+	reported = privacy.report(rows)
+	if len(reported) != 2 || reported[0].OperationType != OperationCreate || reported[1].TableName != "payroll" {
+		t.Fatalf("got %+v, want the 2 large groups", reported)
+	}
+}
@@ -0,0 +1,43 @@
+package audited
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// WithStore writes entries with store instead of inserting them in the audit
+// tables, e.g. to keep the trail in another storage backend. Query and the
+// reports still read the audit tables. Wrap a GormSink in store to write the
+// entries there too.
+func WithStore(store AuditSink) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// GormSink is the default store of entries, see WithStore: it inserts them in
+// the audit tables of DB, in its storage layout
+type GormSink struct {
+	DB *gorm.DB
+}
+
+// NewGormSink returns a sink inserting entries in the audit tables of db
+func NewGormSink(db *gorm.DB) *GormSink {
+	return &GormSink{DB: db}
+}
+
+// Write inserts entries in a single statement, or in a transaction with their
+// field changes and payloads
+func (s *GormSink) Write(ctx context.Context, entries []AuditLog) error {
+	return insertAuditLogs(s.DB.Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: ctx}), entries)
+}
+
+// storeOf returns the store of the entries of db, its GormSink unless set
+// with WithStore
+func storeOf(db *gorm.DB) AuditSink {
+	if store := configFor(db).store; store != nil {
+		return store
+	}
+	return NewGormSink(auditConn(db))
+}
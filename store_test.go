@@ -0,0 +1,58 @@
+package audited
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestWithStore(t *testing.T) {
+	db := statementFor(t, &Invoice{})
+	if _, ok := storeOf(db).(*GormSink); !ok {
+		t.Fatalf("got store %T, want the GormSink", storeOf(db))
+	}
+
+	var stored []AuditLog
+	store := sinkFunc(func(ctx context.Context, entries []AuditLog) error {
+		stored = append(stored, entries...)
+		return nil
+	})
+	if err := RegisterCallbacks(db, WithStore(store)); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveAuditLogs(db, []AuditLog{{Id: newID(), TableName: "invoices", OperationType: OperationCreate}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].TableName != "invoices" {
+		t.Fatalf("got %v stored, want the entry", stored)
+	}
+}
+
+type memoryOrder struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+}
+
+func TestWithMemoryStore(t *testing.T) {
+	for _, id := range []string{"o-1", "o-2"} {
+		id := id
+		t.Run(id, func(t *testing.T) {
+			t.Parallel()
+			store := NewMemoryStore()
+			db := statementFor(t, &memoryOrder{})
+			if err := RegisterCallbacks(db, WithMemoryStore(store)); err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.WithValue(context.Background(), ContextKeyEmail, "ann@example.com")
+			if err := db.Session(&gorm.Session{DryRun: true}).WithContext(ctx).Create(&memoryOrder{Id: id, Status: "new"}).Error; err != nil {
+				t.Fatal(err)
+			}
+			// the store of each database only has its own entries
+			entries := store.Entries()
+			if len(entries) != 1 || entries[0].ObjectId != id || entries[0].OperationType != OperationCreate {
+				t.Fatalf("got entries %+v, want the create of %s", entries, id)
+			}
+		})
+	}
+}
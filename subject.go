@@ -0,0 +1,109 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SubjectSpec identifies the person of a data subject access request
+type SubjectSpec struct {
+	Email  string `json:"email,omitempty"`
+	UserId string `json:"user_id,omitempty"`
+}
+
+func (s SubjectSpec) identifiers() []string {
+	var ids []string
+	if s.Email != "" {
+		ids = append(ids, s.Email)
+	}
+	if s.UserId != "" {
+		ids = append(ids, s.UserId)
+	}
+	return ids
+}
+
+// SubjectReport holds the entries about a person, it is meant to be handed
+// over as JSON
+type SubjectReport struct {
+	Subject   SubjectSpec `json:"subject"`
+	CreatedAt time.Time   `json:"created_at"`
+	// Acted are the entries of changes the person made
+	Acted []AuditLog `json:"acted"`
+	// Owned are the entries of objects the person owns, per their OwnerId,
+	// see RegisterOwnerOf
+	Owned []AuditLog `json:"owned"`
+}
+
+// ErrEmptySubject is returned by SubjectExport without an email or user id
+var ErrEmptySubject = errors.New("audited: subject has no email or user id")
+
+// SubjectExport gathers the entries where the person of spec is the acting
+// user or the owner of the object, see RegisterOwnerOf, in sequence order,
+// e.g. to answer a GDPR article 15 request
+func SubjectExport(ctx context.Context, db *gorm.DB, spec SubjectSpec) (*SubjectReport, error) {
+	ids := spec.identifiers()
+	if len(ids) == 0 {
+		return nil, ErrEmptySubject
+	}
+	db = db.Session(&gorm.Session{NewDB: true, Context: ctx})
+	report := &SubjectReport{Subject: spec, CreatedAt: time.Now().UTC()}
+
+	acted := Query(Reader(db)).Where("user_id IN ?", ids).Session(&gorm.Session{})
+	if err := eachEntry(acted, func(entry AuditLog) error {
+		report.Acted = append(report.Acted, entry)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	owned := Query(Reader(db)).Where("owner_id IN ?", ids).Session(&gorm.Session{})
+	if err := eachEntry(owned, func(entry AuditLog) error {
+		report.Owned = append(report.Owned, entry)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := RecordAdminAction(ctx, db, MetaSubjectExport, map[string]interface{}{
+		"email": spec.Email, "user_id": spec.UserId, "acted": len(report.Acted), "owned": len(report.Owned),
+	}); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// RequestSubjectErasure queues the purge of the history of every object
+// owned by the person of spec, see RegisterOwnerOf, for the next PurgeHistory
+// run, e.g. for a GDPR article 17 request, and returns the number of objects.
+// The entries of changes the person made to objects they don't own are kept.
+func RequestSubjectErasure(ctx context.Context, db *gorm.DB, spec SubjectSpec, mode PurgeMode) (int, error) {
+	ids := spec.identifiers()
+	if len(ids) == 0 {
+		return 0, ErrEmptySubject
+	}
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: ctx})
+	var objects []struct {
+		TableName string
+		ObjectId  string
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := QueryOperations(tx).Where("owner_id IN ?", ids).
+			Distinct("table_name", "object_id").Find(&objects).Error; err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if err := queuePurge(tx, object.TableName, object.ObjectId, mode, getCurrentUser(tx)); err != nil {
+				return err
+			}
+		}
+		return RecordAdminAction(ctx, tx, MetaErasure, map[string]interface{}{
+			"email": spec.Email, "user_id": spec.UserId, "objects": len(objects), "mode": string(mode),
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(objects), nil
+}
@@ -0,0 +1,20 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSubjectIdentifiers(t *testing.T) {
+	if ids := (SubjectSpec{}).identifiers(); len(ids) != 0 {
+		t.Fatalf("empty spec has identifiers %v", ids)
+	}
+	ids := SubjectSpec{Email: "ann@example.com", UserId: "42"}.identifiers()
+	if len(ids) != 2 || ids[0] != "ann@example.com" || ids[1] != "42" {
+		t.Fatalf("got identifiers %v", ids)
+	}
+	if _, err := RequestSubjectErasure(context.Background(), statementFor(t, &Subscriber{}), SubjectSpec{}, PurgeDelete); !errors.Is(err, ErrEmptySubject) {
+		t.Fatalf("erasure of an empty subject returned %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package audited
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+
+	"gorm.io/datatypes"
+)
+
+var summaries = struct {
+	sync.RWMutex
+	m map[string]*template.Template
+}{m: map[string]*template.Template{}}
+
+// RegisterSummary sets the template rendered into the Summary of entries
+// written for table, e.g.
+//
+//	{{.user}} changed order {{.object_id}} status to {{.new.status}}
+//
+// Templates get user, table, operation, object_id, old and new. For creates and
+// updates new holds the row after the operation, for updates old holds the row
+// as recorded by the previous entry of the object, for deletes old holds the
+// row that was deleted. added and removed hold the elements an update added
+// to and removed from array fields:
+//
+//	{{range .added.roles}}added role {{.}} {{end}}
+func RegisterSummary(table, text string) error {
+	tmpl, err := template.New(table).Parse(text)
+	if err != nil {
+		return fmt.Errorf("audited: invalid summary template for %s: %w", table, err)
+	}
+	summaries.Lock()
+	defer summaries.Unlock()
+	summaries.m[table] = tmpl
+	return nil
+}
+
+// RenderSummary renders the summary template registered for the table of
+// entry, it returns "" when there is none. It can be used at query time for
+// entries written before a template was registered. previousData is the data
+// of the entry before it in the trail of the object, used as old for updates.
+func RenderSummary(entry AuditLog, previousData datatypes.JSON) (string, error) {
+	summaries.RLock()
+	tmpl := summaries.m[entry.TableName]
+	summaries.RUnlock()
+	if tmpl == nil {
+		return "", nil
+	}
+
+	data, err := templateData(entry, previousData)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// templateData returns the data summary and notification templates get
+func templateData(entry AuditLog, previousData datatypes.JSON) (map[string]interface{}, error) {
+	data, err := decodeTableSnapshot(entry.TableName, entry.Data)
+	if err != nil {
+		return nil, err
+	}
+	before, after := map[string]interface{}{}, map[string]interface{}{}
+	switch entry.OperationType {
+	case OperationDelete, OperationSoftDelete, OperationHardDelete:
+		before = data
+	case OperationUpdate, OperationRestore:
+		if before, err = decodeTableSnapshot(entry.TableName, previousData); err != nil {
+			return nil, err
+		}
+		after = data
+	default:
+		after = data
+	}
+	added, removed := map[string]interface{}{}, map[string]interface{}{}
+	for _, change := range diffMaps(before, after) {
+		if change.Added != nil {
+			added[change.Field] = change.Added
+		}
+		if change.Removed != nil {
+			removed[change.Field] = change.Removed
+		}
+	}
+	return map[string]interface{}{
+		"user":      entry.UserId,
+		"table":     entry.TableName,
+		"operation": entry.OperationType,
+		"object_id": entry.ObjectId,
+		"old":       before,
+		"new":       after,
+		"added":     added,
+		"removed":   removed,
+	}, nil
+}
+
+func hasSummary(table string) bool {
+	summaries.RLock()
+	defer summaries.RUnlock()
+	return summaries.m[table] != nil
+}
+
+func summarize(entry *AuditLog, previousData datatypes.JSON) string {
+	summary, err := RenderSummary(*entry, previousData)
+	if err != nil {
+		log.Println(fmt.Errorf("error rendering audit summary: %s", err.Error()))
+	}
+	return summary
+}
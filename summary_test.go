@@ -0,0 +1,41 @@
+package audited
+
+import "testing"
+
+func TestRenderSummaryUpdate(t *testing.T) {
+	if err := RegisterSummary("summary_orders", "{{.user}} moved {{.object_id}} from {{.old.status}} to {{.new.status}}"); err != nil {
+		t.Fatal(err)
+	}
+	entry := AuditLog{
+		TableName:     "summary_orders",
+		OperationType: OperationUpdate,
+		ObjectId:      "o-1",
+		UserId:        "ana",
+		Data:          []byte(`{"status": "shipped"}`),
+	}
+	got, err := RenderSummary(entry, []byte(`{"status": "paid"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ana moved o-1 from paid to shipped"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenderSummaryArrayElements(t *testing.T) {
+	if err := RegisterSummary("summary_users", "{{range .added.roles}}added role {{.}}. {{end}}{{range .removed.roles}}removed role {{.}}. {{end}}"); err != nil {
+		t.Fatal(err)
+	}
+	entry := AuditLog{
+		TableName:     "summary_users",
+		OperationType: OperationUpdate,
+		Data:          []byte(`{"roles": ["viewer", "admin"]}`),
+	}
+	got, err := RenderSummary(entry, []byte(`{"roles": ["viewer", "editor"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "added role admin. removed role editor. "; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/mleonidas/audited/temporal
+
+go 1.21.0
+
+require (
+	github.com/mleonidas/audited v0.0.0
+	go.temporal.io/api v1.24.0
+	go.temporal.io/sdk v1.25.1
+)
+
+replace github.com/mleonidas/audited => ../
@@ -0,0 +1,119 @@
+// Package temporal attributes the changes made in the activities of Temporal
+// workflows to the workflow and the user who started it, see
+// audited.WithWorkflow
+package temporal
+
+import (
+	"context"
+
+	"github.com/mleonidas/audited"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+)
+
+// HeaderKey is the header of workflows and activities carrying the user who
+// started the workflow
+const HeaderKey = "audited-user"
+
+type workflowUserKey struct{}
+
+// Propagator is a workflow.ContextPropagator carrying the user of the context
+// a workflow is started with, see audited.WorkflowUser, to its activities and
+// child workflows. Set it in the ContextPropagators of the client options of
+// both the starters and the workers.
+type Propagator struct{}
+
+var _ workflow.ContextPropagator = Propagator{}
+
+// Inject writes the user of ctx to the headers of the workflow it starts
+func (Propagator) Inject(ctx context.Context, writer workflow.HeaderWriter) error {
+	return writeUser(writer, audited.WorkflowUser(ctx))
+}
+
+// Extract returns ctx, the user is read from the headers by the activity
+// interceptor, which also knows the workflow execution
+func (Propagator) Extract(ctx context.Context, _ workflow.HeaderReader) (context.Context, error) {
+	return ctx, nil
+}
+
+// InjectFromWorkflow writes the user of the workflow to the headers of the
+// activities and child workflows it starts
+func (Propagator) InjectFromWorkflow(ctx workflow.Context, writer workflow.HeaderWriter) error {
+	user, _ := ctx.Value(workflowUserKey{}).(string)
+	return writeUser(writer, user)
+}
+
+// ExtractToWorkflow keeps the user of the headers in the context of the
+// workflow
+func (Propagator) ExtractToWorkflow(ctx workflow.Context, reader workflow.HeaderReader) (workflow.Context, error) {
+	payload, ok := reader.Get(HeaderKey)
+	if !ok {
+		return ctx, nil
+	}
+	var user string
+	if err := converter.GetDefaultDataConverter().FromPayload(payload, &user); err != nil {
+		return ctx, err
+	}
+	return workflow.WithValue(ctx, workflowUserKey{}, user), nil
+}
+
+func writeUser(writer workflow.HeaderWriter, user string) error {
+	if user == "" {
+		return nil
+	}
+	payload, err := converter.GetDefaultDataConverter().ToPayload(user)
+	if err != nil {
+		return err
+	}
+	writer.Set(HeaderKey, payload)
+	return nil
+}
+
+// NewInterceptor returns a worker interceptor running every activity with
+// the context of its workflow execution, see audited.WithWorkflow, so the
+// entries of its changes get the workflow_id and workflow_run_id metadata and
+// the user of the HeaderKey header as the acting user:
+//
+//	worker.New(c, "orders", worker.Options{
+//		Interceptors: []interceptor.WorkerInterceptor{temporal.NewInterceptor()},
+//	})
+func NewInterceptor() interceptor.WorkerInterceptor {
+	return &workerInterceptor{}
+}
+
+type workerInterceptor struct {
+	interceptor.WorkerInterceptorBase
+}
+
+func (w *workerInterceptor) InterceptActivity(ctx context.Context, next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityInterceptor{}
+	i.Next = next
+	return i
+}
+
+type activityInterceptor struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+func (a *activityInterceptor) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	ctx, err := withWorkflow(ctx, activity.GetInfo(ctx).WorkflowExecution, interceptor.Header(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return a.Next.ExecuteActivity(ctx, in)
+}
+
+// withWorkflow returns ctx carrying the workflow execution and the user of
+// header
+func withWorkflow(ctx context.Context, execution workflow.Execution, header map[string]*commonpb.Payload) (context.Context, error) {
+	info := audited.WorkflowInfo{WorkflowId: execution.ID, RunId: execution.RunID}
+	if payload, ok := header[HeaderKey]; ok {
+		if err := converter.GetDefaultDataConverter().FromPayload(payload, &info.UserId); err != nil {
+			return ctx, err
+		}
+	}
+	return audited.WithWorkflow(ctx, info), nil
+}
@@ -0,0 +1,49 @@
+package temporal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mleonidas/audited"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/workflow"
+)
+
+type header map[string]*commonpb.Payload
+
+func (h header) Set(key string, value *commonpb.Payload) { h[key] = value }
+
+func TestPropagation(t *testing.T) {
+	started := context.WithValue(context.Background(), audited.ContextKeyEmail, "ann@example.com")
+	headers := header{}
+	if err := (Propagator{}).Inject(started, headers); err != nil {
+		t.Fatal(err)
+	}
+
+	// an activity on a worker, with the headers of its workflow
+	execution := workflow.Execution{ID: "invoice-42", RunID: "r1"}
+	ctx, err := withWorkflow(context.Background(), execution, headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, ok := audited.WorkflowFrom(ctx)
+	if !ok || info != (audited.WorkflowInfo{WorkflowId: "invoice-42", RunId: "r1", UserId: "ann@example.com"}) {
+		t.Fatalf("got workflow %+v", info)
+	}
+
+	// a workflow started without a user carries none
+	headers = header{}
+	if err := (Propagator{}).Inject(context.Background(), headers); err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 0 {
+		t.Fatalf("got headers %v", headers)
+	}
+	ctx, err = withWorkflow(context.Background(), execution, headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := audited.WorkflowFrom(ctx); info.UserId != "" || info.WorkflowId != "invoice-42" {
+		t.Fatalf("got workflow %+v", info)
+	}
+}
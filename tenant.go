@@ -0,0 +1,147 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var contextKeyTenant = ContextKey("audited_tenant")
+
+// ErrEmptyTenant is returned by ExportTenant and PurgeTenant without a tenant,
+// which would select the entries of no tenant instead
+var ErrEmptyTenant = errors.New("audited: empty tenant")
+
+// WithTenant returns a copy of ctx making changes for tenant, stored in the
+// tenant_id of their entries, so the trail of a multi-tenant deployment can
+// be read, limited, see EnforceQuotas, and exported per tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKeyTenant, tenant)
+}
+
+// TenantFrom returns the tenant set on ctx with WithTenant
+func TenantFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	tenant, _ := ctx.Value(contextKeyTenant).(string)
+	return tenant
+}
+
+// ForTenant is a scope selecting the entries of the changes made for tenant
+func ForTenant(tenant string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenant)
+	}
+}
+
+// ExportTenant exports the entries of tenant, as Export does with the other
+// selections of opts, e.g. to return its data when it is offboarded. The
+// entries stored in the table of a region are exported with a ctx of the
+// region, see WithResidency.
+func ExportTenant(ctx context.Context, db *gorm.DB, tenant string, opts ExportOptions) (*ExportManifest, error) {
+	if tenant == "" {
+		return nil, ErrEmptyTenant
+	}
+	opts.Tenant = tenant
+	return Export(ctx, db, opts)
+}
+
+// PurgeTenant deletes the entries of tenant, with their payloads and field
+// changes, from the audit table and the tables of the regions, see
+// WithResidencyTable, and returns the number deleted. The last changes of
+// WithMaintainLatest pointing to them are deleted, and the rolled up hours they
+// were counted in are rolled up again without them. Entries of other tenants
+// and of no tenant are left as they are.
+func PurgeTenant(ctx context.Context, db *gorm.DB, tenant string) (int64, error) {
+	if tenant == "" {
+		return 0, ErrEmptyTenant
+	}
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: ctx})
+	regions := []string{""}
+	for region := range configFor(db).residency {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	var purged int64
+	hours := map[time.Time]bool{}
+	for _, region := range regions {
+		rdb := db
+		if region != "" {
+			rdb = db.WithContext(WithResidency(ctx, region))
+		}
+		n, err := purgeTenantEntries(rdb, tenant, hours)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	if err := rollupAgain(db, hours); err != nil {
+		return purged, err
+	}
+	return purged, RecordAdminAction(ctx, db, MetaTenantPurge, map[string]interface{}{
+		"tenant": tenant, "entries": purged,
+	})
+}
+
+// purgeTenantEntries deletes the entries of tenant from the audit table of db,
+// adding the hours they were created in to hours
+func purgeTenantEntries(db *gorm.DB, tenant string, hours map[time.Time]bool) (int64, error) {
+	var purged int64
+	for {
+		var batch []AuditLog
+		if err := QueryOperations(db).Select("id, table_name, object_id, created_at").Scopes(ForTenant(tenant)).
+			Limit(defaultSeqLimit).Find(&batch).Error; err != nil {
+			return purged, err
+		}
+		if len(batch) == 0 {
+			return purged, nil
+		}
+		ids := make([]ID, len(batch))
+		for i, entry := range batch {
+			ids[i] = entry.Id
+			hours[entry.CreatedAt.Truncate(time.Hour)] = true
+		}
+		var n int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if n, err = deleteEntryIds(tx, ids); err != nil {
+				return err
+			}
+			if !configFor(tx).maintainLatest {
+				return nil
+			}
+			return tx.Exec("DELETE FROM "+tx.Statement.Quote(latestTable(tx))+" WHERE audit_id IN ?", ids).Error
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += n
+		invalidateTrails(db, batch)
+	}
+}
+
+// rollupAgain rolls up again the hours already rolled up, so the counts of
+// RollupsTable drop the entries deleted from them
+func rollupAgain(db *gorm.DB, hours map[time.Time]bool) error {
+	if len(hours) == 0 || !db.Migrator().HasTable(rollupsTable(db)) {
+		return nil
+	}
+	watermark, err := rollupWatermark(db)
+	if err != nil {
+		return err
+	}
+	for hour := range hours {
+		if !hour.Before(watermark) {
+			continue
+		}
+		if err := rollupHour(db, hour); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package audited
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEmptyTenant(t *testing.T) {
+	db := statementFor(t, &Invoice{})
+	if _, err := ExportTenant(context.Background(), db, "", ExportOptions{Dir: t.TempDir()}); !errors.Is(err, ErrEmptyTenant) {
+		t.Errorf("got %v exporting no tenant, want ErrEmptyTenant", err)
+	}
+	if _, err := PurgeTenant(context.Background(), db, ""); !errors.Is(err, ErrEmptyTenant) {
+		t.Errorf("got %v purging no tenant, want ErrEmptyTenant", err)
+	}
+}
@@ -0,0 +1,91 @@
+package audited
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// coldTierBatchSize is the number of entries moved to the cold table per
+// transaction
+const coldTierBatchSize = 1000
+
+// WithColdTier keeps the entries younger than after in the audit table and
+// has MoveToColdTier move older ones to its cold table, the audit table with
+// a "_cold" suffix, e.g. on cheaper storage. Query and the other readers read
+// both tables. It applies to LayoutSingleTable.
+func WithColdTier(after time.Duration) Option {
+	return func(o *options) {
+		o.coldAfter = after
+	}
+}
+
+// coldTable returns the cold table of the audit table of db, ok is false when
+// entries aren't tiered
+func coldTable(db *gorm.DB) (table string, ok bool) {
+	if splitLayout(db) || configFor(db).coldAfter <= 0 {
+		return "", false
+	}
+	return auditTable(db) + "_cold", true
+}
+
+// tiered returns table with the cold table of db when table is its audit
+// table, for statements changing entries in both tiers
+func tiered(db *gorm.DB, table string) []string {
+	if cold, ok := coldTable(db); ok && table == auditTable(db) {
+		return []string{table, cold}
+	}
+	return []string{table}
+}
+
+// tieredQuery returns a query over the entries of both tiers of db, read as
+// its audit table
+func tieredQuery(db *gorm.DB, cold string) *gorm.DB {
+	hot, quote := auditTable(db), db.Statement.Quote
+	return db.Table("(SELECT * FROM " + quote(hot) + " UNION ALL SELECT * FROM " + quote(cold) + ") AS " + hot)
+}
+
+// MoveToColdTier moves the entries older than the age given to WithColdTier
+// from the audit table of db to its cold table, in batches, and returns the
+// number of entries moved. The cold table has the columns of the audit
+// table. Run it on its own or with the maintenance pass, see
+// MaintenanceOptions.ColdTier.
+func MoveToColdTier(ctx context.Context, db *gorm.DB) (int64, error) {
+	db = auditConn(db).Session(&gorm.Session{NewDB: true, Context: ctx})
+	cold, ok := coldTable(db)
+	if !ok {
+		return 0, nil
+	}
+	hot, quote := auditTable(db), db.Statement.Quote
+	cutoff := time.Now().Add(-configFor(db).coldAfter)
+
+	var moved int64
+	for {
+		var ids []string
+		if err := db.Table(hot).Where("created_at < ?", cutoff).Order("created_at").
+			Limit(coldTierBatchSize).Pluck("id", &ids).Error; err != nil {
+			return moved, err
+		}
+		if len(ids) == 0 {
+			return moved, nil
+		}
+		objects, err := cachedObjects(db, "id IN ?", ids)
+		if err != nil {
+			return moved, err
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("INSERT INTO "+quote(cold)+" SELECT * FROM "+quote(hot)+" WHERE id IN ?", ids).Error; err != nil {
+				return err
+			}
+			return tx.Exec("DELETE FROM "+quote(hot)+" WHERE id IN ?", ids).Error
+		}); err != nil {
+			return moved, err
+		}
+		invalidateTrails(db, objects)
+		moved += int64(len(ids))
+		if len(ids) < coldTierBatchSize {
+			return moved, nil
+		}
+	}
+}
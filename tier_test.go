@@ -0,0 +1,42 @@
+package audited
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestColdTier(t *testing.T) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := coldTable(db); ok {
+		t.Fatal("got a cold table without WithColdTier")
+	}
+	if err := RegisterCallbacks(db, WithTableName("billing_audit_logs"), WithColdTier(90*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if cold, ok := coldTable(db); !ok || cold != "billing_audit_logs_cold" {
+		t.Fatalf("got cold table %q, %v", cold, ok)
+	}
+	if !isAuditTable(db, "billing_audit_logs_cold") {
+		t.Fatal("expected the cold table to be an audit table")
+	}
+	if got, want := tiered(db, "billing_audit_logs"), []string{"billing_audit_logs", "billing_audit_logs_cold"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got tables %v, want %v", got, want)
+	}
+	if got := tiered(db, FieldChangesTable); !reflect.DeepEqual(got, []string{FieldChangesTable}) {
+		t.Fatalf("got tables %v", got)
+	}
+
+	sql := Query(db).ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("object_id = ?", "1").Find(&[]AuditLog{})
+	})
+	if want := "SELECT * FROM (SELECT * FROM `billing_audit_logs` UNION ALL SELECT * FROM `billing_audit_logs_cold`) AS billing_audit_logs WHERE object_id = \"1\""; sql != want {
+		t.Fatalf("got query %s", sql)
+	}
+}
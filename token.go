@@ -0,0 +1,167 @@
+package audited
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scopes of API tokens, reading the entries of a table is scoped per table
+// with ScopeReadTable
+const (
+	ScopeExport = "export"
+	ScopePurge  = "purge"
+)
+
+// ScopeReadTable is the scope to read the entries of table, "*" for every table
+func ScopeReadTable(table string) string {
+	return "read:table:" + table
+}
+
+// ErrInvalidToken is returned for tokens that are malformed, badly signed or
+// expired
+var ErrInvalidToken = errors.New("audited: invalid api token")
+
+// MinTokenKeySize is the length in bytes of the shortest Key a TokenIssuer
+// accepts, the size of the SHA-256 block halved
+const MinTokenKeySize = 32
+
+// ErrWeakTokenKey is returned by a TokenIssuer whose Key is shorter than
+// MinTokenKeySize, e.g. unset, which would let anyone sign tokens
+var ErrWeakTokenKey = errors.New("audited: api token key shorter than 32 bytes")
+
+// TokenIssuer issues and validates API tokens for the audit endpoints of a
+// service. Tokens are JWTs signed with HMAC-SHA256, the scope claim holds the
+// space separated scopes, as in RFC 8693. Key must be a random secret of at
+// least MinTokenKeySize bytes.
+type TokenIssuer struct {
+	Key []byte
+}
+
+// TokenClaims are the claims of an API token
+type TokenClaims struct {
+	Subject   string `json:"sub"`
+	Scope     string `json:"scope"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Issue returns a token for subject, e.g. the name of an internal tool, with
+// scopes, valid for ttl
+func (t *TokenIssuer) Issue(subject string, scopes []string, ttl time.Duration) (string, error) {
+	if len(t.Key) < MinTokenKeySize {
+		return "", ErrWeakTokenKey
+	}
+	now := time.Now()
+	claims, err := json.Marshal(TokenClaims{
+		Subject:   subject,
+		Scope:     strings.Join(scopes, " "),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(t.sign(signed)), nil
+}
+
+// Validate checks the signature and expiry of token and returns its claims
+func (t *TokenIssuer) Validate(token string) (*TokenClaims, error) {
+	if len(t.Key) < MinTokenKeySize {
+		return nil, ErrWeakTokenKey
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	encoded, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(encoded, &header) != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, t.sign(parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+	var claims TokenClaims
+	if encoded, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil ||
+		json.Unmarshal(encoded, &claims) != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+func (t *TokenIssuer) sign(signed string) []byte {
+	mac := hmac.New(sha256.New, t.Key)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// Allows reports whether the token grants scope. A granted scope ending in "*"
+// grants every scope it is a prefix of, e.g. "read:table:*".
+func (c TokenClaims) Allows(scope string) bool {
+	for _, granted := range strings.Fields(c.Scope) {
+		if granted == scope || (strings.HasSuffix(granted, "*") && strings.HasPrefix(scope, granted[:len(granted)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenContextKey struct{}
+
+// TokenFromContext returns the claims of the token a request was authorized
+// with by RequireScope, or nil
+func TokenFromContext(ctx context.Context) *TokenClaims {
+	claims, _ := ctx.Value(tokenContextKey{}).(*TokenClaims)
+	return claims
+}
+
+// RequireScope only lets requests through with a bearer token of issuer that
+// grants scope. The subject of the token becomes the acting user of the
+// request unless one is set already.
+func RequireScope(issuer *TokenIssuer, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "missing api token", http.StatusUnauthorized)
+				return
+			}
+			claims, err := issuer.Validate(token)
+			if errors.Is(err, ErrWeakTokenKey) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if !claims.Allows(scope) {
+				http.Error(w, "api token lacks scope "+scope, http.StatusForbidden)
+				return
+			}
+			ctx := context.WithValue(r.Context(), tokenContextKey{}, claims)
+			if ctx.Value(ContextKeyEmail) == nil {
+				ctx = context.WithValue(ctx, ContextKeyEmail, claims.Subject)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
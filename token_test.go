@@ -0,0 +1,96 @@
+package audited
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTokenKey = []byte("a secret of at least thirty two bytes")
+
+func TestTokens(t *testing.T) {
+	issuer := &TokenIssuer{Key: testTokenKey}
+	token, err := issuer.Issue("support-tool", []string{ScopeReadTable("users"), ScopeExport}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := issuer.Validate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "support-tool" || !claims.Allows("read:table:users") || !claims.Allows(ScopeExport) {
+		t.Fatalf("got claims %+v", claims)
+	}
+	if claims.Allows(ScopeReadTable("orders")) || claims.Allows(ScopePurge) {
+		t.Fatal("token allows scopes it wasn't issued")
+	}
+
+	if _, err := (&TokenIssuer{Key: []byte("another secret of at least 32 bytes")}).Validate(token); err != ErrInvalidToken {
+		t.Fatalf("token signed with another key validated: %v", err)
+	}
+	parts := strings.Split(token, ".")
+	forged, _ := issuer.Issue("support-tool", []string{ScopePurge}, time.Hour)
+	if _, err := issuer.Validate(parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]); err != ErrInvalidToken {
+		t.Fatalf("token with changed claims validated: %v", err)
+	}
+	expired, _ := issuer.Issue("support-tool", []string{ScopeExport}, -time.Minute)
+	if _, err := issuer.Validate(expired); err != ErrInvalidToken {
+		t.Fatalf("expired token validated: %v", err)
+	}
+}
+
+func TestWildcardScope(t *testing.T) {
+	claims := TokenClaims{Scope: ScopeReadTable("*")}
+	if !claims.Allows(ScopeReadTable("orders")) || claims.Allows(ScopeExport) {
+		t.Fatalf("wildcard scope %q matched wrongly", claims.Scope)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	issuer := &TokenIssuer{Key: testTokenKey}
+	handler := RequireScope(issuer, ScopePurge)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := r.Context().Value(ContextKeyEmail); user != "ops-tool" {
+			t.Errorf("acting user is %v", user)
+		}
+	}))
+	purge, _ := issuer.Issue("ops-tool", []string{ScopePurge}, time.Hour)
+	read, _ := issuer.Issue("ops-tool", []string{ScopeReadTable("*")}, time.Hour)
+
+	for _, c := range []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer nonsense", http.StatusUnauthorized},
+		{"Bearer " + read, http.StatusForbidden},
+		{"Bearer " + purge, http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/purge", nil)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%q: got status %d, want %d", c.auth, rec.Code, c.want)
+		}
+	}
+}
+
+func TestWeakTokenKey(t *testing.T) {
+	token, err := (&TokenIssuer{Key: testTokenKey}).Issue("ops-tool", []string{ScopePurge}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][]byte{nil, {}, []byte("short")} {
+		issuer := &TokenIssuer{Key: key}
+		if _, err := issuer.Issue("ops-tool", []string{ScopePurge}, time.Hour); err != ErrWeakTokenKey {
+			t.Errorf("issued with a %d byte key: %v", len(key), err)
+		}
+		if _, err := issuer.Validate(token); err != ErrWeakTokenKey {
+			t.Errorf("validated with a %d byte key: %v", len(key), err)
+		}
+	}
+}
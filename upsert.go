@@ -0,0 +1,130 @@
+package audited
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OperationUpsert is recorded for creates with an ON CONFLICT DO UPDATE
+// clause when whether they inserted or updated the row can't be told, see
+// captureUpsert
+const OperationUpsert = "UPSERT"
+
+// settingUpsert holds the upsertRows of a create with an ON CONFLICT clause
+const settingUpsert = "audited:upsert"
+
+// upsertRow is a row of an upsert as found before it runs
+type upsertRow struct {
+	// known is set when the row was looked up by its conflict columns
+	known bool
+	// existing is the row the object conflicts with, invalid when there is
+	// none and the object is inserted
+	existing reflect.Value
+}
+
+// upsertClause returns the ON CONFLICT clause of the statement of db
+func upsertClause(db *gorm.DB) (clause.OnConflict, bool) {
+	c, ok := db.Statement.Clauses["ON CONFLICT"]
+	if !ok {
+		return clause.OnConflict{}, false
+	}
+	onConflict, ok := c.Expression.(clause.OnConflict)
+	return onConflict, ok
+}
+
+// captureUpsert looks up the rows the objects of a create with an ON CONFLICT
+// clause conflict with, before it runs: their entries are then a CREATE of
+// the objects inserted and an UPDATE, with its pre-image, of the rows
+// updated. Objects whose conflict columns aren't set get an UPSERT.
+func captureUpsert(db *gorm.DB) {
+	if isAuditTable(db, db.Statement.Table) || db.Error != nil || db.DryRun || memoryStoreOf(db) != nil {
+		return
+	}
+	if db.Statement.Schema == nil || !auditsStatement(db) {
+		return
+	}
+	onConflict, ok := upsertClause(db)
+	if !ok {
+		return
+	}
+	columns := make([]string, 0, len(onConflict.Columns))
+	for _, column := range onConflict.Columns {
+		columns = append(columns, column.Name)
+	}
+	if len(columns) == 0 {
+		columns = db.Statement.Schema.PrimaryFieldDBNames
+	}
+
+	rows := statementRows(db)
+	upserts := make([]upsertRow, len(rows))
+	oldData := map[string]datatypes.JSON{}
+	for i, row := range rows {
+		conds, ok := conflictConditions(db, row, columns)
+		if !ok {
+			continue
+		}
+		found := reflect.New(reflect.SliceOf(db.Statement.Schema.ModelType))
+		// the conflict is with soft deleted rows too
+		if err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Table(db.Statement.Table).Unscoped().
+			Where(clause.And(conds...)).Limit(1).Find(found.Interface()).Error; err != nil {
+			log.Println(fmt.Errorf("error reading audited upsert rows: %s", err.Error()))
+			continue
+		}
+		upserts[i].known = true
+		if found.Elem().Len() == 0 {
+			continue
+		}
+		existing := found.Elem().Index(0)
+		upserts[i].existing = existing
+		recordMap, err := snapshot(db, existing)
+		if err != nil {
+			continue
+		}
+		oldData[rowObjectId(db, existing, recordMap)] = prepareData(recordMap)
+	}
+	db.InstanceSet(settingUpsert, upserts)
+	db.InstanceSet(settingOldData, oldData)
+}
+
+// conflictConditions matches the rows having the values of the conflict
+// columns of row, ok is false when one of them isn't set
+func conflictConditions(db *gorm.DB, row reflect.Value, columns []string) (conds []clause.Expression, ok bool) {
+	for _, column := range columns {
+		field := db.Statement.Schema.LookUpField(column)
+		if field == nil {
+			return nil, false
+		}
+		value, zero := field.ValueOf(db.Statement.Context, row)
+		if zero {
+			return nil, false
+		}
+		conds = append(conds, clause.Eq{Column: clause.Column{Name: field.DBName}, Value: value})
+	}
+	return conds, len(conds) > 0
+}
+
+// upsertOperation returns the operation the create of the i-th object row of
+// the statement of db is recorded as, and the row it is read back from. ok
+// is false when the create left the row alone and has no entry.
+func upsertOperation(db *gorm.DB, i int, row reflect.Value) (operation string, record reflect.Value, ok bool) {
+	value, found := db.InstanceGet(settingUpsert)
+	if !found {
+		return OperationCreate, row, true
+	}
+	upserts := value.([]upsertRow)
+	if i >= len(upserts) || !upserts[i].known {
+		return OperationUpsert, row, true
+	}
+	if !upserts[i].existing.IsValid() {
+		return OperationCreate, row, true
+	}
+	if onConflict, _ := upsertClause(db); onConflict.DoNothing {
+		return "", row, false
+	}
+	return OperationUpdate, upserts[i].existing, true
+}
@@ -0,0 +1,52 @@
+package audited
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm/clause"
+)
+
+func TestUpsertOperation(t *testing.T) {
+	db := statementFor(t, &Counter{}).Clauses(clause.OnConflict{UpdateAll: true})
+	existing := reflect.ValueOf(Counter{ID: 1, Count: 4})
+	db.InstanceSet(settingUpsert, []upsertRow{{known: true, existing: existing}, {known: true}, {}})
+	cases := []struct {
+		operation string
+		record    reflect.Value
+	}{
+		{OperationUpdate, existing},
+		{OperationCreate, reflect.ValueOf(Counter{ID: 2})},
+		{OperationUpsert, reflect.ValueOf(Counter{})},
+	}
+	for i, c := range cases {
+		row := reflect.ValueOf(Counter{ID: uint(i + 1)})
+		if i == 2 {
+			row = reflect.ValueOf(Counter{})
+		}
+		operation, record, ok := upsertOperation(db, i, row)
+		if !ok || operation != c.operation || !reflect.DeepEqual(record.Interface(), c.record.Interface()) {
+			t.Errorf("row %d: got %s %v %v", i, operation, record, ok)
+		}
+	}
+
+	ignore := statementFor(t, &Counter{}).Clauses(clause.OnConflict{DoNothing: true})
+	ignore.InstanceSet(settingUpsert, []upsertRow{{known: true, existing: existing}})
+	if _, _, ok := upsertOperation(ignore, 0, reflect.ValueOf(Counter{ID: 1})); ok {
+		t.Error("got an entry for a conflicting row left alone")
+	}
+	if operation, _, _ := upsertOperation(statementFor(t, &Counter{}), 0, reflect.ValueOf(Counter{})); operation != OperationCreate {
+		t.Errorf("got %s for a plain create", operation)
+	}
+}
+
+func TestConflictConditions(t *testing.T) {
+	db := statementFor(t, &OrderLine{})
+	if _, ok := conflictConditions(db, reflect.ValueOf(OrderLine{OrderId: "o-1"}), []string{"order_id", "line"}); ok {
+		t.Error("got conditions with an unset conflict column")
+	}
+	conds, ok := conflictConditions(db, reflect.ValueOf(OrderLine{OrderId: "o-1", Line: 2}), []string{"order_id", "line"})
+	if !ok || len(conds) != 2 {
+		t.Errorf("got %v %v", conds, ok)
+	}
+}
@@ -0,0 +1,119 @@
+package audited
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// TypeKey marks snapshot values that are stored in an encoded form rather than
+// as plain JSON, e.g. {"$type": "decimal", "value": "10.25"}
+const TypeKey = "$type"
+
+// Type markers of encoded snapshot values
+const (
+	TypeDecimal  = "decimal"
+	TypeBigInt   = "bigint"
+	TypeBigFloat = "bigfloat"
+	TypeBigRat   = "bigrat"
+	TypeTime     = "time"
+	TypeDate     = "date"
+	// TypeTimeOfDay is a time of day without a date, e.g. a datatypes.Time
+	TypeTimeOfDay = "time_of_day"
+)
+
+var (
+	bigIntType    = reflect.TypeOf(big.Int{})
+	bigFloatType  = reflect.TypeOf(big.Float{})
+	bigRatType    = reflect.TypeOf(big.Rat{})
+	timeType      = reflect.TypeOf(time.Time{})
+	dateType      = reflect.TypeOf(datatypes.Date{})
+	timeOfDayType = reflect.TypeOf(datatypes.Time(0))
+)
+
+func typedValue(typ string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{TypeKey: typ, "value": value}
+}
+
+// encodeValue returns the snapshot representation of values that would lose
+// precision or meaning as plain JSON, ok is false for everything else
+func encodeValue(value reflect.Value) (interface{}, bool) {
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, false
+		}
+		value = value.Elem()
+	}
+	if !value.IsValid() {
+		return nil, false
+	}
+
+	switch typ := value.Type(); {
+	case typ == timeType:
+		return encodeTime(value.Interface().(time.Time)), true
+	case typ == dateType:
+		// dates have no meaningful zone, keep the calendar day as written
+		return typedValue(TypeDate, time.Time(value.Interface().(datatypes.Date)).Format(time.DateOnly)), true
+	case typ == timeOfDayType:
+		// stored as a duration, its 15:04:05 form tells it apart from a string
+		return typedValue(TypeTimeOfDay, value.Interface().(datatypes.Time).String()), true
+	case isNullable(typ):
+		// sql.NullString, sql.Null[T], gorm.DeletedAt and friends are stored as
+		// null or as their value, never as the {"Valid": ...} struct
+		if !value.FieldByName("Valid").Bool() {
+			return nil, true
+		}
+		inner := nullableValue(value)
+		if encoded, ok := encodeValue(inner); ok {
+			return encoded, true
+		}
+		return inner.Interface(), true
+	case typ == bigIntType:
+		return typedValue(TypeBigInt, addr(value).Interface().(*big.Int).String()), true
+	case typ == bigFloatType:
+		return typedValue(TypeBigFloat, addr(value).Interface().(*big.Float).Text('g', -1)), true
+	case typ == bigRatType:
+		return typedValue(TypeBigRat, addr(value).Interface().(*big.Rat).RatString()), true
+	case typ.Name() == "Decimal":
+		// shopspring/decimal, ericlagergren/decimal and friends all print exactly
+		if s, ok := addr(value).Interface().(fmt.Stringer); ok {
+			return typedValue(TypeDecimal, s.String()), true
+		}
+	}
+	return nil, false
+}
+
+// encodeTime stores t in UTC, keeping the original offset next to it so the
+// local wall clock time can be recovered
+func encodeTime(t time.Time) map[string]interface{} {
+	v := typedValue(TypeTime, t.UTC().Format(time.RFC3339Nano))
+	v["offset"] = t.Format("-07:00")
+	return v
+}
+
+// isNullable reports whether typ is shaped like the sql.Null types: a value
+// field next to a Valid flag
+func isNullable(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Struct || typ.NumField() != 2 {
+		return false
+	}
+	valid, ok := typ.FieldByName("Valid")
+	return ok && valid.Type.Kind() == reflect.Bool && valid.Index[0] == 1
+}
+
+func nullableValue(value reflect.Value) reflect.Value {
+	return value.Field(0)
+}
+
+// addr returns a pointer to value, copying it when it is not addressable
+func addr(value reflect.Value) reflect.Value {
+	if value.CanAddr() {
+		return value.Addr()
+	}
+	ptr := reflect.New(value.Type())
+	ptr.Elem().Set(value)
+	return ptr
+}
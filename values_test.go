@@ -0,0 +1,186 @@
+package audited
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+// Decimal stands in for shopspring/decimal and friends, which are matched by
+// type name and printed with String
+type Decimal struct {
+	value string
+}
+
+func (d Decimal) String() string {
+	return d.value
+}
+
+func (d Decimal) Value() (driver.Value, error) {
+	return d.value, nil
+}
+
+func (d *Decimal) Scan(value interface{}) error {
+	d.value = fmt.Sprint(value)
+	return nil
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	// decimal libraries marshal as strings or as floats, neither of which the
+	// snapshot should keep
+	return []byte(d.value), nil
+}
+
+type Invoice struct {
+	Id       string   `json:"id"`
+	Total    Decimal  `json:"total"`
+	Discount *Decimal `json:"discount"`
+	Cents    big.Int  `json:"cents" gorm:"serializer:json"`
+	Rate     *big.Rat `json:"rate" gorm:"serializer:json"`
+	Count    int64    `json:"count"`
+}
+
+// statementFor returns a session whose statement is parsed for model, without
+// a database behind it
+func statementFor(t *testing.T, model interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := db.Model(model)
+	if err := tx.Statement.Parse(model); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestEncodeValueMoney(t *testing.T) {
+	discount := Decimal{"0.10"}
+	cases := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"decimal", Decimal{"19.99"}, typedValue(TypeDecimal, "19.99")},
+		{"decimal pointer", &discount, typedValue(TypeDecimal, "0.10")},
+		{"trailing zeros", Decimal{"10.500"}, typedValue(TypeDecimal, "10.500")},
+		{"big int", *big.NewInt(0).Exp(big.NewInt(10), big.NewInt(30), nil), typedValue(TypeBigInt, "1000000000000000000000000000000")},
+		{"big rat", big.NewRat(1, 3), typedValue(TypeBigRat, "1/3")},
+		{"big float", big.NewFloat(0.5), typedValue(TypeBigFloat, "0.5")},
+	}
+	for _, c := range cases {
+		got, ok := encodeValue(reflect.ValueOf(c.value))
+		if !ok {
+			t.Errorf("%s: not encoded", c.name)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	var nilDecimal *Decimal
+	if _, ok := encodeValue(reflect.ValueOf(nilDecimal)); ok {
+		t.Errorf("nil decimal pointer was encoded")
+	}
+	if _, ok := encodeValue(reflect.ValueOf(int64(5))); ok {
+		t.Errorf("int64 was encoded")
+	}
+}
+
+func TestSnapshotMoney(t *testing.T) {
+	invoice := &Invoice{
+		Id:    "inv-1",
+		Total: Decimal{"12345678901234567890.12"},
+		Rate:  big.NewRat(7, 3),
+		Count: 9007199254740993, // 2^53 + 1, not representable as a float64
+	}
+	invoice.Cents.SetString("1234567890123456789012345678901", 10)
+
+	data, err := snapshot(statementFor(t, invoice), reflect.ValueOf(invoice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the stored snapshot must decode back to the exact same values
+	decoded, err := decodeSnapshot(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"id":       "inv-1",
+		"total":    map[string]interface{}{TypeKey: TypeDecimal, "value": "12345678901234567890.12"},
+		"discount": nil,
+		"cents":    map[string]interface{}{TypeKey: TypeBigInt, "value": "1234567890123456789012345678901"},
+		"rate":     map[string]interface{}{TypeKey: TypeBigRat, "value": "7/3"},
+		"count":    json.Number("9007199254740993"),
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("got %v, want %v", decoded, want)
+	}
+
+	cents, ok := new(big.Int).SetString(decoded["cents"].(map[string]interface{})["value"].(string), 10)
+	if !ok || cents.Cmp(&invoice.Cents) != 0 {
+		t.Errorf("big.Int did not round trip: %v", cents)
+	}
+	rate, ok := new(big.Rat).SetString(decoded["rate"].(map[string]interface{})["value"].(string))
+	if !ok || rate.Cmp(invoice.Rate) != 0 {
+		t.Errorf("big.Rat did not round trip: %v", rate)
+	}
+}
+
+func TestDecodeSnapshotNumbers(t *testing.T) {
+	decoded, err := decodeSnapshot([]byte(`{"count": 9007199254740993, "price": 0.1, "huge": 1e400}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]string{"count": "9007199254740993", "price": "0.1", "huge": "1e400"} {
+		if got, ok := decoded[field].(json.Number); !ok || got.String() != want {
+			t.Errorf("%s: got %#v, want %s", field, decoded[field], want)
+		}
+	}
+}
+
+func TestEncodeTime(t *testing.T) {
+	zone := time.FixedZone("", -3*60*60-30*60)
+	at := time.Date(2024, 3, 9, 22, 15, 1, 123456789, zone)
+	got := encodeTime(at)
+	want := map[string]interface{}{TypeKey: TypeTime, "value": "2024-03-10T01:45:01.123456789Z", "offset": "-03:30"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// the wall clock time is recovered from the stored value and offset
+	utc, _ := time.Parse(time.RFC3339Nano, got["value"].(string))
+	offset, _ := time.Parse("-07:00", got["offset"].(string))
+	local := utc.In(offset.Location())
+	if !local.Equal(at) || local.Format(time.DateTime) != at.Format(time.DateTime) {
+		t.Errorf("got %s back, want %s", local, at)
+	}
+}
+
+func TestEncodeValueDates(t *testing.T) {
+	day := datatypes.Date(time.Date(2024, 2, 29, 23, 30, 0, 0, time.FixedZone("", 14*60*60)))
+	got, ok := encodeValue(reflect.ValueOf(day))
+	if want := typedValue(TypeDate, "2024-02-29"); !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("date: got %v, want %v", got, want)
+	}
+
+	got, ok = encodeValue(reflect.ValueOf(datatypes.NewTime(3, 4, 5, 0)))
+	if want := typedValue(TypeTimeOfDay, "03:04:05"); !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("time of day: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+// Package vault provides data keys for encrypted audit payloads from the
+// transit secrets engine of HashiCorp Vault
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mleonidas/audited"
+)
+
+// Config locates the transit key data keys are wrapped with
+type Config struct {
+	// Address of the Vault server, e.g. https://vault.internal:8200
+	Address string
+	// Token authenticates the requests, it needs the datakey and decrypt
+	// capabilities on the key
+	Token string
+	// Namespace is the Vault Enterprise namespace, if any
+	Namespace string
+	// Mount is the path the transit engine is mounted at, "transit" by default
+	Mount string
+	// KeyName is the name of the transit key
+	KeyName string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// KeyProvider is an audited.KeyProvider backed by a Vault transit key. Vault
+// rotates the transit key itself, wrapped data keys carry its version.
+type KeyProvider struct {
+	cfg Config
+}
+
+var _ audited.KeyProvider = (*KeyProvider)(nil)
+
+// New returns a KeyProvider for the transit key of cfg
+func New(cfg Config) *KeyProvider {
+	if cfg.Mount == "" {
+		cfg.Mount = "transit"
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	return &KeyProvider{cfg: cfg}
+}
+
+// KeyID returns "vault:<mount>/<key name>"
+func (p *KeyProvider) KeyID() string {
+	return "vault:" + p.cfg.Mount + "/" + p.cfg.KeyName
+}
+
+// GenerateDataKey asks Vault for a new data key
+func (p *KeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	var resp struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := p.call(ctx, "datakey/plaintext", map[string]interface{}{"bits": 256}, &resp); err != nil {
+		return nil, nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, []byte(resp.Ciphertext), nil
+}
+
+// DecryptDataKey asks Vault to unwrap a data key
+func (p *KeyProvider) DecryptDataKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := p.call(ctx, "decrypt", map[string]interface{}{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
+
+// call posts body to the transit endpoint and decodes the data of the response
+func (p *KeyProvider) call(ctx context.Context, endpoint string, body, data interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", p.cfg.Address, p.cfg.Mount, endpoint, p.cfg.KeyName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", p.cfg.Token)
+	if p.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.cfg.Namespace)
+	}
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var decoded struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return fmt.Errorf("vault %s: %s: %w", endpoint, resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault %s: %s: %s", endpoint, resp.Status, strings.Join(decoded.Errors, ", "))
+	}
+	return json.Unmarshal(decoded.Data, data)
+}
@@ -0,0 +1,72 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeTransit wraps keys by prefixing their base64 form, like Vault's "vault:v1:" ciphertexts
+func fakeTransit(t *testing.T) *httptest.Server {
+	key := bytes.Repeat([]byte{7}, 32)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		plaintext := base64.StdEncoding.EncodeToString(key)
+		switch r.URL.Path {
+		case "/v1/transit/datakey/plaintext/audit":
+			if body["bits"] != float64(256) {
+				t.Errorf("got bits %v", body["bits"])
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{
+				"plaintext": plaintext, "ciphertext": "vault:v1:" + plaintext,
+			}})
+		case "/v1/transit/decrypt/audit":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{
+				"plaintext": strings.TrimPrefix(body["ciphertext"].(string), "vault:v1:"),
+			}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"no handler"}})
+		}
+	}))
+}
+
+func TestKeyProvider(t *testing.T) {
+	server := fakeTransit(t)
+	defer server.Close()
+
+	p := New(Config{Address: server.URL + "/", Token: "token", KeyName: "audit"})
+	if p.KeyID() != "vault:transit/audit" {
+		t.Fatalf("got key id %q", p.KeyID())
+	}
+	key, wrapped, err := p.GenerateDataKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 32 || !strings.HasPrefix(string(wrapped), "vault:v1:") {
+		t.Fatalf("got key %x wrapped as %q", key, wrapped)
+	}
+	unwrapped, err := p.DecryptDataKey(context.Background(), wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Fatalf("got %x, want %x", unwrapped, key)
+	}
+
+	denied := New(Config{Address: server.URL, Token: "wrong", KeyName: "audit"})
+	if _, _, err := denied.GenerateDataKey(context.Background()); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("got error %v", err)
+	}
+}
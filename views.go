@@ -0,0 +1,112 @@
+package audited
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const defaultMaskValue = "***"
+
+// ErrUnsupportedDialect is returned by helpers that rely on dialect specific SQL
+var ErrUnsupportedDialect = errors.New("audited: unsupported database dialect")
+
+// ViewOptions describes a pseudonymized view over the audit table
+type ViewOptions struct {
+	// Name of the view, defaults to "<audit table>_pseudonymized"
+	Name string
+	// DropKeys are removed from the data payload
+	DropKeys []string
+	// MaskKeys are replaced with MaskValue when present
+	MaskKeys []string
+	// HashKeys are replaced with a salted sha256 hex digest when present
+	HashKeys []string
+	// MaskValue defaults to "***"
+	MaskValue string
+	// Salt is prepended to values before hashing
+	Salt string
+	// HashUserId hashes the user_id column instead of exposing it
+	HashUserId bool
+	// GrantTo lists roles that get SELECT on the view
+	GrantTo []string
+}
+
+// CreatePseudonymizedView creates or replaces a view over the audit table with
+// the configured data keys dropped, masked or hashed, and grants read access on
+// it to the given roles. Analysts can then be given access to the view only.
+// Only postgres is supported since the view relies on jsonb functions.
+func CreatePseudonymizedView(db *gorm.DB, opts ViewOptions) error {
+	db = auditConn(db)
+	if db.Dialector.Name() != "postgres" {
+		return ErrUnsupportedDialect
+	}
+	stmt, err := pseudonymizedViewSQL(db, opts)
+	if err != nil {
+		return err
+	}
+	return db.Session(&gorm.Session{NewDB: true}).Transaction(func(tx *gorm.DB) error {
+		for _, s := range stmt {
+			if err := tx.Exec(s).Error; err != nil {
+				return fmt.Errorf("error creating pseudonymized view: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func pseudonymizedViewSQL(db *gorm.DB, opts ViewOptions) ([]string, error) {
+	name := opts.Name
+	if name == "" {
+		name = auditTable(db) + "_pseudonymized"
+	}
+	maskValue := opts.MaskValue
+	if maskValue == "" {
+		maskValue = defaultMaskValue
+	}
+
+	data := "data"
+	for _, key := range opts.DropKeys {
+		data = fmt.Sprintf("(%s - %s)", data, quoteLiteral(key))
+	}
+	for _, key := range opts.MaskKeys {
+		data = fmt.Sprintf("jsonb_set(%s, ARRAY[%s], to_jsonb(%s::text), false)",
+			data, quoteLiteral(key), quoteLiteral(maskValue))
+	}
+	for _, key := range opts.HashKeys {
+		data = fmt.Sprintf("jsonb_set(%s, ARRAY[%s], COALESCE(to_jsonb(%s), 'null'::jsonb), false)",
+			data, quoteLiteral(key), hashExpr(fmt.Sprintf("data->>%s", quoteLiteral(key)), opts.Salt))
+	}
+
+	userId := "user_id"
+	if opts.HashUserId {
+		userId = hashExpr("user_id", opts.Salt) + " AS user_id"
+	}
+
+	// the view reads the entries the way Query does, joining the payloads in
+	// the split layout
+	entries := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return Query(tx).Find(&[]AuditLog{})
+	})
+	quote := db.Statement.Quote
+	stmts := []string{fmt.Sprintf(
+		"CREATE OR REPLACE VIEW %s AS SELECT id, table_name, operation_type, object_id, %s AS data, %s, created_at FROM (%s) AS entries",
+		quote(name), data, userId, entries,
+	)}
+	for _, role := range opts.GrantTo {
+		if role == "" {
+			return nil, errors.New("audited: empty role in GrantTo")
+		}
+		stmts = append(stmts, fmt.Sprintf("GRANT SELECT ON %s TO %s", quote(name), quote(role)))
+	}
+	return stmts, nil
+}
+
+func hashExpr(value, salt string) string {
+	return fmt.Sprintf("encode(sha256(convert_to(%s || %s, 'UTF8')), 'hex')", quoteLiteral(salt), value)
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,98 @@
+package audited
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func postgresDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPseudonymizedViewSQL(t *testing.T) {
+	db := postgresDB(t)
+	opts := ViewOptions{DropKeys: []string{"password"}, GrantTo: []string{"analyst"}}
+
+	stmts, err := pseudonymizedViewSQL(db, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 2 || !strings.Contains(stmts[0], `FROM (SELECT * FROM "audit_logs") AS entries`) {
+		t.Errorf("single table view: %q", stmts)
+	}
+
+	split := postgresDB(t)
+	if err := RegisterCallbacks(split, WithStorageLayout(LayoutSplit)); err != nil {
+		t.Fatal(err)
+	}
+	stmts, err = pseudonymizedViewSQL(split, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stmts[0], `LEFT JOIN "audit_payloads" ON "audit_payloads"."id" = "audit_operations"."id"`) {
+		t.Errorf("split view doesn't join the payloads: %q", stmts[0])
+	}
+}
+
+func TestPseudonymizedViewOptions(t *testing.T) {
+	db := postgresDB(t)
+	stmts, err := pseudonymizedViewSQL(db, ViewOptions{
+		Name:       "audit_for_analysts",
+		DropKeys:   []string{"password"},
+		MaskKeys:   []string{"email"},
+		HashKeys:   []string{"phone"},
+		MaskValue:  "<masked>",
+		Salt:       "pepper",
+		HashUserId: true,
+		GrantTo:    []string{"analyst", "auditor"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 3 {
+		t.Fatalf("got %d statements, want the view and 2 grants: %q", len(stmts), stmts)
+	}
+	view := stmts[0]
+	for _, want := range []string{
+		`CREATE OR REPLACE VIEW "audit_for_analysts"`,
+		`(data - 'password')`,
+		`ARRAY['email'], to_jsonb('<masked>'::text)`,
+		`ARRAY['phone'], COALESCE(to_jsonb(encode(sha256(convert_to('pepper' || data->>'phone', 'UTF8')), 'hex'))`,
+		`encode(sha256(convert_to('pepper' || user_id, 'UTF8')), 'hex') AS user_id`,
+	} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view doesn't contain %s: %s", want, view)
+		}
+	}
+	if stmts[1] != `GRANT SELECT ON "audit_for_analysts" TO "analyst"` || stmts[2] != `GRANT SELECT ON "audit_for_analysts" TO "auditor"` {
+		t.Errorf("unexpected grants %q", stmts[1:])
+	}
+
+	// keys and roles are quoted
+	stmts, err = pseudonymizedViewSQL(db, ViewOptions{DropKeys: []string{"it's"}, GrantTo: []string{`a"b`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stmts[0], `CREATE OR REPLACE VIEW "audit_logs_pseudonymized"`) ||
+		!strings.Contains(stmts[0], `(data - 'it''s')`) || stmts[1] != `GRANT SELECT ON "audit_logs_pseudonymized" TO "a""b"` {
+		t.Errorf("keys or roles not quoted: %q", stmts)
+	}
+	if _, err := pseudonymizedViewSQL(db, ViewOptions{GrantTo: []string{""}}); err == nil {
+		t.Error("empty role accepted")
+	}
+}
+
+func TestCreatePseudonymizedViewDialect(t *testing.T) {
+	if err := CreatePseudonymizedView(statementFor(t, &Archived{}), ViewOptions{}); !errors.Is(err, ErrUnsupportedDialect) {
+		t.Fatalf("got %v, want ErrUnsupportedDialect", err)
+	}
+}
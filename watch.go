@@ -0,0 +1,103 @@
+package audited
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WatchInterval is how often a watcher reads the entries of its object when
+// no write of this process woke it, it bounds how late the entries written by
+// other processes are seen
+var WatchInterval = time.Second
+
+type watchKey struct{ table, object string }
+
+type watcher struct {
+	key  watchKey
+	wake chan struct{}
+}
+
+var watchers = struct {
+	sync.RWMutex
+	m map[watchKey]map[*watcher]struct{}
+}{m: map[watchKey]map[*watcher]struct{}{}}
+
+// Watch calls handler with every entry of the object of table with objectId
+// committed after it started, in sequence order, until ctx is done or the
+// entries can't be read. It follows the sequence of the entries of db (see
+// EntriesAfter), woken by the writes of this process once their transaction
+// commits (see Transaction) and every WatchInterval for those of other
+// processes. The handler runs on the goroutine of Watch, one entry at a time.
+func Watch(ctx context.Context, db *gorm.DB, table, objectId string, handler func(ctx context.Context, entry AuditLog)) error {
+	db = db.WithContext(ctx)
+	var cursor *int64
+	if err := QueryOperations(db).Where("table_name = ? AND object_id = ?", table, objectId).
+		Select("MAX(seq)").Row().Scan(&cursor); err != nil {
+		return err
+	}
+	var seq int64
+	if cursor != nil {
+		seq = *cursor
+	}
+
+	w := &watcher{key: watchKey{table, objectId}, wake: make(chan struct{}, 1)}
+	watchers.Lock()
+	if watchers.m[w.key] == nil {
+		watchers.m[w.key] = map[*watcher]struct{}{}
+	}
+	watchers.m[w.key][w] = struct{}{}
+	watchers.Unlock()
+	defer func() {
+		watchers.Lock()
+		delete(watchers.m[w.key], w)
+		if len(watchers.m[w.key]) == 0 {
+			delete(watchers.m, w.key)
+		}
+		watchers.Unlock()
+	}()
+
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.wake:
+		case <-ticker.C:
+		}
+		var entries []AuditLog
+		if err := Query(db).Where("table_name = ? AND object_id = ? AND seq > ?", table, objectId, seq).
+			Order("seq").Find(&entries).Error; err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		for _, entry := range entries {
+			handler(ctx, entry)
+			seq = entry.Seq
+		}
+	}
+}
+
+// notifyWatchers wakes the watchers of the objects of entries without
+// blocking the write
+func notifyWatchers(entries []AuditLog) {
+	watchers.RLock()
+	defer watchers.RUnlock()
+	if len(watchers.m) == 0 {
+		return
+	}
+	for _, entry := range entries {
+		for w := range watchers.m[watchKey{entry.TableName, entry.ObjectId}] {
+			select {
+			case w.wake <- struct{}{}:
+			default:
+				// already woken
+			}
+		}
+	}
+}
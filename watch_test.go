@@ -0,0 +1,36 @@
+package audited
+
+import (
+	"testing"
+)
+
+func TestNotifyWatchers(t *testing.T) {
+	w := &watcher{key: watchKey{"orders", "7"}, wake: make(chan struct{}, 1)}
+	watchers.Lock()
+	watchers.m[w.key] = map[*watcher]struct{}{w: {}}
+	watchers.Unlock()
+	defer func() {
+		watchers.Lock()
+		delete(watchers.m, w.key)
+		watchers.Unlock()
+	}()
+
+	notifyWatchers([]AuditLog{{Id: "1", TableName: "orders", ObjectId: "8"}, {Id: "2", TableName: "invoices", ObjectId: "7"}})
+	select {
+	case <-w.wake:
+		t.Fatal("woken by the entries of other objects")
+	default:
+	}
+	// a watcher busy reading is woken once for all the entries written meanwhile
+	notifyWatchers([]AuditLog{{Id: "3", TableName: "orders", ObjectId: "7"}, {Id: "4", TableName: "orders", ObjectId: "7"}})
+	select {
+	case <-w.wake:
+	default:
+		t.Fatal("not woken by the entries of its object")
+	}
+	select {
+	case <-w.wake:
+		t.Fatal("woken twice")
+	default:
+	}
+}
@@ -0,0 +1,196 @@
+package audited
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Headers of the requests of a WebhookSink
+const (
+	// WebhookSignatureHeader is the HMAC-SHA256 of the timestamp and body of
+	// the request, see VerifyWebhookSignature
+	WebhookSignatureHeader = "X-Audit-Signature"
+	// WebhookTimestampHeader is the unix time the request was signed at
+	WebhookTimestampHeader = "X-Audit-Timestamp"
+	// WebhookDeliveryHeader identifies a batch by the ids of its entries, it
+	// is the same on every retry and when a consumer hands the batch again,
+	// so receivers can drop the batches they already have
+	WebhookDeliveryHeader = "X-Audit-Delivery"
+)
+
+// ErrInsecureWebhook is returned by WebhookSink.Write for endpoints that are
+// not https, which would send the entries and their signature in clear
+var ErrInsecureWebhook = errors.New("audited: webhook endpoint is not https")
+
+// WebhookSink is an AuditSink posting entries to an HTTPS endpoint as a JSON
+// array of events in PublishFormat, signed with Secret. Hand Handle to Consume
+// to deliver the committed entries off the path of the changes: registered
+// with RegisterSink it posts, and retries, while the transaction of the change
+// holds its locks, and posts the entries of changes that roll back.
+type WebhookSink struct {
+	URL    string
+	Secret []byte
+	Client *http.Client
+	// DB is used to read the data before updates for Debezium events, without
+	// it their before is left empty
+	DB *gorm.DB
+	// BatchSize is the maximum number of entries per request
+	BatchSize int
+	// Timeout bounds every attempt of a request
+	Timeout time.Duration
+	// Retries is the number of times a request failing with a network error,
+	// a 429 or a 5xx status is retried, waiting Backoff doubled every time
+	Retries int
+	Backoff time.Duration
+}
+
+// NewWebhookSink returns a sink posting to url batches of up to 100 entries,
+// retried 3 times from 500ms apart, with a timeout of 10s per attempt
+func NewWebhookSink(url string, secret []byte) *WebhookSink {
+	return &WebhookSink{
+		URL:       url,
+		Secret:    secret,
+		Client:    http.DefaultClient,
+		BatchSize: 100,
+		Timeout:   10 * time.Second,
+		Retries:   3,
+		Backoff:   500 * time.Millisecond,
+	}
+}
+
+// Write posts entries in batches of BatchSize, it stops at the first batch
+// that can't be delivered
+func (s *WebhookSink) Write(ctx context.Context, entries []AuditLog) error {
+	endpoint, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	if endpoint.Scheme != "https" {
+		return ErrInsecureWebhook
+	}
+	size := s.BatchSize
+	if size <= 0 {
+		size = len(entries)
+	}
+	for start := 0; start < len(entries); start += size {
+		end := start + size
+		if end > len(entries) {
+			end = len(entries)
+		}
+		body, err := s.body(entries[start:end])
+		if err != nil {
+			return err
+		}
+		if err := s.deliver(ctx, deliveryId(entries[start:end]), body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handle is a ConsumerHandler posting entries
+func (s *WebhookSink) Handle(ctx context.Context, tx *gorm.DB, entries []AuditLog) error {
+	return s.Write(ctx, entries)
+}
+
+// deliveryId returns the id of the delivery of entries, the digest of their ids
+func deliveryId(entries []AuditLog) string {
+	h := sha256.New()
+	for _, entry := range entries {
+		h.Write([]byte(entry.Id))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *WebhookSink) body(entries []AuditLog) ([]byte, error) {
+	events := make([]json.RawMessage, 0, len(entries))
+	for _, entry := range entries {
+		event, err := MarshalEvent(s.DB, entry)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return json.Marshal(events)
+}
+
+// deliver posts body, retrying the failures that may be temporary
+func (s *WebhookSink) deliver(ctx context.Context, delivery string, body []byte) error {
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, delivery, body)
+		if err == nil || !retry || attempt >= s.Retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one attempt at delivering body, and reports whether a failure
+// is worth retrying
+func (s *WebhookSink) post(ctx context.Context, delivery string, body []byte) (bool, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookDeliveryHeader, delivery)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(s.Secret, timestamp, body))
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// retried unless the caller gave up
+		return ctx.Err() == nil || errors.Is(ctx.Err(), context.DeadlineExceeded), err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("audited: webhook returned %s", resp.Status)
+}
+
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature reports whether signature, the value of the
+// WebhookSignatureHeader of a request, is the signature of its timestamp and
+// body with secret. Receivers should also reject old timestamps, so captured
+// requests can't be replayed.
+func VerifyWebhookSignature(secret []byte, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte("sha256="+signWebhook(secret, timestamp, body)))
+}
@@ -0,0 +1,82 @@
+package audited
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWebhookSink(t *testing.T) {
+	secret := []byte("secret")
+	var mu sync.Mutex
+	var batches [][]AuditLog
+	deliveries := map[string]int{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !VerifyWebhookSignature(secret, r.Header.Get(WebhookTimestampHeader), body, r.Header.Get(WebhookSignatureHeader)) {
+			t.Error("got a request with an invalid signature")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// the first attempt of every batch fails
+		delivery := r.Header.Get(WebhookDeliveryHeader)
+		if deliveries[delivery]++; deliveries[delivery] == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []AuditLog
+		if err := json.Unmarshal(body, &batch); err != nil {
+			t.Error(err)
+		}
+		batches = append(batches, batch)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, secret)
+	sink.Client = server.Client()
+	sink.BatchSize = 2
+	sink.Backoff = 0
+	entries := []AuditLog{{Id: "a", ObjectId: "1"}, {Id: "b", ObjectId: "2"}, {Id: "c", ObjectId: "3"}}
+	if err := sink.Handle(context.Background(), nil, entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || len(batches[0]) != 2 || batches[1][0].ObjectId != "3" {
+		t.Fatalf("got batches %v, want 2 then 1 entries", batches)
+	}
+	if len(deliveries) != 2 || deliveries[deliveryId(entries[2:])] != 2 {
+		t.Fatalf("got deliveries %v, want 2 retried with the same id", deliveries)
+	}
+
+	// a batch handed again, e.g. after the consumer restarted, keeps its id
+	if err := sink.Write(context.Background(), entries[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries[deliveryId(entries[2:])] != 3 {
+		t.Fatalf("got deliveries %v, want the last batch delivered again with its id", deliveries)
+	}
+}
+
+func TestWebhookSinkFailure(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, []byte("secret"))
+	sink.Client = server.Client()
+	if err := sink.Write(context.Background(), []AuditLog{{}}); err == nil || requests != 1 {
+		t.Fatalf("got %v after %d requests, want a 400 not to be retried", err, requests)
+	}
+
+	sink.URL = "http://example.com/audit"
+	if err := sink.Write(context.Background(), []AuditLog{{}}); !errors.Is(err, ErrInsecureWebhook) {
+		t.Fatalf("got %v, want ErrInsecureWebhook", err)
+	}
+}
@@ -0,0 +1,57 @@
+package audited
+
+import (
+	"context"
+)
+
+var contextKeyWorkflow = ContextKey("audited_workflow")
+
+// WorkflowInfo identifies the execution of a long-running workflow, e.g. a
+// Temporal workflow, changes are made in. Its activities run on workers
+// without the context of the request that started it, their entries are
+// attributed to it instead.
+type WorkflowInfo struct {
+	WorkflowId string `json:"workflow_id"`
+	RunId      string `json:"run_id"`
+	// UserId is the user who started the workflow, the acting user of its
+	// changes unless one is set otherwise
+	UserId string `json:"user_id"`
+}
+
+// WithWorkflow returns a copy of ctx carrying the workflow execution changes
+// are made in. An activity interceptor sets it from the activity info and
+// the headers of the workflow.
+func WithWorkflow(ctx context.Context, info WorkflowInfo) context.Context {
+	return context.WithValue(ctx, contextKeyWorkflow, info)
+}
+
+// WorkflowFrom returns the workflow execution of ctx, ok is false when it has
+// none
+func WorkflowFrom(ctx context.Context) (info WorkflowInfo, ok bool) {
+	info, ok = ctx.Value(contextKeyWorkflow).(WorkflowInfo)
+	return info, ok
+}
+
+// WorkflowUser returns the user of ctx to carry in the headers of the
+// workflows started with it: the acting user of the context, or else the
+// user of its own workflow, so child workflows keep the initiating user
+func WorkflowUser(ctx context.Context) string {
+	if user, _ := ctx.Value(ContextKeyEmail).(string); user != "" {
+		return user
+	}
+	info, _ := WorkflowFrom(ctx)
+	return info.UserId
+}
+
+// setWorkflowMetadata stores the workflow execution of ctx in the metadata of
+// entry
+func setWorkflowMetadata(ctx context.Context, entry *AuditLog) {
+	info, ok := WorkflowFrom(ctx)
+	if !ok {
+		return
+	}
+	entry.SetMetadata("workflow_id", info.WorkflowId)
+	if info.RunId != "" {
+		entry.SetMetadata("workflow_run_id", info.RunId)
+	}
+}
@@ -0,0 +1,40 @@
+package audited
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestWorkflowAttribution(t *testing.T) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := context.WithValue(context.Background(), ContextKeyEmail, "ann@example.com")
+	user := WorkflowUser(started)
+	if user != "ann@example.com" {
+		t.Fatalf("got workflow user %q", user)
+	}
+
+	// an activity on a worker, with the user carried in the workflow headers
+	activity := WithWorkflow(context.Background(), WorkflowInfo{WorkflowId: "invoice-42", RunId: "r1", UserId: user})
+	if got := getCurrentUser(db.WithContext(activity)); got != "ann@example.com" {
+		t.Fatalf("got user %q in the activity", got)
+	}
+	if got := WorkflowUser(activity); got != "ann@example.com" {
+		t.Fatalf("got user %q for a child workflow", got)
+	}
+	entry := &AuditLog{}
+	setWorkflowMetadata(activity, entry)
+	if entry.Metadata["workflow_id"] != "invoice-42" || entry.Metadata["workflow_run_id"] != "r1" {
+		t.Fatalf("got metadata %v", entry.Metadata)
+	}
+
+	withUser := context.WithValue(activity, ContextKeyEmail, "bob@example.com")
+	if got := getCurrentUser(db.WithContext(withUser)); got != "bob@example.com" {
+		t.Fatalf("got user %q, want the user of the context", got)
+	}
+}